@@ -0,0 +1,51 @@
+// Package apitest provides helpers for testing code that uses the api library without MongoDB or Redis.
+//
+// New creates a Library backed by in-memory storage, cache and events transport, every event called on it
+// is captured by an EventRecorder:
+//
+//	lib, events := apitest.New(t)
+//	user := apitest.User().Name("Ikuta").Create(t, lib)
+//
+//	events.ExpectEvent(api.UserCreateEventType, apitest.Match(func(event *api.UserCreateEvent) bool {
+//		return event.User.ID == user.ID
+//	}))
+package apitest
+
+import (
+	"api"
+	"testing"
+)
+
+// Secret is the secret of the libraries created by New.
+const Secret = "apitest"
+
+// New creates a Library backed by in-memory fakes and an EventRecorder capturing its events, configure can
+// change the config before the library is created. The event manager is closed once the test completes.
+func New(t testing.TB, configure ...func(config *api.Config)) (*api.Library, *EventRecorder) {
+	t.Helper()
+
+	config := api.Config{Secret: Secret}
+	for _, configure := range configure {
+		configure(&config)
+	}
+
+	// The fakes are used whatever the config says.
+	config.MongoDB.Active = false
+	config.Redis.Active = false
+	config.Streams.Active = false
+	// Generating RSA keys is slow.
+	if len(config.Keys.Algorithm) < 1 {
+		config.Keys.Algorithm = api.SigningKeyEdDSA
+	}
+	if config.Events.EventTransport == nil {
+		config.Events.Transport = "memory"
+	}
+
+	lib, err := api.New(config)
+	if err != nil {
+		t.Fatalf("apitest: failed to create the library: %v", err)
+	}
+	t.Cleanup(lib.Close)
+
+	return lib, NewEventRecorder(t, lib.EventManager)
+}
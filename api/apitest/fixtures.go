@@ -0,0 +1,365 @@
+package apitest
+
+import (
+	"context"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"api"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sequence makes the unique fields of the fixtures unique within a test binary.
+var sequence uint64
+
+// next returns the next value of the fixture sequence.
+func next() uint64 {
+	return atomic.AddUint64(&sequence, 1)
+}
+
+// UserBuilder builds users for tests.
+type UserBuilder struct {
+	user     api.User
+	password string
+}
+
+// User returns a builder of a valid user with a unique id and email address, in a random group.
+func User() *UserBuilder {
+	n := next()
+	now := time.Now()
+
+	return &UserBuilder{user: api.User{
+		ID:               bson.NewObjectId(),
+		UniqueID:         fmt.Sprintf("unique-%d", n),
+		Email:            fmt.Sprintf("user%d@example.com", n),
+		Name:             fmt.Sprintf("User%d", n),
+		MessagingEnabled: true,
+		MessagingSounds:  true,
+		Group:            bson.NewObjectId(),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}}
+}
+
+// Name sets the user's name.
+func (builder *UserBuilder) Name(name string) *UserBuilder {
+	builder.user.Name = name
+	return builder
+}
+
+// Email sets the user's email address.
+func (builder *UserBuilder) Email(email string) *UserBuilder {
+	builder.user.Email = email
+	return builder
+}
+
+// Password sets the user's raw password, it is hashed by Build.
+func (builder *UserBuilder) Password(password string) *UserBuilder {
+	builder.password = password
+	return builder
+}
+
+// Group sets the user's group.
+func (builder *UserBuilder) Group(group *api.Group) *UserBuilder {
+	builder.user.Group = group.ID
+	return builder
+}
+
+// With applies a function to the user being built.
+func (builder *UserBuilder) With(with func(user *api.User)) *UserBuilder {
+	with(&builder.user)
+	return builder
+}
+
+// Build returns the user without storing it.
+func (builder *UserBuilder) Build() *api.User {
+	user := builder.user
+	if len(builder.password) > 0 {
+		err := user.SetPassword(builder.password)
+		if err != nil {
+			panic(fmt.Sprintf("apitest: failed to hash the user's password: %v", err))
+		}
+	}
+
+	return &user
+}
+
+// Create builds the user and stores it using the user service of the library.
+func (builder *UserBuilder) Create(t testing.TB, lib *api.Library) *api.User {
+	t.Helper()
+
+	user := builder.Build()
+	if err := lib.User.Create(context.Background(), user); err != nil {
+		t.Fatalf("apitest: failed to create the user: %v", err)
+	}
+
+	return user
+}
+
+// GroupBuilder builds groups for tests.
+type GroupBuilder struct {
+	group api.Group
+}
+
+// Group returns a builder of a valid group with a unique name and no permissions.
+func Group() *GroupBuilder {
+	n := next()
+
+	return &GroupBuilder{group: api.Group{
+		ID:             bson.NewObjectId(),
+		Name:           fmt.Sprintf("Group%d", n),
+		Permissions:    []string{},
+		WebPermissions: map[string]bool{},
+		SortID:         int(n),
+	}}
+}
+
+// Name sets the group's name.
+func (builder *GroupBuilder) Name(name string) *GroupBuilder {
+	builder.group.Name = name
+	return builder
+}
+
+// WebPermissions grants web permissions to the group.
+func (builder *GroupBuilder) WebPermissions(permissions ...string) *GroupBuilder {
+	for _, permission := range permissions {
+		builder.group.WebPermissions[permission] = true
+	}
+
+	return builder
+}
+
+// Protected marks the group as protected.
+func (builder *GroupBuilder) Protected() *GroupBuilder {
+	builder.group.Protected = true
+	return builder
+}
+
+// With applies a function to the group being built.
+func (builder *GroupBuilder) With(with func(group *api.Group)) *GroupBuilder {
+	with(&builder.group)
+	return builder
+}
+
+// Build returns the group without storing it.
+func (builder *GroupBuilder) Build() *api.Group {
+	group := builder.group
+	group.WebPermissions = copyPermissions(builder.group.WebPermissions)
+
+	return &group
+}
+
+// Create builds the group and stores it using the group service of the library.
+func (builder *GroupBuilder) Create(t testing.TB, lib *api.Library) *api.Group {
+	t.Helper()
+
+	group := builder.Build()
+	if err := lib.Group.Create(context.Background(), group); err != nil {
+		t.Fatalf("apitest: failed to create the group: %v", err)
+	}
+
+	return group
+}
+
+// PunishmentBuilder builds punishments for tests.
+type PunishmentBuilder struct {
+	punishment api.Punishment
+}
+
+// Punishment returns a builder of a valid permanent ban of a random user.
+func Punishment() *PunishmentBuilder {
+	now := time.Now()
+
+	return &PunishmentBuilder{punishment: api.Punishment{
+		ID:         bson.NewObjectId(),
+		Server:     "apitest",
+		UserID:     bson.NewObjectId(),
+		PunisherID: bson.NewObjectId(),
+		Reason:     "Testing",
+		Type:       "ban",
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}}
+}
+
+// User sets the punished user.
+func (builder *PunishmentBuilder) User(user *api.User) *PunishmentBuilder {
+	builder.punishment.UserID = user.ID
+	return builder
+}
+
+// Punisher sets the user who issued the punishment.
+func (builder *PunishmentBuilder) Punisher(user *api.User) *PunishmentBuilder {
+	builder.punishment.PunisherID = user.ID
+	return builder
+}
+
+// Type sets the punishment's type.
+func (builder *PunishmentBuilder) Type(punishmentType string) *PunishmentBuilder {
+	builder.punishment.Type = punishmentType
+	return builder
+}
+
+// ExpiresIn makes the punishment expire after the specified duration.
+func (builder *PunishmentBuilder) ExpiresIn(duration time.Duration) *PunishmentBuilder {
+	builder.punishment.ExpiresAt = time.Now().Add(duration)
+	return builder
+}
+
+// With applies a function to the punishment being built.
+func (builder *PunishmentBuilder) With(with func(punishment *api.Punishment)) *PunishmentBuilder {
+	with(&builder.punishment)
+	return builder
+}
+
+// Build returns the punishment without storing it.
+func (builder *PunishmentBuilder) Build() *api.Punishment {
+	punishment := builder.punishment
+	return &punishment
+}
+
+// Create builds the punishment and stores it using the punishment service of the library.
+func (builder *PunishmentBuilder) Create(t testing.TB, lib *api.Library) *api.Punishment {
+	t.Helper()
+
+	punishment := builder.Build()
+	if err := lib.Punishment.Create(context.Background(), punishment); err != nil {
+		t.Fatalf("apitest: failed to create the punishment: %v", err)
+	}
+
+	return punishment
+}
+
+// TokenBuilder builds tokens for tests.
+type TokenBuilder struct {
+	token api.Token
+}
+
+// Token returns a builder of a valid token of a random user, expiring in an hour and without permissions.
+func Token() *TokenBuilder {
+	now := time.Now()
+
+	return &TokenBuilder{token: api.Token{
+		ID:          bson.NewObjectId(),
+		User:        bson.NewObjectId(),
+		Address:     "127.0.0.1",
+		UserAgent:   "apitest",
+		Permissions: map[string]bool{},
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}}
+}
+
+// User sets the token's user.
+func (builder *TokenBuilder) User(user *api.User) *TokenBuilder {
+	builder.token.User = user.ID
+	return builder
+}
+
+// Permissions grants permissions to the token.
+func (builder *TokenBuilder) Permissions(permissions ...string) *TokenBuilder {
+	for _, permission := range permissions {
+		builder.token.Permissions[permission] = true
+	}
+
+	return builder
+}
+
+// ExpiresIn makes the token expire after the specified duration, a negative duration creates an expired token.
+func (builder *TokenBuilder) ExpiresIn(duration time.Duration) *TokenBuilder {
+	builder.token.ExpiresAt = time.Now().Add(duration)
+	return builder
+}
+
+// With applies a function to the token being built.
+func (builder *TokenBuilder) With(with func(token *api.Token)) *TokenBuilder {
+	with(&builder.token)
+	return builder
+}
+
+// Build returns the token without storing it.
+func (builder *TokenBuilder) Build() *api.Token {
+	token := builder.token
+	token.Permissions = copyPermissions(builder.token.Permissions)
+
+	return &token
+}
+
+// Create builds the token and stores it using the token service of the library.
+func (builder *TokenBuilder) Create(t testing.TB, lib *api.Library) *api.Token {
+	t.Helper()
+
+	token := builder.Build()
+	if err := lib.Token.Create(context.Background(), token); err != nil {
+		t.Fatalf("apitest: failed to create the token: %v", err)
+	}
+
+	return token
+}
+
+// TicketBuilder builds tickets for tests.
+type TicketBuilder struct {
+	ticket api.Ticket
+}
+
+// Ticket returns a builder of a valid ticket of a random user.
+func Ticket() *TicketBuilder {
+	n := next()
+	now := time.Now()
+
+	return &TicketBuilder{ticket: api.Ticket{
+		ID:        bson.NewObjectId(),
+		User:      bson.NewObjectId(),
+		Body:      fmt.Sprintf("Ticket %d", n),
+		Category:  bson.NewObjectId(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}
+}
+
+// User sets the ticket's user.
+func (builder *TicketBuilder) User(user *api.User) *TicketBuilder {
+	builder.ticket.User = user.ID
+	return builder
+}
+
+// Body sets the ticket's body.
+func (builder *TicketBuilder) Body(body string) *TicketBuilder {
+	builder.ticket.Body = body
+	return builder
+}
+
+// With applies a function to the ticket being built.
+func (builder *TicketBuilder) With(with func(ticket *api.Ticket)) *TicketBuilder {
+	with(&builder.ticket)
+	return builder
+}
+
+// Build returns the ticket without storing it.
+func (builder *TicketBuilder) Build() *api.Ticket {
+	ticket := builder.ticket
+	return &ticket
+}
+
+// Create builds the ticket and stores it using the ticket service of the library.
+func (builder *TicketBuilder) Create(t testing.TB, lib *api.Library) *api.Ticket {
+	t.Helper()
+
+	ticket := builder.Build()
+	if err := lib.Ticket.Create(context.Background(), ticket); err != nil {
+		t.Fatalf("apitest: failed to create the ticket: %v", err)
+	}
+
+	return ticket
+}
+
+// copyPermissions copies a permissions map so built values don't share it with their builder.
+func copyPermissions(permissions map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(permissions))
+	for permission, granted := range permissions {
+		copied[permission] = granted
+	}
+
+	return copied
+}
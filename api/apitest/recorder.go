@@ -0,0 +1,156 @@
+package apitest
+
+import (
+	"api"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordedEvent represents an event captured by an EventRecorder.
+type RecordedEvent struct {
+	Type  string
+	Event interface{}
+}
+
+// Matcher reports whether a recorded event is the expected one.
+type Matcher func(event interface{}) bool
+
+// Match converts a typed function into a Matcher, events of another type don't match.
+func Match[T api.Event](match func(event T) bool) Matcher {
+	return func(event interface{}) bool {
+		typed, ok := event.(T)
+		return ok && match(typed)
+	}
+}
+
+// EventRecorder captures every event passed to the Call and CallPre methods of an event manager.
+//
+// Events are recorded synchronously, before their handlers are called, so they can be asserted right after
+// the service call that produced them. The full events are recorded, not their redacted copy.
+type EventRecorder struct {
+	t      testing.TB
+	lock   sync.Mutex
+	events []RecordedEvent
+}
+
+// NewEventRecorder creates an EventRecorder observing an event manager, assertion failures are reported to t.
+func NewEventRecorder(t testing.TB, manager *api.EventManager) *EventRecorder {
+	recorder := &EventRecorder{t: t}
+	manager.Observe(recorder.record)
+
+	return recorder
+}
+
+// record captures an event.
+func (recorder *EventRecorder) record(eventType string, event interface{}) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.events = append(recorder.events, RecordedEvent{Type: eventType, Event: event})
+}
+
+// Events returns every recorded event, in the order they were called.
+func (recorder *EventRecorder) Events() []RecordedEvent {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	events := make([]RecordedEvent, len(recorder.events))
+	copy(events, recorder.events)
+
+	return events
+}
+
+// Find returns the recorded events of a type that match every matcher.
+func (recorder *EventRecorder) Find(eventType string, matchers ...Matcher) []interface{} {
+	var found []interface{}
+
+	for _, recorded := range recorder.Events() {
+		if recorded.Type == eventType && matchesAll(recorded.Event, matchers) {
+			found = append(found, recorded.Event)
+		}
+	}
+
+	return found
+}
+
+// Reset forgets the recorded events.
+func (recorder *EventRecorder) Reset() {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.events = nil
+}
+
+// ExpectEvent fails the test unless an event of the type matching every matcher has been recorded, it
+// returns the first such event.
+func (recorder *EventRecorder) ExpectEvent(eventType string, matchers ...Matcher) interface{} {
+	recorder.t.Helper()
+
+	found := recorder.Find(eventType, matchers...)
+	if len(found) < 1 {
+		recorder.t.Fatalf("apitest: expected a matching %q event, recorded: %s", eventType, recorder.summary())
+		return nil
+	}
+
+	return found[0]
+}
+
+// ExpectNoEvent fails the test if an event of the type matching every matcher has been recorded.
+func (recorder *EventRecorder) ExpectNoEvent(eventType string, matchers ...Matcher) {
+	recorder.t.Helper()
+
+	if found := recorder.Find(eventType, matchers...); len(found) > 0 {
+		recorder.t.Fatalf("apitest: expected no matching %q event, found %d", eventType, len(found))
+	}
+}
+
+// ExpectCount fails the test unless exactly count events of the type matching every matcher have been recorded.
+func (recorder *EventRecorder) ExpectCount(eventType string, count int, matchers ...Matcher) {
+	recorder.t.Helper()
+
+	if found := recorder.Find(eventType, matchers...); len(found) != count {
+		recorder.t.Fatalf("apitest: expected %d matching %q events, found %d", count, eventType, len(found))
+	}
+}
+
+// ExpectTypes fails the test unless the types of the recorded events are exactly the specified ones, in order.
+func (recorder *EventRecorder) ExpectTypes(eventTypes ...string) {
+	recorder.t.Helper()
+
+	events := recorder.Events()
+	ok := len(events) == len(eventTypes)
+	for i := 0; ok && i < len(events); i++ {
+		ok = events[i].Type == eventTypes[i]
+	}
+
+	if !ok {
+		recorder.t.Fatalf("apitest: expected the events [%s], recorded: %s", strings.Join(eventTypes, ", "), recorder.summary())
+	}
+}
+
+// summary lists the types of the recorded events.
+func (recorder *EventRecorder) summary() string {
+	events := recorder.Events()
+	if len(events) < 1 {
+		return "none"
+	}
+
+	types := make([]string, len(events))
+	for i, recorded := range events {
+		types[i] = recorded.Type
+	}
+
+	return "[" + strings.Join(types, ", ") + "]"
+}
+
+// matchesAll returns true if the event matches every matcher.
+func matchesAll(event interface{}, matchers []Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher(event) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCacheMiss is returned by a Cache when the requested key doesn't exist.
+var ErrCacheMiss = errors.New("cache: miss")
+
+// ErrSubscriptionClosed is returned by Subscription.Receive once the subscription has been closed.
+var ErrSubscriptionClosed = errors.New("cache: subscription closed")
+
+// Cache represents a key/value cache with expiring keys and a message bus used by the api library.
+type Cache interface {
+	Get(key string) (string, error)
+	Set(key string, value interface{}, expiration time.Duration) error
+	Del(keys ...string) error
+	// Incr increments the integer stored at key and returns the new value, the expiration is only set when
+	// the key is created.
+	Incr(key string, expiration time.Duration) (int64, error)
+	Publish(channel string, message interface{}) error
+	Subscribe(channel string) (Subscription, error)
+}
+
+// Subscription represents a subscription to a channel of a Cache.
+type Subscription interface {
+	// Receive blocks until a message is received, it returns an error if the subscription failed or was closed.
+	Receive() (string, error)
+	Close() error
+}
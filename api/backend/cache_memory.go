@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryCache is a Cache implementation that keeps every key in memory.
+type MemoryCache struct {
+	lock        sync.Mutex
+	entries     map[string]memoryCacheEntry
+	subscribers map[string][]*memorySubscription
+}
+
+// memoryCacheEntry represents a single value stored in a MemoryCache.
+type memoryCacheEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:     map[string]memoryCacheEntry{},
+		subscribers: map[string][]*memorySubscription{},
+	}
+}
+
+// Get returns the value stored at key, or ErrCacheMiss if the key doesn't exist or has expired.
+func (cache *MemoryCache) Get(key string) (string, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok {
+		return "", ErrCacheMiss
+	}
+
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(cache.entries, key)
+		return "", ErrCacheMiss
+	}
+
+	return entry.value, nil
+}
+
+// Set stores a value at key, an expiration of zero means the key never expires.
+func (cache *MemoryCache) Set(key string, value interface{}, expiration time.Duration) error {
+	entry := memoryCacheEntry{value: toCacheString(value)}
+	if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration)
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.entries[key] = entry
+	return nil
+}
+
+// Del removes every specified key.
+func (cache *MemoryCache) Del(keys ...string) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for _, key := range keys {
+		delete(cache.entries, key)
+	}
+
+	return nil
+}
+
+// Incr increments the integer stored at key, a missing key is created with the expiration and the value 1.
+func (cache *MemoryCache) Incr(key string, expiration time.Duration) (int64, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	now := time.Now()
+	entry, ok := cache.entries[key]
+	if !ok || (!entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)) {
+		entry = memoryCacheEntry{value: "0"}
+		if expiration > 0 {
+			entry.expiresAt = now.Add(expiration)
+		}
+	}
+
+	value, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cache: value of %q is not an integer", key)
+	}
+
+	value++
+	entry.value = strconv.FormatInt(value, 10)
+	cache.entries[key] = entry
+	return value, nil
+}
+
+// Publish sends a message to every subscriber of a channel, messages are dropped for subscribers that fall behind.
+func (cache *MemoryCache) Publish(channel string, message interface{}) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	data := toCacheString(message)
+	for _, subscriber := range cache.subscribers[channel] {
+		select {
+		case subscriber.messages <- data:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns a Subscription that receives every message published to the specified channel.
+func (cache *MemoryCache) Subscribe(channel string) (Subscription, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	subscription := &memorySubscription{
+		cache:    cache,
+		channel:  channel,
+		messages: make(chan string, 100),
+		closed:   make(chan struct{}),
+	}
+	cache.subscribers[channel] = append(cache.subscribers[channel], subscription)
+
+	return subscription, nil
+}
+
+// unsubscribe removes a subscription from its channel.
+func (cache *MemoryCache) unsubscribe(subscription *memorySubscription) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	subscribers := cache.subscribers[subscription.channel]
+	for i, subscriber := range subscribers {
+		if subscriber == subscription {
+			cache.subscribers[subscription.channel] = append(subscribers[:i:i], subscribers[i+1:]...)
+			break
+		}
+	}
+}
+
+// memorySubscription represents a subscription to a channel of a MemoryCache.
+type memorySubscription struct {
+	cache     *MemoryCache
+	channel   string
+	messages  chan string
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (subscription *memorySubscription) Receive() (string, error) {
+	select {
+	case message := <-subscription.messages:
+		return message, nil
+	case <-subscription.closed:
+		return "", ErrSubscriptionClosed
+	}
+}
+
+func (subscription *memorySubscription) Close() error {
+	subscription.closeOnce.Do(func() {
+		subscription.cache.unsubscribe(subscription)
+		close(subscription.closed)
+	})
+
+	return nil
+}
+
+// toCacheString converts a value to the string representation Redis would store.
+func toCacheString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+
+	return fmt.Sprint(value)
+}
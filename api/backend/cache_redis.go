@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"github.com/go-redis/redis"
+	"time"
+)
+
+// redisCache is a Cache implementation backed by a RedisDriver.
+type redisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache creates a Cache that uses the client of a connected RedisDriver.
+func NewRedisCache(driver RedisDriver) Cache {
+	return &redisCache{client: driver.Client}
+}
+
+func (cache *redisCache) Get(key string) (string, error) {
+	result, err := cache.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", ErrCacheMiss
+	}
+
+	return result, err
+}
+
+func (cache *redisCache) Set(key string, value interface{}, expiration time.Duration) error {
+	return cache.client.Set(key, value, expiration).Err()
+}
+
+func (cache *redisCache) Del(keys ...string) error {
+	return cache.client.Del(keys...).Err()
+}
+
+// incrScript increments a key and sets its expiration when it is created, atomically.
+var incrScript = redis.NewScript(`
+local value = redis.call("INCR", KEYS[1])
+if value == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return value
+`)
+
+func (cache *redisCache) Incr(key string, expiration time.Duration) (int64, error) {
+	return incrScript.Run(cache.client, []string{key}, int64(expiration/time.Millisecond)).Int64()
+}
+
+func (cache *redisCache) Publish(channel string, message interface{}) error {
+	return cache.client.Publish(channel, message).Err()
+}
+
+func (cache *redisCache) Subscribe(channel string) (Subscription, error) {
+	pubSub := cache.client.Subscribe(channel)
+
+	// Wait for the subscription to be confirmed so connection errors are reported right away.
+	_, err := pubSub.Receive()
+	if err != nil {
+		pubSub.Close()
+		return nil, err
+	}
+
+	return &redisSubscription{pubSub: pubSub}, nil
+}
+
+// redisSubscription wraps a *redis.PubSub.
+type redisSubscription struct {
+	pubSub *redis.PubSub
+}
+
+func (subscription *redisSubscription) Receive() (string, error) {
+	message, err := subscription.pubSub.ReceiveMessage()
+	if err != nil {
+		return "", err
+	}
+
+	return message.Payload, nil
+}
+
+func (subscription *redisSubscription) Close() error {
+	return subscription.pubSub.Close()
+}
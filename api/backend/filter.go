@@ -0,0 +1,65 @@
+package backend
+
+// Operator represents a comparison used by a Condition.
+type Operator int
+
+// Operators supported by every backend.
+const (
+	Eq Operator = iota
+	Ne
+	Gt
+	Gte
+	Lt
+	Lte
+	In
+	Exists
+)
+
+// Condition compares a field of a document with a value.
+//
+// Comparing an array field with Eq or In matches if any of its elements matches, dotted field names
+// can be used to reach into embedded documents.
+type Condition struct {
+	Field    string
+	Operator Operator
+	Value    interface{}
+}
+
+// Filter represents a backend independent query filter.
+//
+// A document matches if every condition and every filter in All match, and at least one filter in Any
+// matches when Any isn't empty.
+type Filter struct {
+	Conditions []Condition
+	All        []Filter
+	Any        []Filter
+}
+
+// Where returns a filter that only matches documents where the field matches the value.
+func Where(field string, operator Operator, value interface{}) Filter {
+	return Filter{}.Where(field, operator, value)
+}
+
+// Where returns a copy of the filter with an extra condition.
+func (filter Filter) Where(field string, operator Operator, value interface{}) Filter {
+	conditions := make([]Condition, len(filter.Conditions), len(filter.Conditions)+1)
+	copy(conditions, filter.Conditions)
+
+	filter.Conditions = append(conditions, Condition{Field: field, Operator: operator, Value: value})
+	return filter
+}
+
+// IsEmpty returns true if the filter matches every document.
+func (filter Filter) IsEmpty() bool {
+	if len(filter.Conditions) > 0 || len(filter.Any) > 0 {
+		return false
+	}
+
+	for _, child := range filter.All {
+		if !child.IsEmpty() {
+			return false
+		}
+	}
+
+	return true
+}
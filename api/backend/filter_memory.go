@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// normalizeFilter converts every value of a filter to the types it would have after a round trip through bson.
+func normalizeFilter(filter Filter) (Filter, error) {
+	normalized := Filter{}
+
+	for _, condition := range filter.Conditions {
+		document, err := toDocument(bson.M{"value": condition.Value})
+		if err != nil {
+			return normalized, err
+		}
+
+		condition.Value = document["value"]
+		normalized.Conditions = append(normalized.Conditions, condition)
+	}
+
+	for _, child := range filter.All {
+		child, err := normalizeFilter(child)
+		if err != nil {
+			return normalized, err
+		}
+
+		normalized.All = append(normalized.All, child)
+	}
+
+	for _, child := range filter.Any {
+		child, err := normalizeFilter(child)
+		if err != nil {
+			return normalized, err
+		}
+
+		normalized.Any = append(normalized.Any, child)
+	}
+
+	return normalized, nil
+}
+
+// matchFilter reports whether a document matches a normalized Filter.
+func matchFilter(document bson.M, filter Filter) (bool, error) {
+	for _, condition := range filter.Conditions {
+		matches, err := matchCondition(document, condition)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+
+	for _, child := range filter.All {
+		matches, err := matchFilter(document, child)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+
+	if len(filter.Any) < 1 {
+		return true, nil
+	}
+
+	for _, child := range filter.Any {
+		matches, err := matchFilter(document, child)
+		if err != nil {
+			return false, err
+		}
+
+		if matches {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchCondition reports whether a document satisfies a single condition.
+func matchCondition(document bson.M, condition Condition) (bool, error) {
+	value, exists := lookupField(document, condition.Field)
+
+	switch condition.Operator {
+	case Eq:
+		return matchEquals(value, condition.Value), nil
+	case Ne:
+		return !matchEquals(value, condition.Value), nil
+	case Gt, Gte, Lt, Lte:
+		return matchComparison(value, exists, condition.Operator, condition.Value), nil
+	case In:
+		values, ok := condition.Value.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s: the In operator expects an array", condition.Field)
+		}
+
+		for _, candidate := range values {
+			if matchEquals(value, candidate) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	case Exists:
+		return exists == truthy(condition.Value), nil
+	}
+
+	return false, fmt.Errorf("%s: unsupported operator %d", condition.Field, condition.Operator)
+}
+
+// matchEquals compares a field value with an operand, treating arrays the same way MongoDB does.
+func matchEquals(value interface{}, operand interface{}) bool {
+	if valuesEqual(value, operand) {
+		return true
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		for _, element := range array {
+			if valuesEqual(element, operand) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchComparison handles the $gt, $gte, $lt and $lte operators.
+func matchComparison(value interface{}, exists bool, operator Operator, operand interface{}) bool {
+	if !exists {
+		return false
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		for _, element := range array {
+			if matchComparison(element, true, operator, operand) {
+				return true
+			}
+		}
+		return false
+	}
+
+	result, comparable := compareValues(value, operand)
+	if !comparable {
+		return false
+	}
+
+	switch operator {
+	case Gt:
+		return result > 0
+	case Gte:
+		return result >= 0
+	case Lt:
+		return result < 0
+	default:
+		return result <= 0
+	}
+}
+
+// lookupField returns the value of a (possibly dotted) field inside of a document.
+func lookupField(document bson.M, key string) (interface{}, bool) {
+	var current interface{} = document
+
+	for _, part := range strings.Split(key, ".") {
+		object, ok := current.(bson.M)
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = object[part]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// lessDocuments reports whether document a sorts before document b using mgo style sort fields.
+func lessDocuments(a bson.M, b bson.M, fields []string) bool {
+	for _, field := range fields {
+		descending := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")
+
+		valueA, _ := lookupField(a, field)
+		valueB, _ := lookupField(b, field)
+
+		result, comparable := compareValues(valueA, valueB)
+		if !comparable || result == 0 {
+			continue
+		}
+
+		if descending {
+			return result > 0
+		}
+		return result < 0
+	}
+
+	return false
+}
+
+// valuesEqual compares two normalized bson values.
+func valuesEqual(a interface{}, b interface{}) bool {
+	if result, comparable := compareValues(a, b); comparable {
+		return result == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues compares two normalized bson values of the same kind.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if numberA, ok := toFloat(a); ok {
+		numberB, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+
+		switch {
+		case numberA < numberB:
+			return -1, true
+		case numberA > numberB:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bson.ObjectId:
+		if b, ok := b.(bson.ObjectId); ok {
+			return strings.Compare(string(a), string(b)), true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			switch {
+			case a.Before(b):
+				return -1, true
+			case a.After(b):
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case !a:
+				return -1, true
+			}
+			return 1, true
+		}
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+// toFloat converts any numeric value to a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+
+	return 0, false
+}
+
+// truthy converts an operand into a boolean the same way MongoDB does for $exists.
+func truthy(value interface{}) bool {
+	if number, ok := toFloat(value); ok {
+		return number != 0
+	}
+
+	if boolean, ok := value.(bool); ok {
+		return boolean
+	}
+
+	return value != nil
+}
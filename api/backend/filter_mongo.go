@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+)
+
+// mongoOperators maps every Operator to its MongoDB query operator.
+var mongoOperators = map[Operator]string{
+	Eq:     "$eq",
+	Ne:     "$ne",
+	Gt:     "$gt",
+	Gte:    "$gte",
+	Lt:     "$lt",
+	Lte:    "$lte",
+	In:     "$in",
+	Exists: "$exists",
+}
+
+// compileMongoFilter converts a Filter into a MongoDB query document.
+func compileMongoFilter(filter Filter) (bson.M, error) {
+	var clauses []bson.M
+
+	for _, condition := range filter.Conditions {
+		operator, ok := mongoOperators[condition.Operator]
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator %d", condition.Operator)
+		}
+
+		clauses = append(clauses, bson.M{condition.Field: bson.M{operator: condition.Value}})
+	}
+
+	for _, child := range filter.All {
+		compiled, err := compileMongoFilter(child)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(compiled) > 0 {
+			clauses = append(clauses, compiled)
+		}
+	}
+
+	if len(filter.Any) > 0 {
+		var any []bson.M
+		for _, child := range filter.Any {
+			compiled, err := compileMongoFilter(child)
+			if err != nil {
+				return nil, err
+			}
+
+			any = append(any, compiled)
+		}
+
+		clauses = append(clauses, bson.M{"$or": any})
+	}
+
+	switch len(clauses) {
+	case 0:
+		return bson.M{}, nil
+	case 1:
+		return clauses[0], nil
+	}
+
+	return bson.M{"$and": clauses}, nil
+}
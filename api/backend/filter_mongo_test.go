@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompileMongoFilter(t *testing.T) {
+	id := bson.NewObjectId()
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bson.M
+	}{
+		{"empty", Filter{}, bson.M{}},
+		{"condition", Where("user", Eq, id), bson.M{"user": bson.M{"$eq": id}}},
+		{
+			"conditions",
+			Where("user", Eq, id).Where("createdAt", Gte, from),
+			bson.M{"$and": []bson.M{{"user": bson.M{"$eq": id}}, {"createdAt": bson.M{"$gte": from}}}},
+		},
+		{
+			"same field twice",
+			Where("createdAt", Gte, from).Where("createdAt", Lt, from.Add(time.Hour)),
+			bson.M{"$and": []bson.M{{"createdAt": bson.M{"$gte": from}}, {"createdAt": bson.M{"$lt": from.Add(time.Hour)}}}},
+		},
+		{
+			"operators",
+			Where("a", Ne, 1).Where("b", Gt, 2).Where("c", Lte, 3).Where("d", In, []int{4}).Where("e", Exists, false),
+			bson.M{"$and": []bson.M{
+				{"a": bson.M{"$ne": 1}},
+				{"b": bson.M{"$gt": 2}},
+				{"c": bson.M{"$lte": 3}},
+				{"d": bson.M{"$in": []int{4}}},
+				{"e": bson.M{"$exists": false}},
+			}},
+		},
+		{"empty children", Filter{All: []Filter{{}, {All: []Filter{{}}}}}, bson.M{}},
+		{"child", Filter{All: []Filter{Where("user", Eq, id)}}, bson.M{"user": bson.M{"$eq": id}}},
+		{
+			"any",
+			Filter{Any: []Filter{Where("address", Eq, "::1"), Where("addresses", Eq, "::1")}},
+			bson.M{"$or": []bson.M{{"address": bson.M{"$eq": "::1"}}, {"addresses": bson.M{"$eq": "::1"}}}},
+		},
+		{
+			"condition and any",
+			Filter{
+				Conditions: []Condition{{Field: "group", Operator: Eq, Value: id}},
+				Any:        []Filter{Where("address", Eq, "::1"), Where("addresses", Eq, "::1")},
+			},
+			bson.M{"$and": []bson.M{
+				{"group": bson.M{"$eq": id}},
+				{"$or": []bson.M{{"address": bson.M{"$eq": "::1"}}, {"addresses": bson.M{"$eq": "::1"}}}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			compiled, err := compileMongoFilter(test.filter)
+			if err != nil {
+				t.Fatalf("compileMongoFilter returned an error: %v", err)
+			}
+
+			if !reflect.DeepEqual(compiled, test.want) {
+				t.Errorf("compileMongoFilter() = %v, want %v", compiled, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileMongoFilterUnsupportedOperator(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+	}{
+		{"condition", Where("user", Operator(-1), 1)},
+		{"child", Filter{All: []Filter{Where("user", Operator(-1), 1)}}},
+		{"any", Filter{Any: []Filter{Where("user", Eq, 1), Where("user", Operator(-1), 1)}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := compileMongoFilter(test.filter); err == nil {
+				t.Errorf("compileMongoFilter() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"errors"
+)
+
+// ErrEmptyUpdate is returned by Collection.Update when the update doesn't change any field, MongoDB would
+// replace the whole document with an empty one.
+var ErrEmptyUpdate = errors.New("storage: empty update")
+
+// Collection names used by the api library.
+const (
+	GroupCollection              = "group"
+	InternalTokenCollection      = "internal_token"
+	InternalTokenUsageCollection = "internal_token_usage"
+	OAuthClientCollection        = "oauth_client"
+	OAuthCodeCollection          = "oauth_code"
+	OAuthConsentCollection       = "oauth_consent"
+	OutboxCollection             = "outbox"
+	PunishmentCollection         = "punishment"
+	RefreshTokenCollection       = "refresh_token"
+	RevokedTokenCollection       = "revoked_token"
+	SigningKeyCollection         = "signing_key"
+	TicketCollection             = "ticket"
+	TokenCollection              = "token"
+	TwoFactorAuditCollection     = "two_factor_audit"
+	TwoFactorChallengeCollection = "two_factor_challenge"
+	UserCollection               = "user"
+	WebhookCollection            = "webhook"
+	WebhookDeliveryCollection    = "webhook_delivery"
+)
+
+// Storage represents a document store used by the api library.
+type Storage interface {
+	C(name string) Collection
+}
+
+// Collection represents a set of documents inside of a Storage.
+type Collection interface {
+	Find(filter Filter) Query
+	FindId(id interface{}) Query
+	Insert(docs ...interface{}) error
+	UpdateId(id interface{}, update interface{}) error
+	// Update updates the first document matching the filter, mgo.ErrNotFound is returned if none does and
+	// ErrEmptyUpdate if the update is empty.
+	Update(filter Filter, update Update) error
+	RemoveId(id interface{}) error
+	// RemoveAll removes every document matching the filter and returns how many were removed.
+	RemoveAll(filter Filter) (int, error)
+	Count() (int, error)
+	// EstimatedCount returns the amount of documents from the collection's metadata, it doesn't scan the
+	// collection and may be slightly off.
+	EstimatedCount() (int, error)
+	// EnsureIndex creates an index if it doesn't exist yet.
+	EnsureIndex(index Index) error
+}
+
+// Index represents an index of a Collection.
+type Index struct {
+	// Key lists the indexed fields, a field prefixed with "-" is sorted in descending order.
+	Key []string
+	// Unique rejects the documents sharing their key with another document.
+	Unique bool
+}
+
+// Update represents a partial update of a single document.
+type Update struct {
+	// Set maps (possibly dotted) field names to their new value.
+	Set map[string]interface{}
+	// Inc maps field names to the amount they should be incremented by.
+	Inc map[string]int
+	// Push maps array field names to a value appended to them.
+	Push map[string]interface{}
+	// Pull maps array field names to a value removed from them.
+	Pull map[string]interface{}
+}
+
+// IsEmpty returns true if the update doesn't change any field.
+func (update Update) IsEmpty() bool {
+	return len(update.Set) < 1 && len(update.Inc) < 1 && len(update.Push) < 1 && len(update.Pull) < 1
+}
+
+// Query represents a pending query on a Collection.
+type Query interface {
+	Skip(n int) Query
+	Limit(n int) Query
+	Sort(fields ...string) Query
+	One(result interface{}) error
+	All(result interface{}) error
+	Count() (int, error)
+}
@@ -0,0 +1,415 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStorage is a Storage implementation that keeps every document in memory.
+type MemoryStorage struct {
+	lock        sync.Mutex
+	collections map[string]*memoryCollection
+}
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		collections: map[string]*memoryCollection{},
+	}
+}
+
+// C returns the collection with the specified name, creating it if it doesn't exist.
+func (storage *MemoryStorage) C(name string) Collection {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+
+	collection, ok := storage.collections[name]
+	if !ok {
+		collection = &memoryCollection{
+			name:      name,
+			documents: map[interface{}]bson.M{},
+		}
+		storage.collections[name] = collection
+	}
+
+	return collection
+}
+
+// memoryCollection holds the documents of a single collection in insertion order.
+type memoryCollection struct {
+	name      string
+	lock      sync.RWMutex
+	order     []interface{}
+	documents map[interface{}]bson.M
+}
+
+func (collection *memoryCollection) Find(filter Filter) Query {
+	return &memoryQuery{collection: collection, filter: filter}
+}
+
+func (collection *memoryCollection) FindId(id interface{}) Query {
+	return &memoryQuery{collection: collection, filter: Where("_id", Eq, id)}
+}
+
+func (collection *memoryCollection) Insert(docs ...interface{}) error {
+	collection.lock.Lock()
+	defer collection.lock.Unlock()
+
+	for _, doc := range docs {
+		document, err := toDocument(doc)
+		if err != nil {
+			return err
+		}
+
+		id, ok := document["_id"]
+		if !ok {
+			id = bson.NewObjectId()
+			document["_id"] = id
+		}
+
+		if _, exists := collection.documents[id]; exists {
+			return &mgo.LastError{
+				Code: 11000,
+				Err:  fmt.Sprintf("E11000 duplicate key error collection: %s index: _id_", collection.name),
+			}
+		}
+
+		collection.order = append(collection.order, id)
+		collection.documents[id] = document
+	}
+
+	return nil
+}
+
+func (collection *memoryCollection) UpdateId(id interface{}, update interface{}) error {
+	document, err := toDocument(update)
+	if err != nil {
+		return err
+	}
+
+	collection.lock.Lock()
+	defer collection.lock.Unlock()
+
+	if _, ok := collection.documents[id]; !ok {
+		return mgo.ErrNotFound
+	}
+
+	document["_id"] = id
+	collection.documents[id] = document
+	return nil
+}
+
+func (collection *memoryCollection) Update(filter Filter, update Update) error {
+	if update.IsEmpty() {
+		return ErrEmptyUpdate
+	}
+
+	filter, err := normalizeFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	collection.lock.Lock()
+	defer collection.lock.Unlock()
+
+	for _, id := range collection.order {
+		document := collection.documents[id]
+
+		matches, err := matchFilter(document, filter)
+		if err != nil {
+			return err
+		}
+
+		if !matches {
+			continue
+		}
+
+		// Copy the document so readers holding the previous version aren't affected.
+		updated, err := toDocument(document)
+		if err != nil {
+			return err
+		}
+
+		for field, value := range update.Set {
+			setField(updated, field, value)
+		}
+
+		for field, amount := range update.Inc {
+			current, _ := lookupField(updated, field)
+			number, _ := toFloat(current)
+
+			if _, isFloat := current.(float64); isFloat {
+				setField(updated, field, number+float64(amount))
+			} else {
+				setField(updated, field, int64(number)+int64(amount))
+			}
+		}
+
+		for field, value := range update.Push {
+			current, _ := lookupField(updated, field)
+			values, _ := current.([]interface{})
+			setField(updated, field, append(values[:len(values):len(values)], value))
+		}
+
+		for field, value := range update.Pull {
+			current, _ := lookupField(updated, field)
+			values, _ := current.([]interface{})
+
+			remaining := []interface{}{}
+			for _, element := range values {
+				if !valuesEqual(element, value) {
+					remaining = append(remaining, element)
+				}
+			}
+			setField(updated, field, remaining)
+		}
+
+		// Normalize the new values the same way inserted documents are.
+		updated, err = toDocument(updated)
+		if err != nil {
+			return err
+		}
+
+		collection.documents[id] = updated
+		return nil
+	}
+
+	return mgo.ErrNotFound
+}
+
+func (collection *memoryCollection) RemoveId(id interface{}) error {
+	collection.lock.Lock()
+	defer collection.lock.Unlock()
+
+	if _, ok := collection.documents[id]; !ok {
+		return mgo.ErrNotFound
+	}
+
+	delete(collection.documents, id)
+	for i, key := range collection.order {
+		if key == id {
+			collection.order = append(collection.order[:i], collection.order[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (collection *memoryCollection) RemoveAll(filter Filter) (int, error) {
+	filter, err := normalizeFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	collection.lock.Lock()
+	defer collection.lock.Unlock()
+
+	var order, removed []interface{}
+	for _, id := range collection.order {
+		matches, err := matchFilter(collection.documents[id], filter)
+		if err != nil {
+			return 0, err
+		}
+
+		if matches {
+			removed = append(removed, id)
+		} else {
+			order = append(order, id)
+		}
+	}
+
+	for _, id := range removed {
+		delete(collection.documents, id)
+	}
+
+	collection.order = order
+	return len(removed), nil
+}
+
+func (collection *memoryCollection) Count() (int, error) {
+	collection.lock.RLock()
+	defer collection.lock.RUnlock()
+
+	return len(collection.documents), nil
+}
+
+func (collection *memoryCollection) EstimatedCount() (int, error) {
+	return collection.Count()
+}
+
+// EnsureIndex does nothing, every query scans the documents and only the ids are unique.
+func (collection *memoryCollection) EnsureIndex(index Index) error {
+	return nil
+}
+
+// memoryQuery represents a pending query on a memoryCollection.
+type memoryQuery struct {
+	collection *memoryCollection
+	filter     Filter
+	sort       []string
+	skip       int
+	limit      int
+}
+
+func (query *memoryQuery) Skip(n int) Query {
+	query.skip = n
+	return query
+}
+
+func (query *memoryQuery) Limit(n int) Query {
+	query.limit = n
+	return query
+}
+
+func (query *memoryQuery) Sort(fields ...string) Query {
+	query.sort = fields
+	return query
+}
+
+func (query *memoryQuery) One(result interface{}) error {
+	documents, err := query.Limit(1).(*memoryQuery).execute()
+	if err != nil {
+		return err
+	}
+
+	if len(documents) < 1 {
+		return mgo.ErrNotFound
+	}
+
+	return fromDocument(documents[0], result)
+}
+
+func (query *memoryQuery) All(result interface{}) error {
+	documents, err := query.execute()
+	if err != nil {
+		return err
+	}
+
+	resultValue := reflect.ValueOf(result)
+	if resultValue.Kind() != reflect.Ptr || resultValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("result argument must be a slice address")
+	}
+
+	sliceValue := resultValue.Elem()
+	sliceValue = sliceValue.Slice(0, 0)
+	elementType := sliceValue.Type().Elem()
+
+	for _, document := range documents {
+		element := reflect.New(elementType)
+		err := fromDocument(document, element.Interface())
+		if err != nil {
+			return err
+		}
+
+		sliceValue = reflect.Append(sliceValue, element.Elem())
+	}
+
+	resultValue.Elem().Set(sliceValue)
+	return nil
+}
+
+func (query *memoryQuery) Count() (int, error) {
+	documents, err := query.execute()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(documents), nil
+}
+
+// execute returns the documents matching the query after sorting, skipping and limiting.
+func (query *memoryQuery) execute() ([]bson.M, error) {
+	filter, err := normalizeFilter(query.filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query.collection.lock.RLock()
+	var documents []bson.M
+	for _, id := range query.collection.order {
+		document := query.collection.documents[id]
+
+		matches, err := matchFilter(document, filter)
+		if err != nil {
+			query.collection.lock.RUnlock()
+			return nil, err
+		}
+
+		if matches {
+			documents = append(documents, document)
+		}
+	}
+	query.collection.lock.RUnlock()
+
+	if len(query.sort) > 0 {
+		sort.SliceStable(documents, func(i, j int) bool {
+			return lessDocuments(documents[i], documents[j], query.sort)
+		})
+	}
+
+	if query.skip > 0 {
+		if query.skip >= len(documents) {
+			return nil, nil
+		}
+		documents = documents[query.skip:]
+	}
+
+	if query.limit > 0 && query.limit < len(documents) {
+		documents = documents[:query.limit]
+	}
+
+	return documents, nil
+}
+
+// setField sets a (possibly dotted) field of a document, creating embedded documents as needed.
+func setField(document bson.M, field string, value interface{}) {
+	parts := strings.Split(field, ".")
+
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := document[part].(bson.M)
+		if !ok {
+			child = bson.M{}
+			document[part] = child
+		}
+
+		document = child
+	}
+
+	document[parts[len(parts)-1]] = value
+}
+
+// toDocument converts any bson marshallable value into a bson.M, normalizing its values in the process.
+func toDocument(value interface{}) (bson.M, error) {
+	if value == nil {
+		return bson.M{}, nil
+	}
+
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	document := bson.M{}
+	err = bson.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// fromDocument decodes a bson.M into the result value.
+func fromDocument(document bson.M, result interface{}) error {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return bson.Unmarshal(data, result)
+}
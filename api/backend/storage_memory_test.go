@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"testing"
+)
+
+func TestMemoryCollectionUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		update Update
+		err    error
+		want   int
+	}{
+		{"set", Where("name", Eq, "a"), Update{Set: map[string]interface{}{"count": 2}}, nil, 2},
+		{"inc", Where("name", Eq, "a"), Update{Inc: map[string]int{"count": 1}}, nil, 2},
+		{"no match", Where("name", Eq, "b"), Update{Set: map[string]interface{}{"count": 2}}, mgo.ErrNotFound, 1},
+		{"empty", Where("name", Eq, "a"), Update{}, ErrEmptyUpdate, 1},
+		{"empty maps", Where("name", Eq, "a"), Update{Set: map[string]interface{}{}, Inc: map[string]int{}}, ErrEmptyUpdate, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			collection := NewMemoryStorage().C("test")
+			id := bson.NewObjectId()
+			if err := collection.Insert(bson.M{"_id": id, "name": "a", "count": 1}); err != nil {
+				t.Fatalf("Insert returned an error: %v", err)
+			}
+
+			if err := collection.Update(test.filter, test.update); err != test.err {
+				t.Fatalf("Update() = %v, want %v", err, test.err)
+			}
+
+			var document struct {
+				Name  string `bson:"name"`
+				Count int    `bson:"count"`
+			}
+			if err := collection.FindId(id).One(&document); err != nil {
+				t.Fatalf("FindId returned an error: %v", err)
+			}
+
+			if document.Name != "a" || document.Count != test.want {
+				t.Errorf("document = %+v, want the name kept and a count of %d", document, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// mongoStorage is a Storage implementation backed by a MongoDriver.
+type mongoStorage struct {
+	database    *mgo.Database
+	collections map[string]*mgo.Collection
+}
+
+// NewMongoStorage creates a Storage that uses the collections of a connected MongoDriver.
+func NewMongoStorage(driver MongoDriver) Storage {
+	return &mongoStorage{
+		database: driver.User.Database,
+		collections: map[string]*mgo.Collection{
+			GroupCollection:         driver.Group,
+			InternalTokenCollection: driver.InternalToken,
+			PunishmentCollection:    driver.Punishment,
+			TicketCollection:        driver.Ticket,
+			TokenCollection:         driver.Token,
+			UserCollection:          driver.User,
+		},
+	}
+}
+
+// C returns the collection with the specified name.
+func (storage *mongoStorage) C(name string) Collection {
+	if collection, ok := storage.collections[name]; ok {
+		return &mongoCollection{collection: collection}
+	}
+
+	return &mongoCollection{collection: storage.database.C(name)}
+}
+
+// mongoCollection wraps a *mgo.Collection.
+type mongoCollection struct {
+	collection *mgo.Collection
+}
+
+func (collection *mongoCollection) Find(filter Filter) Query {
+	query, err := compileMongoFilter(filter)
+	if err != nil {
+		return &mongoQuery{err: err}
+	}
+
+	return &mongoQuery{query: collection.collection.Find(query)}
+}
+
+func (collection *mongoCollection) FindId(id interface{}) Query {
+	return &mongoQuery{query: collection.collection.FindId(id)}
+}
+
+func (collection *mongoCollection) Insert(docs ...interface{}) error {
+	return collection.collection.Insert(docs...)
+}
+
+func (collection *mongoCollection) UpdateId(id interface{}, update interface{}) error {
+	return collection.collection.UpdateId(id, update)
+}
+
+func (collection *mongoCollection) Update(filter Filter, update Update) error {
+	if update.IsEmpty() {
+		return ErrEmptyUpdate
+	}
+
+	query, err := compileMongoFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	change := bson.M{}
+	if len(update.Set) > 0 {
+		change["$set"] = update.Set
+	}
+	if len(update.Inc) > 0 {
+		change["$inc"] = update.Inc
+	}
+	if len(update.Push) > 0 {
+		change["$push"] = update.Push
+	}
+	if len(update.Pull) > 0 {
+		change["$pull"] = update.Pull
+	}
+
+	return collection.collection.Update(query, change)
+}
+
+func (collection *mongoCollection) RemoveId(id interface{}) error {
+	return collection.collection.RemoveId(id)
+}
+
+func (collection *mongoCollection) RemoveAll(filter Filter) (int, error) {
+	query, err := compileMongoFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := collection.collection.RemoveAll(query)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
+
+func (collection *mongoCollection) Count() (int, error) {
+	return collection.collection.Count()
+}
+
+func (collection *mongoCollection) EstimatedCount() (int, error) {
+	// A count command without a query is answered from the collection's metadata.
+	var result struct {
+		N int `bson:"n"`
+	}
+
+	err := collection.collection.Database.Run(bson.D{{Name: "count", Value: collection.collection.Name}}, &result)
+	return result.N, err
+}
+
+func (collection *mongoCollection) EnsureIndex(index Index) error {
+	return collection.collection.EnsureIndex(mgo.Index{
+		Key:        index.Key,
+		Unique:     index.Unique,
+		Background: true,
+	})
+}
+
+// mongoQuery wraps a *mgo.Query, err is set if the filter couldn't be compiled.
+type mongoQuery struct {
+	query *mgo.Query
+	err   error
+}
+
+func (query *mongoQuery) Skip(n int) Query {
+	if query.err == nil {
+		query.query.Skip(n)
+	}
+
+	return query
+}
+
+func (query *mongoQuery) Limit(n int) Query {
+	if query.err == nil {
+		query.query.Limit(n)
+	}
+
+	return query
+}
+
+func (query *mongoQuery) Sort(fields ...string) Query {
+	if query.err == nil {
+		query.query.Sort(fields...)
+	}
+
+	return query
+}
+
+func (query *mongoQuery) One(result interface{}) error {
+	if query.err != nil {
+		return query.err
+	}
+
+	return query.query.One(result)
+}
+
+func (query *mongoQuery) All(result interface{}) error {
+	if query.err != nil {
+		return query.err
+	}
+
+	return query.query.All(result)
+}
+
+func (query *mongoQuery) Count() (int, error) {
+	if query.err != nil {
+		return 0, query.err
+	}
+
+	return query.query.Count()
+}
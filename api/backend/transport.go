@@ -0,0 +1,28 @@
+package backend
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTransportClosed is returned by an EventTransport once it has been closed.
+var ErrTransportClosed = errors.New("transport: closed")
+
+// EventTransport represents the message bus encoded events are exchanged on by the instances of the api library.
+type EventTransport interface {
+	// Publish sends an encoded event to every instance, including this one.
+	Publish(data []byte) error
+	// Subscribe calls handle for every received event and blocks until the subscription fails, it returns
+	// ErrTransportClosed once the transport has been closed.
+	Subscribe(handle func(data []byte)) error
+	Close() error
+}
+
+// ReplayTransport is implemented by the transports storing the published events.
+type ReplayTransport interface {
+	EventTransport
+	// Replay calls handle for every stored event, starting at the specified entry id.
+	Replay(fromID string, handle func(data []byte)) error
+	// ReplaySince calls handle for every event stored since the specified time.
+	ReplaySince(since time.Time, handle func(data []byte)) error
+}
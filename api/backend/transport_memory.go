@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"sync"
+)
+
+// MemoryTransport is an EventTransport passing events between the subscribers of the same process.
+//
+// A MemoryTransport can be shared by several libraries, for example in tests, so they receive each other's
+// events. Closing it stops every subscriber.
+type MemoryTransport struct {
+	lock        sync.Mutex
+	subscribers []chan []byte
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewMemoryTransport creates a MemoryTransport without subscribers.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{closed: make(chan struct{})}
+}
+
+// Publish passes an event to every subscriber, it blocks until each of them has room for it.
+func (transport *MemoryTransport) Publish(data []byte) error {
+	transport.lock.Lock()
+	subscribers := transport.subscribers
+	transport.lock.Unlock()
+
+	for _, subscriber := range subscribers {
+		select {
+		case subscriber <- data:
+		case <-transport.closed:
+			return ErrTransportClosed
+		}
+	}
+
+	return nil
+}
+
+// Subscribe calls handle for every published event until the transport is closed.
+func (transport *MemoryTransport) Subscribe(handle func(data []byte)) error {
+	messages := make(chan []byte, 100)
+
+	transport.lock.Lock()
+	transport.subscribers = append(transport.subscribers, messages)
+	transport.lock.Unlock()
+
+	defer transport.unsubscribe(messages)
+
+	for {
+		select {
+		case data := <-messages:
+			handle(data)
+		case <-transport.closed:
+			return ErrTransportClosed
+		}
+	}
+}
+
+// unsubscribe removes a subscriber.
+func (transport *MemoryTransport) unsubscribe(messages chan []byte) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	for i, subscriber := range transport.subscribers {
+		if subscriber == messages {
+			transport.subscribers = append(transport.subscribers[:i:i], transport.subscribers[i+1:]...)
+			break
+		}
+	}
+}
+
+// Close stops every subscriber, it can be called more than once.
+func (transport *MemoryTransport) Close() error {
+	transport.closeOnce.Do(func() {
+		close(transport.closed)
+	})
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"github.com/nats-io/nats.go"
+	"sync"
+)
+
+// natsTransport is an EventTransport backed by a NATS subject, events published while an instance is
+// disconnected are lost.
+type natsTransport struct {
+	conn    *nats.Conn
+	subject string
+	closed  chan struct{}
+
+	closeOnce sync.Once
+}
+
+// NewNatsTransport connects to a NATS server and creates an EventTransport publishing to a subject, the
+// connection is re-established by the client whenever it is lost.
+func NewNatsTransport(url string, subject string) (EventTransport, error) {
+	conn, err := nats.Connect(url, nats.Name("ikuta-access"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsTransport{
+		conn:    conn,
+		subject: subject,
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+func (transport *natsTransport) Publish(data []byte) error {
+	return transport.conn.Publish(transport.subject, data)
+}
+
+func (transport *natsTransport) Subscribe(handle func(data []byte)) error {
+	messages := make(chan *nats.Msg, 1000)
+
+	subscription, err := transport.conn.ChanSubscribe(transport.subject, messages)
+	if err != nil {
+		if transport.conn.IsClosed() {
+			return ErrTransportClosed
+		}
+
+		return err
+	}
+	defer subscription.Unsubscribe()
+
+	for {
+		select {
+		case message := <-messages:
+			handle(message.Data)
+		case <-transport.closed:
+			return ErrTransportClosed
+		}
+	}
+}
+
+// Close closes the connection to the NATS server.
+func (transport *natsTransport) Close() error {
+	transport.closeOnce.Do(func() {
+		close(transport.closed)
+		transport.conn.Close()
+	})
+
+	return nil
+}
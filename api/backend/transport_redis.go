@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"github.com/go-redis/redis"
+	"sync"
+)
+
+// redisTransport is an EventTransport backed by a Redis Pub/Sub channel, events published while an instance
+// is disconnected are lost.
+type redisTransport struct {
+	client  *redis.Client
+	channel string
+
+	lock    sync.Mutex
+	closed  bool
+	pubSubs map[*redis.PubSub]struct{}
+}
+
+// NewRedisTransport creates an EventTransport publishing to a channel using the client of a connected RedisDriver.
+func NewRedisTransport(driver RedisDriver, channel string) EventTransport {
+	return &redisTransport{
+		client:  driver.Client,
+		channel: channel,
+		pubSubs: map[*redis.PubSub]struct{}{},
+	}
+}
+
+func (transport *redisTransport) Publish(data []byte) error {
+	return transport.client.Publish(transport.channel, data).Err()
+}
+
+func (transport *redisTransport) Subscribe(handle func(data []byte)) error {
+	pubSub, err := transport.open()
+	if err != nil {
+		return err
+	}
+	defer transport.release(pubSub)
+
+	for {
+		message, err := pubSub.ReceiveMessage()
+		if err != nil {
+			if transport.isClosed() {
+				return ErrTransportClosed
+			}
+
+			return err
+		}
+
+		handle([]byte(message.Payload))
+	}
+}
+
+// open subscribes to the channel and waits for the subscription to be confirmed.
+func (transport *redisTransport) open() (*redis.PubSub, error) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	if transport.closed {
+		return nil, ErrTransportClosed
+	}
+
+	pubSub := transport.client.Subscribe(transport.channel)
+
+	// Wait for the subscription to be confirmed so connection errors are reported right away.
+	_, err := pubSub.Receive()
+	if err != nil {
+		pubSub.Close()
+		return nil, err
+	}
+
+	transport.pubSubs[pubSub] = struct{}{}
+	return pubSub, nil
+}
+
+// release closes a subscription opened by open.
+func (transport *redisTransport) release(pubSub *redis.PubSub) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	if _, ok := transport.pubSubs[pubSub]; ok {
+		delete(transport.pubSubs, pubSub)
+		pubSub.Close()
+	}
+}
+
+// isClosed returns true once Close has been called.
+func (transport *redisTransport) isClosed() bool {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	return transport.closed
+}
+
+// Close closes the open subscriptions, the client belongs to the RedisDriver and stays open.
+func (transport *redisTransport) Close() error {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	transport.closed = true
+	for pubSub := range transport.pubSubs {
+		delete(transport.pubSubs, pubSub)
+		pubSub.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package backend
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream transport settings.
+const (
+	streamDefaultMaxLen = 100000
+	streamBatchSize     = 100
+	streamBlock         = 5 * time.Second
+	streamClaimInterval = 30 * time.Second
+	streamClaimIdle     = time.Minute
+)
+
+// StreamOptions configures a stream transport.
+type StreamOptions struct {
+	// Stream is the key of the Redis Stream.
+	Stream string
+	// Group is the consumer group of this instance. Instances sharing a group split the events between them
+	// while every group receives every event.
+	Group string
+	// Consumer identifies this instance within its group.
+	Consumer string
+	// MaxLen is the approximate amount of events kept in the stream, it defaults to 100000.
+	MaxLen int64
+}
+
+// streamTransport is a durable EventTransport backed by a Redis Stream.
+//
+// Every event is appended to the stream and read through a consumer group. Entries are acknowledged once
+// they have been handled, entries left pending by a stopped consumer are claimed by the remaining ones.
+type streamTransport struct {
+	client  *redis.Client
+	options StreamOptions
+	closed  chan struct{}
+
+	closeOnce sync.Once
+}
+
+// NewStreamTransport creates a ReplayTransport using the client of a connected RedisDriver.
+func NewStreamTransport(driver RedisDriver, options StreamOptions) ReplayTransport {
+	if options.MaxLen < 1 {
+		options.MaxLen = streamDefaultMaxLen
+	}
+
+	return &streamTransport{
+		client:  driver.Client,
+		options: options,
+		closed:  make(chan struct{}),
+	}
+}
+
+func (transport *streamTransport) Publish(data []byte) error {
+	return transport.client.XAdd(&redis.XAddArgs{
+		Stream:       transport.options.Stream,
+		MaxLenApprox: transport.options.MaxLen,
+		Values:       map[string]interface{}{"event": data},
+	}).Err()
+}
+
+// Subscribe reads the stream through the consumer group, the transport is checked for closing between
+// blocking reads.
+func (transport *streamTransport) Subscribe(handle func(data []byte)) error {
+	// New groups only receive the events appended after their creation.
+	err := transport.client.XGroupCreateMkStream(transport.options.Stream, transport.options.Group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	// Handle the entries this consumer left pending before reading new ones.
+	err = transport.readGroup("0", handle)
+	if err != nil {
+		return err
+	}
+
+	lastClaim := time.Now()
+	for {
+		select {
+		case <-transport.closed:
+			return ErrTransportClosed
+		default:
+		}
+
+		err = transport.readGroup(">", handle)
+		if err != nil {
+			return err
+		}
+
+		if time.Since(lastClaim) >= streamClaimInterval {
+			err = transport.claimStale(handle)
+			if err != nil {
+				return err
+			}
+
+			lastClaim = time.Now()
+		}
+	}
+}
+
+// readGroup reads a batch of entries through the consumer group, starting at the specified id.
+func (transport *streamTransport) readGroup(start string, handle func(data []byte)) error {
+	streams, err := transport.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    transport.options.Group,
+		Consumer: transport.options.Consumer,
+		Streams:  []string{transport.options.Stream, start},
+		Count:    streamBatchSize,
+		Block:    streamBlock,
+	}).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, result := range streams {
+		err = transport.handle(result.Messages, handle)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// claimStale takes over the entries that other consumers of the group left pending for too long.
+func (transport *streamTransport) claimStale(handle func(data []byte)) error {
+	pending, err := transport.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: transport.options.Stream,
+		Group:  transport.options.Group,
+		Start:  "-",
+		End:    "+",
+		Count:  streamBatchSize,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, entry := range pending {
+		if entry.Idle >= streamClaimIdle {
+			ids = append(ids, entry.Id)
+		}
+	}
+
+	if len(ids) < 1 {
+		return nil
+	}
+
+	messages, err := transport.client.XClaim(&redis.XClaimArgs{
+		Stream:   transport.options.Stream,
+		Group:    transport.options.Group,
+		Consumer: transport.options.Consumer,
+		MinIdle:  streamClaimIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	return transport.handle(messages, handle)
+}
+
+// handle passes every entry to the handler and acknowledges them.
+func (transport *streamTransport) handle(messages []redis.XMessage, handle func(data []byte)) error {
+	if len(messages) < 1 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		handle(streamData(message))
+		ids = append(ids, message.ID)
+	}
+
+	return transport.client.XAck(transport.options.Stream, transport.options.Group, ids...).Err()
+}
+
+// Replay calls handle for every entry of the stream, starting at the specified entry id.
+func (transport *streamTransport) Replay(fromID string, handle func(data []byte)) error {
+	start := fromID
+	for {
+		messages, err := transport.client.XRangeN(transport.options.Stream, start, "+", streamBatchSize).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			handle(streamData(message))
+		}
+
+		if len(messages) < streamBatchSize {
+			return nil
+		}
+
+		// Continue right after the last entry, XRANGE is inclusive.
+		start = nextStreamID(messages[len(messages)-1].ID)
+	}
+}
+
+// ReplaySince calls handle for every entry appended to the stream since the specified time.
+func (transport *streamTransport) ReplaySince(since time.Time, handle func(data []byte)) error {
+	return transport.Replay(fmt.Sprintf("%d-0", since.UnixNano()/int64(time.Millisecond)), handle)
+}
+
+// Close stops the subscription once its current read returns, the client belongs to the RedisDriver and
+// stays open.
+func (transport *streamTransport) Close() error {
+	transport.closeOnce.Do(func() {
+		close(transport.closed)
+	})
+
+	return nil
+}
+
+// streamData returns the encoded event of a stream entry.
+func streamData(message redis.XMessage) []byte {
+	data, _ := message.Values["event"].(string)
+	return []byte(data)
+}
+
+// nextStreamID returns the smallest stream entry id greater than the specified one.
+func nextStreamID(id string) string {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) < 2 {
+		return id
+	}
+
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return id
+	}
+
+	return parts[0] + "-" + strconv.FormatUint(sequence+1, 10)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"sync"
+)
+
+// background runs the loops of background components until it is closed.
+type background struct {
+	stop      chan struct{}
+	loops     sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// newBackground creates a background without any loop.
+func newBackground() *background {
+	return &background{stop: make(chan struct{})}
+}
+
+// run starts a loop in a goroutine, the loop must return once stop is closed.
+func (b *background) run(loop func(stop <-chan struct{})) {
+	b.loops.Add(1)
+	go func() {
+		defer b.loops.Done()
+		loop(b.stop)
+	}()
+}
+
+// close stops the loops and waits for them to return, closing again only waits.
+func (b *background) close() {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+	})
+
+	b.loops.Wait()
+}
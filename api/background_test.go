@@ -0,0 +1,44 @@
+package api
+
+import (
+	"api/backend"
+	"testing"
+	"time"
+)
+
+func TestBackgroundClose(t *testing.T) {
+	outbox := newOutboxTestRelay(&outboxTestTransport{})
+	webhooks, _ := newWebhookTestRelay(t, "https://hooks.test/")
+	sweeper := &expirySweeper{library: &Library{Storage: backend.NewMemoryStorage()}}
+
+	tests := []struct {
+		name string
+		loop func(stop <-chan struct{})
+	}{
+		{"outbox relay", outbox.run},
+		{"webhook relay", webhooks.run},
+		{"expiry sweeper", sweeper.run},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBackground()
+			b.run(test.loop)
+
+			closed := make(chan struct{})
+			go func() {
+				b.close()
+				close(closed)
+			}()
+
+			select {
+			case <-closed:
+			case <-time.After(time.Second):
+				t.Fatalf("close didn't return, the loop is still running")
+			}
+
+			// Closing again only waits for the loops that already returned.
+			b.close()
+		})
+	}
+}
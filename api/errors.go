@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"time"
+)
+
+// Errors returned by every service, use errors.Is to check for them.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrInvalidID  = errors.New("invalid id")
+	ErrValidation = errors.New("validation failed")
+	// ErrRejected is returned when a pre-event handler rejected a write.
+	ErrRejected = errors.New("rejected")
+	// ErrForbidden is returned when a token isn't allowed to be used for a request.
+	ErrForbidden = errors.New("forbidden")
+	// ErrTooManyAttempts is returned when a login is attempted on a locked account, from a blocked address or
+	// before the delay following a failed attempt is over, see RetryError.
+	ErrTooManyAttempts = errors.New("too many attempts")
+	// ErrInvalidToken is returned when a token has been revoked, or a refresh token is unknown, expired or reused.
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Error represents an error returned by a service.
+type Error struct {
+	// Kind is one of the package level errors, or nil if the error is unknown.
+	Kind error
+	// Op is the operation that failed, for example "user.GetByID".
+	Op string
+	// Err is the underlying error, usually returned by the backend.
+	Err error
+}
+
+// Error returns the error's message.
+func (err *Error) Error() string {
+	switch {
+	case err.Kind != nil && err.Err != nil:
+		return fmt.Sprintf("%s: %v: %v", err.Op, err.Kind, err.Err)
+	case err.Kind != nil:
+		return fmt.Sprintf("%s: %v", err.Op, err.Kind)
+	}
+
+	return fmt.Sprintf("%s: %v", err.Op, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Is reports whether the error is of the target kind.
+func (err *Error) Is(target error) bool {
+	return err.Kind != nil && err.Kind == target
+}
+
+// RetryError wraps an Error, it tells when the failed operation can be attempted again.
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// Error returns the message of the wrapped error.
+func (err *RetryError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (err *RetryError) Unwrap() error {
+	return err.Err
+}
+
+// OAuthError wraps an Error returned by OAuthService, Code is the OAuth error code reported to the client,
+// for example "invalid_grant".
+type OAuthError struct {
+	Code string
+	Err  error
+}
+
+// Error returns the message of the wrapped error.
+func (err *OAuthError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (err *OAuthError) Unwrap() error {
+	return err.Err
+}
+
+// newError creates an Error of a specific kind.
+func newError(op string, kind error, format string, args ...interface{}) error {
+	var err error
+	if len(format) > 0 {
+		err = fmt.Errorf(format, args...)
+	}
+
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// wrapError converts an error returned by a backend into an Error.
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch {
+	case err == mgo.ErrNotFound:
+		return &Error{Kind: ErrNotFound, Op: op}
+	case mgo.IsDup(err):
+		return &Error{Kind: ErrConflict, Op: op, Err: err}
+	}
+
+	return &Error{Op: op, Err: err}
+}
+
+// parseObjectID converts a hex string into an ObjectId without panicking on malformed input.
+func parseObjectID(op string, id string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(id) {
+		return "", newError(op, ErrInvalidID, "%q is not a valid object id", id)
+	}
+
+	return bson.ObjectIdHex(id), nil
+}
@@ -1,5 +1,13 @@
 package api
 
+import (
+	"path"
+	"reflect"
+	"sync/atomic"
+)
+
+//go:generate go run ./eventgen -in events.json -out event_gen.go
+
 // Event .
 type Event interface {
 	Type() string
@@ -17,10 +25,114 @@ type EventInterfaceProvider interface {
 	Type() string
 }
 
+// PreEvent is embedded by the events called before a write, their handlers can reject the write.
+type PreEvent struct {
+	rejection string
+}
+
+// Reject aborts the write with the specified reason, the first rejection wins.
+func (event *PreEvent) Reject(reason string) {
+	if len(event.rejection) < 1 {
+		event.rejection = reason
+	}
+}
+
+// Rejection returns the reason the write was rejected for, or an empty string.
+func (event *PreEvent) Rejection() string {
+	return event.rejection
+}
+
+// EventObserver is called synchronously with every event passed to Call or CallPre, before its handlers.
+type EventObserver func(eventType string, event interface{})
+
+// redactedEvent is implemented by events carrying data that must not leave the process, the copy returned
+// by Redacted is published instead of the event.
+type redactedEvent interface {
+	Redacted() interface{}
+}
+
+// rejectableEvent is implemented by every event embedding PreEvent.
+type rejectableEvent interface {
+	Rejection() string
+}
+
 type eventHandlerInstance struct {
 	eventHandler EventHandler
+	// patterns are the event type patterns the handler subscribed to, exact handlers don't have any.
+	patterns []string
+	// removed is set to 1 once the handler is unregistered, queued events are no longer passed to it.
+	removed int32
+}
+
+// matches returns true if the handler subscribed to a pattern matching the event type.
+func (instance *eventHandlerInstance) matches(eventType string) bool {
+	for _, pattern := range instance.patterns {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRemoved returns true if the handler has been unregistered.
+func (instance *eventHandlerInstance) isRemoved() bool {
+	return atomic.LoadInt32(&instance.removed) == 1
 }
 
+// typedEventHandler adapts a handler of a single event type, it is created by Register.
+type typedEventHandler[T Event] func(*Library, T)
+
+// Handle calls the underlying handler.
+func (handler typedEventHandler[T]) Handle(library *Library, i interface{}) {
+	if event, ok := i.(T); ok {
+		handler(library, event)
+	}
+}
+
+// Type returns the event's type.
+func (handler typedEventHandler[T]) Type() string {
+	var event T
+	return event.Type()
+}
+
+// reflectEventHandler adapts a func(*Library, *XEvent) handler passed to EventManager.Register.
+type reflectEventHandler struct {
+	eventType string
+	function  reflect.Value
+}
+
+// Handle calls the underlying handler.
+func (handler reflectEventHandler) Handle(library *Library, i interface{}) {
+	if reflect.TypeOf(i) == handler.function.Type().In(1) {
+		handler.function.Call([]reflect.Value{reflect.ValueOf(library), reflect.ValueOf(i)})
+	}
+}
+
+// Type returns the event's type.
+func (handler reflectEventHandler) Type() string {
+	return handler.eventType
+}
+
+// eventProvider creates empty events of a registered type.
+type eventProvider struct {
+	eventType string
+	new       func() Event
+}
+
+// New .
+func (provider eventProvider) New() interface{} {
+	return provider.new()
+}
+
+// Type returns the event's type.
+func (provider eventProvider) Type() string {
+	return provider.eventType
+}
+
+// interfaceEventType is the type of catch-all handlers, they receive every event.
+const interfaceEventType = "__INTERFACE__"
+
 // interface
 type interfaceEventHandler func(*Library, interface{})
 
@@ -29,7 +141,7 @@ func (handler interfaceEventHandler) Handle(library *Library, i interface{}) {
 }
 
 func (handler interfaceEventHandler) Type() string {
-	return "__INTERFACE__"
+	return interfaceEventType
 }
 
 // END interface
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/vmihailenco/msgpack"
+	"time"
+)
+
+// eventCodec encodes the redisEvent envelopes exchanged on the events transport.
+type eventCodec interface {
+	// marshal encodes an envelope.
+	marshal(event redisEvent) ([]byte, error)
+	// unmarshal decodes an envelope, its event is kept encoded until its type is known.
+	unmarshal(data []byte) (*receivedRedisEvent, error)
+	// unmarshalEvent decodes the event of a received envelope.
+	unmarshalEvent(data []byte, event interface{}) error
+}
+
+// newEventCodec returns the codec with the specified name, JSON is used by default.
+func newEventCodec(name string) (eventCodec, bool) {
+	switch name {
+	case "", "json":
+		return jsonEventCodec{}, true
+	case "msgpack":
+		return msgpackEventCodec{}, true
+	}
+
+	return nil, false
+}
+
+// jsonEventCodec encodes envelopes as JSON.
+type jsonEventCodec struct{}
+
+func (jsonEventCodec) marshal(event redisEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonEventCodec) unmarshal(data []byte) (*receivedRedisEvent, error) {
+	var received receivedRedisEvent
+	err := json.Unmarshal(data, &received)
+	if err != nil {
+		return nil, err
+	}
+
+	return &received, nil
+}
+
+func (jsonEventCodec) unmarshalEvent(data []byte, event interface{}) error {
+	return json.Unmarshal(data, event)
+}
+
+// msgpackEventCodec encodes envelopes as msgpack, the event is encoded separately so it can be decoded once
+// its type is known. Field names are taken from the json tags so both codecs describe events the same way.
+type msgpackEventCodec struct{}
+
+// msgpackEnvelope represents a redisEvent encoded by the msgpack codec.
+type msgpackEnvelope struct {
+	ID        string    `msgpack:"id"`
+	Version   int       `msgpack:"version"`
+	Timestamp time.Time `msgpack:"timestamp"`
+	Origin    string    `msgpack:"origin"`
+	Type      string    `msgpack:"type"`
+	Event     []byte    `msgpack:"event"`
+}
+
+func (codec msgpackEventCodec) marshal(event redisEvent) ([]byte, error) {
+	payload, err := codec.encode(event.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.encode(msgpackEnvelope{
+		ID:        event.ID,
+		Version:   event.Version,
+		Timestamp: event.Timestamp,
+		Origin:    event.Origin,
+		Type:      event.Type,
+		Event:     payload,
+	})
+}
+
+func (codec msgpackEventCodec) unmarshal(data []byte) (*receivedRedisEvent, error) {
+	var envelope msgpackEnvelope
+	err := codec.unmarshalEvent(data, &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &receivedRedisEvent{
+		ID:        envelope.ID,
+		Version:   envelope.Version,
+		Timestamp: envelope.Timestamp,
+		Origin:    envelope.Origin,
+		Type:      envelope.Type,
+		Event:     envelope.Event,
+	}, nil
+}
+
+func (msgpackEventCodec) unmarshalEvent(data []byte, event interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.UseJSONTag(true)
+	return decoder.Decode(event)
+}
+
+// encode encodes a value using the json tags of its fields.
+func (msgpackEventCodec) encode(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := msgpack.NewEncoder(&buffer)
+	encoder.UseJSONTag(true)
+
+	err := encoder.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
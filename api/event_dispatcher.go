@@ -0,0 +1,225 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"api/logger"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dispatcher default settings.
+const (
+	dispatcherDefaultQueueSize      = 1000
+	dispatcherDefaultHandlerTimeout = 30 * time.Second
+)
+
+// EntityEvent is implemented by events describing a single entity, the events of an entity are handled in
+// the order they were called. A handler that times out keeps running while the next events of its entity are
+// handled, the order is only kept for the handlers that return in time.
+type EntityEvent interface {
+	EntityID() string
+}
+
+// EventStats represents the state of the event dispatcher.
+type EventStats struct {
+	// Queued is the amount of events waiting to be handled.
+	Queued int `json:"queued"`
+	// Workers is the amount of goroutines handling events.
+	Workers    int    `json:"workers"`
+	Dispatched uint64 `json:"dispatched"`
+	Panics     uint64 `json:"panics"`
+	Timeouts   uint64 `json:"timeouts"`
+	// Dropped is the amount of events called after the dispatcher was closed or while their queue stayed full.
+	Dropped uint64 `json:"dropped"`
+}
+
+// dispatchJob represents an event waiting to be handled.
+type dispatchJob struct {
+	eventType string
+	event     interface{}
+	handlers  []*eventHandlerInstance
+}
+
+// eventDispatcher calls event handlers on a pool of workers.
+//
+// Every worker owns a queue, events are assigned to a queue by entity ID so the events of an entity are
+// handled in order. Events that don't describe an entity are spread across the queues. Call blocks once
+// the queue of an event is full, for the handler timeout at most: the event is dropped then. Handlers calling
+// events can fill the queue of their own worker, which only drains once they return or time out.
+type eventDispatcher struct {
+	library *Library
+	timeout time.Duration
+	queues  []chan dispatchJob
+	next    uint32
+
+	closeLock sync.RWMutex
+	closed    bool
+	workers   sync.WaitGroup
+
+	dispatched uint64
+	panics     uint64
+	timeouts   uint64
+	dropped    uint64
+}
+
+// newEventDispatcher creates a new event dispatcher and starts its workers.
+func newEventDispatcher(library *Library) *eventDispatcher {
+	config := library.config.Events
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	queueSize := config.QueueSize
+	if queueSize < 1 {
+		queueSize = dispatcherDefaultQueueSize
+	}
+
+	timeout := time.Duration(config.HandlerTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = dispatcherDefaultHandlerTimeout
+	}
+
+	dispatcher := &eventDispatcher{
+		library: library,
+		timeout: timeout,
+		queues:  make([]chan dispatchJob, workers),
+	}
+
+	for i := range dispatcher.queues {
+		dispatcher.queues[i] = make(chan dispatchJob, queueSize)
+
+		dispatcher.workers.Add(1)
+		go dispatcher.work(dispatcher.queues[i])
+	}
+
+	return dispatcher
+}
+
+// dispatch queues an event for its handlers, it returns false if the dispatcher has been closed or the queue
+// of the event stayed full for the handler timeout.
+func (dispatcher *eventDispatcher) dispatch(job dispatchJob) bool {
+	dispatcher.closeLock.RLock()
+	defer dispatcher.closeLock.RUnlock()
+
+	if dispatcher.closed {
+		atomic.AddUint64(&dispatcher.dropped, 1)
+		return false
+	}
+
+	queue := dispatcher.queues[dispatcher.queueFor(job.event)]
+	select {
+	case queue <- job:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(dispatcher.timeout)
+	defer timer.Stop()
+
+	select {
+	case queue <- job:
+		return true
+	case <-timer.C:
+		atomic.AddUint64(&dispatcher.dropped, 1)
+		return false
+	}
+}
+
+// queueFor returns the index of the queue an event is assigned to, the events of an entity share a queue.
+// Their order holds as long as their handlers don't time out, see handle.
+func (dispatcher *eventDispatcher) queueFor(event interface{}) int {
+	if entity, ok := event.(EntityEvent); ok && len(entity.EntityID()) > 0 {
+		hash := fnv.New32a()
+		hash.Write([]byte(entity.EntityID()))
+		return int(hash.Sum32() % uint32(len(dispatcher.queues)))
+	}
+
+	return int(atomic.AddUint32(&dispatcher.next, 1) % uint32(len(dispatcher.queues)))
+}
+
+// work handles the events of a queue until it is closed.
+func (dispatcher *eventDispatcher) work(queue chan dispatchJob) {
+	defer dispatcher.workers.Done()
+
+	for job := range queue {
+		for _, handler := range job.handlers {
+			// Skip the handlers unregistered while the event was queued.
+			if handler.isRemoved() {
+				continue
+			}
+
+			dispatcher.handle(job.eventType, handler, job.event)
+		}
+
+		atomic.AddUint64(&dispatcher.dispatched, 1)
+	}
+}
+
+// handle calls a single handler, recovering from panics and giving up on it once the timeout is reached.
+//
+// Handlers can't be interrupted, a handler that times out keeps running in the background while the worker
+// moves on to the next one, it can then run concurrently with the handlers of the next events of its entity.
+func (dispatcher *eventDispatcher) handle(eventType string, handler *eventHandlerInstance, event interface{}) {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				atomic.AddUint64(&dispatcher.panics, 1)
+				logger.Errorw("[Events] Recovered from a panicking event handler.", "type", eventType, logger.Err(fmt.Errorf("%v", r)))
+			}
+		}()
+
+		handler.eventHandler.Handle(dispatcher.library, event)
+	}()
+
+	timer := time.NewTimer(dispatcher.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		atomic.AddUint64(&dispatcher.timeouts, 1)
+		logger.Errorw("[Events] Event handler timed out.", "type", eventType, "timeout", dispatcher.timeout.String())
+	}
+}
+
+// stats returns the current state of the dispatcher.
+func (dispatcher *eventDispatcher) stats() EventStats {
+	stats := EventStats{
+		Workers:    len(dispatcher.queues),
+		Dispatched: atomic.LoadUint64(&dispatcher.dispatched),
+		Panics:     atomic.LoadUint64(&dispatcher.panics),
+		Timeouts:   atomic.LoadUint64(&dispatcher.timeouts),
+		Dropped:    atomic.LoadUint64(&dispatcher.dropped),
+	}
+
+	for _, queue := range dispatcher.queues {
+		stats.Queued += len(queue)
+	}
+
+	return stats
+}
+
+// close stops accepting events and waits for the queued ones to be handled.
+func (dispatcher *eventDispatcher) close() {
+	dispatcher.closeLock.Lock()
+	if dispatcher.closed {
+		dispatcher.closeLock.Unlock()
+		return
+	}
+
+	dispatcher.closed = true
+	for _, queue := range dispatcher.queues {
+		close(queue)
+	}
+	dispatcher.closeLock.Unlock()
+
+	dispatcher.workers.Wait()
+}
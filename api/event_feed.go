@@ -0,0 +1,119 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// Event feed settings.
+const (
+	eventFeedHistory    = 1000
+	eventFeedBufferSize = 256
+)
+
+// FeedEvent represents an event passed to the listeners of the event feed, the event is redacted.
+type FeedEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Event     interface{} `json:"event"`
+}
+
+// EntityID returns the id of the entity the event describes, or an empty string.
+func (event FeedEvent) EntityID() string {
+	if entity, ok := event.Event.(EntityEvent); ok {
+		return entity.EntityID()
+	}
+
+	return ""
+}
+
+// eventFeed keeps the latest events called on, or received by, this instance and passes new ones to its
+// listeners. Pre-events and replayed events are not part of the feed.
+type eventFeed struct {
+	lock      sync.Mutex
+	history   []FeedEvent
+	listeners map[*FeedListener]struct{}
+}
+
+// newEventFeed creates an empty event feed.
+func newEventFeed() *eventFeed {
+	return &eventFeed{
+		history:   make([]FeedEvent, 0, eventFeedHistory),
+		listeners: map[*FeedListener]struct{}{},
+	}
+}
+
+// publish adds an event to the history and passes it to the listeners, listeners that fall behind are closed.
+func (feed *eventFeed) publish(event FeedEvent) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	if len(feed.history) >= eventFeedHistory {
+		copy(feed.history, feed.history[1:])
+		feed.history = feed.history[:len(feed.history)-1]
+	}
+	feed.history = append(feed.history, event)
+
+	for listener := range feed.listeners {
+		select {
+		case listener.events <- event:
+		default:
+			feed.remove(listener)
+		}
+	}
+}
+
+// remove closes a listener, the feed's lock must be held.
+func (feed *eventFeed) remove(listener *FeedListener) {
+	if _, ok := feed.listeners[listener]; ok {
+		delete(feed.listeners, listener)
+		close(listener.events)
+	}
+}
+
+// Listen returns a listener receiving every new event of the feed.
+//
+// If lastEventID is set, the events that followed it are returned so a client can resume where it left off.
+// resumed is false if the event is no longer part of the history, in which case no events are returned.
+func (manager *EventManager) Listen(lastEventID string) (listener *FeedListener, missed []FeedEvent, resumed bool) {
+	feed := manager.feed
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	listener = &FeedListener{feed: feed, events: make(chan FeedEvent, eventFeedBufferSize)}
+	feed.listeners[listener] = struct{}{}
+
+	if len(lastEventID) < 1 {
+		return listener, nil, true
+	}
+
+	for i := len(feed.history) - 1; i >= 0; i-- {
+		if feed.history[i].ID == lastEventID {
+			missed = make([]FeedEvent, len(feed.history)-i-1)
+			copy(missed, feed.history[i+1:])
+			return listener, missed, true
+		}
+	}
+
+	return listener, nil, false
+}
+
+// FeedListener receives the events of the event feed.
+type FeedListener struct {
+	feed   *eventFeed
+	events chan FeedEvent
+}
+
+// Events returns the channel receiving the events, it is closed once the listener is closed or falls behind.
+func (listener *FeedListener) Events() <-chan FeedEvent {
+	return listener.events
+}
+
+// Close stops the listener, it can be called more than once.
+func (listener *FeedListener) Close() {
+	listener.feed.lock.Lock()
+	defer listener.feed.lock.Unlock()
+
+	listener.feed.remove(listener)
+}
@@ -0,0 +1,842 @@
+// Code generated by eventgen from events.json. DO NOT EDIT.
+
+package api
+
+// GroupCreateEventType holds the event type string for this event.
+const GroupCreateEventType = "group_create"
+
+// GroupCreateEvent .
+type GroupCreateEvent struct {
+	Group *Group `json:"group"`
+}
+
+// Type returns the event's type.
+func (event *GroupCreateEvent) Type() string {
+	return GroupCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *GroupCreateEvent) EntityID() string {
+	if event.Group == nil {
+		return ""
+	}
+
+	return event.Group.ID.Hex()
+}
+
+// GroupDeleteEventType holds the event type string for this event.
+const GroupDeleteEventType = "group_delete"
+
+// GroupDeleteEvent .
+type GroupDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *GroupDeleteEvent) Type() string {
+	return GroupDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *GroupDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// GroupPreDeleteEventType holds the event type string for this event.
+const GroupPreDeleteEventType = "group_pre_delete"
+
+// GroupPreDeleteEvent is called before a group is deleted, handlers can reject the deletion.
+type GroupPreDeleteEvent struct {
+	PreEvent
+	Group *Group `json:"group"`
+}
+
+// Type returns the event's type.
+func (event *GroupPreDeleteEvent) Type() string {
+	return GroupPreDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *GroupPreDeleteEvent) EntityID() string {
+	if event.Group == nil {
+		return ""
+	}
+
+	return event.Group.ID.Hex()
+}
+
+// GroupUpdateEventType holds the event type string for this event.
+const GroupUpdateEventType = "group_update"
+
+// GroupUpdateEvent .
+type GroupUpdateEvent struct {
+	Group *Group `json:"group"`
+}
+
+// Type returns the event's type.
+func (event *GroupUpdateEvent) Type() string {
+	return GroupUpdateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *GroupUpdateEvent) EntityID() string {
+	if event.Group == nil {
+		return ""
+	}
+
+	return event.Group.ID.Hex()
+}
+
+// InternalTokenCreateEventType holds the event type string for this event.
+const InternalTokenCreateEventType = "internal_token_create"
+
+// InternalTokenCreateEvent .
+type InternalTokenCreateEvent struct {
+	InternalToken *InternalToken `json:"internalToken"`
+}
+
+// Type returns the event's type.
+func (event *InternalTokenCreateEvent) Type() string {
+	return InternalTokenCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *InternalTokenCreateEvent) EntityID() string {
+	if event.InternalToken == nil {
+		return ""
+	}
+
+	return event.InternalToken.ID.Hex()
+}
+
+// Redacted returns a copy of the event that is safe to publish.
+func (event *InternalTokenCreateEvent) Redacted() interface{} {
+	redacted := *event
+	if redacted.InternalToken != nil {
+		redacted.InternalToken = redacted.InternalToken.redacted()
+	}
+
+	return &redacted
+}
+
+// InternalTokenDeleteEventType holds the event type string for this event.
+const InternalTokenDeleteEventType = "internal_token_delete"
+
+// InternalTokenDeleteEvent .
+type InternalTokenDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *InternalTokenDeleteEvent) Type() string {
+	return InternalTokenDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *InternalTokenDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// InternalTokenRotateEventType holds the event type string for this event.
+const InternalTokenRotateEventType = "internal_token_rotate"
+
+// InternalTokenRotateEvent is called when an internal token is replaced, Previous is the id of the token being retired.
+type InternalTokenRotateEvent struct {
+	InternalToken *InternalToken `json:"internalToken"`
+	Previous      string         `json:"previous"`
+}
+
+// Type returns the event's type.
+func (event *InternalTokenRotateEvent) Type() string {
+	return InternalTokenRotateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *InternalTokenRotateEvent) EntityID() string {
+	if event.InternalToken == nil {
+		return ""
+	}
+
+	return event.InternalToken.ID.Hex()
+}
+
+// Redacted returns a copy of the event that is safe to publish.
+func (event *InternalTokenRotateEvent) Redacted() interface{} {
+	redacted := *event
+	if redacted.InternalToken != nil {
+		redacted.InternalToken = redacted.InternalToken.redacted()
+	}
+
+	return &redacted
+}
+
+// LoginFailEventType holds the event type string for this event.
+const LoginFailEventType = "login_fail"
+
+// LoginFailEvent is called when a login attempt fails, User is empty if the account doesn't exist.
+type LoginFailEvent struct {
+	Account  string `json:"account"`
+	User     string `json:"user"`
+	Address  string `json:"address"`
+	Attempts int    `json:"attempts"`
+}
+
+// Type returns the event's type.
+func (event *LoginFailEvent) Type() string {
+	return LoginFailEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *LoginFailEvent) EntityID() string {
+	return event.Account
+}
+
+// LoginLockEventType holds the event type string for this event.
+const LoginLockEventType = "login_lock"
+
+// LoginLockEvent is called when too many failed login attempts lock an account, or block an address if Account is empty. Duration is in seconds.
+type LoginLockEvent struct {
+	Account  string `json:"account"`
+	User     string `json:"user"`
+	Address  string `json:"address"`
+	Duration int    `json:"duration"`
+}
+
+// Type returns the event's type.
+func (event *LoginLockEvent) Type() string {
+	return LoginLockEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *LoginLockEvent) EntityID() string {
+	return event.Account
+}
+
+// LoginUnlockEventType holds the event type string for this event.
+const LoginUnlockEventType = "login_unlock"
+
+// LoginUnlockEvent is called when a staff member unlocks an account.
+type LoginUnlockEvent struct {
+	Account string `json:"account"`
+	User    string `json:"user"`
+	Staff   string `json:"staff"`
+}
+
+// Type returns the event's type.
+func (event *LoginUnlockEvent) Type() string {
+	return LoginUnlockEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *LoginUnlockEvent) EntityID() string {
+	return event.Account
+}
+
+// OAuthClientCreateEventType holds the event type string for this event.
+const OAuthClientCreateEventType = "oauth_client_create"
+
+// OAuthClientCreateEvent .
+type OAuthClientCreateEvent struct {
+	OAuthClient *OAuthClient `json:"oauthClient"`
+}
+
+// Type returns the event's type.
+func (event *OAuthClientCreateEvent) Type() string {
+	return OAuthClientCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *OAuthClientCreateEvent) EntityID() string {
+	if event.OAuthClient == nil {
+		return ""
+	}
+
+	return event.OAuthClient.ID.Hex()
+}
+
+// Redacted returns a copy of the event that is safe to publish.
+func (event *OAuthClientCreateEvent) Redacted() interface{} {
+	redacted := *event
+	if redacted.OAuthClient != nil {
+		redacted.OAuthClient = redacted.OAuthClient.redacted()
+	}
+
+	return &redacted
+}
+
+// OAuthClientDeleteEventType holds the event type string for this event.
+const OAuthClientDeleteEventType = "oauth_client_delete"
+
+// OAuthClientDeleteEvent .
+type OAuthClientDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *OAuthClientDeleteEvent) Type() string {
+	return OAuthClientDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *OAuthClientDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// OAuthConsentGrantEventType holds the event type string for this event.
+const OAuthConsentGrantEventType = "oauth_consent_grant"
+
+// OAuthConsentGrantEvent is called when a user grants scopes to an OAuth client, Scope lists every scope the client has been granted.
+type OAuthConsentGrantEvent struct {
+	User   string `json:"user"`
+	Client string `json:"client"`
+	Scope  string `json:"scope"`
+}
+
+// Type returns the event's type.
+func (event *OAuthConsentGrantEvent) Type() string {
+	return OAuthConsentGrantEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *OAuthConsentGrantEvent) EntityID() string {
+	return event.User
+}
+
+// OAuthConsentRevokeEventType holds the event type string for this event.
+const OAuthConsentRevokeEventType = "oauth_consent_revoke"
+
+// OAuthConsentRevokeEvent is called when a user revokes the access of an OAuth client, its tokens are revoked as well.
+type OAuthConsentRevokeEvent struct {
+	User   string `json:"user"`
+	Client string `json:"client"`
+}
+
+// Type returns the event's type.
+func (event *OAuthConsentRevokeEvent) Type() string {
+	return OAuthConsentRevokeEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *OAuthConsentRevokeEvent) EntityID() string {
+	return event.User
+}
+
+// PunishmentCreateEventType holds the event type string for this event.
+const PunishmentCreateEventType = "punishment_create"
+
+// PunishmentCreateEvent .
+type PunishmentCreateEvent struct {
+	Punishment *Punishment `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *PunishmentCreateEvent) Type() string {
+	return PunishmentCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *PunishmentCreateEvent) EntityID() string {
+	if event.Punishment == nil {
+		return ""
+	}
+
+	return event.Punishment.ID.Hex()
+}
+
+// PunishmentDeleteEventType holds the event type string for this event.
+const PunishmentDeleteEventType = "punishment_delete"
+
+// PunishmentDeleteEvent .
+type PunishmentDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *PunishmentDeleteEvent) Type() string {
+	return PunishmentDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *PunishmentDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// PunishmentPreCreateEventType holds the event type string for this event.
+const PunishmentPreCreateEventType = "punishment_pre_create"
+
+// PunishmentPreCreateEvent is called before a punishment is created, handlers can mutate the punishment or reject the write.
+type PunishmentPreCreateEvent struct {
+	PreEvent
+	Punishment *Punishment `json:"punishment"`
+}
+
+// Type returns the event's type.
+func (event *PunishmentPreCreateEvent) Type() string {
+	return PunishmentPreCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *PunishmentPreCreateEvent) EntityID() string {
+	if event.Punishment == nil {
+		return ""
+	}
+
+	return event.Punishment.ID.Hex()
+}
+
+// PunishmentUpdateEventType holds the event type string for this event.
+const PunishmentUpdateEventType = "punishment_update"
+
+// PunishmentUpdateEvent .
+type PunishmentUpdateEvent struct {
+	Punishment *Punishment `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *PunishmentUpdateEvent) Type() string {
+	return PunishmentUpdateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *PunishmentUpdateEvent) EntityID() string {
+	if event.Punishment == nil {
+		return ""
+	}
+
+	return event.Punishment.ID.Hex()
+}
+
+// SigningKeyDeleteEventType holds the event type string for this event.
+const SigningKeyDeleteEventType = "signing_key_delete"
+
+// SigningKeyDeleteEvent is called when a compromised signing key is deleted, the instances stop accepting the tokens it signed.
+type SigningKeyDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *SigningKeyDeleteEvent) Type() string {
+	return SigningKeyDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *SigningKeyDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// SigningKeyRotateEventType holds the event type string for this event.
+const SigningKeyRotateEventType = "signing_key_rotate"
+
+// SigningKeyRotateEvent is called when a new signing key replaces the active one, the instances reload their keys.
+type SigningKeyRotateEvent struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`
+}
+
+// Type returns the event's type.
+func (event *SigningKeyRotateEvent) Type() string {
+	return SigningKeyRotateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *SigningKeyRotateEvent) EntityID() string {
+	return event.ID
+}
+
+// TicketCreateEventType holds the event type string for this event.
+const TicketCreateEventType = "ticket_create"
+
+// TicketCreateEvent .
+type TicketCreateEvent struct {
+	Ticket *Ticket `json:"ticket"`
+}
+
+// Type returns the event's type.
+func (event *TicketCreateEvent) Type() string {
+	return TicketCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TicketCreateEvent) EntityID() string {
+	if event.Ticket == nil {
+		return ""
+	}
+
+	return event.Ticket.ID.Hex()
+}
+
+// TicketDeleteEventType holds the event type string for this event.
+const TicketDeleteEventType = "ticket_delete"
+
+// TicketDeleteEvent .
+type TicketDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *TicketDeleteEvent) Type() string {
+	return TicketDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TicketDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// TicketUpdateEventType holds the event type string for this event.
+const TicketUpdateEventType = "ticket_update"
+
+// TicketUpdateEvent .
+type TicketUpdateEvent struct {
+	Ticket *Ticket `json:"ticket"`
+}
+
+// Type returns the event's type.
+func (event *TicketUpdateEvent) Type() string {
+	return TicketUpdateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TicketUpdateEvent) EntityID() string {
+	if event.Ticket == nil {
+		return ""
+	}
+
+	return event.Ticket.ID.Hex()
+}
+
+// TokenCreateEventType holds the event type string for this event.
+const TokenCreateEventType = "token_create"
+
+// TokenCreateEvent .
+type TokenCreateEvent struct {
+	Token *Token `json:"token"`
+}
+
+// Type returns the event's type.
+func (event *TokenCreateEvent) Type() string {
+	return TokenCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TokenCreateEvent) EntityID() string {
+	if event.Token == nil {
+		return ""
+	}
+
+	return event.Token.ID.Hex()
+}
+
+// Redacted returns a copy of the event that is safe to publish.
+func (event *TokenCreateEvent) Redacted() interface{} {
+	redacted := *event
+	if redacted.Token != nil {
+		redacted.Token = redacted.Token.redacted()
+	}
+
+	return &redacted
+}
+
+// TokenDeleteEventType holds the event type string for this event.
+const TokenDeleteEventType = "token_delete"
+
+// TokenDeleteEvent .
+type TokenDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *TokenDeleteEvent) Type() string {
+	return TokenDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TokenDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// TokenFamilyRevokeEventType holds the event type string for this event.
+const TokenFamilyRevokeEventType = "token_family_revoke"
+
+// TokenFamilyRevokeEvent is called when every token issued from a refresh token family is revoked, on logout or once a used refresh token is presented again.
+type TokenFamilyRevokeEvent struct {
+	Family string `json:"family"`
+	User   string `json:"user"`
+	Reason string `json:"reason"`
+}
+
+// Type returns the event's type.
+func (event *TokenFamilyRevokeEvent) Type() string {
+	return TokenFamilyRevokeEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TokenFamilyRevokeEvent) EntityID() string {
+	return event.Family
+}
+
+// TokenRefreshEventType holds the event type string for this event.
+const TokenRefreshEventType = "token_refresh"
+
+// TokenRefreshEvent is called when a refresh token is rotated, Token is the new access token.
+type TokenRefreshEvent struct {
+	Token    *Token `json:"token"`
+	Family   string `json:"family"`
+	Previous string `json:"previous"`
+}
+
+// Type returns the event's type.
+func (event *TokenRefreshEvent) Type() string {
+	return TokenRefreshEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TokenRefreshEvent) EntityID() string {
+	if event.Token == nil {
+		return ""
+	}
+
+	return event.Token.ID.Hex()
+}
+
+// Redacted returns a copy of the event that is safe to publish.
+func (event *TokenRefreshEvent) Redacted() interface{} {
+	redacted := *event
+	if redacted.Token != nil {
+		redacted.Token = redacted.Token.redacted()
+	}
+
+	return &redacted
+}
+
+// TokenRevokeAllEventType holds the event type string for this event.
+const TokenRevokeAllEventType = "token_revoke_all"
+
+// TokenRevokeAllEvent is called once every token of a user has been revoked, Count is the amount of revoked access tokens.
+type TokenRevokeAllEvent struct {
+	User  string `json:"user"`
+	Count int    `json:"count"`
+}
+
+// Type returns the event's type.
+func (event *TokenRevokeAllEvent) Type() string {
+	return TokenRevokeAllEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TokenRevokeAllEvent) EntityID() string {
+	return event.User
+}
+
+// TwoFactorDisableEventType holds the event type string for this event.
+const TwoFactorDisableEventType = "two_factor_disable"
+
+// TwoFactorDisableEvent is called when the two-factor authentication of a user is disabled, Staff is the staff member who disabled it or empty if it was the user.
+type TwoFactorDisableEvent struct {
+	User  string `json:"user"`
+	Staff string `json:"staff"`
+}
+
+// Type returns the event's type.
+func (event *TwoFactorDisableEvent) Type() string {
+	return TwoFactorDisableEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TwoFactorDisableEvent) EntityID() string {
+	return event.User
+}
+
+// TwoFactorEnableEventType holds the event type string for this event.
+const TwoFactorEnableEventType = "two_factor_enable"
+
+// TwoFactorEnableEvent is called when a user enables two-factor authentication.
+type TwoFactorEnableEvent struct {
+	User string `json:"user"`
+}
+
+// Type returns the event's type.
+func (event *TwoFactorEnableEvent) Type() string {
+	return TwoFactorEnableEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TwoFactorEnableEvent) EntityID() string {
+	return event.User
+}
+
+// TwoFactorRecoveryUseEventType holds the event type string for this event.
+const TwoFactorRecoveryUseEventType = "two_factor_recovery_use"
+
+// TwoFactorRecoveryUseEvent is called when a user logs in with a recovery code, Remaining is the amount of unused codes.
+type TwoFactorRecoveryUseEvent struct {
+	User      string `json:"user"`
+	Remaining int    `json:"remaining"`
+}
+
+// Type returns the event's type.
+func (event *TwoFactorRecoveryUseEvent) Type() string {
+	return TwoFactorRecoveryUseEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *TwoFactorRecoveryUseEvent) EntityID() string {
+	return event.User
+}
+
+// UserCreateEventType holds the event type string for this event.
+const UserCreateEventType = "user_create"
+
+// UserCreateEvent .
+type UserCreateEvent struct {
+	User *User `json:"user"`
+}
+
+// Type returns the event's type.
+func (event *UserCreateEvent) Type() string {
+	return UserCreateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *UserCreateEvent) EntityID() string {
+	if event.User == nil {
+		return ""
+	}
+
+	return event.User.ID.Hex()
+}
+
+// UserDeleteEventType holds the event type string for this event.
+const UserDeleteEventType = "user_delete"
+
+// UserDeleteEvent .
+type UserDeleteEvent struct {
+	ID string `json:"id"`
+}
+
+// Type returns the event's type.
+func (event *UserDeleteEvent) Type() string {
+	return UserDeleteEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *UserDeleteEvent) EntityID() string {
+	return event.ID
+}
+
+// UserLoginEventType holds the event type string for this event.
+const UserLoginEventType = "user_login"
+
+// UserLoginEvent .
+type UserLoginEvent struct {
+	User  *User  `json:"user"`
+	Token *Token `json:"token"`
+}
+
+// Type returns the event's type.
+func (event *UserLoginEvent) Type() string {
+	return UserLoginEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *UserLoginEvent) EntityID() string {
+	if event.User == nil {
+		return ""
+	}
+
+	return event.User.ID.Hex()
+}
+
+// Redacted returns a copy of the event that is safe to publish.
+func (event *UserLoginEvent) Redacted() interface{} {
+	redacted := *event
+	if redacted.Token != nil {
+		redacted.Token = redacted.Token.redacted()
+	}
+
+	return &redacted
+}
+
+// UserPreUpdateEventType holds the event type string for this event.
+const UserPreUpdateEventType = "user_pre_update"
+
+// UserPreUpdateEvent is called before a user is updated, handlers can mutate the user or reject the write.
+type UserPreUpdateEvent struct {
+	PreEvent
+	User *User `json:"user"`
+}
+
+// Type returns the event's type.
+func (event *UserPreUpdateEvent) Type() string {
+	return UserPreUpdateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *UserPreUpdateEvent) EntityID() string {
+	if event.User == nil {
+		return ""
+	}
+
+	return event.User.ID.Hex()
+}
+
+// UserUpdateEventType holds the event type string for this event.
+const UserUpdateEventType = "user_update"
+
+// UserUpdateEvent .
+type UserUpdateEvent struct {
+	User *User `json:"user"`
+}
+
+// Type returns the event's type.
+func (event *UserUpdateEvent) Type() string {
+	return UserUpdateEventType
+}
+
+// EntityID returns the id of the entity the event describes.
+func (event *UserUpdateEvent) EntityID() string {
+	if event.User == nil {
+		return ""
+	}
+
+	return event.User.ID.Hex()
+}
+
+func init() {
+	registerEvent(func() Event { return &GroupCreateEvent{} })
+	registerEvent(func() Event { return &GroupDeleteEvent{} })
+	registerEvent(func() Event { return &GroupPreDeleteEvent{} })
+	registerEvent(func() Event { return &GroupUpdateEvent{} })
+	registerEvent(func() Event { return &InternalTokenCreateEvent{} })
+	registerEvent(func() Event { return &InternalTokenDeleteEvent{} })
+	registerEvent(func() Event { return &InternalTokenRotateEvent{} })
+	registerEvent(func() Event { return &LoginFailEvent{} })
+	registerEvent(func() Event { return &LoginLockEvent{} })
+	registerEvent(func() Event { return &LoginUnlockEvent{} })
+	registerEvent(func() Event { return &OAuthClientCreateEvent{} })
+	registerEvent(func() Event { return &OAuthClientDeleteEvent{} })
+	registerEvent(func() Event { return &OAuthConsentGrantEvent{} })
+	registerEvent(func() Event { return &OAuthConsentRevokeEvent{} })
+	registerEvent(func() Event { return &PunishmentCreateEvent{} })
+	registerEvent(func() Event { return &PunishmentDeleteEvent{} })
+	registerEvent(func() Event { return &PunishmentPreCreateEvent{} })
+	registerEvent(func() Event { return &PunishmentUpdateEvent{} })
+	registerEvent(func() Event { return &SigningKeyDeleteEvent{} })
+	registerEvent(func() Event { return &SigningKeyRotateEvent{} })
+	registerEvent(func() Event { return &TicketCreateEvent{} })
+	registerEvent(func() Event { return &TicketDeleteEvent{} })
+	registerEvent(func() Event { return &TicketUpdateEvent{} })
+	registerEvent(func() Event { return &TokenCreateEvent{} })
+	registerEvent(func() Event { return &TokenDeleteEvent{} })
+	registerEvent(func() Event { return &TokenFamilyRevokeEvent{} })
+	registerEvent(func() Event { return &TokenRefreshEvent{} })
+	registerEvent(func() Event { return &TokenRevokeAllEvent{} })
+	registerEvent(func() Event { return &TwoFactorDisableEvent{} })
+	registerEvent(func() Event { return &TwoFactorEnableEvent{} })
+	registerEvent(func() Event { return &TwoFactorRecoveryUseEvent{} })
+	registerEvent(func() Event { return &UserCreateEvent{} })
+	registerEvent(func() Event { return &UserDeleteEvent{} })
+	registerEvent(func() Event { return &UserLoginEvent{} })
+	registerEvent(func() Event { return &UserPreUpdateEvent{} })
+	registerEvent(func() Event { return &UserUpdateEvent{} })
+}
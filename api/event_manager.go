@@ -2,39 +2,133 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
 	"api/logger"
+	"reflect"
 	"sync"
+	"time"
 )
 
+// eventSchemaVersion is the version of the redisEvent envelope, it is incremented on breaking changes.
+const eventSchemaVersion = 1
+
 type redisEvent struct {
-	Type  string      `json:"type"`
-	Event interface{} `json:"event"`
+	ID        string      `json:"id"`
+	Version   int         `json:"version"`
+	Timestamp time.Time   `json:"timestamp"`
+	Origin    string      `json:"origin"`
+	Type      string      `json:"type"`
+	Event     interface{} `json:"event"`
+}
+
+// receivedRedisEvent represents a redisEvent received from the events channel, its event is still encoded
+// by the codec of the manager.
+type receivedRedisEvent struct {
+	ID        string          `json:"id"`
+	Version   int             `json:"version"`
+	Timestamp time.Time       `json:"timestamp"`
+	Origin    string          `json:"origin"`
+	Type      string          `json:"type"`
+	Event     json.RawMessage `json:"event"`
 }
 
 // EventManager represents an "egirls.me" event manager.
 type EventManager struct {
-	Library    *Library
+	Library *Library
+	// NodeID identifies the events published by this instance.
+	NodeID     string
 	handleLock sync.RWMutex
 	handlers   map[string][]*eventHandlerInstance
+	// patternHandlers are the handlers subscribed to event type patterns, such as "user_*".
+	patternHandlers []*eventHandlerInstance
+	observers       []EventObserver
+	outbox          *outboxRelay
+	transport       backend.EventTransport
+	// ownsTransport is false if the transport was passed in the config, it is then closed by its owner.
+	ownsTransport bool
+	codec         eventCodec
+	dispatcher    *eventDispatcher
+	webhooks      *webhookRelay
+	feed          *eventFeed
+	// background runs the outbox and webhook relays until the manager is closed.
+	background *background
+	closeOnce  sync.Once
 }
 
 // newEventManager will create a new event manager.
-func newEventManager(library *Library) *EventManager {
+func newEventManager(library *Library) (*EventManager, error) {
 	manager := &EventManager{
 		Library: library,
+		NodeID:  library.config.NodeID,
+	}
+	if len(manager.NodeID) < 1 {
+		manager.NodeID = bson.NewObjectId().Hex()
+	}
+
+	codec, ok := newEventCodec(library.config.Events.Codec)
+	if !ok {
+		return nil, fmt.Errorf("unknown events codec %q", library.config.Events.Codec)
+	}
+	manager.codec = codec
+
+	transport, owned, err := manager.newEventTransport()
+	if err != nil {
+		return nil, err
 	}
+	manager.transport = transport
+	manager.ownsTransport = owned
 
-	return manager
+	manager.outbox = newOutboxRelay(manager)
+	manager.dispatcher = newEventDispatcher(library)
+	manager.webhooks = newWebhookRelay(manager)
+	manager.feed = newEventFeed()
+
+	// Start relaying committed events to the events channel and the webhooks.
+	manager.background = newBackground()
+	manager.background.run(manager.outbox.run)
+	manager.background.run(manager.webhooks.run)
+
+	// Start receiving the events published by other instances.
+	go manager.subscribe()
+
+	return manager, nil
 }
 
-// Register registers an event handler.
-func (manager *EventManager) Register(i interface{}) {
+// Register registers a typed event handler, T is a pointer to an event type such as *UserCreateEvent.
+func Register[T Event](manager *EventManager, handler func(*Library, T)) *EventSubscription {
+	eventHandler := typedEventHandler[T](handler)
+
+	if _, ok := registeredInterfaceProviders[eventHandler.Type()]; !ok {
+		logger.Errorw("[Events] Registered a handler for an unregistered event.", "type", eventHandler.Type())
+	}
+
+	return manager.register(eventHandler)
+}
+
+// Register registers an event handler, either an EventHandler, a func(*Library, *XEvent) where XEvent is a
+// registered event, or a func(*Library, interface{}) which receives every event. It returns nil if the
+// handler has an unsupported signature.
+//
+// Deprecated: use the Register function, handlers with an unsupported signature are only reported at runtime.
+func (manager *EventManager) Register(i interface{}) *EventSubscription {
 	// Get the proper event handler.
 	handler := getHandlerForInterface(i)
 
 	// Check if the handler is nil
 	if handler == nil {
-		return
+		logger.Errorw("[Events] Ignored an event handler with an unsupported signature.", "handler", fmt.Sprintf("%T", i))
+		return nil
+	}
+
+	return manager.register(handler)
+}
+
+// register adds a handler to the handlers of its event type, catch-all handlers subscribe to "*".
+func (manager *EventManager) register(handler EventHandler) *EventSubscription {
+	if handler.Type() == interfaceEventType {
+		return manager.subscribePatterns(handler, []string{"*"})
 	}
 
 	manager.handleLock.Lock()
@@ -45,120 +139,339 @@ func (manager *EventManager) Register(i interface{}) {
 		manager.handlers = map[string][]*eventHandlerInstance{}
 	}
 
-	eventHandler := &eventHandlerInstance{handler}
+	eventHandler := &eventHandlerInstance{eventHandler: handler}
 	manager.handlers[handler.Type()] = append(manager.handlers[handler.Type()], eventHandler)
+
+	return &EventSubscription{manager: manager, instance: eventHandler}
+}
+
+// Observe adds an observer receiving every event called on this instance, events received from other
+// instances aren't observed. It is meant for tests, see the apitest package.
+func (manager *EventManager) Observe(observer EventObserver) {
+	manager.handleLock.Lock()
+	defer manager.handleLock.Unlock()
+
+	manager.observers = append(manager.observers, observer)
+}
+
+// observe passes an event to the observers.
+func (manager *EventManager) observe(eventType string, i interface{}) {
+	manager.handleLock.RLock()
+	observers := manager.observers
+	manager.handleLock.RUnlock()
+
+	for _, observer := range observers {
+		observer(eventType, i)
+	}
 }
 
 // Call calls registered event handlers for the event passed into the function.
-func (manager *EventManager) Call(i interface{}) {
+//
+// Services only call events once the write they describe has succeeded, events describing the write of a
+// single document are recorded with it by insert, update and remove instead. The event is recorded to the
+// outbox before the handlers are called, it is then relayed to the events channel in the background. An error
+// is returned if it couldn't be recorded, the handlers aren't called then.
+func (manager *EventManager) Call(i interface{}) error {
+	event, err := manager.prepare(i, &outboxEntry{})
+	if err != nil {
+		return err
+	}
+
+	event.dispatch()
+	return nil
+}
+
+// insert inserts a document along with the outbox entry of the event describing it, see outboxRelay.
+func (manager *EventManager) insert(collection string, id interface{}, document interface{}, i interface{}) error {
+	event, err := manager.prepare(i, &outboxEntry{Pending: true, Collection: collection, Document: id})
+	if err != nil {
+		return err
+	}
+
+	marked, err := toMarkedDocument(document, event.entry.ID)
+	if err == nil {
+		err = manager.Library.Storage.C(collection).Insert(marked)
+	}
+
+	return event.complete(err)
+}
+
+// update updates the document matching a filter along with the outbox entry of the event describing it, the
+// filter must match the document with the specified id.
+func (manager *EventManager) update(collection string, id interface{}, filter backend.Filter, update backend.Update, i interface{}) error {
+	event, err := manager.prepare(i, &outboxEntry{Pending: true, Collection: collection, Document: id})
+	if err != nil {
+		return err
+	}
+
+	update.Push = map[string]interface{}{outboxMarkerField: event.entry.ID}
+	return event.complete(manager.Library.Storage.C(collection).Update(filter, update))
+}
+
+// remove removes a document and records the event describing it to the outbox, the event is relayed once the
+// document is gone.
+func (manager *EventManager) remove(collection string, id interface{}, i interface{}) error {
+	event, err := manager.prepare(i, &outboxEntry{Pending: true, Collection: collection, Document: id, Removes: true})
+	if err != nil {
+		return err
+	}
+
+	return event.complete(manager.Library.Storage.C(collection).RemoveId(id))
+}
+
+// preparedEvent represents an event that has been recorded to the outbox, its handlers haven't been called yet.
+type preparedEvent struct {
+	manager   *EventManager
+	entry     *outboxEntry
+	eventType string
+	event     redisEvent
+	local     interface{}
+}
+
+// prepare encodes an event and records it to the outbox with the specified entry.
+func (manager *EventManager) prepare(i interface{}, entry *outboxEntry) (*preparedEvent, error) {
 	eventType := getTypeFromInterface(i)
 
 	// Check if there was no event type found.
 	if len(eventType) == 0 {
-		return
+		return nil, fmt.Errorf("%T is not a registered event", i)
 	}
 
-	go func() {
-		data, err := json.Marshal(redisEvent{
-			Type:  eventType,
-			Event: i,
-		})
-		if err != nil {
-			logger.Errorw("[Events] Failed to json#Marshal event data.")
-			return
-		}
+	event := redisEvent{
+		ID:        bson.NewObjectId().Hex(),
+		Version:   eventSchemaVersion,
+		Timestamp: time.Now(),
+		Origin:    manager.NodeID,
+		Type:      eventType,
+		Event:     i,
+	}
 
-		manager.Library.Redis.Client.Publish("ikuta:access:events", data)
-	}()
+	// Local handlers receive the full event, only the redacted copy is published.
+	if redacted, ok := i.(redactedEvent); ok {
+		event.Event = redacted.Redacted()
+	}
 
-	// Check if no handlers for the event type are registered.
-	if manager.handlers[eventType] == nil {
-		return
+	data, err := manager.codec.marshal(event)
+	if err != nil {
+		return nil, err
 	}
 
-	manager.handleLock.RLock()
-	defer manager.handleLock.RUnlock()
+	// Webhooks always receive JSON, whatever the codec of the events transport.
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
 
-	// Loop through registered event handlers and call it's function.
-	for _, handler := range manager.handlers[eventType] {
-		handler.eventHandler.Handle(manager.Library, i)
+	entry.EventID = event.ID
+	entry.Type = eventType
+	entry.Data = data
+	entry.Payload = payload
+	err = manager.outbox.record(entry)
+	if err != nil {
+		return nil, err
 	}
+
+	return &preparedEvent{manager: manager, entry: entry, eventType: eventType, event: event, local: i}, nil
 }
 
-func getHandlerForInterface(handler interface{}) EventHandler {
-	switch params := handler.(type) {
+// complete commits a pending event and calls its handlers if its write succeeded, or aborts it. The error
+// of the write is returned.
+func (event *preparedEvent) complete(err error) error {
+	if err != nil {
+		event.manager.outbox.abort(event.entry)
+		return err
+	}
 
-	case func(*Library, interface{}):
-		return interfaceEventHandler(params)
+	event.manager.outbox.commit(event.entry)
+	event.dispatch()
+	return nil
+}
 
-	case func(*Library, *GroupCreateEvent):
-		return groupCreateEventHandler(params)
+// dispatch passes a recorded event to the observers, the feed and the local handlers. The webhook deliveries
+// are created by the outbox relay.
+func (event *preparedEvent) dispatch() {
+	manager := event.manager
+	manager.observe(event.eventType, event.local)
 
-	case func(*Library, *GroupDeleteEvent):
-		return groupDeleteEventHandler(params)
+	manager.feed.publish(FeedEvent{
+		ID:        event.event.ID,
+		Type:      event.eventType,
+		Timestamp: event.event.Timestamp,
+		Event:     event.event.Event,
+	})
 
-	case func(*Library, *GroupUpdateEvent):
-		return groupUpdateEventHandler(params)
+	manager.callHandlers(event.eventType, event.local)
+}
 
-	case func(*Library, *PunishmentCreateEvent):
-		return punishmentCreateEventHandler(params)
+// toMarkedDocument converts a document into a bson.M holding the id of an outbox entry in its _events.
+func toMarkedDocument(document interface{}, entry bson.ObjectId) (bson.M, error) {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
 
-	case func(*Library, *PunishmentDeleteEvent):
-		return punishmentDeleteEventHandler(params)
+	marked := bson.M{}
+	err = bson.Unmarshal(data, &marked)
+	if err != nil {
+		return nil, err
+	}
 
-	case func(*Library, *PunishmentUpdateEvent):
-		return punishmentUpdateEventHandler(params)
+	marked[outboxMarkerField] = []bson.ObjectId{entry}
+	return marked, nil
+}
 
-	case func(*Library, *UserCreateEvent):
-		return userCreateEventHandler(params)
+// CallPre synchronously calls the handlers registered for a pre-event, in the order they were registered.
+//
+// Handlers can mutate the event's payload, the first one rejecting the event stops the chain and the write
+// is aborted with an ErrRejected error. A panicking handler aborts the write too.
+func (manager *EventManager) CallPre(op string, i interface{}) error {
+	eventType := getTypeFromInterface(i)
+	event, ok := i.(rejectableEvent)
+
+	// Check if the event isn't a registered pre-event, skipping it would bypass its handlers.
+	if len(eventType) == 0 || !ok {
+		return &Error{Op: op, Err: fmt.Errorf("%T is not a registered pre-event", i)}
+	}
 
-	case func(*Library, *UserDeleteEvent):
-		return userDeleteEventHandler(params)
+	manager.observe(eventType, i)
 
-	case func(*Library, *UserLoginEvent):
-		return userLoginEventHandler(params)
+	manager.handleLock.RLock()
+	handlers := manager.handlers[eventType]
+	manager.handleLock.RUnlock()
 
-	case func(*Library, *UserUpdateEvent):
-		return userUpdateEventHandler(params)
+	for _, handler := range handlers {
+		if handler.isRemoved() {
+			continue
+		}
+
+		err := manager.callPreHandler(op, handler, i)
+		if err != nil {
+			return err
+		}
 
+		if rejection := event.Rejection(); len(rejection) > 0 {
+			return newError(op, ErrRejected, "%s", rejection)
+		}
 	}
+
+	return nil
+}
+
+// callPreHandler calls a single pre-event handler, converting a panic into an error.
+func (manager *EventManager) callPreHandler(op string, handler *eventHandlerInstance, i interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorw("[Events] Recovered from a panicking pre-event handler.", logger.Err(fmt.Errorf("%v", r)))
+			err = &Error{Op: op, Err: fmt.Errorf("pre-event handler panicked: %v", r)}
+		}
+	}()
+
+	handler.eventHandler.Handle(manager.Library, i)
 	return nil
 }
 
-func getTypeFromInterface(event interface{}) string {
-	switch event.(type) {
+// callHandlers queues an event for the handlers registered for its type and the handlers subscribed to a
+// matching pattern, they are called in the background.
+func (manager *EventManager) callHandlers(eventType string, i interface{}) {
+	manager.handleLock.RLock()
+	handlers := manager.handlers[eventType]
+	for _, handler := range manager.patternHandlers {
+		if handler.matches(eventType) {
+			// Copy the exact handlers before appending so the registered slice isn't modified.
+			handlers = append(handlers[:len(handlers):len(handlers)], handler)
+		}
+	}
+	manager.handleLock.RUnlock()
 
-	case *GroupCreateEvent:
-		return GroupCreateEventType
+	// Check if no handlers for the event type are registered.
+	if len(handlers) < 1 {
+		return
+	}
 
-	case *GroupDeleteEvent:
-		return GroupDeleteEventType
+	if !manager.dispatcher.dispatch(dispatchJob{eventType: eventType, event: i, handlers: handlers}) {
+		logger.Errorw("[Events] Dropped an event called after the event manager was closed or while its queue was full.", "type", eventType)
+	}
+}
 
-	case *GroupUpdateEvent:
-		return GroupUpdateEventType
+// Stats returns the state of the event handler dispatcher.
+func (manager *EventManager) Stats() EventStats {
+	return manager.dispatcher.stats()
+}
 
-	case *PunishmentCreateEvent:
-		return PunishmentCreateEventType
+// Close stops the outbox and webhook relays, stops receiving and dispatching new events and waits for the
+// handlers of the queued ones to return. The entries left in the outbox are relayed by the other instances,
+// or once the instance starts again. Closing again does nothing.
+func (manager *EventManager) Close() {
+	manager.closeOnce.Do(func() {
+		manager.background.close()
+
+		if manager.ownsTransport {
+			err := manager.transport.Close()
+			if err != nil {
+				logger.Errorw("[Events] Failed to close the events transport.", logger.Err(err))
+			}
+		}
 
-	case *PunishmentDeleteEvent:
-		return PunishmentDeleteEventType
+		manager.dispatcher.close()
+	})
+}
 
-	case *PunishmentUpdateEvent:
-		return PunishmentUpdateEventType
+// registeredInterfaceProviders maps event types to a provider creating empty events of that type.
+var registeredInterfaceProviders = map[string]EventInterfaceProvider{}
 
-	case *UserCreateEvent:
-		return UserCreateEventType
+// registerEvent registers an event type so it can be called and decoded, it is called by the generated
+// event_gen.go file.
+func registerEvent(new func() Event) {
+	eventType := new().Type()
+	registeredInterfaceProviders[eventType] = eventProvider{eventType: eventType, new: new}
+}
 
-	case *UserDeleteEvent:
-		return UserDeleteEventType
+// getHandlerForInterface converts a handler passed to EventManager.Register into an EventHandler.
+func getHandlerForInterface(handler interface{}) EventHandler {
+	switch handler := handler.(type) {
+	case EventHandler:
+		return handler
 
-	case *UserLoginEvent:
-		return UserLoginEventType
+	case func(*Library, interface{}):
+		return interfaceEventHandler(handler)
+	}
+
+	// Look for a func(*Library, *XEvent).
+	function := reflect.ValueOf(handler)
+	if function.Kind() != reflect.Func || function.IsNil() {
+		return nil
+	}
+
+	signature := function.Type()
+	if signature.NumIn() != 2 || signature.NumOut() != 0 || signature.In(0) != reflect.TypeOf((*Library)(nil)) {
+		return nil
+	}
+
+	// Event types only return a constant, the method can be called on a nil event.
+	event, ok := reflect.Zero(signature.In(1)).Interface().(Event)
+	if !ok || signature.In(1).Kind() != reflect.Ptr {
+		return nil
+	}
+
+	eventType := getTypeFromInterface(event)
+	if len(eventType) == 0 {
+		return nil
+	}
 
-	case *UserUpdateEvent:
-		return UserUpdateEventType
+	return reflectEventHandler{eventType: eventType, function: function}
+}
+
+// getTypeFromInterface returns the type of a registered event, or an empty string.
+func getTypeFromInterface(i interface{}) string {
+	event, ok := i.(Event)
+	if !ok {
+		return ""
+	}
 
+	eventType := event.Type()
+	if _, ok := registeredInterfaceProviders[eventType]; !ok {
+		return ""
 	}
 
-	return ""
+	return eventType
 }
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"api/backend"
+	"api/logger"
+	"time"
+)
+
+// Subscriber reconnection settings.
+const (
+	subscriberMinBackoff = time.Second
+	subscriberMaxBackoff = 30 * time.Second
+)
+
+// subscribe receives the events published by other instances and calls the local handlers, subscribing
+// again with an exponential backoff whenever the subscription fails. It returns once the transport is closed.
+func (manager *EventManager) subscribe() {
+	backoff := subscriberMinBackoff
+
+	for {
+		subscribedAt := time.Now()
+		err := manager.transport.Subscribe(manager.receive)
+		if err == backend.ErrTransportClosed {
+			return
+		}
+
+		logger.Errorw("[Events] Lost the subscription to the events transport.", logger.Err(err))
+
+		// Start over from the minimum backoff if the subscription was healthy for a while.
+		if time.Since(subscribedAt) > subscriberMaxBackoff {
+			backoff = subscriberMinBackoff
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > subscriberMaxBackoff {
+			backoff = subscriberMaxBackoff
+		}
+	}
+}
+
+// receive decodes an event received from the events transport and calls the local handlers.
+func (manager *EventManager) receive(data []byte) {
+	received, event, ok := manager.decode(data)
+	if !ok {
+		return
+	}
+
+	// Events published by this instance have already been handled by Call.
+	if received.Origin == manager.NodeID {
+		return
+	}
+
+	manager.feed.publish(FeedEvent{
+		ID:        received.ID,
+		Type:      received.Type,
+		Timestamp: received.Timestamp,
+		Event:     event,
+	})
+	manager.callHandlers(received.Type, event)
+}
+
+// decode decodes a redisEvent using the codec of the manager and the registered interface providers.
+func (manager *EventManager) decode(data []byte) (*receivedRedisEvent, interface{}, bool) {
+	received, err := manager.codec.unmarshal(data)
+	if err != nil {
+		logger.Errorw("[Events] Failed to decode received event.", logger.Err(err))
+		return nil, nil, false
+	}
+
+	if received.Version > eventSchemaVersion {
+		logger.Errorw("[Events] Received an event with an unsupported schema version.", "version", received.Version)
+		return nil, nil, false
+	}
+
+	provider, ok := registeredInterfaceProviders[received.Type]
+	if !ok {
+		return nil, nil, false
+	}
+
+	event := provider.New()
+	err = manager.codec.unmarshalEvent(received.Event, event)
+	if err != nil {
+		logger.Errorw("[Events] Failed to decode received event data.", logger.Err(err))
+		return nil, nil, false
+	}
+
+	return received, event, true
+}
+
+// Replay calls the local handlers of every event stored by the events transport, starting at the specified
+// entry id. Events published by this instance are replayed too. Only the streams transport stores events.
+func (manager *EventManager) Replay(fromID string) error {
+	transport, ok := manager.transport.(backend.ReplayTransport)
+	if !ok {
+		return newError("events.Replay", ErrValidation, "the events transport doesn't store events")
+	}
+
+	return wrapError("events.Replay", transport.Replay(fromID, manager.replay))
+}
+
+// ReplaySince calls the local handlers of every event stored by the events transport since the specified time.
+func (manager *EventManager) ReplaySince(since time.Time) error {
+	transport, ok := manager.transport.(backend.ReplayTransport)
+	if !ok {
+		return newError("events.ReplaySince", ErrValidation, "the events transport doesn't store events")
+	}
+
+	return wrapError("events.ReplaySince", transport.ReplaySince(since, manager.replay))
+}
+
+// replay decodes a stored event and calls the local handlers.
+func (manager *EventManager) replay(data []byte) {
+	received, event, ok := manager.decode(data)
+	if ok {
+		manager.callHandlers(received.Type, event)
+	}
+}
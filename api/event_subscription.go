@@ -0,0 +1,77 @@
+package api
+
+import (
+	"path"
+	"sync/atomic"
+)
+
+// EventSubscription represents a registered event handler.
+type EventSubscription struct {
+	manager  *EventManager
+	instance *eventHandlerInstance
+}
+
+// Subscribe registers a handler receiving every event whose type matches one of the patterns, for example
+// "user_*" or "*". Patterns use the path.Match syntax, pre-events are never passed to pattern handlers.
+func (manager *EventManager) Subscribe(handler func(*Library, interface{}), patterns ...string) (*EventSubscription, error) {
+	if len(patterns) < 1 {
+		return nil, newError("events.Subscribe", ErrValidation, "at least one pattern is required")
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, newError("events.Subscribe", ErrValidation, "invalid pattern %q", pattern)
+		}
+	}
+
+	return manager.subscribePatterns(interfaceEventHandler(handler), patterns), nil
+}
+
+// subscribePatterns adds a handler to the pattern handlers.
+func (manager *EventManager) subscribePatterns(handler EventHandler, patterns []string) *EventSubscription {
+	manager.handleLock.Lock()
+	defer manager.handleLock.Unlock()
+
+	eventHandler := &eventHandlerInstance{eventHandler: handler, patterns: patterns}
+	manager.patternHandlers = append(manager.patternHandlers, eventHandler)
+
+	return &EventSubscription{manager: manager, instance: eventHandler}
+}
+
+// Unregister removes the handler, it can be called more than once and from inside of a handler.
+//
+// Events queued before Unregister was called are not passed to the handler anymore, a call that is already
+// running isn't interrupted.
+func (subscription *EventSubscription) Unregister() {
+	if !atomic.CompareAndSwapInt32(&subscription.instance.removed, 0, 1) {
+		return
+	}
+
+	manager := subscription.manager
+	manager.handleLock.Lock()
+	defer manager.handleLock.Unlock()
+
+	// Build new slices, the current ones may be held by queued events.
+	if len(subscription.instance.patterns) > 0 {
+		manager.patternHandlers = withoutHandler(manager.patternHandlers, subscription.instance)
+		return
+	}
+
+	eventType := subscription.instance.eventHandler.Type()
+	manager.handlers[eventType] = withoutHandler(manager.handlers[eventType], subscription.instance)
+	if len(manager.handlers[eventType]) < 1 {
+		delete(manager.handlers, eventType)
+	}
+}
+
+// withoutHandler returns a copy of handlers without the specified instance.
+func withoutHandler(handlers []*eventHandlerInstance, instance *eventHandlerInstance) []*eventHandlerInstance {
+	remaining := make([]*eventHandlerInstance, 0, len(handlers))
+	for _, handler := range handlers {
+		if handler != instance {
+			remaining = append(remaining, handler)
+		}
+	}
+
+	return remaining
+}
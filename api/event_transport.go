@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"api/backend"
+	"strings"
+)
+
+// Events channel defaults.
+const (
+	eventDefaultNamespace = "ikuta:access"
+	eventDefaultChannel   = "events"
+)
+
+// eventsChannel returns the name of the events channel, "ikuta:access:events" by default.
+func eventsChannel(config Config) string {
+	namespace := config.Events.Namespace
+	if len(namespace) < 1 {
+		namespace = eventDefaultNamespace
+	}
+
+	channel := config.Events.Channel
+	if len(channel) < 1 {
+		channel = eventDefaultChannel
+	}
+
+	return namespace + ":" + channel
+}
+
+// eventTransportName returns the name of the configured events transport.
+func eventTransportName(config Config) string {
+	switch {
+	case len(config.Events.Transport) > 0:
+		return config.Events.Transport
+	case config.Streams.Active:
+		return "streams"
+	case config.Redis.Active:
+		return "redis"
+	}
+
+	return "memory"
+}
+
+// newEventTransport creates the configured events transport, owned is false if the transport was passed
+// in the config and must not be closed by the manager.
+func (manager *EventManager) newEventTransport() (transport backend.EventTransport, owned bool, err error) {
+	library := manager.Library
+	config := library.config
+
+	if config.Events.EventTransport != nil {
+		return config.Events.EventTransport, false, nil
+	}
+
+	channel := eventsChannel(config)
+	name := eventTransportName(config)
+
+	switch name {
+	case "redis", "streams":
+		if !config.Redis.Active {
+			return nil, false, fmt.Errorf("the %s events transport requires redis to be active", name)
+		}
+
+		if name == "redis" {
+			return backend.NewRedisTransport(library.Redis, channel), true, nil
+		}
+
+		// The group must survive restarts, a new group only receives the events appended after its creation.
+		group := config.Streams.Group
+		if len(group) < 1 {
+			group = config.NodeID
+		}
+		if len(group) < 1 {
+			return nil, false, errors.New("the streams events transport requires a consumer group or a node id")
+		}
+
+		return backend.NewStreamTransport(library.Redis, backend.StreamOptions{
+			Stream:   channel + ":stream",
+			Group:    group,
+			Consumer: manager.NodeID,
+			MaxLen:   config.Streams.MaxLen,
+		}), true, nil
+
+	case "nats":
+		if len(config.Events.NATS.URL) < 1 {
+			return nil, false, errors.New("the nats events transport requires a nats url")
+		}
+
+		transport, err = backend.NewNatsTransport(config.Events.NATS.URL, strings.Replace(channel, ":", ".", -1))
+		if err != nil {
+			return nil, false, err
+		}
+
+		return transport, true, nil
+
+	case "memory":
+		return backend.NewMemoryTransport(), true, nil
+	}
+
+	return nil, false, fmt.Errorf("unknown events transport %q", name)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestStreamTransportGroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		group  string
+		nodeID string
+		ok     bool
+	}{
+		{"group", "access", "", true},
+		{"node id", "", "node-1", true},
+		{"group and node id", "access", "node-1", true},
+		{"neither", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var config Config
+			config.Redis.Active = true
+			config.Events.Transport = "streams"
+			config.Streams.Group = test.group
+			config.NodeID = test.nodeID
+
+			// The node id of the manager is random when none is configured.
+			manager := &EventManager{Library: &Library{config: config}, NodeID: "random"}
+
+			_, _, err := manager.newEventTransport()
+			if (err == nil) != test.ok {
+				t.Errorf("newEventTransport() = %v, want ok %t", err, test.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Command eventgen generates the event types of the api library and their registry entries from a JSON
+// declaration, it is run by "go generate" in the api package.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"text/template"
+)
+
+// field represents a field of an event.
+type field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	JSON string `json:"json"`
+}
+
+// declaration represents a single event declared in the JSON file.
+type declaration struct {
+	// Name is the event's name without the "Event" suffix, for example "UserCreate".
+	Name string `json:"name"`
+	// Type is the event's type string, for example "user_create".
+	Type string `json:"type"`
+	// Doc completes the "// <Name>Event" doc comment, it defaults to ".".
+	Doc string `json:"doc"`
+	// Pre marks a cancellable pre-event, it embeds PreEvent.
+	Pre bool `json:"pre"`
+	// Entity is the field identifying the entity the event describes, either a string or a pointer to a
+	// struct with an ID field.
+	Entity string `json:"entity"`
+	// Redact lists the fields replaced by their redacted() copy when the event is published.
+	Redact []string `json:"redact"`
+	Fields []field  `json:"fields"`
+}
+
+// entityType returns the type of the entity field.
+func (decl declaration) entityType() string {
+	for _, field := range decl.Fields {
+		if field.Name == decl.Entity {
+			return field.Type
+		}
+	}
+
+	return ""
+}
+
+var funcs = template.FuncMap{
+	"entityType": declaration.entityType,
+}
+
+var source = template.Must(template.New("events").Funcs(funcs).Parse(`// Code generated by eventgen from events.json. DO NOT EDIT.
+
+package api
+{{range .}}
+// {{.Name}}EventType holds the event type string for this event.
+const {{.Name}}EventType = "{{.Type}}"
+
+// {{.Name}}Event {{if .Doc}}{{.Doc}}{{else}}.{{end}}
+type {{.Name}}Event struct {
+	{{- if .Pre}}
+	PreEvent
+	{{- end}}
+	{{- range .Fields}}
+	{{.Name}} {{.Type}} ` + "`" + `json:"{{.JSON}}"` + "`" + `
+	{{- end}}
+}
+
+// Type returns the event's type.
+func (event *{{.Name}}Event) Type() string {
+	return {{.Name}}EventType
+}
+{{if .Entity}}
+// EntityID returns the id of the entity the event describes.
+func (event *{{.Name}}Event) EntityID() string {
+	{{- if eq (entityType .) "string"}}
+	return event.{{.Entity}}
+	{{- else}}
+	if event.{{.Entity}} == nil {
+		return ""
+	}
+
+	return event.{{.Entity}}.ID.Hex()
+	{{- end}}
+}
+{{end}}
+{{- if .Redact}}
+// Redacted returns a copy of the event that is safe to publish.
+func (event *{{.Name}}Event) Redacted() interface{} {
+	redacted := *event
+	{{- range .Redact}}
+	if redacted.{{.}} != nil {
+		redacted.{{.}} = redacted.{{.}}.redacted()
+	}
+	{{- end}}
+
+	return &redacted
+}
+{{end}}
+{{- end}}
+func init() {
+	{{- range .}}
+	registerEvent(func() Event { return &{{.Name}}Event{} })
+	{{- end}}
+}
+`))
+
+func main() {
+	in := flag.String("in", "events.json", "the JSON file declaring the events")
+	out := flag.String("out", "event_gen.go", "the generated Go file")
+	flag.Parse()
+
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("eventgen: %v", err)
+	}
+
+	var declarations []declaration
+	err = json.Unmarshal(data, &declarations)
+	if err != nil {
+		log.Fatalf("eventgen: failed to parse %s: %v", *in, err)
+	}
+
+	err = check(declarations)
+	if err != nil {
+		log.Fatalf("eventgen: %s: %v", *in, err)
+	}
+
+	var buffer bytes.Buffer
+	err = source.Execute(&buffer, declarations)
+	if err != nil {
+		log.Fatalf("eventgen: %v", err)
+	}
+
+	formatted, err := format.Source(buffer.Bytes())
+	if err != nil {
+		log.Fatalf("eventgen: generated invalid code: %v", err)
+	}
+
+	err = ioutil.WriteFile(*out, formatted, 0644)
+	if err != nil {
+		log.Fatalf("eventgen: %v", err)
+	}
+}
+
+// check makes sure the declarations are complete and don't collide.
+func check(declarations []declaration) error {
+	names := map[string]bool{}
+	types := map[string]bool{}
+
+	for _, decl := range declarations {
+		if len(decl.Name) < 1 || len(decl.Type) < 1 {
+			return fmt.Errorf("every event needs a name and a type")
+		}
+
+		if names[decl.Name] || types[decl.Type] {
+			return fmt.Errorf("event %q is declared twice", decl.Name)
+		}
+		names[decl.Name] = true
+		types[decl.Type] = true
+
+		if len(decl.Entity) > 0 && len(decl.entityType()) < 1 {
+			return fmt.Errorf("event %q has no %q field to use as its entity", decl.Name, decl.Entity)
+		}
+
+		for _, redact := range decl.Redact {
+			found := false
+			for _, field := range decl.Fields {
+				found = found || field.Name == redact
+			}
+
+			if !found {
+				return fmt.Errorf("event %q has no %q field to redact", decl.Name, redact)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"api/backend"
+	"api/logger"
+	"time"
+)
+
+// expirySweepInterval is how often the expired documents are removed.
+const expirySweepInterval = 10 * time.Minute
+
+// expirySweeper removes the documents that are rejected once they expire, so the collections issuing
+// short-lived grants don't grow forever. Every instance sweeps, removing a document twice does nothing.
+type expirySweeper struct {
+	library *Library
+}
+
+// newExpirySweeper creates an expirySweeper and starts sweeping in the background.
+func newExpirySweeper(library *Library) *expirySweeper {
+	sweeper := &expirySweeper{library: library}
+
+	library.background.run(sweeper.run)
+	return sweeper
+}
+
+// run sweeps the expired documents until stop is closed.
+func (sweeper *expirySweeper) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sweeper.sweep()
+		}
+	}
+}
+
+// sweep removes the expired documents of every collection, the errors are logged.
+func (sweeper *expirySweeper) sweep() {
+	for collection, filter := range expiredDocuments(time.Now()) {
+		_, err := sweeper.library.Storage.C(collection).RemoveAll(filter)
+		if err != nil {
+			logger.Errorw("[Storage] Failed to remove the expired documents.", "collection", collection, logger.Err(err))
+		}
+	}
+}
+
+// expiredDocuments returns the filters matching the expired documents of the collections swept by
+// expirySweeper.
+//
+// Used authorization codes and refresh tokens are kept until they expire, presenting them again revokes the
+// tokens issued from them. A family of refresh tokens expires at once, so its used tokens are removed with
+// the last one.
+func expiredDocuments(now time.Time) map[string]backend.Filter {
+	expired := backend.Where("expiresAt", backend.Gt, time.Time{}).Where("expiresAt", backend.Lte, now)
+
+	return map[string]backend.Filter{
+		backend.OAuthCodeCollection:          expired,
+		backend.RefreshTokenCollection:       expired,
+		backend.TwoFactorChallengeCollection: expired,
+		// Only the tokens given an expiry, like the ones of the client credentials grant stored before it
+		// issued stateless tokens.
+		backend.InternalTokenCollection: expired,
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"testing"
+	"time"
+)
+
+func TestExpirySweep(t *testing.T) {
+	library := &Library{Storage: backend.NewMemoryStorage()}
+	sweeper := &expirySweeper{library: library}
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		usedAt    time.Time
+		kept      bool
+	}{
+		{"valid", now.Add(time.Hour), time.Time{}, true},
+		{"used", now.Add(time.Hour), now, true},
+		{"expired", now.Add(-time.Second), time.Time{}, false},
+		{"used and expired", now.Add(-time.Second), now.Add(-time.Minute), false},
+		{"never expires", time.Time{}, time.Time{}, true},
+	}
+
+	collections := []string{
+		backend.OAuthCodeCollection,
+		backend.RefreshTokenCollection,
+		backend.TwoFactorChallengeCollection,
+		backend.InternalTokenCollection,
+	}
+
+	ids := make(map[string]bson.ObjectId)
+	for _, collection := range collections {
+		for _, test := range tests {
+			id := bson.NewObjectId()
+			ids[collection+"/"+test.name] = id
+
+			err := library.Storage.C(collection).Insert(bson.M{"_id": id, "expiresAt": test.expiresAt, "usedAt": test.usedAt})
+			if err != nil {
+				t.Fatalf("Insert returned an error: %v", err)
+			}
+		}
+	}
+
+	sweeper.sweep()
+
+	for _, collection := range collections {
+		for _, test := range tests {
+			t.Run(collection+"/"+test.name, func(t *testing.T) {
+				count, err := library.Storage.C(collection).Find(backend.Where("_id", backend.Eq, ids[collection+"/"+test.name])).Count()
+				if err != nil {
+					t.Fatalf("Count returned an error: %v", err)
+				}
+
+				if kept := count == 1; kept != test.kept {
+					t.Errorf("document kept = %t, want %t", kept, test.kept)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package api
+
+import "time"
+
+// KeyringRotationKey is the cache key of the lock held while the signing keys are rotated.
+const KeyringRotationKey = keyringRotationKey
+
+// TOTPCode returns the code of a two-factor secret for the period a time falls into.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return totpCode(secret, totpStep(t))
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"api/backend"
+	"time"
+)
+
+// TimeRange represents an inclusive range of time, a zero bound is ignored.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// compile adds the range's conditions on the specified field to a filter.
+func (timeRange TimeRange) compile(filter backend.Filter, field string) backend.Filter {
+	if !timeRange.From.IsZero() {
+		filter = filter.Where(field, backend.Gte, timeRange.From)
+	}
+
+	if !timeRange.To.IsZero() {
+		filter = filter.Where(field, backend.Lte, timeRange.To)
+	}
+
+	return filter
+}
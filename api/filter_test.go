@@ -0,0 +1,99 @@
+package api
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilterCompile(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	id := bson.NewObjectId()
+	protected := true
+
+	tests := []struct {
+		name     string
+		compiled backend.Filter
+		want     backend.Filter
+	}{
+		{"empty time range", TimeRange{}.compile(backend.Filter{}, "createdAt"), backend.Filter{}},
+		{
+			"time range start",
+			TimeRange{From: from}.compile(backend.Filter{}, "createdAt"),
+			backend.Where("createdAt", backend.Gte, from),
+		},
+		{
+			"time range",
+			TimeRange{From: from, To: to}.compile(backend.Where("user", backend.Eq, id), "expiresAt"),
+			backend.Where("user", backend.Eq, id).Where("expiresAt", backend.Gte, from).Where("expiresAt", backend.Lte, to),
+		},
+		{"empty group filter", GroupFilter{}.compile(), backend.Filter{}},
+		{
+			"group filter",
+			GroupFilter{Name: "staff", Protected: &protected, WebPermission: "tickets.read"}.compile(),
+			backend.Where("name", backend.Eq, "staff").Where("protected", backend.Eq, true).Where("webPermissions.tickets.read", backend.Eq, true),
+		},
+		{
+			"user filter",
+			UserFilter{Email: "ikuta@example.com", Group: id, CreatedBetween: TimeRange{To: to}}.compile(),
+			backend.Where("email", backend.Eq, "ikuta@example.com").Where("group", backend.Eq, id).Where("createdAt", backend.Lte, to),
+		},
+		{
+			"user address filter",
+			UserFilter{Address: "127.0.0.1"}.compile(),
+			backend.Filter{Any: []backend.Filter{
+				backend.Where("address", backend.Eq, "127.0.0.1"),
+				backend.Where("addresses", backend.Eq, "127.0.0.1"),
+			}},
+		},
+		{
+			"ticket filter",
+			TicketFilter{User: id, CreatedBetween: TimeRange{From: from}}.compile(),
+			backend.Where("user", backend.Eq, id).Where("createdAt", backend.Gte, from),
+		},
+		{
+			"internal token filter",
+			InternalTokenFilter{Description: "ci"}.compile(),
+			backend.Where("description", backend.Eq, "ci"),
+		},
+		{
+			"webhook delivery filter",
+			WebhookDeliveryFilter{WebhookID: id, Status: WebhookDeliveryDead}.compile(),
+			backend.Where("webhookId", backend.Eq, id).Where("status", backend.Eq, WebhookDeliveryDead),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !reflect.DeepEqual(test.compiled, test.want) {
+				t.Errorf("compile() = %+v, want %+v", test.compiled, test.want)
+			}
+		})
+	}
+}
+
+func TestUserFilterMatchesPreviousAddresses(t *testing.T) {
+	collection := backend.NewMemoryStorage().C(backend.UserCollection)
+	group := bson.NewObjectId()
+	err := collection.Insert(
+		&User{ID: bson.NewObjectId(), Group: group, Name: "current", Address: "10.0.0.1"},
+		&User{ID: bson.NewObjectId(), Group: group, Name: "previous", Address: "10.0.0.2", Addresses: []string{"10.0.0.1"}},
+		&User{ID: bson.NewObjectId(), Group: group, Name: "other", Address: "10.0.0.3"},
+	)
+	if err != nil {
+		t.Fatalf("Insert returned an error: %v", err)
+	}
+
+	var users []User
+	err = collection.Find(UserFilter{Address: "10.0.0.1"}.compile()).Sort("name").All(&users)
+	if err != nil {
+		t.Fatalf("Find returned an error: %v", err)
+	}
+
+	if len(users) != 2 || users[0].Name != "current" || users[1].Name != "previous" {
+		t.Errorf("found %+v, want the current and previous users", users)
+	}
+}
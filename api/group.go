@@ -3,14 +3,14 @@ package api
 import (
 	"context"
 	"github.com/globalsign/mgo/bson"
-	"strings"
+	"api/backend"
 )
 
 // GroupService is an interface for interfacing with Groups.
 type GroupService interface {
 	New(context.Context, string, bool) *Group
 	GetByID(context.Context, string) (*Group, error)
-	List(context.Context, map[string]interface{}) ([]Group, error)
+	List(context.Context, GroupFilter) ([]Group, error)
 	Create(context.Context, *Group) error
 	Update(context.Context, *Group) error
 	Delete(context.Context, string) error
@@ -34,22 +34,27 @@ func (service *GroupServiceImpl) New(ctx context.Context, name string, protected
 
 // GetByID attempts to get a group by using an id.
 func (service *GroupServiceImpl) GetByID(ctx context.Context, id string) (*Group, error) {
-	var group *Group
-	err := service.library.Mongo.Group.Find(bson.M{"_id": bson.ObjectIdHex(id)}).One(&group)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
+	objectID, err := parseObjectID("group.GetByID", id)
+	if err != nil {
 		return nil, err
 	}
 
+	var group *Group
+	err = service.library.Storage.C(backend.GroupCollection).FindId(objectID).One(&group)
+	if err != nil {
+		return nil, wrapError("group.GetByID", err)
+	}
+
 	return group, nil
 }
 
 // List groups
-func (service *GroupServiceImpl) List(ctx context.Context, filter map[string]interface{}) ([]Group, error) {
+func (service *GroupServiceImpl) List(ctx context.Context, filter GroupFilter) ([]Group, error) {
 	var groups []Group
 
-	err := service.library.Mongo.Group.Find(filter).All(&groups)
+	err := service.library.Storage.C(backend.GroupCollection).Find(filter.compile()).All(&groups)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("group.List", err)
 	}
 
 	return groups, nil
@@ -57,31 +62,78 @@ func (service *GroupServiceImpl) List(ctx context.Context, filter map[string]int
 
 // Create a group
 func (service *GroupServiceImpl) Create(ctx context.Context, group *Group) error {
-	service.library.EventManager.Call(&GroupCreateEvent{
+	if err := group.validate("group.Create"); err != nil {
+		return err
+	}
+
+	group.Version = 1
+
+	err := service.library.EventManager.insert(backend.GroupCollection, group.ID, group, &GroupCreateEvent{
 		Group: group,
 	})
-	return service.library.Mongo.Group.Insert(&group)
+	if err != nil {
+		return wrapError("group.Create", err)
+	}
+
+	return nil
 }
 
 // Update a group
 func (service *GroupServiceImpl) Update(ctx context.Context, group *Group) error {
-	service.library.EventManager.Call(&GroupUpdateEvent{
-		Group: group,
+	if err := group.validate("group.Update"); err != nil {
+		return err
+	}
+
+	updated, err := updateVersioned("group.Update", service.library.EventManager, backend.GroupCollection, group.ID, group.Version, group, func() interface{} {
+		updated := *group
+		updated.Version++
+		return &GroupUpdateEvent{Group: &updated}
 	})
-	return service.library.Mongo.Group.UpdateId(group.ID, &group)
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		group.Version++
+	}
+
+	return nil
 }
 
 // Delete a group
 func (service *GroupServiceImpl) Delete(ctx context.Context, id string) error {
-	service.library.EventManager.Call(&GroupDeleteEvent{
+	objectID, err := parseObjectID("group.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	var group *Group
+	err = service.library.Storage.C(backend.GroupCollection).FindId(objectID).One(&group)
+	if err != nil {
+		return wrapError("group.Delete", err)
+	}
+
+	err = service.library.EventManager.CallPre("group.Delete", &GroupPreDeleteEvent{
+		Group: group,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = service.library.EventManager.remove(backend.GroupCollection, objectID, &GroupDeleteEvent{
 		ID: id,
 	})
-	return service.library.Mongo.Group.RemoveId(bson.ObjectIdHex(id))
+	if err != nil {
+		return wrapError("group.Delete", err)
+	}
+
+	return nil
 }
 
 // Count all groups
 func (service *GroupServiceImpl) Count(ctx context.Context) (int, error) {
-	return service.library.Mongo.Group.Count()
+	count, err := service.library.Storage.C(backend.GroupCollection).Count()
+	return count, wrapError("group.Count", err)
 }
 
 // Group represents a "egirls.me" group
@@ -95,6 +147,50 @@ type Group struct {
 	WebPermissions map[string]bool `json:"webPermissions" bson:"webPermissions"`
 	SortID         int             `json:"sortId" bson:"sortId"`
 	Protected      bool            `json:"protected" bson:"protected"`
+	Version        int             `json:"version" bson:"version"`
+	// RequireTwoFactor requires two-factor authentication from the members of the group, see
+	// Config.TwoFactor for requiring it based on web permissions.
+	RequireTwoFactor bool `json:"requireTwoFactor" bson:"requireTwoFactor"`
+}
+
+// GroupFilter represents the criteria used to filter groups, empty fields are ignored.
+type GroupFilter struct {
+	Name      string
+	Protected *bool
+	// WebPermission only matches groups that have been granted the web permission directly.
+	WebPermission string
+}
+
+// compile converts the filter into a backend filter.
+func (filter GroupFilter) compile() backend.Filter {
+	compiled := backend.Filter{}
+
+	if len(filter.Name) > 0 {
+		compiled = compiled.Where("name", backend.Eq, filter.Name)
+	}
+
+	if filter.Protected != nil {
+		compiled = compiled.Where("protected", backend.Eq, *filter.Protected)
+	}
+
+	if len(filter.WebPermission) > 0 {
+		compiled = compiled.Where("webPermissions."+filter.WebPermission, backend.Eq, true)
+	}
+
+	return compiled
+}
+
+// validate checks that the group can be written to storage.
+func (group *Group) validate(op string) error {
+	if !group.ID.Valid() {
+		return newError(op, ErrInvalidID, "group is missing an id")
+	}
+
+	if len(group.Name) < 1 {
+		return newError(op, ErrValidation, "group must have a name")
+	}
+
+	return nil
 }
 
 // HasWebPermission returns true if the group has the specified permission.
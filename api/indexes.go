@@ -0,0 +1,36 @@
+package api
+
+import (
+	"api/backend"
+)
+
+// storageIndexes lists the indexes of the collections queried by something else than their id.
+var storageIndexes = map[string][]backend.Index{
+	backend.OAuthCodeCollection: {
+		{Key: []string{"hash"}, Unique: true},
+	},
+	backend.RefreshTokenCollection: {
+		{Key: []string{"hash"}, Unique: true},
+		{Key: []string{"accessToken"}},
+		{Key: []string{"family"}},
+		{Key: []string{"user"}},
+	},
+	// The revoked tokens are pruned and loaded by their expiry.
+	backend.RevokedTokenCollection: {
+		{Key: []string{"expiresAt"}},
+	},
+}
+
+// ensureIndexes creates the indexes missing from the storage.
+func ensureIndexes(storage backend.Storage) error {
+	for name, indexes := range storageIndexes {
+		for _, index := range indexes {
+			err := storage.C(name).EnsureIndex(index)
+			if err != nil {
+				return wrapError("library.ensureIndexes", err)
+			}
+		}
+	}
+
+	return nil
+}
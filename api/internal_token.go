@@ -7,21 +7,28 @@ import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/globalsign/mgo/bson"
+	"api/backend"
 	"api/logger"
-	"strings"
 	"time"
 )
 
+// internalTokenSubject is the subject of the internal token JWTs, access tokens use "access_token".
+const internalTokenSubject = "internal_token"
+
 // InternalTokenService is an interface for interfacing with Tokens.
 type InternalTokenService interface {
 	New(context.Context, string, map[string]bool) *InternalToken
 	FromJWT(context.Context, string) (*InternalToken, error)
 	GetByID(context.Context, string) (*InternalToken, error)
-	List(context.Context, map[string]interface{}) ([]InternalToken, error)
+	List(context.Context, InternalTokenFilter) ([]InternalToken, error)
 	Create(context.Context, *InternalToken) error
 	Delete(context.Context, string) error
-	Paginate(context.Context, int, int, map[string]interface{}) ([]InternalToken, error)
-	Count(context.Context, map[string]interface{}) (int, error)
+	Paginate(context.Context, PageOptions, InternalTokenFilter) (*InternalTokenPage, error)
+	Count(context.Context, InternalTokenFilter) (int, error)
+	Authorize(context.Context, *InternalToken, string, string, string) error
+	Rotate(context.Context, string, time.Duration) (*InternalToken, error)
+	RecordUsage(context.Context, InternalTokenUsage)
+	Usage(context.Context, string, PageOptions) (*InternalTokenUsagePage, error)
 }
 
 // InternalTokenServiceImpl is an implementation for the InternalTokenService interface.
@@ -36,7 +43,7 @@ func (service *InternalTokenServiceImpl) New(ctx context.Context, description st
 		Description: description,
 		Permissions: permissions,
 		CreatedAt:   time.Now(),
-		ExpiresAt:   time.Now().Add(time.Hour * 720), // 1 month
+		// Internal tokens never expire unless ExpiresAt is set.
 	}
 
 	return token
@@ -44,13 +51,7 @@ func (service *InternalTokenServiceImpl) New(ctx context.Context, description st
 
 // FromJWT converts a "Json Web Token" string to a Token object.
 func (service *InternalTokenServiceImpl) FromJWT(ctx context.Context, rawJwt string) (*InternalToken, error) {
-	parsedJwt, err := jwt.Parse(rawJwt, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("invalid signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(service.library.config.Secret), nil
-	})
+	parsedJwt, err := jwt.Parse(rawJwt, service.library.keyring.keyfunc)
 
 	if parsedJwt == nil {
 		return nil, errors.New("parsed jwt is nil")
@@ -77,9 +78,31 @@ func (service *InternalTokenServiceImpl) FromJWT(ctx context.Context, rawJwt str
 		return nil, errors.New("jwt is missing the \"iat\" field")
 	}
 
-	/*if claims["exp"] == nil {
-		return nil, errors.New("jwt is missing the \"exp\" field")
-	}*/
+	id, err := parseObjectID("internalToken.FromJWT", fmt.Sprint(parsedJwt.Header["id"]))
+	if err != nil {
+		return nil, err
+	}
+
+	switch claims["sub"] {
+	case internalTokenSubject:
+	case "access_token":
+		// Tokens signed before internal tokens had their own subject can't be told apart from access tokens by
+		// their claims, they are only accepted if the token is stored as an internal token.
+		if _, err := service.GetByID(ctx, id.Hex()); err != nil {
+			return nil, errors.New("jwt isn't an internal token")
+		}
+	default:
+		return nil, errors.New("jwt isn't an internal token")
+	}
+
+	revoked, err := service.library.revocations.isRevoked("internalToken.FromJWT", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, newError("internalToken.FromJWT", ErrInvalidToken, "token has been revoked")
+	}
 
 	permissions := make(map[string]bool)
 
@@ -91,11 +114,18 @@ func (service *InternalTokenServiceImpl) FromJWT(ctx context.Context, rawJwt str
 	}
 
 	token := &InternalToken{
-		ID:          bson.ObjectIdHex(parsedJwt.Header["id"].(string)),
+		ID:          id,
 		Description: claims["aud"].(string),
 		Permissions: permissions,
-		CreatedAt:   time.Unix(int64(claims["iat"].(float64)), 0),
-		//ExpiresAt:   time.Unix(int64(claims["exp"].(float64)), 0),
+		// Tokens signed before scopes and networks were introduced have neither, they aren't restricted.
+		Scopes:    headerStrings(parsedJwt.Header["scopes"]),
+		Networks:  headerStrings(parsedJwt.Header["networks"]),
+		CreatedAt: time.Unix(int64(claims["iat"].(float64)), 0),
+	}
+
+	// Tokens without an "exp" field never expire, the parser already rejected the expired ones.
+	if exp, ok := claims["exp"].(float64); ok {
+		token.ExpiresAt = time.Unix(int64(exp), 0)
 	}
 
 	return token, nil
@@ -103,20 +133,22 @@ func (service *InternalTokenServiceImpl) FromJWT(ctx context.Context, rawJwt str
 
 // GetByID attempts to get a token by using an id.
 func (service *InternalTokenServiceImpl) GetByID(ctx context.Context, id string) (*InternalToken, error) {
+	objectID, err := parseObjectID("internalToken.GetByID", id)
+	if err != nil {
+		return nil, err
+	}
+
 	var token *InternalToken
 
-	// Attempt to get the token from redis.
-	result, err := service.library.Redis.Client.Get(fmt.Sprintf("ikuta:access:token:%s", id)).Result()
+	// Attempt to get the token from the cache.
+	result, err := service.library.Cache.Get(fmt.Sprintf("ikuta:access:token:%s", id))
 	if err != nil {
 		// Check if the error is telling us the value doesn't exist.
-		if err.Error() == "redis: nil" {
-			// Pull data from mongo.
-			err := service.library.Mongo.InternalToken.Find(bson.M{"_id": bson.ObjectIdHex(id)}).One(&token)
+		if err == backend.ErrCacheMiss {
+			// Pull data from storage.
+			err := service.library.Storage.C(backend.InternalTokenCollection).FindId(objectID).One(&token)
 			if err != nil {
-				if strings.Contains(err.Error(), "not found") {
-					return nil, nil
-				}
-				return nil, err
+				return nil, wrapError("internalToken.GetByID", err)
 			}
 
 			go func() {
@@ -127,35 +159,39 @@ func (service *InternalTokenServiceImpl) GetByID(ctx context.Context, id string)
 					return
 				}
 
-				// Insert the token into Redis.
-				err = service.library.Redis.Client.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute).Err()
+				// Insert the token into the cache.
+				err = service.library.Cache.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute)
 				if err != nil {
 					logger.Errorw("[Redis] (token.go) Failed to insert object.", logger.Err(err))
 					return
 				}
 			}()
 
-			// Return the token from MongoDB.
+			// Return the token from storage.
 			return token, nil
 		}
-		return nil, err
+		return nil, wrapError("internalToken.GetByID", err)
 	}
 
 	if len(result) < 1 {
-		return nil, errors.New("empty result returned from cache")
+		return nil, newError("internalToken.GetByID", nil, "empty result returned from cache")
 	}
 
-	err = json.Unmarshal([]byte(result), token)
-	return token, err
+	err = json.Unmarshal([]byte(result), &token)
+	if err != nil {
+		return nil, wrapError("internalToken.GetByID", err)
+	}
+
+	return token, nil
 }
 
 // List all tokens matching a filter
-func (service *InternalTokenServiceImpl) List(ctx context.Context, filter map[string]interface{}) ([]InternalToken, error) {
+func (service *InternalTokenServiceImpl) List(ctx context.Context, filter InternalTokenFilter) ([]InternalToken, error) {
 	var tokens []InternalToken
 
-	err := service.library.Mongo.InternalToken.Find(filter).All(&tokens)
+	err := service.library.Storage.C(backend.InternalTokenCollection).Find(filter.compile()).All(&tokens)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("internalToken.List", err)
 	}
 
 	return tokens, nil
@@ -163,9 +199,17 @@ func (service *InternalTokenServiceImpl) List(ctx context.Context, filter map[st
 
 // Create a token
 func (service *InternalTokenServiceImpl) Create(ctx context.Context, token *InternalToken) error {
-	/*service.library.EventManager.Call(&InternalTokenCreateEvent{
+	if err := token.validate("internalToken.Create"); err != nil {
+		return err
+	}
+
+	// Insert the token into storage.
+	err := service.library.EventManager.insert(backend.InternalTokenCollection, token.ID, token, &InternalTokenCreateEvent{
 		InternalToken: token,
-	})*/
+	})
+	if err != nil {
+		return wrapError("internalToken.Create", err)
+	}
 
 	go func() {
 		// Convert the token to a JSON string.
@@ -175,51 +219,130 @@ func (service *InternalTokenServiceImpl) Create(ctx context.Context, token *Inte
 			return
 		}
 
-		// Insert the token into Redis.
-		err = service.library.Redis.Client.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute).Err()
+		// Insert the token into the cache.
+		err = service.library.Cache.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute)
 		if err != nil {
 			logger.Errorw("[Redis] (token.go) Failed to insert object.", logger.Err(err))
 			return
 		}
 	}()
 
-	// Insert the token into MongoDB.
-	return service.library.Mongo.InternalToken.Insert(&token)
+	return nil
 }
 
 // Delete a token
 func (service *InternalTokenServiceImpl) Delete(ctx context.Context, id string) error {
-	/*service.library.EventManager.Call(&InternalTokenDeleteEvent{
+	objectID, err := parseObjectID("internalToken.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	var token *InternalToken
+	err = service.library.Storage.C(backend.InternalTokenCollection).FindId(objectID).One(&token)
+	if err != nil {
+		return wrapError("internalToken.Delete", err)
+	}
+
+	// Revoke the token first so a failed removal can be retried.
+	err = service.library.revocations.revoke(objectID, token.ExpiresAt)
+	if err != nil {
+		return wrapError("internalToken.Delete", err)
+	}
+
+	err = service.library.EventManager.remove(backend.InternalTokenCollection, objectID, &InternalTokenDeleteEvent{
 		ID: id,
-	})*/
+	})
+	if err != nil {
+		return wrapError("internalToken.Delete", err)
+	}
 
 	go func() {
-		// Delete the token from Redis.
-		err := service.library.Redis.Client.Del(fmt.Sprintf("ikuta:access:token:%s", id)).Err()
+		// Delete the token from the cache.
+		err := service.library.Cache.Del(fmt.Sprintf("ikuta:access:token:%s", id))
 		if err != nil {
 			logger.Errorw("[Redis] (token.go) Failed to delete object.", logger.Err(err))
 			return
 		}
 	}()
 
-	return service.library.Mongo.InternalToken.RemoveId(bson.ObjectIdHex(id))
+	return nil
+}
+
+// Rotate replaces a token with a new one granting the same access, the previous token keeps working for a
+// grace period so its holder can switch to the new one.
+func (service *InternalTokenServiceImpl) Rotate(ctx context.Context, id string, grace time.Duration) (*InternalToken, error) {
+	objectID, err := parseObjectID("internalToken.Rotate", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *InternalToken
+	err = service.library.Storage.C(backend.InternalTokenCollection).FindId(objectID).One(&previous)
+	if err != nil {
+		return nil, wrapError("internalToken.Rotate", err)
+	}
+
+	if previous.ReplacedBy.Valid() {
+		return nil, newError("internalToken.Rotate", ErrConflict, "token has already been rotated")
+	}
+
+	now := time.Now()
+	token := service.New(ctx, previous.Description, previous.Permissions)
+	token.Scopes = previous.Scopes
+	token.Networks = previous.Networks
+	if !previous.ExpiresAt.IsZero() {
+		token.ExpiresAt = now.Add(previous.ExpiresAt.Sub(previous.CreatedAt))
+	}
+
+	err = service.Create(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if grace < 0 {
+		grace = 0
+	}
+
+	retiresAt := now.Add(grace)
+	err = service.library.revocations.schedule(objectID, retiresAt, previous.ExpiresAt)
+	if err != nil {
+		return nil, wrapError("internalToken.Rotate", err)
+	}
+
+	err = service.library.EventManager.update(backend.InternalTokenCollection, objectID, backend.Where("_id", backend.Eq, objectID), backend.Update{
+		Set: map[string]interface{}{"replacedBy": token.ID, "retiresAt": retiresAt},
+	}, &InternalTokenRotateEvent{
+		InternalToken: token,
+		Previous:      id,
+	})
+	if err != nil {
+		return nil, wrapError("internalToken.Rotate", err)
+	}
+
+	err = service.library.Cache.Del(fmt.Sprintf("ikuta:access:token:%s", id))
+	if err != nil {
+		logger.Errorw("[Redis] (token.go) Failed to delete object.", logger.Err(err))
+	}
+
+	return token, nil
 }
 
 // Paginate a list of tokens
-func (service *InternalTokenServiceImpl) Paginate(ctx context.Context, page int, perPage int, filter map[string]interface{}) ([]InternalToken, error) {
-	var tokens []InternalToken
+func (service *InternalTokenServiceImpl) Paginate(ctx context.Context, options PageOptions, filter InternalTokenFilter) (*InternalTokenPage, error) {
+	page := &InternalTokenPage{Items: []InternalToken{}}
 
-	err := service.library.Mongo.InternalToken.Find(filter).Skip(perPage * (page - 1)).Limit(perPage).All(&tokens)
+	err := paginate("internalToken.Paginate", service.library.Storage.C(backend.InternalTokenCollection), options, filter.compile(), &page.Items, &page.Page)
 	if err != nil {
 		return nil, err
 	}
 
-	return tokens, nil
+	return page, nil
 }
 
 // Count all tokens
-func (service *InternalTokenServiceImpl) Count(ctx context.Context, filter map[string]interface{}) (int, error) {
-	return service.library.Mongo.InternalToken.Find(filter).Count()
+func (service *InternalTokenServiceImpl) Count(ctx context.Context, filter InternalTokenFilter) (int, error) {
+	count, err := service.library.Storage.C(backend.InternalTokenCollection).Find(filter.compile()).Count()
+	return count, wrapError("internalToken.Count", err)
 }
 
 // InternalToken represents a "egirls.me" token
@@ -227,23 +350,76 @@ type InternalToken struct {
 	ID          bson.ObjectId   `json:"id" bson:"_id,omitempty"`
 	Description string          `json:"description" bson:"description"`
 	Permissions map[string]bool `json:"permissions" bson:"permissions"`
-	CreatedAt   time.Time       `json:"createdAt" bson:"createdAt"`
-	ExpiresAt   time.Time       `json:"expiresAt" bson:"expiresAt"`
+	// Scopes restrict the routes the token can be used for, see Authorize. Any route is allowed if it is empty.
+	Scopes []string `json:"scopes" bson:"scopes"`
+	// Networks restrict the addresses the token can be used from, as CIDR blocks or addresses. Any address
+	// is allowed if it is empty.
+	Networks []string  `json:"networks" bson:"networks"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+	// LastUsedAt and LastUsedAddress are updated about once a minute while the token is used.
+	LastUsedAt      time.Time `json:"lastUsedAt" bson:"lastUsedAt"`
+	LastUsedAddress string    `json:"lastUsedAddress" bson:"lastUsedAddress"`
+	// ReplacedBy is the token replacing this one once it has been rotated, RetiresAt is when this one is revoked.
+	ReplacedBy bson.ObjectId `json:"replacedBy,omitempty" bson:"replacedBy,omitempty"`
+	RetiresAt  time.Time     `json:"retiresAt" bson:"retiresAt"`
+}
+
+// InternalTokenPage represents a page of tokens.
+type InternalTokenPage struct {
+	Items []InternalToken `json:"items"`
+	Page
+}
+
+// pageKey returns the fields used to create a cursor pointing to this token.
+func (token InternalToken) pageKey() (bson.ObjectId, time.Time) {
+	return token.ID, token.CreatedAt
+}
+
+// InternalTokenFilter represents the criteria used to filter internal tokens, empty fields are ignored.
+type InternalTokenFilter struct {
+	Description    string
+	CreatedBetween TimeRange
+}
+
+// compile converts the filter into a backend filter.
+func (filter InternalTokenFilter) compile() backend.Filter {
+	compiled := backend.Filter{}
+
+	if len(filter.Description) > 0 {
+		compiled = compiled.Where("description", backend.Eq, filter.Description)
+	}
+
+	return filter.CreatedBetween.compile(compiled, "createdAt")
+}
+
+// redacted returns a copy of the token that can be published, it leaves out the permissions granted to the token.
+func (token *InternalToken) redacted() *InternalToken {
+	redacted := *token
+	redacted.Permissions = nil
+	return &redacted
 }
 
 // JWT generates a Json Web Token using the data from the Token object.
 func (token *InternalToken) JWT(lib *Library) (string, error) {
-	jsonToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+	claims := &jwt.StandardClaims{
 		Audience: token.Description,
-		//ExpiresAt: token.ExpiresAt.Unix(), DISABLED: We want the token to last forever.
 		Issuer:   "egirls.me",
 		IssuedAt: token.CreatedAt.Unix(),
-		Subject:  "access_token",
-	})
-	jsonToken.Header["id"] = token.ID.Hex()
-	jsonToken.Header["permissions"] = token.Permissions
+		Subject:  internalTokenSubject,
+	}
 
-	signedJwt, err := jsonToken.SignedString([]byte(lib.config.Secret))
+	// Tokens last forever unless they were given an expiry.
+	if !token.ExpiresAt.IsZero() {
+		claims.ExpiresAt = token.ExpiresAt.Unix()
+	}
+
+	signedJwt, err := lib.keyring.sign(claims, map[string]interface{}{
+		"id":          token.ID.Hex(),
+		"permissions": token.Permissions,
+		"scopes":      token.Scopes,
+		"networks":    token.Networks,
+	})
 	if err != nil {
 		logger.Errorw("[Backend] Failed to sign JWT.", logger.Err(err))
 	}
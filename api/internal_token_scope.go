@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// internalTokenScope represents a parsed scope of an internal token.
+//
+// A scope is a path pattern, optionally preceded by a method, for example "GET /punishment/*" or "/user/**".
+// A "*" segment matches any single segment and a trailing "**" segment matches any remaining segments.
+type internalTokenScope struct {
+	method   string
+	segments []string
+}
+
+// parseInternalTokenScope parses a scope.
+func parseInternalTokenScope(scope string) (*internalTokenScope, error) {
+	fields := strings.Fields(scope)
+
+	parsed := &internalTokenScope{}
+	switch len(fields) {
+	case 1:
+		parsed.segments = splitPath(fields[0])
+	case 2:
+		parsed.method = strings.ToUpper(fields[0])
+		parsed.segments = splitPath(fields[1])
+	default:
+		return nil, fmt.Errorf("scope %q must be a path, optionally preceded by a method", scope)
+	}
+
+	if !strings.HasPrefix(fields[len(fields)-1], "/") {
+		return nil, fmt.Errorf("the path of scope %q must start with a slash", scope)
+	}
+
+	for i, segment := range parsed.segments {
+		if segment == "**" && i != len(parsed.segments)-1 {
+			return nil, fmt.Errorf("\"**\" must be the last segment of scope %q", scope)
+		}
+	}
+
+	return parsed, nil
+}
+
+// matches returns true if a request is within the scope.
+func (scope *internalTokenScope) matches(method string, path string) bool {
+	if len(scope.method) > 0 && scope.method != strings.ToUpper(method) {
+		return false
+	}
+
+	segments := splitPath(path)
+	for i, segment := range scope.segments {
+		if segment == "**" {
+			return true
+		}
+
+		if i >= len(segments) || (segment != "*" && segment != segments[i]) {
+			return false
+		}
+	}
+
+	return len(segments) == len(scope.segments)
+}
+
+// splitPath splits a path into its segments, ignoring the empty ones.
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/'
+	})
+}
+
+// parseNetwork parses a CIDR block, or a single address.
+func parseNetwork(network string) (*net.IPNet, error) {
+	if !strings.Contains(network, "/") {
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not a valid address or CIDR block", network)
+		}
+
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, parsed, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid address or CIDR block", network)
+	}
+
+	return parsed, nil
+}
+
+// validate checks that the scopes and networks of the token can be parsed.
+func (token *InternalToken) validate(op string) error {
+	for _, scope := range token.Scopes {
+		if _, err := parseInternalTokenScope(scope); err != nil {
+			return newError(op, ErrValidation, "%v", err)
+		}
+	}
+
+	for _, network := range token.Networks {
+		if _, err := parseNetwork(network); err != nil {
+			return newError(op, ErrValidation, "%v", err)
+		}
+	}
+
+	return nil
+}
+
+// Authorize checks that a token can be used from an address for a request, an ErrForbidden error is returned
+// if the address is outside of the token's networks or the request outside of its scopes.
+func (service *InternalTokenServiceImpl) Authorize(ctx context.Context, token *InternalToken, address string, method string, path string) error {
+	if len(token.Networks) > 0 && !token.allowsAddress(address) {
+		return newError("internalToken.Authorize", ErrForbidden, "the token can't be used from %s", address)
+	}
+
+	if len(token.Scopes) > 0 && !token.allowsRequest(method, path) {
+		return newError("internalToken.Authorize", ErrForbidden, "the token can't be used for %s %s", method, path)
+	}
+
+	return nil
+}
+
+// allowsAddress returns true if an address is within one of the token's networks.
+func (token *InternalToken) allowsAddress(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range token.Networks {
+		parsed, err := parseNetwork(network)
+		if err == nil && parsed.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsRequest returns true if a request is within one of the token's scopes.
+func (token *InternalToken) allowsRequest(method string, path string) bool {
+	for _, scope := range token.Scopes {
+		parsed, err := parseInternalTokenScope(scope)
+		if err == nil && parsed.matches(method, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerStrings converts a JWT header holding a list of strings, it returns nil if the header is missing.
+func headerStrings(header interface{}) []string {
+	values, ok := header.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+
+	return strs
+}
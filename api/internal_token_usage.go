@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"api/logger"
+	"sync"
+	"time"
+)
+
+// internalTokenUsageInterval is how often the recorded uses of the internal tokens are written.
+const internalTokenUsageInterval = time.Minute
+
+// InternalTokenUsage represents the uses of an internal token for a route, from an address and with the same
+// response status. Uses are aggregated for a minute on every instance, so an entry covers at most a minute.
+type InternalTokenUsage struct {
+	ID      bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	Token   bson.ObjectId `json:"token" bson:"token"`
+	Address string        `json:"address" bson:"address"`
+	Method  string        `json:"method" bson:"method"`
+	// Route is the pattern of the route, or the path of the requests that were rejected before being routed.
+	Route  string `json:"route" bson:"route"`
+	Status int    `json:"status" bson:"status"`
+	Count  int    `json:"count" bson:"count"`
+	// CreatedAt is the first use of the entry, LastUsedAt the last one.
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt" bson:"lastUsedAt"`
+}
+
+// InternalTokenUsagePage represents a page of the usage log of an internal token.
+type InternalTokenUsagePage struct {
+	Items []InternalTokenUsage `json:"items"`
+	Page
+}
+
+// pageKey returns the fields used to create a cursor pointing to this entry.
+func (usage InternalTokenUsage) pageKey() (bson.ObjectId, time.Time) {
+	return usage.ID, usage.CreatedAt
+}
+
+// RecordUsage records a use of an internal token, the usage log and the last use of the token are written
+// in the background.
+func (service *InternalTokenServiceImpl) RecordUsage(ctx context.Context, usage InternalTokenUsage) {
+	service.library.usage.record(usage)
+}
+
+// Usage paginates the usage log of an internal token.
+func (service *InternalTokenServiceImpl) Usage(ctx context.Context, id string, options PageOptions) (*InternalTokenUsagePage, error) {
+	objectID, err := parseObjectID("internalToken.Usage", id)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &InternalTokenUsagePage{Items: []InternalTokenUsage{}}
+
+	filter := backend.Where("token", backend.Eq, objectID)
+	err = paginate("internalToken.Usage", service.library.Storage.C(backend.InternalTokenUsageCollection), options, filter, &page.Items, &page.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// internalTokenUsageKey identifies the uses aggregated into an entry.
+type internalTokenUsageKey struct {
+	token   bson.ObjectId
+	address string
+	method  string
+	route   string
+	status  int
+}
+
+// internalTokenUsageRecorder aggregates the uses of the internal tokens and writes them every minute. The
+// uses recorded since the last write are lost if the process exits.
+type internalTokenUsageRecorder struct {
+	library *Library
+
+	lock    sync.Mutex
+	pending map[internalTokenUsageKey]*InternalTokenUsage
+}
+
+// newInternalTokenUsageRecorder creates an internalTokenUsageRecorder writing the uses in the background.
+func newInternalTokenUsageRecorder(library *Library) *internalTokenUsageRecorder {
+	recorder := &internalTokenUsageRecorder{
+		library: library,
+		pending: map[internalTokenUsageKey]*InternalTokenUsage{},
+	}
+
+	library.background.run(recorder.run)
+	return recorder
+}
+
+// record aggregates a use.
+func (recorder *internalTokenUsageRecorder) record(usage InternalTokenUsage) {
+	now := time.Now()
+	key := internalTokenUsageKey{
+		token:   usage.Token,
+		address: usage.Address,
+		method:  usage.Method,
+		route:   usage.Route,
+		status:  usage.Status,
+	}
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if pending, ok := recorder.pending[key]; ok {
+		pending.Count++
+		pending.LastUsedAt = now
+		return
+	}
+
+	usage.ID = bson.NewObjectId()
+	usage.Count = 1
+	usage.CreatedAt = now
+	usage.LastUsedAt = now
+	recorder.pending[key] = &usage
+}
+
+// run writes the recorded uses until stop is closed, the uses recorded since the last write are written then.
+func (recorder *internalTokenUsageRecorder) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(internalTokenUsageInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			recorder.flush()
+			return
+		case <-ticker.C:
+			recorder.flush()
+		}
+	}
+}
+
+// flush writes the recorded uses and the last use of every used token.
+func (recorder *internalTokenUsageRecorder) flush() {
+	recorder.lock.Lock()
+	pending := recorder.pending
+	recorder.pending = map[internalTokenUsageKey]*InternalTokenUsage{}
+	recorder.lock.Unlock()
+
+	lastUses := map[bson.ObjectId]*InternalTokenUsage{}
+	for _, usage := range pending {
+		err := recorder.library.Storage.C(backend.InternalTokenUsageCollection).Insert(usage)
+		if err != nil {
+			logger.Errorw("[Tokens] Failed to write the usage of an internal token.", "token", usage.Token.Hex(), logger.Err(err))
+		}
+
+		if last, ok := lastUses[usage.Token]; !ok || usage.LastUsedAt.After(last.LastUsedAt) {
+			lastUses[usage.Token] = usage
+		}
+	}
+
+	for id, usage := range lastUses {
+		err := recorder.library.Storage.C(backend.InternalTokenCollection).Update(backend.Where("_id", backend.Eq, id), backend.Update{
+			Set: map[string]interface{}{"lastUsedAt": usage.LastUsedAt, "lastUsedAddress": usage.Address},
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			logger.Errorw("[Tokens] Failed to update the last use of an internal token.", "token", id.Hex(), logger.Err(err))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// errInvalidEdDSAKey is returned when the key passed to the EdDSA signing method has the wrong type.
+var errInvalidEdDSAKey = errors.New("key is not a valid Ed25519 key")
+
+// signingMethodEdDSA implements the "EdDSA" JWT signing method with Ed25519 keys, jwt-go doesn't provide it.
+type signingMethodEdDSA struct{}
+
+// signingMethodEd25519 is the EdDSA signing method, it is registered so jwt.Parse accepts "EdDSA" tokens.
+var signingMethodEd25519 = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return signingMethodEd25519
+	})
+}
+
+// Alg returns the name of the signing method.
+func (method *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign signs a string with an ed25519.PrivateKey.
+func (method *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", errInvalidEdDSAKey
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// Verify verifies the signature of a string with an ed25519.PublicKey.
+func (method *signingMethodEdDSA) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return errInvalidEdDSAKey
+	}
+
+	decoded, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), decoded) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+)
+
+// The Ed25519 signing example of RFC 8037, appendix A.4.
+const (
+	eddsaTestSeed          = "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"
+	eddsaTestSigningString = "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc"
+	eddsaTestSignature     = "hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+)
+
+// eddsaTestKey returns the private key of the RFC 8037 example.
+func eddsaTestKey(t *testing.T) ed25519.PrivateKey {
+	seed, err := base64.RawURLEncoding.DecodeString(eddsaTestSeed)
+	if err != nil {
+		t.Fatalf("failed to decode the seed: %v", err)
+	}
+
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+func TestEdDSASign(t *testing.T) {
+	signature, err := signingMethodEd25519.Sign(eddsaTestSigningString, eddsaTestKey(t))
+	if err != nil {
+		t.Fatalf("Sign returned an error: %v", err)
+	}
+
+	if signature != eddsaTestSignature {
+		t.Errorf("Sign() = %q, want %q", signature, eddsaTestSignature)
+	}
+}
+
+func TestEdDSAVerify(t *testing.T) {
+	key := eddsaTestKey(t)
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate a key: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		signingString string
+		signature     string
+		key           interface{}
+		err           error
+	}{
+		{"valid", eddsaTestSigningString, eddsaTestSignature, key.Public(), nil},
+		{"tampered string", eddsaTestSigningString + "x", eddsaTestSignature, key.Public(), jwt.ErrSignatureInvalid},
+		{"other key", eddsaTestSigningString, eddsaTestSignature, otherPublic, jwt.ErrSignatureInvalid},
+		{"private key", eddsaTestSigningString, eddsaTestSignature, key, errInvalidEdDSAKey},
+		{"wrong key type", eddsaTestSigningString, eddsaTestSignature, []byte("secret"), errInvalidEdDSAKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := signingMethodEd25519.Verify(test.signingString, test.signature, test.key)
+			if err != test.err {
+				t.Errorf("Verify() = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEdDSASignWrongKey(t *testing.T) {
+	tests := []interface{}{
+		eddsaTestKey(t).Public(),
+		ed25519.PrivateKey("short"),
+		[]byte("secret"),
+	}
+
+	for _, key := range tests {
+		if _, err := signingMethodEd25519.Sign(eddsaTestSigningString, key); err != errInvalidEdDSAKey {
+			t.Errorf("Sign(%T) = %v, want %v", key, err, errInvalidEdDSAKey)
+		}
+	}
+}
+
+func TestEdDSAParse(t *testing.T) {
+	key := eddsaTestKey(t)
+
+	signed, err := jwt.NewWithClaims(signingMethodEd25519, jwt.MapClaims{"sub": "test"}).SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString returned an error: %v", err)
+	}
+
+	token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		return key.Public(), nil
+	})
+	if err != nil || !token.Valid {
+		t.Fatalf("jwt.Parse() = %v, want a valid token", err)
+	}
+
+	if token.Header["alg"] != "EdDSA" {
+		t.Errorf("alg header = %v, want EdDSA", token.Header["alg"])
+	}
+}
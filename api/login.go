@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"api/backend"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Login throttling defaults.
+const (
+	loginDefaultMaxAttempts        = 5
+	loginDefaultAddressMaxAttempts = 50
+	loginDefaultWindow             = 15 * time.Minute
+	loginDefaultLockout            = 15 * time.Minute
+	loginDefaultDelay              = time.Second
+	loginMaxDelay                  = time.Minute
+)
+
+// LoginService is an interface for throttling the login attempts, per account and per address.
+//
+// Accounts are identified by the email used to log in, failed attempts on accounts that don't exist are
+// throttled the same way so they can't be told apart. Wrong two-factor codes are counted apart from wrong
+// passwords, so logging in with the password again doesn't reset them, and lock the account the same way.
+type LoginService interface {
+	Check(context.Context, string, string) error
+	Fail(context.Context, string, string) error
+	Succeed(context.Context, string) error
+	FailTwoFactor(context.Context, string, string) error
+	SucceedTwoFactor(context.Context, string) error
+	Status(context.Context, string) (*LoginStatus, error)
+	Unlock(context.Context, string, string) error
+}
+
+// LoginServiceImpl is an implementation for the LoginService interface.
+type LoginServiceImpl struct {
+	library *Library
+}
+
+// LoginStatus represents the failed login attempts of a user.
+type LoginStatus struct {
+	// Attempts is the amount of failed attempts within the window, they are reset when the account is locked.
+	Attempts int `json:"attempts"`
+	// TwoFactorAttempts is the amount of wrong two-factor codes within the window.
+	TwoFactorAttempts int       `json:"twoFactorAttempts"`
+	Locked            bool      `json:"locked"`
+	LockedUntil       time.Time `json:"lockedUntil"`
+	// RetryAt is the end of the delay following the last failed attempt.
+	RetryAt time.Time `json:"retryAt"`
+}
+
+// Check returns a RetryError of kind ErrTooManyAttempts if the account is locked, the address is blocked or
+// the delay following the last failed attempt on the account isn't over. An empty account only checks the
+// address.
+func (service *LoginServiceImpl) Check(ctx context.Context, account string, address string) error {
+	keys := []string{loginAddressKey(address, "lock")}
+	if account = normalizeAccount(account); len(account) > 0 {
+		keys = append(keys, loginAccountKey(account, "lock"), loginAccountKey(account, "delay"))
+	}
+
+	for _, key := range keys {
+		until, err := service.until(key)
+		if err != nil {
+			return wrapError("login.Check", err)
+		}
+
+		if wait := time.Until(until); wait > 0 {
+			return &RetryError{
+				Err:        &Error{Kind: ErrTooManyAttempts, Op: "login.Check"},
+				RetryAfter: wait,
+			}
+		}
+	}
+
+	return nil
+}
+
+// Fail records a failed login attempt, it delays the next attempt on the account and locks the account or
+// blocks the address once they reach their maximum amount of failed attempts.
+func (service *LoginServiceImpl) Fail(ctx context.Context, account string, address string) error {
+	return service.fail(ctx, "login.Fail", account, address, "attempts")
+}
+
+// FailTwoFactor records a wrong two-factor code, it is throttled like a failed login attempt.
+func (service *LoginServiceImpl) FailTwoFactor(ctx context.Context, account string, address string) error {
+	return service.fail(ctx, "login.FailTwoFactor", account, address, "twoFactorAttempts")
+}
+
+// fail records a failed attempt on the account counter with the specified name.
+func (service *LoginServiceImpl) fail(ctx context.Context, op string, account string, address string, counter string) error {
+	config := service.config()
+	now := time.Now()
+
+	addressAttempts, err := service.library.Cache.Incr(loginAddressKey(address, "attempts"), config.window)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	if addressAttempts == int64(config.addressMaxAttempts) {
+		err = service.lock(loginAddressKey(address, "lock"), now.Add(config.lockout), config.lockout)
+		if err != nil {
+			return wrapError(op, err)
+		}
+
+		err = service.library.EventManager.Call(&LoginLockEvent{
+			Address:  address,
+			Duration: int(config.lockout / time.Second),
+		})
+		if err != nil {
+			return wrapError(op, err)
+		}
+	}
+
+	email := strings.TrimSpace(account)
+	account = normalizeAccount(account)
+	if len(account) < 1 {
+		return nil
+	}
+
+	attempts, err := service.library.Cache.Incr(loginAccountKey(account, counter), config.window)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	user := ""
+	if found, err := service.library.User.GetByEmail(ctx, email); err == nil {
+		user = found.ID.Hex()
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	err = service.library.EventManager.Call(&LoginFailEvent{
+		Account:  account,
+		User:     user,
+		Address:  address,
+		Attempts: int(attempts),
+	})
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	if attempts < int64(config.maxAttempts) {
+		delay := config.delay << uint(attempts-1)
+		if delay <= 0 || delay > loginMaxDelay {
+			delay = loginMaxDelay
+		}
+
+		return wrapError(op, service.lock(loginAccountKey(account, "delay"), now.Add(delay), delay))
+	}
+
+	// Concurrent attempts may go past the maximum, only the one reaching it locks the account.
+	if attempts > int64(config.maxAttempts) {
+		return nil
+	}
+
+	err = service.lock(loginAccountKey(account, "lock"), now.Add(config.lockout), config.lockout)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	err = service.library.Cache.Del(loginAccountKey(account, counter), loginAccountKey(account, "delay"))
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	err = service.library.EventManager.Call(&LoginLockEvent{
+		Account:  account,
+		User:     user,
+		Address:  address,
+		Duration: int(config.lockout / time.Second),
+	})
+	return wrapError(op, err)
+}
+
+// Succeed resets the failed login attempts of an account, the attempts from the address are kept so an
+// attacker can't reset them by logging into their own account.
+func (service *LoginServiceImpl) Succeed(ctx context.Context, account string) error {
+	account = normalizeAccount(account)
+	err := service.library.Cache.Del(loginAccountKey(account, "attempts"), loginAccountKey(account, "delay"))
+	return wrapError("login.Succeed", err)
+}
+
+// SucceedTwoFactor resets the failed login attempts and the wrong two-factor codes of an account once it has
+// completed a two-factor login.
+func (service *LoginServiceImpl) SucceedTwoFactor(ctx context.Context, account string) error {
+	account = normalizeAccount(account)
+	err := service.library.Cache.Del(
+		loginAccountKey(account, "attempts"),
+		loginAccountKey(account, "twoFactorAttempts"),
+		loginAccountKey(account, "delay"),
+	)
+	return wrapError("login.SucceedTwoFactor", err)
+}
+
+// Status returns the failed login attempts of a user and whether they are locked.
+func (service *LoginServiceImpl) Status(ctx context.Context, id string) (*LoginStatus, error) {
+	user, err := service.library.User.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	account := normalizeAccount(user.Email)
+	status := &LoginStatus{}
+
+	attempts, err := service.library.Cache.Get(loginAccountKey(account, "attempts"))
+	if err != nil && err != backend.ErrCacheMiss {
+		return nil, wrapError("login.Status", err)
+	}
+	status.Attempts, _ = strconv.Atoi(attempts)
+
+	twoFactorAttempts, err := service.library.Cache.Get(loginAccountKey(account, "twoFactorAttempts"))
+	if err != nil && err != backend.ErrCacheMiss {
+		return nil, wrapError("login.Status", err)
+	}
+	status.TwoFactorAttempts, _ = strconv.Atoi(twoFactorAttempts)
+
+	if status.LockedUntil, err = service.until(loginAccountKey(account, "lock")); err != nil {
+		return nil, wrapError("login.Status", err)
+	}
+	status.Locked = time.Now().Before(status.LockedUntil)
+
+	if status.RetryAt, err = service.until(loginAccountKey(account, "delay")); err != nil {
+		return nil, wrapError("login.Status", err)
+	}
+
+	return status, nil
+}
+
+// Unlock unlocks a user and resets their failed login attempts, staff identifies who unlocked them.
+func (service *LoginServiceImpl) Unlock(ctx context.Context, id string, staff string) error {
+	user, err := service.library.User.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	account := normalizeAccount(user.Email)
+	err = service.library.Cache.Del(
+		loginAccountKey(account, "lock"),
+		loginAccountKey(account, "attempts"),
+		loginAccountKey(account, "twoFactorAttempts"),
+		loginAccountKey(account, "delay"),
+	)
+	if err != nil {
+		return wrapError("login.Unlock", err)
+	}
+
+	err = service.library.EventManager.Call(&LoginUnlockEvent{
+		Account: account,
+		User:    user.ID.Hex(),
+		Staff:   staff,
+	})
+	return wrapError("login.Unlock", err)
+}
+
+// until returns the time stored at a key by lock, it is zero if the key doesn't exist.
+func (service *LoginServiceImpl) until(key string) (time.Time, error) {
+	value, err := service.library.Cache.Get(key)
+	if err == backend.ErrCacheMiss {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q stored at %s", value, key)
+	}
+
+	return time.Unix(0, nanos), nil
+}
+
+// lock stores the end of a lock or a delay at a key expiring with it.
+func (service *LoginServiceImpl) lock(key string, until time.Time, duration time.Duration) error {
+	return service.library.Cache.Set(key, until.UnixNano(), duration)
+}
+
+// loginConfig holds the login settings with their defaults applied.
+type loginConfig struct {
+	maxAttempts        int
+	addressMaxAttempts int
+	window             time.Duration
+	lockout            time.Duration
+	delay              time.Duration
+}
+
+// config returns the login settings of the library.
+func (service *LoginServiceImpl) config() loginConfig {
+	config := service.library.config.Login
+	loaded := loginConfig{
+		maxAttempts:        config.MaxAttempts,
+		addressMaxAttempts: config.AddressMaxAttempts,
+		window:             time.Duration(config.Window) * time.Second,
+		lockout:            time.Duration(config.Lockout) * time.Second,
+		delay:              time.Duration(config.Delay) * time.Second,
+	}
+
+	if loaded.maxAttempts <= 0 {
+		loaded.maxAttempts = loginDefaultMaxAttempts
+	}
+
+	if loaded.addressMaxAttempts <= 0 {
+		loaded.addressMaxAttempts = loginDefaultAddressMaxAttempts
+	}
+
+	if loaded.window <= 0 {
+		loaded.window = loginDefaultWindow
+	}
+
+	if loaded.lockout <= 0 {
+		loaded.lockout = loginDefaultLockout
+	}
+
+	if loaded.delay <= 0 {
+		loaded.delay = loginDefaultDelay
+	}
+
+	return loaded
+}
+
+// normalizeAccount lowercases an email so the attempts on every spelling of an account are counted together.
+func normalizeAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+// loginAccountKey returns the cache key holding the login state of an account.
+func loginAccountKey(account string, name string) string {
+	return fmt.Sprintf("ikuta:access:login:account:%s:%s", account, name)
+}
+
+// loginAddressKey returns the cache key holding the login state of an address.
+func loginAddressKey(address string, name string) string {
+	return fmt.Sprintf("ikuta:access:login:address:%s:%s", address, name)
+}
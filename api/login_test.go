@@ -0,0 +1,170 @@
+package api_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectRetry fails the test unless err is a RetryError of kind ErrTooManyAttempts, or nil if retry is false.
+func expectRetry(t *testing.T, err error, retry bool) {
+	t.Helper()
+
+	if !retry {
+		if err != nil {
+			t.Errorf("Check() = %v, want nil", err)
+		}
+		return
+	}
+
+	var retryErr *api.RetryError
+	if !errors.Is(err, api.ErrTooManyAttempts) || !errors.As(err, &retryErr) || retryErr.RetryAfter <= 0 {
+		t.Errorf("Check() = %v, want a retry error", err)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	const (
+		fail    = "fail"
+		upper   = "upper"
+		padded  = "padded"
+		succeed = "succeed"
+		unlock  = "unlock"
+	)
+
+	tests := []struct {
+		name string
+		// steps are run in turn, upper and padded fail with other spellings of the email.
+		steps      []string
+		retry      bool
+		locked     bool
+		attempts   int
+		lockEvents int
+	}{
+		{"one failure", []string{fail}, true, false, 1, 0},
+		{"below the maximum", []string{fail, fail}, true, false, 2, 0},
+		{"locked", []string{fail, fail, fail}, true, true, 0, 1},
+		{"other spellings", []string{fail, upper, padded}, true, true, 0, 1},
+		{"succeeded", []string{fail, fail, succeed}, false, false, 0, 0},
+		{"unlocked", []string{fail, fail, fail, unlock}, false, false, 0, 1},
+		{"failed after unlock", []string{fail, fail, fail, unlock, fail}, true, false, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, events := apitest.New(t, func(config *api.Config) {
+				config.Login.MaxAttempts = 3
+			})
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+
+			for _, step := range test.steps {
+				var err error
+				switch step {
+				case unlock:
+					err = lib.Login.Unlock(ctx, user.ID.Hex(), "staff")
+				case succeed:
+					err = lib.Login.Succeed(ctx, user.Email)
+				case upper:
+					err = lib.Login.Fail(ctx, strings.ToUpper(user.Email), "127.0.0.1")
+				case padded:
+					err = lib.Login.Fail(ctx, " "+user.Email+" ", "127.0.0.1")
+				default:
+					err = lib.Login.Fail(ctx, user.Email, "127.0.0.1")
+				}
+				if err != nil {
+					t.Fatalf("%s returned an error: %v", step, err)
+				}
+			}
+
+			expectRetry(t, lib.Login.Check(ctx, user.Email, "127.0.0.1"), test.retry)
+
+			status, err := lib.Login.Status(ctx, user.ID.Hex())
+			if err != nil {
+				t.Fatalf("Status returned an error: %v", err)
+			}
+
+			if status.Locked != test.locked || status.Attempts != test.attempts {
+				t.Errorf("Status() = %+v, want locked %t after %d attempts", status, test.locked, test.attempts)
+			}
+
+			if test.locked && time.Until(status.LockedUntil) <= 0 {
+				t.Errorf("account is locked until %s, want a time to come", status.LockedUntil)
+			}
+
+			events.ExpectCount(api.LoginLockEventType, test.lockEvents, apitest.Match(func(event *api.LoginLockEvent) bool {
+				return event.Account == user.Email && event.User == user.ID.Hex()
+			}))
+		})
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	lib, _ := apitest.New(t, func(config *api.Config) {
+		config.Login.Delay = 2
+	})
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+
+	// The delay doubles with every failed attempt.
+	for attempt, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if err := lib.Login.Fail(ctx, user.Email, "127.0.0.1"); err != nil {
+			t.Fatalf("Fail returned an error: %v", err)
+		}
+
+		status, err := lib.Login.Status(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatalf("Status returned an error: %v", err)
+		}
+
+		if wait := time.Until(status.RetryAt); wait <= want-time.Second || wait > want {
+			t.Errorf("attempt %d waits %s, want %s", attempt+1, wait, want)
+		}
+	}
+}
+
+func TestLoginAddressBlock(t *testing.T) {
+	lib, events := apitest.New(t, func(config *api.Config) {
+		config.Login.AddressMaxAttempts = 3
+	})
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+
+	// Failed attempts on accounts that don't exist count against the address.
+	for _, account := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if err := lib.Login.Fail(ctx, account, "10.0.0.1"); err != nil {
+			t.Fatalf("Fail returned an error: %v", err)
+		}
+	}
+
+	events.ExpectCount(api.LoginLockEventType, 1, apitest.Match(func(event *api.LoginLockEvent) bool {
+		return event.Address == "10.0.0.1" && len(event.Account) < 1
+	}))
+
+	// Logging into an account doesn't unblock the address.
+	if err := lib.Login.Succeed(ctx, user.Email); err != nil {
+		t.Fatalf("Succeed returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		account string
+		address string
+		retry   bool
+	}{
+		{"blocked address", "", "10.0.0.1", true},
+		{"account from the blocked address", user.Email, "10.0.0.1", true},
+		{"account from another address", user.Email, "10.0.0.2", false},
+		{"failed account from another address", "a@example.com", "10.0.0.2", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectRetry(t, lib.Login.Check(ctx, test.account, test.address), test.retry)
+		})
+	}
+}
@@ -9,19 +9,40 @@ type Library struct {
 	config        Config
 	Mongo         backend.MongoDriver
 	Redis         backend.RedisDriver
+	Storage       backend.Storage
+	Cache         backend.Cache
 	EventManager  *EventManager
+	revocations   *tokenRevocations
+	keyring       *keyring
+	sessions      *sessionActivity
+	usage         *internalTokenUsageRecorder
+	expiry        *expirySweeper
+	// background runs the loops of the components above until the library is closed.
+	background *background
 	Group         GroupService
 	InternalToken InternalTokenService
+	Login         LoginService
+	OAuth         OAuthService
+	OAuthClient   OAuthClientService
 	Punishment    PunishmentService
+	Session       SessionService
+	SigningKey    SigningKeyService
 	Ticket        TicketService
 	Token         TokenService
+	TwoFactor     TwoFactorService
 	User          UserService
+	Webhook       WebhookService
 }
 
 // Config .
 type Config struct {
+	// Secret verifies the tokens signed before the signing keys were introduced, it can be removed once they
+	// have all expired.
 	Secret string `json:"secret"`
 
+	// NodeID identifies this instance on the events channel, a random id is used if it is empty.
+	NodeID string `json:"nodeId"`
+
 	MongoDB struct {
 		Active   bool   `json:"active"`
 		URI      string `json:"uri"`
@@ -34,6 +55,108 @@ type Config struct {
 		Password string `json:"password"`
 		Database int    `json:"database"`
 	} `json:"redis"`
+
+	// Tokens configures the tokens issued by TokenService.Issue.
+	Tokens struct {
+		// AccessTTL is how many seconds an access token is valid for, it defaults to 15 minutes.
+		AccessTTL int `json:"accessTtl"`
+		// RefreshTTL is how many seconds a refresh token is valid for, it defaults to 30 days. Every rotation
+		// issues a refresh token valid for the whole duration again.
+		RefreshTTL int `json:"refreshTtl"`
+	} `json:"tokens"`
+
+	// Keys configures the keys signing the tokens, see SigningKey.
+	Keys struct {
+		// Algorithm is the algorithm of the new keys: "RS256" (default), "EdDSA" or "HS256". HS256 keys are
+		// secret, they aren't published in the JSON Web Key Set.
+		Algorithm string `json:"algorithm"`
+		// RotationInterval is how many seconds a key signs tokens before being replaced, it defaults to 7 days.
+		RotationInterval int `json:"rotationInterval"`
+		// Retention is how many seconds a replaced key keeps verifying the tokens it signed, it defaults to
+		// 30 days. Internal tokens that never expire need a new JWT within that period.
+		Retention int `json:"retention"`
+	} `json:"keys"`
+
+	// Login configures the throttling of the login attempts, see LoginService.
+	Login struct {
+		// MaxAttempts is how many failed attempts lock an account, it defaults to 5.
+		MaxAttempts int `json:"maxAttempts"`
+		// AddressMaxAttempts is how many failed attempts, on any account, block an address. It defaults to 50.
+		AddressMaxAttempts int `json:"addressMaxAttempts"`
+		// Window is how many seconds a failed attempt is counted for, it defaults to 15 minutes.
+		Window int `json:"window"`
+		// Lockout is how many seconds an account or an address stays locked, it defaults to 15 minutes.
+		Lockout int `json:"lockout"`
+		// Delay is how many seconds an account waits after its first failed attempt, it doubles with every
+		// following one up to a minute. It defaults to 1.
+		Delay int `json:"delay"`
+	} `json:"login"`
+
+	// TwoFactor configures the two-factor authentication of the users, see TwoFactorService.
+	TwoFactor struct {
+		// Issuer names the accounts in authenticator apps, it defaults to "egirls.me".
+		Issuer string `json:"issuer"`
+		// RequiredWebPermissions requires two-factor authentication from the members of the groups granted
+		// one of these web permissions, groups granted "root" are included. Groups can also require it with
+		// Group.RequireTwoFactor.
+		RequiredWebPermissions []string `json:"requiredWebPermissions"`
+		// ChallengeTTL is how many seconds a user has to complete a two-factor login, it defaults to 5 minutes.
+		ChallengeTTL int `json:"challengeTtl"`
+	} `json:"twoFactor"`
+
+	// OAuth configures the OAuth 2.0 and OpenID Connect provider, see OAuthService.
+	OAuth struct {
+		// Issuer is the public URL of the api, the OAuth endpoints and the "iss" claim of the ID tokens are
+		// based on it. It defaults to "https://egirls.me".
+		Issuer string `json:"issuer"`
+		// CodeTTL is how many seconds an authorization code can be exchanged for, it defaults to a minute.
+		CodeTTL int `json:"codeTtl"`
+	} `json:"oauth"`
+
+	// Streams configures the durable Redis Streams events transport.
+	Streams struct {
+		// Active selects the streams events transport.
+		//
+		// Deprecated: set Events.Transport to "streams" instead.
+		Active bool `json:"active"`
+		// Group is the consumer group of this instance, it defaults to the node id and one of them is required.
+		// Instances sharing a group split the events between them. The group is created the first time the
+		// instance starts and receives the events published from then on, including while it is stopped.
+		Group string `json:"group"`
+		// MaxLen is the approximate amount of events kept in the stream.
+		MaxLen int64 `json:"maxLen"`
+	} `json:"streams"`
+
+	// Events configures how event handlers are called.
+	Events struct {
+		// Workers is the amount of goroutines calling event handlers, it defaults to the amount of CPUs.
+		Workers int `json:"workers"`
+		// QueueSize is the amount of events a worker holds before Call blocks, it defaults to 1000. Events
+		// are dropped once Call has been blocked for the handler timeout.
+		QueueSize int `json:"queueSize"`
+		// HandlerTimeout is how many seconds a handler may run before it is given up on, it defaults to 30.
+		HandlerTimeout int `json:"handlerTimeout"`
+
+		// Transport is the transport events are exchanged on by the instances: "redis" (Pub/Sub), "streams",
+		// "nats" or "memory". It defaults to "redis" if Redis is active and to "memory" otherwise.
+		Transport string `json:"transport"`
+		// Namespace prefixes the name of the events channel, it defaults to "ikuta:access".
+		Namespace string `json:"namespace"`
+		// Channel is the name of the events channel within the namespace, it defaults to "events". The
+		// streams transport appends ":stream" to it and the NATS transport replaces colons with dots.
+		Channel string `json:"channel"`
+		// Codec is the encoding of the published events: "json" (default) or "msgpack". Every instance
+		// sharing a channel must use the same codec.
+		Codec string `json:"codec"`
+
+		NATS struct {
+			URL string `json:"url"`
+		} `json:"nats"`
+
+		// EventTransport replaces the configured transport, libraries sharing a backend.MemoryTransport
+		// receive each other's events. It isn't closed with the event manager.
+		EventTransport backend.EventTransport `json:"-"`
+	} `json:"events"`
 }
 
 // New .
@@ -55,17 +178,67 @@ func New(config Config) (*Library, error) {
 	}
 
 	library := &Library{
-		config: config,
-		Mongo:  mongo,
-		Redis:  redis,
+		config:     config,
+		Mongo:      mongo,
+		Redis:      redis,
+		background: newBackground(),
+	}
+
+	// Fall back to in-memory backends when MongoDB or Redis are disabled.
+	if config.MongoDB.Active {
+		library.Storage = backend.NewMongoStorage(mongo)
+	} else {
+		library.Storage = backend.NewMemoryStorage()
 	}
-	library.EventManager = newEventManager(library)
+
+	err := ensureIndexes(library.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Redis.Active {
+		library.Cache = backend.NewRedisCache(redis)
+	} else {
+		library.Cache = backend.NewMemoryCache()
+	}
+
+	manager, err := newEventManager(library)
+	if err != nil {
+		return nil, err
+	}
+
+	library.EventManager = manager
+	library.revocations = newTokenRevocations(library)
+	library.sessions = newSessionActivity(library)
+	library.usage = newInternalTokenUsageRecorder(library)
+	library.expiry = newExpirySweeper(library)
+
+	library.keyring, err = newKeyring(library)
+	if err != nil {
+		library.Close()
+		return nil, err
+	}
+
 	library.Group = &GroupServiceImpl{library: library}
 	library.InternalToken = &InternalTokenServiceImpl{library: library}
+	library.Login = &LoginServiceImpl{library: library}
+	library.OAuth = &OAuthServiceImpl{library: library}
+	library.OAuthClient = &OAuthClientServiceImpl{library: library}
 	library.Punishment = &PunishmentServiceImpl{library: library}
+	library.Session = &SessionServiceImpl{library: library}
+	library.SigningKey = &SigningKeyServiceImpl{library: library}
 	library.Ticket = &TicketServiceImpl{library: library}
 	library.Token = &TokenServiceImpl{library: library}
+	library.TwoFactor = &TwoFactorServiceImpl{library: library}
 	library.User = &UserServiceImpl{library: library}
+	library.Webhook = &WebhookServiceImpl{library: library}
 
 	return library, nil
 }
+
+// Close stops the background work of the library and waits for it to return, then closes the event manager.
+// The storage and cache drivers are left open.
+func (library *Library) Close() {
+	library.background.close()
+	library.EventManager.Close()
+}
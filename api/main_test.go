@@ -0,0 +1,35 @@
+package api_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"testing"
+)
+
+func TestLibraryClose(t *testing.T) {
+	lib, _ := apitest.New(t)
+	ctx := context.Background()
+
+	token := lib.InternalToken.New(ctx, "test", map[string]bool{})
+	if err := lib.InternalToken.Create(ctx, token); err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+
+	lib.InternalToken.RecordUsage(ctx, api.InternalTokenUsage{Token: token.ID, Address: "127.0.0.1", Method: "GET", Route: "/user", Status: 200})
+
+	// The uses recorded since the last write are written before Close returns.
+	lib.Close()
+
+	usage, err := lib.InternalToken.Usage(ctx, token.ID.Hex(), api.PageOptions{})
+	if err != nil {
+		t.Fatalf("Usage returned an error: %v", err)
+	}
+
+	if len(usage.Items) != 1 || usage.Items[0].Count != 1 {
+		t.Errorf("Usage() = %+v, want the recorded use", usage.Items)
+	}
+
+	// Closing again does nothing.
+	lib.Close()
+}
@@ -0,0 +1,891 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OAuth scopes that aren't web permissions, every other scope grants the web permission of the same name.
+const (
+	// OAuthScopeOpenID asks for an ID token, the "sub" claim is the id of the user.
+	OAuthScopeOpenID = "openid"
+	// OAuthScopeProfile adds the "uniqueId", "name" and "group" claims.
+	OAuthScopeProfile = "profile"
+	// OAuthScopeEmail adds the "email" claim.
+	OAuthScopeEmail = "email"
+)
+
+// OAuth error codes (RFC 6749), see OAuthError.
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthAccessDenied            = "access_denied"
+)
+
+// TokenRevokeOAuth is passed to TokenFamilyRevokeEvent when an OAuth client, or the user, revokes the tokens
+// of a client.
+const TokenRevokeOAuth = "oauth"
+
+// OAuth provider defaults.
+const (
+	oauthDefaultIssuer  = "https://egirls.me"
+	oauthDefaultCodeTTL = time.Minute
+	// oauthCodeChallengeMethod is the only PKCE method supported, "plain" is rejected.
+	oauthCodeChallengeMethod = "S256"
+)
+
+// oauthErrorKinds maps the OAuth error codes to the kind of the wrapped errors.
+var oauthErrorKinds = map[string]error{
+	OAuthInvalidRequest:          ErrValidation,
+	OAuthInvalidClient:           ErrInvalidToken,
+	OAuthInvalidGrant:            ErrInvalidToken,
+	OAuthInvalidScope:            ErrValidation,
+	OAuthUnauthorizedClient:      ErrForbidden,
+	OAuthUnsupportedGrantType:    ErrValidation,
+	OAuthUnsupportedResponseType: ErrValidation,
+	OAuthAccessDenied:            ErrForbidden,
+}
+
+// OAuthService is an OAuth 2.0 and OpenID Connect provider, it lets network services log users in with the
+// authorization code grant (with PKCE) and authenticate themselves with the client credentials grant.
+//
+// The access tokens issued to the clients are regular Tokens, or InternalTokens for the client credentials
+// grant, restricted to the web permissions granted as scopes. Services verify them with the JSON Web Key
+// Set instead of sharing Config.Secret.
+type OAuthService interface {
+	Authorize(context.Context, *OAuthRequest, bson.ObjectId) (*OAuthAuthorization, error)
+	Approve(context.Context, *OAuthRequest, bson.ObjectId) (string, error)
+	Deny(context.Context, *OAuthRequest, bson.ObjectId) (string, error)
+	Exchange(context.Context, *OAuthTokenRequest) (*OAuthTokenResponse, error)
+	UserInfo(context.Context, *Token) (map[string]interface{}, error)
+	Revoke(context.Context, string, string, string) error
+	Consents(context.Context, bson.ObjectId) ([]OAuthConsent, error)
+	RevokeConsent(context.Context, bson.ObjectId, string) error
+	Discovery(context.Context) *OAuthDiscovery
+}
+
+// OAuthServiceImpl is an implementation for the OAuthService interface.
+type OAuthServiceImpl struct {
+	library *Library
+}
+
+// OAuthRequest represents an authorization request, the parameters a client redirects the user with.
+type OAuthRequest struct {
+	ClientID     string `json:"client_id"`
+	RedirectURI  string `json:"redirect_uri"`
+	ResponseType string `json:"response_type"`
+	// Scope is a space separated list of scopes.
+	Scope string `json:"scope"`
+	State string `json:"state"`
+	// Nonce is copied into the ID token.
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// OAuthAuthorization represents a validated authorization request, it is shown to the user when their consent
+// is required.
+type OAuthAuthorization struct {
+	Client      *OAuthClient `json:"client"`
+	RedirectURI string       `json:"redirectUri"`
+	// Scopes are the scopes the client will be granted, the web permissions the user doesn't have are left out.
+	Scopes          []string `json:"scopes"`
+	ConsentRequired bool     `json:"consentRequired"`
+}
+
+// OAuthTokenRequest represents a request to the token endpoint.
+type OAuthTokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	// Scope restricts the permissions of the client credentials grant, every permission of the client is
+	// granted if it is empty.
+	Scope     string
+	Address   string
+	UserAgent string
+}
+
+// OAuthTokenResponse represents the response of the token endpoint.
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// OAuthConsent represents the scopes a user granted to a client, they aren't asked again for them.
+type OAuthConsent struct {
+	ID        bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	User      bson.ObjectId `json:"user" bson:"user"`
+	Client    bson.ObjectId `json:"client" bson:"client"`
+	Scopes    []string      `json:"scopes" bson:"scopes"`
+	CreatedAt time.Time     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// OAuthCode represents an authorization code, only its hash is stored. It can be exchanged once, exchanging
+// it again revokes the tokens issued for it.
+type OAuthCode struct {
+	ID          bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	Hash        string        `json:"-" bson:"hash"`
+	Client      bson.ObjectId `json:"client" bson:"client"`
+	User        bson.ObjectId `json:"user" bson:"user"`
+	RedirectURI string        `json:"redirectUri" bson:"redirectUri"`
+	// RedirectURIRequired is true if the authorization request included the redirect uri, the token request
+	// must then include it too.
+	RedirectURIRequired bool            `json:"-" bson:"redirectUriRequired"`
+	Scopes              []string        `json:"scopes" bson:"scopes"`
+	Permissions         map[string]bool `json:"-" bson:"permissions"`
+	Nonce               string          `json:"-" bson:"nonce"`
+	// CodeChallenge is the PKCE challenge, the code verifier must match it.
+	CodeChallenge string    `json:"-" bson:"codeChallenge"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt" bson:"expiresAt"`
+	UsedAt        time.Time `json:"usedAt" bson:"usedAt"`
+	// Family is the family of the refresh tokens issued for the code.
+	Family bson.ObjectId `json:"family,omitempty" bson:"family,omitempty"`
+}
+
+// OAuthDiscovery represents the OpenID Connect discovery document.
+type OAuthDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// Authorize validates an authorization request made by a user, it returns the scopes the client will be
+// granted and whether the user must consent to them.
+func (service *OAuthServiceImpl) Authorize(ctx context.Context, request *OAuthRequest, user bson.ObjectId) (*OAuthAuthorization, error) {
+	authorization, _, err := service.authorize(ctx, "oauth.Authorize", request, user)
+	return authorization, err
+}
+
+// Approve records the consent of the user and returns the redirect uri of the client with an authorization
+// code, it is called once the user agreed to the request.
+func (service *OAuthServiceImpl) Approve(ctx context.Context, request *OAuthRequest, user bson.ObjectId) (string, error) {
+	authorization, permissions, err := service.authorize(ctx, "oauth.Approve", request, user)
+	if err != nil {
+		return "", err
+	}
+
+	if authorization.ConsentRequired {
+		if err = service.consent(ctx, "oauth.Approve", user, authorization); err != nil {
+			return "", err
+		}
+	}
+
+	codeTTL := time.Duration(service.library.config.OAuth.CodeTTL) * time.Second
+	if codeTTL <= 0 {
+		codeTTL = oauthDefaultCodeTTL
+	}
+
+	rawCode, err := newRefreshTokenSecret()
+	if err != nil {
+		return "", wrapError("oauth.Approve", err)
+	}
+
+	now := time.Now()
+	code := &OAuthCode{
+		ID:                  bson.NewObjectId(),
+		Hash:                hashRefreshToken(rawCode),
+		Client:              authorization.Client.ID,
+		User:                user,
+		RedirectURI:         authorization.RedirectURI,
+		RedirectURIRequired: len(request.RedirectURI) > 0,
+		Scopes:              authorization.Scopes,
+		Permissions:         permissions,
+		Nonce:               request.Nonce,
+		CodeChallenge:       request.CodeChallenge,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(codeTTL),
+	}
+
+	err = service.library.Storage.C(backend.OAuthCodeCollection).Insert(code)
+	if err != nil {
+		return "", wrapError("oauth.Approve", err)
+	}
+
+	return redirectWith(authorization.RedirectURI, url.Values{"code": {rawCode}}, request.State), nil
+}
+
+// Deny returns the redirect uri of the client with an "access_denied" error, it is called once the user
+// refused the request.
+func (service *OAuthServiceImpl) Deny(ctx context.Context, request *OAuthRequest, user bson.ObjectId) (string, error) {
+	authorization, _, err := service.authorize(ctx, "oauth.Deny", request, user)
+	if err != nil {
+		return "", err
+	}
+
+	return redirectWith(authorization.RedirectURI, url.Values{"error": {OAuthAccessDenied}}, request.State), nil
+}
+
+// Exchange implements the token endpoint, it authenticates the client and issues tokens for the
+// authorization code, refresh token and client credentials grants. Failed client authentications and invalid
+// grants are throttled by address with LoginService, a RetryError is returned while the address is blocked.
+func (service *OAuthServiceImpl) Exchange(ctx context.Context, request *OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	err := service.library.Login.Check(ctx, "", request.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := service.exchange(ctx, request)
+
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) && (oauthErr.Code == OAuthInvalidClient || oauthErr.Code == OAuthInvalidGrant) {
+		if failErr := service.library.Login.Fail(ctx, "", request.Address); failErr != nil {
+			return nil, failErr
+		}
+	}
+
+	return response, err
+}
+
+// exchange authenticates the client of a token request and issues the tokens of its grant.
+func (service *OAuthServiceImpl) exchange(ctx context.Context, request *OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	client, err := service.library.OAuthClient.Authenticate(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, oauthError("oauth.Exchange", OAuthInvalidClient, "client authentication failed")
+	}
+
+	switch request.GrantType {
+	case OAuthGrantAuthorizationCode, OAuthGrantRefreshToken, OAuthGrantClientCredentials:
+	default:
+		return nil, oauthError("oauth.Exchange", OAuthUnsupportedGrantType, "unsupported grant type %q", request.GrantType)
+	}
+
+	if !client.allowsGrant(request.GrantType) || (client.Public && request.GrantType == OAuthGrantClientCredentials) {
+		return nil, oauthError("oauth.Exchange", OAuthUnauthorizedClient, "client may not use the %s grant", request.GrantType)
+	}
+
+	switch request.GrantType {
+	case OAuthGrantAuthorizationCode:
+		return service.exchangeCode(ctx, client, request)
+	case OAuthGrantRefreshToken:
+		return service.exchangeRefreshToken(ctx, client, request)
+	}
+
+	return service.exchangeClientCredentials(ctx, client, request)
+}
+
+// UserInfo returns the claims of the user an access token was issued to, the token must have been granted
+// the "openid" scope.
+func (service *OAuthServiceImpl) UserInfo(ctx context.Context, token *Token) (map[string]interface{}, error) {
+	if len(token.Client) < 1 || !containsString(token.Scopes, OAuthScopeOpenID) {
+		return nil, newError("oauth.UserInfo", ErrForbidden, "token wasn't granted the %q scope", OAuthScopeOpenID)
+	}
+
+	return service.userClaims(ctx, "oauth.UserInfo", token.User, token.Scopes)
+}
+
+// Revoke revokes a refresh token, along with its family, or an access token issued to a client (RFC 7009).
+// Unknown tokens and tokens issued to other clients are ignored, only a failed client authentication is
+// reported.
+func (service *OAuthServiceImpl) Revoke(ctx context.Context, clientID string, clientSecret string, rawToken string) error {
+	client, err := service.library.OAuthClient.Authenticate(ctx, clientID, clientSecret)
+	if err != nil {
+		return oauthError("oauth.Revoke", OAuthInvalidClient, "client authentication failed")
+	}
+
+	tokens := &TokenServiceImpl{library: service.library}
+	refreshToken, _ := tokens.findRefreshToken("oauth.Revoke", rawToken)
+	if refreshToken != nil {
+		if refreshToken.Client != client.ID || !refreshToken.RevokedAt.IsZero() {
+			return nil
+		}
+
+		return tokens.revokeFamily(ctx, "oauth.Revoke", refreshToken, TokenRevokeOAuth)
+	}
+
+	token, err := tokens.FromJWT(ctx, rawToken)
+	if err != nil || token.Client != client.ID {
+		return nil
+	}
+
+	err = tokens.Delete(ctx, token.ID.Hex())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// Consents lists the clients a user granted scopes to.
+func (service *OAuthServiceImpl) Consents(ctx context.Context, user bson.ObjectId) ([]OAuthConsent, error) {
+	var consents []OAuthConsent
+
+	err := service.consents().Find(backend.Where("user", backend.Eq, user)).All(&consents)
+	if err != nil {
+		return nil, wrapError("oauth.Consents", err)
+	}
+
+	return consents, nil
+}
+
+// RevokeConsent removes the consent a user gave to a client and revokes the tokens issued to it on their
+// behalf, the user is asked for their consent again on their next authorization.
+func (service *OAuthServiceImpl) RevokeConsent(ctx context.Context, user bson.ObjectId, clientID string) error {
+	client, err := parseObjectID("oauth.RevokeConsent", clientID)
+	if err != nil {
+		return err
+	}
+
+	consent, err := service.findConsent("oauth.RevokeConsent", user, client)
+	if err != nil {
+		return err
+	}
+
+	tokens := &TokenServiceImpl{library: service.library}
+	revoked, err := tokens.revokeFamilies(ctx, "oauth.RevokeConsent", backend.Where("user", backend.Eq, user).Where("client", backend.Eq, client), TokenRevokeOAuth)
+	if err != nil {
+		return err
+	}
+
+	// Trusted clients have no consent, their tokens can still be revoked.
+	if consent == nil && revoked < 1 {
+		return newError("oauth.RevokeConsent", ErrNotFound, "")
+	}
+
+	event := &OAuthConsentRevokeEvent{
+		User:   user.Hex(),
+		Client: clientID,
+	}
+	if consent != nil {
+		err = service.library.EventManager.remove(backend.OAuthConsentCollection, consent.ID, event)
+		if err == mgo.ErrNotFound {
+			// The consent was revoked concurrently, its tokens still had to be.
+			err = service.library.EventManager.Call(event)
+		}
+	} else {
+		err = service.library.EventManager.Call(event)
+	}
+	return wrapError("oauth.RevokeConsent", err)
+}
+
+// Discovery returns the OpenID Connect discovery document, the endpoints are based on Config.OAuth.Issuer.
+func (service *OAuthServiceImpl) Discovery(ctx context.Context) *OAuthDiscovery {
+	issuer := service.issuer()
+
+	// Tokens signed with secret keys can't be verified by the clients.
+	var algorithms []string
+	for _, key := range service.library.keyring.list() {
+		if key.Algorithm != SigningKeyHS256 && !containsString(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	return &OAuthDiscovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{OAuthGrantAuthorizationCode, OAuthGrantRefreshToken, OAuthGrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algorithms,
+		ScopesSupported:                   []string{OAuthScopeOpenID, OAuthScopeProfile, OAuthScopeEmail},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "uniqueId", "name", "group", "email"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{oauthCodeChallengeMethod},
+	}
+}
+
+// authorize validates an authorization request, it returns the authorization and the web permissions granted
+// by its scopes.
+func (service *OAuthServiceImpl) authorize(ctx context.Context, op string, request *OAuthRequest, user bson.ObjectId) (*OAuthAuthorization, map[string]bool, error) {
+	client, err := service.library.OAuthClient.GetByID(ctx, request.ClientID)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidID) {
+		return nil, nil, oauthError(op, OAuthInvalidRequest, "unknown client %q", request.ClientID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The redirect uri is checked first, the other errors can be reported to the client through it.
+	redirectURI := request.RedirectURI
+	if len(redirectURI) < 1 && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+
+	if !client.allowsRedirect(redirectURI) {
+		return nil, nil, oauthError(op, OAuthInvalidRequest, "redirect uri %q isn't registered", redirectURI)
+	}
+
+	if request.ResponseType != "code" {
+		return nil, nil, oauthError(op, OAuthUnsupportedResponseType, "unsupported response type %q", request.ResponseType)
+	}
+
+	if !client.allowsGrant(OAuthGrantAuthorizationCode) {
+		return nil, nil, oauthError(op, OAuthUnauthorizedClient, "client may not use the %s grant", OAuthGrantAuthorizationCode)
+	}
+
+	if len(request.CodeChallenge) > 0 && request.CodeChallengeMethod != oauthCodeChallengeMethod {
+		return nil, nil, oauthError(op, OAuthInvalidRequest, "code challenge method must be %q", oauthCodeChallengeMethod)
+	}
+
+	if client.Public && len(request.CodeChallenge) < 1 {
+		return nil, nil, oauthError(op, OAuthInvalidRequest, "public clients must use a code challenge")
+	}
+
+	scopes := parseScope(request.Scope)
+	if len(scopes) < 1 {
+		return nil, nil, oauthError(op, OAuthInvalidScope, "missing scope")
+	}
+
+	for _, scope := range scopes {
+		if !client.allowsScope(scope) {
+			return nil, nil, oauthError(op, OAuthInvalidScope, "client may not request the %q scope", scope)
+		}
+	}
+
+	account, err := service.library.User.GetByID(ctx, user.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	group, err := service.library.Group.GetByID(ctx, account.Group.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Web permissions the user doesn't have are left out instead of failing the request.
+	granted := make([]string, 0, len(scopes))
+	permissions := make(map[string]bool)
+	for _, scope := range scopes {
+		if !isIdentityScope(scope) {
+			if !group.HasWebPermission(scope) {
+				continue
+			}
+
+			permissions[scope] = true
+		}
+
+		granted = append(granted, scope)
+	}
+
+	authorization := &OAuthAuthorization{
+		Client:      client,
+		RedirectURI: redirectURI,
+		Scopes:      granted,
+	}
+
+	if !client.Trusted {
+		consent, err := service.findConsent(op, user, client.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		authorization.ConsentRequired = consent == nil || !coversScopes(consent.Scopes, granted)
+	}
+
+	return authorization, permissions, nil
+}
+
+// consent records that a user granted the scopes of an authorization to its client, they are added to the
+// scopes granted previously.
+func (service *OAuthServiceImpl) consent(ctx context.Context, op string, user bson.ObjectId, authorization *OAuthAuthorization) error {
+	consent, err := service.findConsent(op, user, authorization.Client.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if consent == nil {
+		consent = &OAuthConsent{
+			ID:        bson.NewObjectId(),
+			User:      user,
+			Client:    authorization.Client.ID,
+			Scopes:    authorization.Scopes,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		err = service.library.EventManager.insert(backend.OAuthConsentCollection, consent.ID, consent, &OAuthConsentGrantEvent{
+			User:   user.Hex(),
+			Client: authorization.Client.ID.Hex(),
+			Scope:  strings.Join(consent.Scopes, " "),
+		})
+	} else {
+		for _, scope := range authorization.Scopes {
+			if !containsString(consent.Scopes, scope) {
+				consent.Scopes = append(consent.Scopes, scope)
+			}
+		}
+
+		err = service.library.EventManager.update(backend.OAuthConsentCollection, consent.ID, backend.Where("_id", backend.Eq, consent.ID), backend.Update{
+			Set: map[string]interface{}{"scopes": consent.Scopes, "updatedAt": now},
+		}, &OAuthConsentGrantEvent{
+			User:   user.Hex(),
+			Client: authorization.Client.ID.Hex(),
+			Scope:  strings.Join(consent.Scopes, " "),
+		})
+	}
+	return wrapError(op, err)
+}
+
+// findConsent returns the consent a user gave to a client, or nil if there is none.
+func (service *OAuthServiceImpl) findConsent(op string, user bson.ObjectId, client bson.ObjectId) (*OAuthConsent, error) {
+	var consent *OAuthConsent
+	err := service.consents().Find(backend.Where("user", backend.Eq, user).Where("client", backend.Eq, client)).One(&consent)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return consent, nil
+}
+
+// exchangeCode implements the authorization code grant.
+func (service *OAuthServiceImpl) exchangeCode(ctx context.Context, client *OAuthClient, request *OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	codes := service.library.Storage.C(backend.OAuthCodeCollection)
+
+	var code *OAuthCode
+	err := codes.Find(backend.Where("hash", backend.Eq, hashRefreshToken(request.Code))).One(&code)
+	if err == mgo.ErrNotFound || len(request.Code) < 1 {
+		return nil, oauthError("oauth.Exchange", OAuthInvalidGrant, "unknown authorization code")
+	}
+	if err != nil {
+		return nil, wrapError("oauth.Exchange", err)
+	}
+
+	if code.Client != client.ID {
+		return nil, oauthError("oauth.Exchange", OAuthInvalidGrant, "authorization code was issued to another client")
+	}
+
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, oauthError("oauth.Exchange", OAuthInvalidGrant, "authorization code has expired")
+	}
+
+	// The redirect uri may only be left out if the authorization request left it out too.
+	if (code.RedirectURIRequired || len(request.RedirectURI) > 0) && request.RedirectURI != code.RedirectURI {
+		return nil, oauthError("oauth.Exchange", OAuthInvalidGrant, "redirect uri doesn't match the authorization request")
+	}
+
+	if !verifyCodeChallenge(code.CodeChallenge, request.CodeVerifier) {
+		return nil, oauthError("oauth.Exchange", OAuthInvalidGrant, "invalid code verifier")
+	}
+
+	// Claim the code, this fails if it has been exchanged concurrently.
+	err = codes.Update(
+		backend.Where("_id", backend.Eq, code.ID).Where("usedAt", backend.Eq, time.Time{}),
+		backend.Update{Set: map[string]interface{}{"usedAt": time.Now()}},
+	)
+	if err == mgo.ErrNotFound {
+		return nil, service.rejectCodeReuse(ctx, code)
+	}
+	if err != nil {
+		return nil, wrapError("oauth.Exchange", err)
+	}
+
+	tokens := &TokenServiceImpl{library: service.library}
+	pair, err := tokens.issue(ctx, "oauth.Exchange", code.User, request.Address, request.UserAgent, code.Permissions, "", tokenGrant{
+		Name:   client.Name,
+		Client: client.ID,
+		Scopes: code.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = codes.Update(backend.Where("_id", backend.Eq, code.ID), backend.Update{
+		Set: map[string]interface{}{"family": pair.refreshToken.Family},
+	})
+	if err != nil {
+		return nil, wrapError("oauth.Exchange", err)
+	}
+
+	return service.tokenResponse(ctx, "oauth.Exchange", client, pair, code.Nonce)
+}
+
+// rejectCodeReuse revokes the tokens issued for an authorization code that was exchanged twice, as it was
+// likely stolen. It returns the error passed to the client.
+func (service *OAuthServiceImpl) rejectCodeReuse(ctx context.Context, code *OAuthCode) error {
+	var stored *OAuthCode
+	err := service.library.Storage.C(backend.OAuthCodeCollection).FindId(code.ID).One(&stored)
+	if err != nil && err != mgo.ErrNotFound {
+		return wrapError("oauth.Exchange", err)
+	}
+
+	if stored != nil && len(stored.Family) > 0 {
+		tokens := &TokenServiceImpl{library: service.library}
+		err = tokens.revokeFamily(ctx, "oauth.Exchange", &RefreshToken{Family: stored.Family, User: stored.User}, TokenRevokeReuse)
+		if err != nil {
+			return err
+		}
+	}
+
+	return oauthError("oauth.Exchange", OAuthInvalidGrant, "authorization code has already been used")
+}
+
+// exchangeRefreshToken implements the refresh token grant, the refresh token must have been issued to the
+// client.
+func (service *OAuthServiceImpl) exchangeRefreshToken(ctx context.Context, client *OAuthClient, request *OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	tokens := &TokenServiceImpl{library: service.library}
+
+	refreshToken, err := tokens.findRefreshToken("oauth.Exchange", request.RefreshToken)
+	if refreshToken == nil && err != nil && !errors.Is(err, ErrInvalidToken) {
+		return nil, err
+	}
+
+	if refreshToken == nil || refreshToken.Client != client.ID {
+		return nil, oauthError("oauth.Exchange", OAuthInvalidGrant, "unknown refresh token")
+	}
+
+	pair, err := tokens.Refresh(ctx, request.RefreshToken, request.Address, request.UserAgent)
+	if errors.Is(err, ErrInvalidToken) {
+		return nil, &OAuthError{Code: OAuthInvalidGrant, Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return service.tokenResponse(ctx, "oauth.Exchange", client, pair, "")
+}
+
+// exchangeClientCredentials implements the client credentials grant, the client gets an internal token
+// granted its permissions, or the ones it requested as scopes. The token isn't stored, FromJWT accepts it
+// until it expires after Config.Tokens.AccessTTL and it can't be revoked on its own.
+func (service *OAuthServiceImpl) exchangeClientCredentials(ctx context.Context, client *OAuthClient, request *OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	scopes := parseScope(request.Scope)
+	if len(scopes) < 1 {
+		for permission, granted := range client.Permissions {
+			if granted {
+				scopes = append(scopes, permission)
+			}
+		}
+		sort.Strings(scopes)
+	}
+
+	permissions := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		if !client.Permissions[scope] {
+			return nil, oauthError("oauth.Exchange", OAuthInvalidScope, "client wasn't granted the %q permission", scope)
+		}
+
+		permissions[scope] = true
+	}
+
+	accessTTL := time.Duration(service.library.config.Tokens.AccessTTL) * time.Second
+	if accessTTL <= 0 {
+		accessTTL = tokenDefaultAccessTTL
+	}
+
+	token := service.library.InternalToken.New(ctx, "oauth:"+client.Name, permissions)
+	token.ExpiresAt = token.CreatedAt.Add(accessTTL)
+
+	jwt, err := token.JWT(service.library)
+	if err != nil {
+		return nil, wrapError("oauth.Exchange", err)
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken: jwt,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTTL / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// tokenResponse converts the tokens issued to a client into the response of the token endpoint, the ID token
+// is added if the "openid" scope was granted.
+func (service *OAuthServiceImpl) tokenResponse(ctx context.Context, op string, client *OAuthClient, pair *TokenPair, nonce string) (*OAuthTokenResponse, error) {
+	response := &OAuthTokenResponse{
+		AccessToken: pair.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(pair.ExpiresAt).Round(time.Second) / time.Second),
+		Scope:       strings.Join(pair.Token.Scopes, " "),
+	}
+
+	if client.allowsGrant(OAuthGrantRefreshToken) {
+		response.RefreshToken = pair.RefreshToken
+	}
+
+	if containsString(pair.Token.Scopes, OAuthScopeOpenID) {
+		idToken, err := service.idToken(ctx, op, client, pair.Token, nonce)
+		if err != nil {
+			return nil, err
+		}
+
+		response.IDToken = idToken
+	}
+
+	return response, nil
+}
+
+// idToken generates the OpenID Connect ID token of an access token, it expires with it.
+func (service *OAuthServiceImpl) idToken(ctx context.Context, op string, client *OAuthClient, token *Token, nonce string) (string, error) {
+	claims, err := service.userClaims(ctx, op, token.User, token.Scopes)
+	if err != nil {
+		return "", err
+	}
+
+	claims["iss"] = service.issuer()
+	claims["aud"] = client.ID.Hex()
+	claims["iat"] = token.CreatedAt.Unix()
+	claims["exp"] = token.ExpiresAt.Unix()
+	if len(nonce) > 0 {
+		claims["nonce"] = nonce
+	}
+
+	idToken, err := service.library.keyring.sign(jwt.MapClaims(claims), nil)
+	if err != nil {
+		return "", wrapError(op, err)
+	}
+
+	return idToken, nil
+}
+
+// userClaims returns the claims about a user released by a set of scopes.
+func (service *OAuthServiceImpl) userClaims(ctx context.Context, op string, id bson.ObjectId, scopes []string) (map[string]interface{}, error) {
+	user, err := service.library.User.GetByID(ctx, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{
+		"sub": user.ID.Hex(),
+	}
+
+	if containsString(scopes, OAuthScopeProfile) {
+		group, err := service.library.Group.GetByID(ctx, user.Group.Hex())
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		claims["uniqueId"] = user.UniqueID
+		claims["name"] = user.Name
+		if group != nil {
+			claims["group"] = group.Name
+		}
+	}
+
+	if containsString(scopes, OAuthScopeEmail) {
+		claims["email"] = user.Email
+	}
+
+	return claims, nil
+}
+
+// issuer returns the issuer of the ID tokens, without a trailing slash.
+func (service *OAuthServiceImpl) issuer() string {
+	issuer := service.library.config.OAuth.Issuer
+	if len(issuer) < 1 {
+		issuer = oauthDefaultIssuer
+	}
+
+	return strings.TrimSuffix(issuer, "/")
+}
+
+// consents returns the collection holding the consents.
+func (service *OAuthServiceImpl) consents() backend.Collection {
+	return service.library.Storage.C(backend.OAuthConsentCollection)
+}
+
+// allowsScope returns true if the client may request a scope.
+func (client *OAuthClient) allowsScope(scope string) bool {
+	return containsString(client.Scopes, scope)
+}
+
+// oauthError creates an OAuthError, the kind of the wrapped Error depends on the code.
+func oauthError(op string, code string, format string, args ...interface{}) error {
+	return &OAuthError{Code: code, Err: newError(op, oauthErrorKinds[code], format, args...)}
+}
+
+// isIdentityScope returns true if a scope releases claims about the user rather than a web permission.
+func isIdentityScope(scope string) bool {
+	return scope == OAuthScopeOpenID || scope == OAuthScopeProfile || scope == OAuthScopeEmail
+}
+
+// parseScope splits a space separated list of scopes, duplicates are removed.
+func parseScope(scope string) []string {
+	var scopes []string
+	for _, value := range strings.Fields(scope) {
+		if !containsString(scopes, value) {
+			scopes = append(scopes, value)
+		}
+	}
+
+	return scopes
+}
+
+// coversScopes returns true if every scope has been granted.
+func coversScopes(granted []string, scopes []string) bool {
+	for _, scope := range scopes {
+		if !containsString(granted, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against the challenge of an authorization code, a verifier
+// must not be sent for codes without a challenge.
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	if len(challenge) < 1 {
+		return len(verifier) < 1
+	}
+
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// redirectWith adds parameters and the state of the authorization request to a redirect uri.
+func redirectWith(redirectURI string, params url.Values, state string) string {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := parsed.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if len(state) > 0 {
+		query.Set("state", state)
+	}
+
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"net/url"
+	"time"
+)
+
+// OAuth grant types a client can be allowed to use.
+const (
+	OAuthGrantAuthorizationCode = "authorization_code"
+	OAuthGrantClientCredentials = "client_credentials"
+	OAuthGrantRefreshToken      = "refresh_token"
+)
+
+// OAuthClientService is an interface for managing the OAuth clients, the network services users log into
+// with their account.
+type OAuthClientService interface {
+	New(context.Context, string, bool) *OAuthClient
+	GetByID(context.Context, string) (*OAuthClient, error)
+	List(context.Context) ([]OAuthClient, error)
+	Create(context.Context, *OAuthClient) error
+	Update(context.Context, *OAuthClient) error
+	Delete(context.Context, string) error
+	RotateSecret(context.Context, string) (*OAuthClient, error)
+	Authenticate(context.Context, string, string) (*OAuthClient, error)
+}
+
+// OAuthClientServiceImpl is an implementation for the OAuthClientService interface.
+type OAuthClientServiceImpl struct {
+	library *Library
+}
+
+// OAuthClient represents a service users log into with their account, its id is the OAuth client_id.
+type OAuthClient struct {
+	ID   bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	Name string        `json:"name" bson:"name"`
+	// Secret is only set by New and RotateSecret, only its hash is stored.
+	Secret     string `json:"secret,omitempty" bson:"-"`
+	SecretHash string `json:"-" bson:"secretHash"`
+	// Public clients, such as the Discord bot's web dashboard, can't keep a secret. They must use PKCE and
+	// can't use the client credentials grant.
+	Public       bool     `json:"public" bson:"public"`
+	RedirectURIs []string `json:"redirectUris" bson:"redirectUris"`
+	GrantTypes   []string `json:"grantTypes" bson:"grantTypes"`
+	// Scopes are the scopes the client may request, "openid", "profile", "email" and web permissions.
+	Scopes []string `json:"scopes" bson:"scopes"`
+	// Permissions are granted to the tokens issued with the client credentials grant.
+	Permissions map[string]bool `json:"permissions" bson:"permissions"`
+	// Trusted clients, run by the network itself, don't ask the users for their consent.
+	Trusted   bool      `json:"trusted" bson:"trusted"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	Version   int       `json:"version" bson:"version"`
+}
+
+// New attempts to create a new OAuthClient object, confidential clients get a random secret.
+func (service *OAuthClientServiceImpl) New(ctx context.Context, name string, public bool) *OAuthClient {
+	client := &OAuthClient{
+		ID:          bson.NewObjectId(),
+		Name:        name,
+		Public:      public,
+		GrantTypes:  []string{OAuthGrantAuthorizationCode, OAuthGrantRefreshToken},
+		Scopes:      []string{OAuthScopeOpenID, OAuthScopeProfile},
+		Permissions: map[string]bool{},
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if !public {
+		client.setSecret(newOAuthClientSecret())
+	}
+
+	return client
+}
+
+// GetByID attempts to get a client by using an id.
+func (service *OAuthClientServiceImpl) GetByID(ctx context.Context, id string) (*OAuthClient, error) {
+	objectID, err := parseObjectID("oauthClient.GetByID", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var client *OAuthClient
+	err = service.library.Storage.C(backend.OAuthClientCollection).FindId(objectID).One(&client)
+	if err != nil {
+		return nil, wrapError("oauthClient.GetByID", err)
+	}
+
+	return client, nil
+}
+
+// List clients
+func (service *OAuthClientServiceImpl) List(ctx context.Context) ([]OAuthClient, error) {
+	var clients []OAuthClient
+
+	err := service.library.Storage.C(backend.OAuthClientCollection).Find(backend.Filter{}).All(&clients)
+	if err != nil {
+		return nil, wrapError("oauthClient.List", err)
+	}
+
+	return clients, nil
+}
+
+// Create a client
+func (service *OAuthClientServiceImpl) Create(ctx context.Context, client *OAuthClient) error {
+	if err := client.validate("oauthClient.Create"); err != nil {
+		return err
+	}
+
+	client.Version = 1
+
+	err := service.library.EventManager.insert(backend.OAuthClientCollection, client.ID, client, &OAuthClientCreateEvent{
+		OAuthClient: client,
+	})
+	if err != nil {
+		return wrapError("oauthClient.Create", err)
+	}
+
+	return nil
+}
+
+// Update a client
+func (service *OAuthClientServiceImpl) Update(ctx context.Context, client *OAuthClient) error {
+	if err := client.validate("oauthClient.Update"); err != nil {
+		return err
+	}
+
+	client.UpdatedAt = time.Now()
+
+	updated, err := updateVersioned("oauthClient.Update", service.library.EventManager, backend.OAuthClientCollection, client.ID, client.Version, client, nil)
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		client.Version++
+	}
+
+	return nil
+}
+
+// Delete a client, the refresh tokens issued to it are revoked. Its internal tokens, issued by the client
+// credentials grant, stay valid until they expire.
+func (service *OAuthClientServiceImpl) Delete(ctx context.Context, id string) error {
+	objectID, err := parseObjectID("oauthClient.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	err = service.library.EventManager.remove(backend.OAuthClientCollection, objectID, &OAuthClientDeleteEvent{
+		ID: id,
+	})
+	if err != nil {
+		return wrapError("oauthClient.Delete", err)
+	}
+
+	tokens := &TokenServiceImpl{library: service.library}
+	_, err = tokens.revokeFamilies(ctx, "oauthClient.Delete", backend.Where("client", backend.Eq, objectID), TokenRevokeOAuth)
+	return err
+}
+
+// RotateSecret replaces the secret of a confidential client, the client is returned with its new secret.
+func (service *OAuthClientServiceImpl) RotateSecret(ctx context.Context, id string) (*OAuthClient, error) {
+	client, err := service.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public {
+		return nil, newError("oauthClient.RotateSecret", ErrValidation, "public clients have no secret")
+	}
+
+	client.setSecret(newOAuthClientSecret())
+	if err = service.Update(ctx, client); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Authenticate returns the client matching a client_id and a client_secret, public clients are
+// authenticated by their id alone. An ErrInvalidToken error is returned if they don't match.
+func (service *OAuthClientServiceImpl) Authenticate(ctx context.Context, id string, secret string) (*OAuthClient, error) {
+	client, err := service.GetByID(ctx, id)
+	if err != nil {
+		return nil, newError("oauthClient.Authenticate", ErrInvalidToken, "unknown client")
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	hash := hashRefreshToken(secret)
+	if len(secret) < 1 || subtle.ConstantTimeCompare([]byte(hash), []byte(client.SecretHash)) != 1 {
+		return nil, newError("oauthClient.Authenticate", ErrInvalidToken, "invalid client secret")
+	}
+
+	return client, nil
+}
+
+// validate checks that the client can be written to storage.
+func (client *OAuthClient) validate(op string) error {
+	if !client.ID.Valid() {
+		return newError(op, ErrInvalidID, "client is missing an id")
+	}
+
+	if len(client.Name) < 1 {
+		return newError(op, ErrValidation, "client must have a name")
+	}
+
+	if !client.Public && len(client.SecretHash) < 1 {
+		return newError(op, ErrValidation, "confidential clients must have a secret")
+	}
+
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case OAuthGrantAuthorizationCode, OAuthGrantRefreshToken:
+		case OAuthGrantClientCredentials:
+			if client.Public {
+				return newError(op, ErrValidation, "public clients can't use the client credentials grant")
+			}
+		default:
+			return newError(op, ErrValidation, "unsupported grant type %q", grantType)
+		}
+	}
+
+	for _, redirectURI := range client.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || len(parsed.Fragment) > 0 {
+			return newError(op, ErrValidation, "redirect uri %q must be an absolute uri without a fragment", redirectURI)
+		}
+	}
+
+	if client.allowsGrant(OAuthGrantAuthorizationCode) && len(client.RedirectURIs) < 1 {
+		return newError(op, ErrValidation, "clients using the authorization code grant must have a redirect uri")
+	}
+
+	return nil
+}
+
+// redacted returns a copy of the client that can be published, it leaves out the secret.
+func (client *OAuthClient) redacted() *OAuthClient {
+	redacted := *client
+	redacted.Secret = ""
+	return &redacted
+}
+
+// allowsGrant returns true if the client may use a grant type.
+func (client *OAuthClient) allowsGrant(grantType string) bool {
+	return containsString(client.GrantTypes, grantType)
+}
+
+// allowsRedirect returns true if a redirect uri is registered, uris are compared exactly.
+func (client *OAuthClient) allowsRedirect(redirectURI string) bool {
+	return containsString(client.RedirectURIs, redirectURI)
+}
+
+// setSecret sets the secret of the client and its hash.
+func (client *OAuthClient) setSecret(secret string) {
+	client.Secret = secret
+	client.SecretHash = hashRefreshToken(secret)
+}
+
+// newOAuthClientSecret generates a random client secret.
+func newOAuthClientSecret() string {
+	data := make([]byte, 32)
+	_, _ = rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// containsString returns true if a slice contains a string.
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
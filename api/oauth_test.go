@@ -0,0 +1,38 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// The S256 example of RFC 7636, appendix B.
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		ok        bool
+	}{
+		{"rfc example", challenge, verifier, true},
+		{"wrong verifier", challenge, verifier[:42] + "Y", false},
+		{"plain verifier", verifier, verifier, false},
+		{"missing verifier", challenge, "", false},
+		{"short verifier", challenge, verifier[:42], false},
+		{"long verifier", challenge, strings.Repeat("a", 129), false},
+		{"no challenge", "", "", true},
+		{"verifier without challenge", "", verifier, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ok := verifyCodeChallenge(test.challenge, test.verifier); ok != test.ok {
+				t.Errorf("verifyCodeChallenge(%q, %q) = %t, want %t", test.challenge, test.verifier, ok, test.ok)
+			}
+		})
+	}
+}
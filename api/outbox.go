@@ -0,0 +1,308 @@
+package api
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"api/logger"
+	"time"
+)
+
+// Outbox relay settings.
+const (
+	outboxBatchSize    = 100
+	outboxPollInterval = time.Second
+	outboxClaimTimeout = 30 * time.Second
+	outboxMinBackoff   = time.Second
+	outboxMaxBackoff   = 5 * time.Minute
+	// outboxCommitTimeout is how long a pending entry waits for its write before the relay checks the storage.
+	outboxCommitTimeout = 30 * time.Second
+)
+
+// outboxMarkerField is the array field of the documents holding the ids of the pending outbox entries of
+// their writes.
+const outboxMarkerField = "_events"
+
+// outboxEntry represents an event that has been recorded but not yet relayed to the events channel.
+type outboxEntry struct {
+	ID            bson.ObjectId `bson:"_id"`
+	EventID       string        `bson:"eventId"`
+	Type          string        `bson:"type"`
+	Data          []byte        `bson:"data"`
+	Attempts      int           `bson:"attempts"`
+	LastError     string        `bson:"lastError"`
+	NextAttemptAt time.Time     `bson:"nextAttemptAt"`
+	ClaimedUntil  time.Time     `bson:"claimedUntil"`
+	CreatedAt     time.Time     `bson:"createdAt"`
+	// Payload is the event encoded in JSON for the webhooks, whatever the codec of the events transport.
+	Payload []byte `bson:"payload"`
+	// Pending is set until the write of the document the event describes has succeeded.
+	Pending bool `bson:"pending"`
+	// Collection and Document identify the document written with the event, they are empty for events that
+	// don't describe the write of a document.
+	Collection string      `bson:"collection,omitempty"`
+	Document   interface{} `bson:"document,omitempty"`
+	// Removes is set if the write removes the document, other writes add the id of the entry to its _events.
+	Removes bool `bson:"removes,omitempty"`
+}
+
+// outboxRelay publishes outbox entries to the events channel in the background, retrying failed entries
+// with an exponential backoff until they are delivered.
+//
+// Events describing the write of a document are recorded as pending before the write, which adds the id of
+// the entry to the _events of the document or removes it in the same operation. Entries are committed once
+// their write succeeds, the relay checks the storage for the pending entries that are never committed: the
+// event is relayed if the write happened and dropped otherwise. An event is relayed if and only if its write
+// succeeded, even if the instance stops in between.
+//
+// Entries are only removed once they have been published, which makes the delivery at-least-once: an entry
+// can be published twice if the relay stops between publishing and removing it. The webhook deliveries of an
+// entry are created before it is published, their ids are derived from the event so they are only created once.
+type outboxRelay struct {
+	manager *EventManager
+	wake    chan struct{}
+}
+
+// newOutboxRelay creates a new outbox relay.
+func newOutboxRelay(manager *EventManager) *outboxRelay {
+	return &outboxRelay{
+		manager: manager,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// collection returns the collection holding the outbox entries.
+func (relay *outboxRelay) collection() backend.Collection {
+	return relay.manager.Library.Storage.C(backend.OutboxCollection)
+}
+
+// record persists an encoded event to the outbox, pending entries are only relayed once they are committed
+// or their write is found in the storage.
+func (relay *outboxRelay) record(entry *outboxEntry) error {
+	now := time.Now()
+	entry.ID = bson.NewObjectId()
+	entry.CreatedAt = now
+	entry.NextAttemptAt = now
+	if entry.Pending {
+		entry.NextAttemptAt = now.Add(outboxCommitTimeout)
+	}
+
+	err := relay.collection().Insert(entry)
+	if err != nil {
+		return err
+	}
+
+	if !entry.Pending {
+		relay.wakeUp()
+	}
+
+	return nil
+}
+
+// commit marks a pending entry as written and wakes up the relay, the marker of the entry is then removed from
+// its document. Failures are logged, the relay finds the write in the storage.
+func (relay *outboxRelay) commit(entry *outboxEntry) {
+	err := relay.collection().Update(backend.Where("_id", backend.Eq, entry.ID), backend.Update{
+		Set: map[string]interface{}{"pending": false, "nextAttemptAt": time.Now()},
+	})
+	if err != nil {
+		logger.Errorw("[Events] Failed to commit outbox entry.", logger.Err(err))
+		return
+	}
+
+	relay.wakeUp()
+	relay.unmark(entry)
+}
+
+// abort removes a pending entry whose write failed. Failures are logged, the relay drops the entry once it
+// doesn't find the write in the storage.
+func (relay *outboxRelay) abort(entry *outboxEntry) {
+	err := relay.collection().RemoveId(entry.ID)
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Errorw("[Events] Failed to remove aborted outbox entry.", logger.Err(err))
+	}
+}
+
+// written returns true if the write of a pending entry is found in the storage.
+func (relay *outboxRelay) written(entry *outboxEntry) (bool, error) {
+	filter := backend.Where("_id", backend.Eq, entry.Document)
+	if !entry.Removes {
+		filter = filter.Where(outboxMarkerField, backend.Eq, entry.ID)
+	}
+
+	count, err := relay.manager.Library.Storage.C(entry.Collection).Find(filter).Count()
+	if err != nil {
+		return false, err
+	}
+
+	return (count > 0) != entry.Removes, nil
+}
+
+// unmark removes the id of an entry from the _events of its document, failures are logged.
+func (relay *outboxRelay) unmark(entry *outboxEntry) {
+	if len(entry.Collection) < 1 || entry.Removes {
+		return
+	}
+
+	err := relay.manager.Library.Storage.C(entry.Collection).Update(
+		backend.Where("_id", backend.Eq, entry.Document),
+		backend.Update{Pull: map[string]interface{}{outboxMarkerField: entry.ID}},
+	)
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Errorw("[Events] Failed to remove the marker of an outbox entry.", logger.Err(err))
+	}
+}
+
+// wakeUp makes the relay look for due entries right away.
+func (relay *outboxRelay) wakeUp() {
+	select {
+	case relay.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run relays outbox entries until stop is closed.
+func (relay *outboxRelay) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-relay.wake:
+		case <-ticker.C:
+		}
+
+		relay.relayPending()
+	}
+}
+
+// relayPending publishes every entry that is due.
+func (relay *outboxRelay) relayPending() {
+	for {
+		now := time.Now()
+
+		var entries []outboxEntry
+		err := relay.collection().
+			Find(backend.Where("nextAttemptAt", backend.Lte, now).Where("claimedUntil", backend.Lte, now)).
+			Sort("_id").
+			Limit(outboxBatchSize).
+			All(&entries)
+		if err != nil {
+			logger.Errorw("[Events] Failed to read the outbox.", logger.Err(err))
+			return
+		}
+
+		for _, entry := range entries {
+			relay.relay(entry)
+		}
+
+		if len(entries) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// relay publishes a single entry, making sure no other instance is relaying it at the same time.
+func (relay *outboxRelay) relay(entry outboxEntry) {
+	now := time.Now()
+
+	// Claim the entry, this fails if another instance claimed it first.
+	err := relay.collection().Update(
+		backend.Where("_id", backend.Eq, entry.ID).Where("claimedUntil", backend.Eq, entry.ClaimedUntil),
+		backend.Update{Set: map[string]interface{}{"claimedUntil": now.Add(outboxClaimTimeout)}},
+	)
+	if err == mgo.ErrNotFound {
+		return
+	}
+	if err != nil {
+		logger.Errorw("[Events] Failed to claim outbox entry.", logger.Err(err))
+		return
+	}
+
+	// The entry was never committed, relay it only if its write happened.
+	if entry.Pending {
+		written, err := relay.written(&entry)
+		if err != nil {
+			logger.Errorw("[Events] Failed to find the write of a pending outbox entry.", logger.Err(err))
+			relay.reschedule(entry, err)
+			return
+		}
+
+		if !written {
+			relay.abort(&entry)
+			return
+		}
+
+		// The entry is committed before its marker is removed, a retry would drop it otherwise.
+		err = relay.collection().Update(backend.Where("_id", backend.Eq, entry.ID), backend.Update{
+			Set: map[string]interface{}{"pending": false},
+		})
+		if err != nil {
+			logger.Errorw("[Events] Failed to commit outbox entry.", logger.Err(err))
+			relay.reschedule(entry, err)
+			return
+		}
+
+		entry.Pending = false
+		relay.unmark(&entry)
+	}
+
+	// Entries recorded before the webhook deliveries were created by the relay have no payload, their
+	// deliveries were created when the event was called.
+	if len(entry.Payload) > 0 {
+		err = relay.manager.webhooks.enqueue(entry.Type, entry.EventID, entry.CreatedAt, entry.Payload)
+		if err != nil {
+			logger.Errorw("[Webhooks] Failed to queue event deliveries.", logger.Err(err))
+			relay.reschedule(entry, err)
+			return
+		}
+	}
+
+	publishErr := relay.manager.publish(entry.Data)
+	if publishErr != nil {
+		logger.Errorw("[Events] Failed to publish event.", logger.Err(publishErr))
+		relay.reschedule(entry, publishErr)
+		return
+	}
+
+	err = relay.collection().RemoveId(entry.ID)
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Errorw("[Events] Failed to remove relayed outbox entry.", logger.Err(err))
+	}
+}
+
+// reschedule releases the claim of an entry that failed, it is attempted again after a backoff.
+func (relay *outboxRelay) reschedule(entry outboxEntry, cause error) {
+	err := relay.collection().Update(backend.Where("_id", backend.Eq, entry.ID), backend.Update{
+		Set: map[string]interface{}{
+			"lastError":     cause.Error(),
+			"nextAttemptAt": time.Now().Add(outboxBackoff(entry.Attempts)),
+			"claimedUntil":  time.Time{},
+		},
+		Inc: map[string]int{"attempts": 1},
+	})
+	if err != nil {
+		logger.Errorw("[Events] Failed to reschedule outbox entry.", logger.Err(err))
+	}
+}
+
+// publish sends an encoded event to the events transport.
+func (manager *EventManager) publish(data []byte) error {
+	return manager.transport.Publish(data)
+}
+
+// outboxBackoff returns how long to wait before retrying an entry that failed the specified amount of times.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxMinBackoff
+	for i := 0; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+
+	return backoff
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"errors"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// outboxTestTransport records the published events, it fails every publish while fail is set.
+type outboxTestTransport struct {
+	fail      bool
+	published []string
+}
+
+// Publish records an event.
+func (transport *outboxTestTransport) Publish(data []byte) error {
+	if transport.fail {
+		return errors.New("publish failed")
+	}
+
+	transport.published = append(transport.published, string(data))
+	return nil
+}
+
+// Subscribe returns right away, the relay doesn't receive events.
+func (transport *outboxTestTransport) Subscribe(handle func(data []byte)) error {
+	return nil
+}
+
+func (transport *outboxTestTransport) Close() error {
+	return nil
+}
+
+// newOutboxTestRelay creates a relay on an in-memory storage without starting it.
+func newOutboxTestRelay(transport *outboxTestTransport) *outboxRelay {
+	return newOutboxRelay(&EventManager{
+		Library:   &Library{Storage: backend.NewMemoryStorage(), Cache: backend.NewMemoryCache()},
+		transport: transport,
+	})
+}
+
+// outboxEntries returns every entry of the relay's outbox.
+func outboxEntries(t *testing.T, relay *outboxRelay) []outboxEntry {
+	t.Helper()
+
+	var entries []outboxEntry
+	if err := relay.collection().Find(backend.Filter{}).Sort("_id").All(&entries); err != nil {
+		t.Fatalf("Find returned an error: %v", err)
+	}
+
+	return entries
+}
+
+func TestOutboxRelay(t *testing.T) {
+	transport := &outboxTestTransport{}
+	relay := newOutboxTestRelay(transport)
+
+	for _, data := range []string{"first", "second"} {
+		if err := relay.record(&outboxEntry{Type: "test", Data: []byte(data)}); err != nil {
+			t.Fatalf("record returned an error: %v", err)
+		}
+	}
+
+	relay.relayPending()
+
+	if !reflect.DeepEqual(transport.published, []string{"first", "second"}) {
+		t.Errorf("published %q, want the entries in order", transport.published)
+	}
+
+	if entries := outboxEntries(t, relay); len(entries) > 0 {
+		t.Errorf("outbox holds %d entries after relaying, want none", len(entries))
+	}
+}
+
+func TestOutboxRelayRetries(t *testing.T) {
+	transport := &outboxTestTransport{fail: true}
+	relay := newOutboxTestRelay(transport)
+	if err := relay.record(&outboxEntry{Type: "test", Data: []byte("event")}); err != nil {
+		t.Fatalf("record returned an error: %v", err)
+	}
+
+	relay.relayPending()
+
+	entries := outboxEntries(t, relay)
+	if len(entries) != 1 {
+		t.Fatalf("outbox holds %d entries, want the failed entry", len(entries))
+	}
+
+	entry := entries[0]
+	if entry.Attempts != 1 || len(entry.LastError) < 1 || !entry.ClaimedUntil.IsZero() {
+		t.Errorf("entry = %+v, want an unclaimed entry with one failed attempt", entry)
+	}
+
+	if !entry.NextAttemptAt.After(time.Now()) {
+		t.Errorf("entry is due at %s, want a backoff", entry.NextAttemptAt)
+	}
+
+	// The entry isn't retried before its backoff elapsed.
+	relay.relayPending()
+	if entries = outboxEntries(t, relay); entries[0].Attempts != 1 {
+		t.Errorf("entry was retried %d times, want once", entries[0].Attempts)
+	}
+
+	// Once due, the entry is delivered by a working transport.
+	transport.fail = false
+
+	err := relay.collection().Update(
+		backend.Where("_id", backend.Eq, entry.ID),
+		backend.Update{Set: map[string]interface{}{"nextAttemptAt": time.Now()}},
+	)
+	if err != nil {
+		t.Fatalf("Update returned an error: %v", err)
+	}
+
+	relay.relayPending()
+	if len(transport.published) != 1 || len(outboxEntries(t, relay)) > 0 {
+		t.Errorf("published %q and kept %d entries, want the retried entry relayed", transport.published, len(outboxEntries(t, relay)))
+	}
+}
+
+func TestOutboxRelayPendingEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		// removes is set if the write removes the document.
+		removes bool
+		// exists and marked describe the document once the relay checks it.
+		exists    bool
+		marked    bool
+		committed bool
+		published bool
+	}{
+		{"committed", false, true, false, true, true},
+		{"written", false, true, true, false, true},
+		{"not written", false, true, false, false, false},
+		{"document not created", false, false, false, false, false},
+		{"removed", true, false, false, false, true},
+		{"not removed", true, true, false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := &outboxTestTransport{}
+			relay := newOutboxTestRelay(transport)
+			documents := relay.manager.Library.Storage.C(backend.GroupCollection)
+			id := bson.NewObjectId()
+
+			entry := &outboxEntry{
+				Type:       "test",
+				Data:       []byte("event"),
+				Pending:    true,
+				Collection: backend.GroupCollection,
+				Document:   id,
+				Removes:    test.removes,
+			}
+			if err := relay.record(entry); err != nil {
+				t.Fatalf("record returned an error: %v", err)
+			}
+
+			if test.exists {
+				document := bson.M{"_id": id, outboxMarkerField: []bson.ObjectId{}}
+				if test.marked || test.committed {
+					document[outboxMarkerField] = []bson.ObjectId{entry.ID}
+				}
+
+				if err := documents.Insert(document); err != nil {
+					t.Fatalf("Insert returned an error: %v", err)
+				}
+			}
+
+			// Pending entries aren't relayed before their write had time to commit them.
+			relay.relayPending()
+			if len(transport.published) > 0 && !test.committed {
+				t.Fatalf("a pending entry was relayed before its commit timeout")
+			}
+
+			if test.committed {
+				relay.commit(entry)
+			} else {
+				err := relay.collection().Update(
+					backend.Where("_id", backend.Eq, entry.ID),
+					backend.Update{Set: map[string]interface{}{"nextAttemptAt": time.Now()}},
+				)
+				if err != nil {
+					t.Fatalf("Update returned an error: %v", err)
+				}
+			}
+
+			relay.relayPending()
+
+			if published := len(transport.published) > 0; published != test.published {
+				t.Errorf("published = %t, want %t", published, test.published)
+			}
+
+			if entries := outboxEntries(t, relay); len(entries) > 0 {
+				t.Errorf("outbox holds %d entries, want the entry relayed or dropped", len(entries))
+			}
+
+			if test.exists && !test.removes {
+				var document struct {
+					Events []bson.ObjectId `bson:"_events"`
+				}
+				if err := documents.FindId(id).One(&document); err != nil {
+					t.Fatalf("FindId returned an error: %v", err)
+				}
+
+				if len(document.Events) > 0 {
+					t.Errorf("document is still marked with %v", document.Events)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxRelayRetriesWrittenEntries(t *testing.T) {
+	transport := &outboxTestTransport{fail: true}
+	relay := newOutboxTestRelay(transport)
+	id := bson.NewObjectId()
+
+	entry := &outboxEntry{Type: "test", Data: []byte("event"), Pending: true, Collection: backend.GroupCollection, Document: id}
+	if err := relay.record(entry); err != nil {
+		t.Fatalf("record returned an error: %v", err)
+	}
+
+	err := relay.manager.Library.Storage.C(backend.GroupCollection).Insert(bson.M{"_id": id, outboxMarkerField: []bson.ObjectId{entry.ID}})
+	if err != nil {
+		t.Fatalf("Insert returned an error: %v", err)
+	}
+
+	// The write is found but the publish fails, then the retry finds the document without its marker.
+	for _, fail := range []bool{true, false} {
+		transport.fail = fail
+
+		err = relay.collection().Update(
+			backend.Where("_id", backend.Eq, entry.ID),
+			backend.Update{Set: map[string]interface{}{"nextAttemptAt": time.Now()}},
+		)
+		if err != nil {
+			t.Fatalf("Update returned an error: %v", err)
+		}
+
+		relay.relayPending()
+	}
+
+	if len(transport.published) != 1 || len(outboxEntries(t, relay)) > 0 {
+		t.Errorf("published %q, want the retried entry relayed", transport.published)
+	}
+}
+
+func TestOutboxRelaySkipsClaimedEntries(t *testing.T) {
+	transport := &outboxTestTransport{}
+	relay := newOutboxTestRelay(transport)
+
+	// Another instance is relaying the entry.
+	now := time.Now()
+	err := relay.collection().Insert(&outboxEntry{
+		ID:            bson.NewObjectId(),
+		Type:          "test",
+		Data:          []byte("event"),
+		NextAttemptAt: now,
+		ClaimedUntil:  now.Add(time.Minute),
+		CreatedAt:     now,
+	})
+	if err != nil {
+		t.Fatalf("Insert returned an error: %v", err)
+	}
+
+	relay.relayPending()
+
+	if len(transport.published) > 0 || len(outboxEntries(t, relay)) != 1 {
+		t.Errorf("a claimed entry was relayed")
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, outboxMinBackoff},
+		{1, 2 * outboxMinBackoff},
+		{3, 8 * outboxMinBackoff},
+		{100, outboxMaxBackoff},
+	}
+
+	for _, test := range tests {
+		if backoff := outboxBackoff(test.attempts); backoff != test.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", test.attempts, backoff, test.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"reflect"
+	"time"
+)
+
+// Fields that can be used to sort a paginated list.
+const (
+	SortByID        = "_id"
+	SortByCreatedAt = "createdAt"
+)
+
+// Page size limits.
+const (
+	DefaultPageLimit = 25
+	MaxPageLimit     = 100
+)
+
+// PageOptions represents the options used to paginate a list.
+type PageOptions struct {
+	// Cursor is the opaque cursor returned by a previous page, leave empty to get the first page.
+	Cursor string
+	// Limit is the maximum amount of items in the page.
+	Limit int
+	// SortBy is either SortByID or SortByCreatedAt, it defaults to SortByID.
+	SortBy string
+	// Descending reverses the sort order.
+	Descending bool
+	// Total counts the items matching the filter, counting is slow on large collections. Unfiltered lists
+	// always get the estimated size of the collection.
+	Total bool
+}
+
+// Page represents the metadata of a paginated list.
+type Page struct {
+	NextCursor string `json:"nextCursor,omitempty"`
+	// Total is the amount of items in the list, it is left out of filtered lists unless PageOptions.Total
+	// is set.
+	Total *int `json:"total,omitempty"`
+}
+
+// pageItem is implemented by every type that can be paginated using cursors.
+type pageItem interface {
+	pageKey() (bson.ObjectId, time.Time)
+}
+
+// pageCursor represents the decoded form of an opaque cursor.
+type pageCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	ID         string `json:"i"`
+	CreatedAt  int64  `json:"c,omitempty"`
+}
+
+// encodePageCursor creates an opaque cursor pointing after the specified item.
+func encodePageCursor(options PageOptions, item pageItem) string {
+	id, createdAt := item.pageKey()
+
+	cursor := pageCursor{
+		SortBy:     options.SortBy,
+		Descending: options.Descending,
+		ID:         id.Hex(),
+	}
+	if options.SortBy == SortByCreatedAt {
+		cursor.CreatedAt = createdAt.UnixNano() / int64(time.Millisecond)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageCursor converts an opaque cursor into a filter that only matches items after it.
+func decodePageCursor(op string, options PageOptions) (backend.Filter, error) {
+	data, err := base64.RawURLEncoding.DecodeString(options.Cursor)
+	if err != nil {
+		return backend.Filter{}, newError(op, ErrValidation, "invalid cursor")
+	}
+
+	var cursor pageCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		return backend.Filter{}, newError(op, ErrValidation, "invalid cursor")
+	}
+
+	if cursor.SortBy != options.SortBy || cursor.Descending != options.Descending {
+		return backend.Filter{}, newError(op, ErrValidation, "cursor was created with different sort options")
+	}
+
+	id, err := parseObjectID(op, cursor.ID)
+	if err != nil {
+		return backend.Filter{}, err
+	}
+
+	operator := backend.Gt
+	if options.Descending {
+		operator = backend.Lt
+	}
+
+	if options.SortBy == SortByID {
+		return backend.Where("_id", operator, id), nil
+	}
+
+	createdAt := time.Unix(0, cursor.CreatedAt*int64(time.Millisecond))
+	return backend.Filter{Any: []backend.Filter{
+		backend.Where("createdAt", operator, createdAt),
+		backend.Where("createdAt", backend.Eq, createdAt).Where("_id", operator, id),
+	}}, nil
+}
+
+// normalize fills in the default values of the options.
+func (options PageOptions) normalize(op string) (PageOptions, error) {
+	if len(options.SortBy) < 1 {
+		options.SortBy = SortByID
+	}
+
+	if options.SortBy != SortByID && options.SortBy != SortByCreatedAt {
+		return options, newError(op, ErrValidation, "cannot sort by %q", options.SortBy)
+	}
+
+	if options.Limit < 1 {
+		options.Limit = DefaultPageLimit
+	}
+
+	if options.Limit > MaxPageLimit {
+		options.Limit = MaxPageLimit
+	}
+
+	return options, nil
+}
+
+// sortFields returns the fields used to sort the query.
+func (options PageOptions) sortFields() []string {
+	prefix := ""
+	if options.Descending {
+		prefix = "-"
+	}
+
+	if options.SortBy == SortByID {
+		return []string{prefix + "_id"}
+	}
+
+	return []string{prefix + "createdAt", prefix + "_id"}
+}
+
+// paginate runs a keyset paginated query, decoding the items into result which must be a pointer to a slice of pageItems.
+func paginate(op string, collection backend.Collection, options PageOptions, filter backend.Filter, result interface{}, page *Page) error {
+	options, err := options.normalize(op)
+	if err != nil {
+		return err
+	}
+
+	// Count the total before the cursor is applied to the filter.
+	if filter.IsEmpty() || options.Total {
+		var total int
+		if filter.IsEmpty() {
+			total, err = collection.EstimatedCount()
+		} else {
+			total, err = collection.Find(filter).Count()
+		}
+		if err != nil {
+			return wrapError(op, err)
+		}
+
+		page.Total = &total
+	}
+
+	query := filter
+	if len(options.Cursor) > 0 {
+		cursorFilter, err := decodePageCursor(op, options)
+		if err != nil {
+			return err
+		}
+
+		query = backend.Filter{All: []backend.Filter{filter, cursorFilter}}
+	}
+
+	// Request an extra item to know if there is a next page.
+	err = collection.Find(query).Sort(options.sortFields()...).Limit(options.Limit + 1).All(result)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	items := reflect.ValueOf(result).Elem()
+	if items.Len() > options.Limit {
+		items.Set(items.Slice(0, options.Limit))
+		page.NextCursor = encodePageCursor(options, items.Index(options.Limit-1).Interface().(pageItem))
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"errors"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"sort"
+	"testing"
+	"time"
+)
+
+// paginationTestTickets inserts tickets sharing some creation times and returns them in insertion order.
+func paginationTestTickets(t *testing.T, collection backend.Collection, user bson.ObjectId) []Ticket {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	offsets := []int{3, 1, 1, 4, 0, 2, 1}
+	category := bson.NewObjectId()
+
+	tickets := make([]Ticket, len(offsets))
+	for i, offset := range offsets {
+		tickets[i] = Ticket{ID: bson.NewObjectId(), User: user, Category: category, CreatedAt: base.Add(time.Duration(offset) * time.Minute)}
+		if err := collection.Insert(&tickets[i]); err != nil {
+			t.Fatalf("Insert returned an error: %v", err)
+		}
+	}
+
+	return tickets
+}
+
+// paginateAll walks every page of a list and returns the ids of the items in the order they were returned.
+func paginateAll(t *testing.T, collection backend.Collection, options PageOptions, filter backend.Filter) []bson.ObjectId {
+	var ids []bson.ObjectId
+
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("pagination didn't end")
+		}
+
+		var items []Ticket
+		page := &Page{}
+		if err := paginate("test", collection, options, filter, &items, page); err != nil {
+			t.Fatalf("paginate returned an error: %v", err)
+		}
+
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+
+		if len(page.NextCursor) < 1 {
+			return ids
+		}
+
+		options.Cursor = page.NextCursor
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	collection := backend.NewMemoryStorage().C(backend.TicketCollection)
+	user := bson.NewObjectId()
+	tickets := paginationTestTickets(t, collection, user)
+	// Tickets of another user, left out by the filtered lists.
+	other := paginationTestTickets(t, collection, bson.NewObjectId())
+
+	byID := func(tickets []Ticket, descending bool) func(i, j int) bool {
+		return func(i, j int) bool {
+			if descending {
+				return tickets[i].ID > tickets[j].ID
+			}
+			return tickets[i].ID < tickets[j].ID
+		}
+	}
+	byCreatedAt := func(tickets []Ticket, descending bool) func(i, j int) bool {
+		return func(i, j int) bool {
+			if !tickets[i].CreatedAt.Equal(tickets[j].CreatedAt) {
+				return tickets[i].CreatedAt.Before(tickets[j].CreatedAt) != descending
+			}
+			return byID(tickets, descending)(i, j)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options PageOptions
+		filter  backend.Filter
+		items   []Ticket
+		less    func(tickets []Ticket, descending bool) func(i, j int) bool
+	}{
+		{"id", PageOptions{Limit: 3}, backend.Filter{}, append(append([]Ticket{}, tickets...), other...), byID},
+		{"id descending", PageOptions{Limit: 3, Descending: true}, backend.Filter{}, append(append([]Ticket{}, tickets...), other...), byID},
+		{"created at", PageOptions{Limit: 2, SortBy: SortByCreatedAt}, backend.Where("user", backend.Eq, user), tickets, byCreatedAt},
+		{"created at descending", PageOptions{Limit: 2, SortBy: SortByCreatedAt, Descending: true}, backend.Where("user", backend.Eq, user), tickets, byCreatedAt},
+		{"single page", PageOptions{Limit: 50, SortBy: SortByCreatedAt}, backend.Where("user", backend.Eq, user), tickets, byCreatedAt},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := append([]Ticket{}, test.items...)
+			sort.SliceStable(expected, test.less(expected, test.options.Descending))
+
+			ids := paginateAll(t, collection, test.options, test.filter)
+			if len(ids) != len(expected) {
+				t.Fatalf("got %d items, want %d", len(ids), len(expected))
+			}
+
+			for i := range ids {
+				if ids[i] != expected[i].ID {
+					t.Fatalf("item %d is %s, want %s", i, ids[i].Hex(), expected[i].ID.Hex())
+				}
+			}
+		})
+	}
+}
+
+func TestPaginateTotal(t *testing.T) {
+	collection := backend.NewMemoryStorage().C(backend.TicketCollection)
+	user := bson.NewObjectId()
+	tickets := paginationTestTickets(t, collection, user)
+	paginationTestTickets(t, collection, bson.NewObjectId())
+
+	tests := []struct {
+		name    string
+		options PageOptions
+		filter  backend.Filter
+		total   int
+	}{
+		{"unfiltered", PageOptions{Limit: 1}, backend.Filter{}, 2 * len(tickets)},
+		{"filtered", PageOptions{Limit: 1}, backend.Where("user", backend.Eq, user), -1},
+		{"filtered with total", PageOptions{Limit: 1, Total: true}, backend.Where("user", backend.Eq, user), len(tickets)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var items []Ticket
+			page := &Page{}
+			if err := paginate("test", collection, test.options, test.filter, &items, page); err != nil {
+				t.Fatalf("paginate returned an error: %v", err)
+			}
+
+			switch {
+			case test.total < 0 && page.Total != nil:
+				t.Errorf("total = %d, want none", *page.Total)
+			case test.total >= 0 && (page.Total == nil || *page.Total != test.total):
+				t.Errorf("total = %v, want %d", page.Total, test.total)
+			}
+		})
+	}
+}
+
+func TestPaginateInvalidOptions(t *testing.T) {
+	collection := backend.NewMemoryStorage().C(backend.TicketCollection)
+	paginationTestTickets(t, collection, bson.NewObjectId())
+
+	var items []Ticket
+	page := &Page{}
+	if err := paginate("test", collection, PageOptions{Limit: 2}, backend.Filter{}, &items, page); err != nil {
+		t.Fatalf("paginate returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options PageOptions
+	}{
+		{"unknown sort", PageOptions{SortBy: "name"}},
+		{"malformed cursor", PageOptions{Cursor: "not a cursor!"}},
+		{"cursor of another order", PageOptions{Cursor: page.NextCursor, Descending: true}},
+		{"cursor of another sort", PageOptions{Cursor: page.NextCursor, SortBy: SortByCreatedAt}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := paginate("test", collection, test.options, backend.Filter{}, &items, &Page{})
+			if !errors.Is(err, ErrValidation) {
+				t.Errorf("paginate() = %v, want a validation error", err)
+			}
+		})
+	}
+}
+
+func TestPageOptionsNormalize(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{0, DefaultPageLimit},
+		{-1, DefaultPageLimit},
+		{10, 10},
+		{MaxPageLimit + 1, MaxPageLimit},
+	}
+
+	for _, test := range tests {
+		options, err := PageOptions{Limit: test.limit}.normalize("test")
+		if err != nil {
+			t.Fatalf("normalize returned an error: %v", err)
+		}
+
+		if options.Limit != test.want || options.SortBy != SortByID {
+			t.Errorf("normalize(%d) = %d, %q, want %d, %q", test.limit, options.Limit, options.SortBy, test.want, SortByID)
+		}
+	}
+}
@@ -3,7 +3,7 @@ package api
 import (
 	"context"
 	"github.com/globalsign/mgo/bson"
-	"strings"
+	"api/backend"
 	"time"
 )
 
@@ -11,12 +11,12 @@ import (
 type PunishmentService interface {
 	New(context.Context, string, bson.ObjectId, string, bson.ObjectId, string, bool, string, int64) *Punishment
 	GetByID(context.Context, string) (*Punishment, error)
-	List(context.Context, map[string]interface{}) ([]Punishment, error)
+	List(context.Context, PunishmentFilter) ([]Punishment, error)
 	Create(context.Context, *Punishment) error
 	Update(context.Context, *Punishment) error
 	Delete(context.Context, string) error
-	Paginate(context.Context, int, int, map[string]interface{}) ([]Punishment, error)
-	Count(context.Context, map[string]interface{}) (int, error)
+	Paginate(context.Context, PageOptions, PunishmentFilter) (*PunishmentPage, error)
+	Count(context.Context, PunishmentFilter) (int, error)
 }
 
 // PunishmentServiceImpl is an implementation for the PunishmentService interface.
@@ -45,22 +45,27 @@ func (service *PunishmentServiceImpl) New(ctx context.Context, server string, us
 
 // GetByID attempts to get a punishment by using an id.
 func (service *PunishmentServiceImpl) GetByID(ctx context.Context, id string) (*Punishment, error) {
-	var punishment *Punishment
-	err := service.library.Mongo.Punishment.Find(bson.M{"_id": bson.ObjectIdHex(id)}).One(&punishment)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
+	objectID, err := parseObjectID("punishment.GetByID", id)
+	if err != nil {
 		return nil, err
 	}
 
+	var punishment *Punishment
+	err = service.library.Storage.C(backend.PunishmentCollection).FindId(objectID).One(&punishment)
+	if err != nil {
+		return nil, wrapError("punishment.GetByID", err)
+	}
+
 	return punishment, nil
 }
 
 // List punishments
-func (service *PunishmentServiceImpl) List(ctx context.Context, filter map[string]interface{}) ([]Punishment, error) {
+func (service *PunishmentServiceImpl) List(ctx context.Context, filter PunishmentFilter) ([]Punishment, error) {
 	var punishments []Punishment
 
-	err := service.library.Mongo.Punishment.Find(filter).All(&punishments)
+	err := service.library.Storage.C(backend.PunishmentCollection).Find(filter.compile()).All(&punishments)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("punishment.List", err)
 	}
 
 	return punishments, nil
@@ -68,43 +73,86 @@ func (service *PunishmentServiceImpl) List(ctx context.Context, filter map[strin
 
 // Create a punishment
 func (service *PunishmentServiceImpl) Create(ctx context.Context, punishment *Punishment) error {
-	service.library.EventManager.Call(&PunishmentCreateEvent{
+	err := service.library.EventManager.CallPre("punishment.Create", &PunishmentPreCreateEvent{
+		Punishment: punishment,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := punishment.validate("punishment.Create"); err != nil {
+		return err
+	}
+
+	punishment.Version = 1
+
+	err = service.library.EventManager.insert(backend.PunishmentCollection, punishment.ID, punishment, &PunishmentCreateEvent{
 		Punishment: punishment,
 	})
-	return service.library.Mongo.Punishment.Insert(&punishment)
+	if err != nil {
+		return wrapError("punishment.Create", err)
+	}
+
+	return nil
 }
 
 // Update a punishment
 func (service *PunishmentServiceImpl) Update(ctx context.Context, punishment *Punishment) error {
-	service.library.EventManager.Call(&PunishmentUpdateEvent{
-		Punishment: punishment,
+	if err := punishment.validate("punishment.Update"); err != nil {
+		return err
+	}
+
+	punishment.UpdatedAt = time.Now()
+
+	updated, err := updateVersioned("punishment.Update", service.library.EventManager, backend.PunishmentCollection, punishment.ID, punishment.Version, punishment, func() interface{} {
+		updated := *punishment
+		updated.Version++
+		return &PunishmentUpdateEvent{Punishment: &updated}
 	})
-	return service.library.Mongo.Punishment.UpdateId(punishment.ID, &punishment)
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		punishment.Version++
+	}
+
+	return nil
 }
 
 // Delete a punishment
 func (service *PunishmentServiceImpl) Delete(ctx context.Context, id string) error {
-	service.library.EventManager.Call(&PunishmentDeleteEvent{
+	objectID, err := parseObjectID("punishment.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	err = service.library.EventManager.remove(backend.PunishmentCollection, objectID, &PunishmentDeleteEvent{
 		ID: id,
 	})
-	return service.library.Mongo.Punishment.RemoveId(bson.ObjectIdHex(id))
+	if err != nil {
+		return wrapError("punishment.Delete", err)
+	}
+
+	return nil
 }
 
 // Paginate a list of punishments
-func (service *PunishmentServiceImpl) Paginate(ctx context.Context, page int, perPage int, filter map[string]interface{}) ([]Punishment, error) {
-	var punishments []Punishment
+func (service *PunishmentServiceImpl) Paginate(ctx context.Context, options PageOptions, filter PunishmentFilter) (*PunishmentPage, error) {
+	page := &PunishmentPage{Items: []Punishment{}}
 
-	err := service.library.Mongo.Punishment.Find(filter).Skip(perPage * (page - 1)).Limit(perPage).All(&punishments)
+	err := paginate("punishment.Paginate", service.library.Storage.C(backend.PunishmentCollection), options, filter.compile(), &page.Items, &page.Page)
 	if err != nil {
 		return nil, err
 	}
 
-	return punishments, nil
+	return page, nil
 }
 
 // Count all punishments
-func (service *PunishmentServiceImpl) Count(ctx context.Context, filter map[string]interface{}) (int, error) {
-	return service.library.Mongo.Punishment.Find(filter).Count()
+func (service *PunishmentServiceImpl) Count(ctx context.Context, filter PunishmentFilter) (int, error) {
+	count, err := service.library.Storage.C(backend.PunishmentCollection).Find(filter.compile()).Count()
+	return count, wrapError("punishment.Count", err)
 }
 
 // Punishment represents a "egirls.me" punishment
@@ -123,4 +171,101 @@ type Punishment struct {
 	RemoveReason string        `json:"removeReason" bson:"removeReason"`
 	CreatedAt    time.Time     `json:"createdAt" bson:"createdAt"`
 	UpdatedAt    time.Time     `json:"updatedAt" bson:"updatedAt"`
+	Version      int           `json:"version" bson:"version"`
+}
+
+// PunishmentPage represents a page of punishments.
+type PunishmentPage struct {
+	Items []Punishment `json:"items"`
+	Page
+}
+
+// pageKey returns the fields used to create a cursor pointing to this punishment.
+func (punishment Punishment) pageKey() (bson.ObjectId, time.Time) {
+	return punishment.ID, punishment.CreatedAt
+}
+
+// PunishmentFilter represents the criteria used to filter punishments, empty fields are ignored.
+type PunishmentFilter struct {
+	UserID     bson.ObjectId
+	PunisherID bson.ObjectId
+	Address    string
+	Type       string
+	Server     string
+	// Active only matches punishments that are (or aren't) active, see Punishment.IsActive.
+	Active         *bool
+	CreatedBetween TimeRange
+}
+
+// compile converts the filter into a backend filter.
+func (filter PunishmentFilter) compile() backend.Filter {
+	compiled := backend.Filter{}
+
+	if len(filter.UserID) > 0 {
+		compiled = compiled.Where("userId", backend.Eq, filter.UserID)
+	}
+
+	if len(filter.PunisherID) > 0 {
+		compiled = compiled.Where("punisherId", backend.Eq, filter.PunisherID)
+	}
+
+	if len(filter.Address) > 0 {
+		compiled = compiled.Where("address", backend.Eq, filter.Address)
+	}
+
+	if len(filter.Type) > 0 {
+		compiled = compiled.Where("type", backend.Eq, filter.Type)
+	}
+
+	if len(filter.Server) > 0 {
+		compiled = compiled.Where("server", backend.Eq, filter.Server)
+	}
+
+	if filter.Active != nil {
+		now := time.Now()
+		permanent := time.Unix(0, 0)
+
+		if *filter.Active {
+			compiled = compiled.Where("removedAt", backend.Eq, time.Time{})
+			compiled.Any = []backend.Filter{
+				backend.Where("expiresAt", backend.Lte, permanent),
+				backend.Where("expiresAt", backend.Gt, now),
+			}
+		} else {
+			compiled.Any = []backend.Filter{
+				backend.Where("removedAt", backend.Ne, time.Time{}),
+				backend.Where("expiresAt", backend.Gt, permanent).Where("expiresAt", backend.Lte, now),
+			}
+		}
+	}
+
+	return filter.CreatedBetween.compile(compiled, "createdAt")
+}
+
+// IsActive returns true if the punishment hasn't been removed and hasn't expired.
+//
+// A punishment that expires at or before the unix epoch is permanent.
+func (punishment *Punishment) IsActive() bool {
+	if !punishment.RemovedAt.IsZero() {
+		return false
+	}
+
+	return !punishment.ExpiresAt.After(time.Unix(0, 0)) || punishment.ExpiresAt.After(time.Now())
+}
+
+// validate checks that the punishment can be written to storage.
+func (punishment *Punishment) validate(op string) error {
+	if !punishment.ID.Valid() {
+		return newError(op, ErrInvalidID, "punishment is missing an id")
+	}
+
+	if len(punishment.Type) < 1 {
+		return newError(op, ErrValidation, "punishment must have a type")
+	}
+
+	if !punishment.UserID.Valid() && len(punishment.Address) < 1 {
+		return newError(op, ErrValidation, "punishment must have a userId or an address")
+	}
+
+	return nil
 }
@@ -0,0 +1,411 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"api/logger"
+	"time"
+)
+
+// Token lifetime defaults.
+const (
+	tokenDefaultAccessTTL  = 15 * time.Minute
+	tokenDefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// Reasons passed to TokenFamilyRevokeEvent.
+const (
+	TokenRevokeLogout = "logout"
+	TokenRevokeReuse  = "reuse"
+	TokenRevokeAll    = "revoke_all"
+)
+
+// RefreshToken represents an opaque token exchanged for a new access token, only its hash is stored.
+//
+// Every refresh token can be used once, using it issues a new access token and the next refresh token of
+// its family. Presenting a used refresh token again revokes the whole family, as it was likely stolen.
+type RefreshToken struct {
+	ID bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	// Family is the id of the first refresh token of the rotation chain.
+	Family      bson.ObjectId   `json:"family" bson:"family"`
+	User        bson.ObjectId   `json:"user" bson:"user"`
+	Hash        string          `json:"-" bson:"hash"`
+	AccessToken bson.ObjectId   `json:"accessToken" bson:"accessToken"`
+	Permissions map[string]bool `json:"-" bson:"permissions"`
+	Client      bson.ObjectId   `json:"client,omitempty" bson:"client,omitempty"`
+	Scopes      []string        `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	ReplacedBy  bson.ObjectId   `json:"replacedBy,omitempty" bson:"replacedBy,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time       `json:"expiresAt" bson:"expiresAt"`
+	UsedAt      time.Time       `json:"usedAt" bson:"usedAt"`
+	RevokedAt   time.Time       `json:"revokedAt" bson:"revokedAt"`
+}
+
+// TokenPair represents an access token and the refresh token issued with it.
+type TokenPair struct {
+	AccessToken      string    `json:"accessToken"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+	// TwoFactor is set when AccessToken is the JWT of a two-factor challenge instead, to be completed with
+	// TwoFactorService.Complete. It is TwoFactorVerify or TwoFactorEnroll.
+	TwoFactor string `json:"twoFactor,omitempty"`
+	// RecoveryCodes are returned once, by the login that enrolled the user in two-factor authentication.
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// Token is the access token, AccessToken is its JWT.
+	Token *Token `json:"-"`
+
+	refreshToken *RefreshToken
+}
+
+// Issue creates a short-lived access token and the first refresh token of a new family, it is meant to be
+// called on login.
+//
+// Users who enabled two-factor authentication, or whose group requires it, get a two-factor challenge
+// instead, see TokenPair.TwoFactor. The tokens are issued once it is completed.
+func (service *TokenServiceImpl) Issue(ctx context.Context, user bson.ObjectId, address string, userAgent string, permissions map[string]bool) (*TokenPair, error) {
+	twoFactor := &TwoFactorServiceImpl{library: service.library}
+	challenge, err := twoFactor.challenge(ctx, "token.Issue", user, permissions)
+	if err != nil || challenge != nil {
+		return challenge, err
+	}
+
+	return service.issue(ctx, "token.Issue", user, address, userAgent, permissions, "", tokenGrant{})
+}
+
+// Refresh rotates a refresh token, it returns a new access token and the next refresh token of the family.
+//
+// The permissions are computed again from the user's current group, an OAuth grant keeps the web permissions
+// of its scopes the group still has. The refresh tokens of a family expire with the first one.
+//
+// An ErrInvalidToken error is returned if the refresh token is unknown, expired or revoked, or if its user
+// was deleted. If it has already been used, the whole family is revoked as well.
+func (service *TokenServiceImpl) Refresh(ctx context.Context, rawRefreshToken string, address string, userAgent string) (*TokenPair, error) {
+	refreshToken, err := service.findRefreshToken("token.Refresh", rawRefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if !refreshToken.UsedAt.IsZero() {
+		return nil, service.rejectReuse(ctx, "token.Refresh", refreshToken)
+	}
+
+	permissions, err := service.refreshPermissions(ctx, "token.Refresh", refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the refresh token, this fails if it has been used concurrently.
+	err = service.refreshTokens().Update(
+		backend.Where("_id", backend.Eq, refreshToken.ID).Where("usedAt", backend.Eq, time.Time{}),
+		backend.Update{Set: map[string]interface{}{"usedAt": time.Now()}},
+	)
+	if err == mgo.ErrNotFound {
+		return nil, service.rejectReuse(ctx, "token.Refresh", refreshToken)
+	}
+	if err != nil {
+		return nil, wrapError("token.Refresh", err)
+	}
+
+	// The new access token continues the session of the previous one.
+	var previous *Token
+	err = service.library.Storage.C(backend.TokenCollection).FindId(refreshToken.AccessToken).One(&previous)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, wrapError("token.Refresh", err)
+	}
+
+	grant := tokenGrant{Client: refreshToken.Client, Scopes: refreshToken.Scopes, ExpiresAt: refreshToken.ExpiresAt}
+	if previous != nil {
+		grant.Name = previous.Name
+	}
+
+	pair, err := service.issue(ctx, "token.Refresh", refreshToken.User, address, userAgent, permissions, refreshToken.Family, grant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.library.EventManager.update(backend.RefreshTokenCollection, refreshToken.ID, backend.Where("_id", backend.Eq, refreshToken.ID), backend.Update{
+		Set: map[string]interface{}{"replacedBy": pair.refreshToken.ID},
+	}, &TokenRefreshEvent{
+		Token:    pair.Token,
+		Family:   refreshToken.Family.Hex(),
+		Previous: refreshToken.AccessToken.Hex(),
+	})
+	if err != nil {
+		return nil, wrapError("token.Refresh", err)
+	}
+
+	// The previous access token is superseded, it is revoked so only the new one can be used.
+	service.deleteAccessToken(ctx, refreshToken.AccessToken)
+
+	return pair, nil
+}
+
+// Logout revokes the family of a refresh token and removes the access tokens issued from it, expired refresh
+// tokens are accepted and logging out a revoked family again does nothing.
+func (service *TokenServiceImpl) Logout(ctx context.Context, rawRefreshToken string) error {
+	refreshToken, err := service.findRefreshToken("token.Logout", rawRefreshToken)
+	if refreshToken == nil {
+		return err
+	}
+
+	if !refreshToken.RevokedAt.IsZero() {
+		return nil
+	}
+
+	return service.revokeFamily(ctx, "token.Logout", refreshToken, TokenRevokeLogout)
+}
+
+// tokenGrant describes what the tokens of a family are issued for, it carries over when they are refreshed.
+type tokenGrant struct {
+	// Name is the name of the session.
+	Name string
+	// Client is the OAuth client the tokens are issued to, it is granted the scopes.
+	Client bson.ObjectId
+	Scopes []string
+	// ExpiresAt is the expiry of the family, the refresh tokens don't outlive it. It is zero for a new family.
+	ExpiresAt time.Time
+}
+
+// issue creates and stores an access token and a refresh token, family is empty to start a new family.
+func (service *TokenServiceImpl) issue(ctx context.Context, op string, user bson.ObjectId, address string, userAgent string, permissions map[string]bool, family bson.ObjectId, grant tokenGrant) (*TokenPair, error) {
+	config := service.library.config.Tokens
+	now := time.Now()
+
+	accessTTL := time.Duration(config.AccessTTL) * time.Second
+	if accessTTL <= 0 {
+		accessTTL = tokenDefaultAccessTTL
+	}
+
+	refreshTTL := time.Duration(config.RefreshTTL) * time.Second
+	if refreshTTL <= 0 {
+		refreshTTL = tokenDefaultRefreshTTL
+	}
+
+	token := service.New(ctx, user, address, userAgent, permissions)
+	token.ExpiresAt = now.Add(accessTTL)
+	token.Name = grant.Name
+	token.Client = grant.Client
+	token.Scopes = grant.Scopes
+
+	rawRefreshToken, err := newRefreshTokenSecret()
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	refreshToken := &RefreshToken{
+		ID:          bson.NewObjectId(),
+		Family:      family,
+		User:        user,
+		Hash:        hashRefreshToken(rawRefreshToken),
+		AccessToken: token.ID,
+		Permissions: permissions,
+		Client:      grant.Client,
+		Scopes:      grant.Scopes,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(refreshTTL),
+	}
+	if len(refreshToken.Family) < 1 {
+		refreshToken.Family = refreshToken.ID
+	}
+	if !grant.ExpiresAt.IsZero() && grant.ExpiresAt.Before(refreshToken.ExpiresAt) {
+		refreshToken.ExpiresAt = grant.ExpiresAt
+	}
+
+	err = service.refreshTokens().Insert(refreshToken)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	err = service.Create(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := token.JWT(service.library)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return &TokenPair{
+		AccessToken:      jwt,
+		ExpiresAt:        token.ExpiresAt,
+		RefreshToken:     rawRefreshToken,
+		RefreshExpiresAt: refreshToken.ExpiresAt,
+		Token:            token,
+		refreshToken:     refreshToken,
+	}, nil
+}
+
+// findRefreshToken returns the stored refresh token matching a raw refresh token. An ErrInvalidToken error is
+// returned with the refresh token if it has expired or has been revoked.
+func (service *TokenServiceImpl) findRefreshToken(op string, rawRefreshToken string) (*RefreshToken, error) {
+	if len(rawRefreshToken) < 1 {
+		return nil, newError(op, ErrInvalidToken, "missing refresh token")
+	}
+
+	var refreshToken *RefreshToken
+	err := service.refreshTokens().Find(backend.Where("hash", backend.Eq, hashRefreshToken(rawRefreshToken))).One(&refreshToken)
+	if err == mgo.ErrNotFound {
+		return nil, newError(op, ErrInvalidToken, "unknown refresh token")
+	}
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	if !refreshToken.RevokedAt.IsZero() {
+		return refreshToken, newError(op, ErrInvalidToken, "refresh token has been revoked")
+	}
+
+	if !time.Now().Before(refreshToken.ExpiresAt) {
+		return refreshToken, newError(op, ErrInvalidToken, "refresh token has expired")
+	}
+
+	return refreshToken, nil
+}
+
+// refreshPermissions returns the permissions of the tokens refreshed from a refresh token, the web permissions
+// of the user's current group. OAuth grants only keep the web permissions granted as scopes. An
+// ErrInvalidToken error is returned if the user doesn't exist anymore.
+func (service *TokenServiceImpl) refreshPermissions(ctx context.Context, op string, refreshToken *RefreshToken) (map[string]bool, error) {
+	user, err := service.library.User.GetByID(ctx, refreshToken.User.Hex())
+	if errors.Is(err, ErrNotFound) {
+		return nil, newError(op, ErrInvalidToken, "user of the refresh token doesn't exist anymore")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make(map[string]bool)
+	if len(user.Group) < 1 {
+		return permissions, nil
+	}
+
+	// Users whose group was deleted keep their session without any permission.
+	group, err := service.library.Group.GetByID(ctx, user.Group.Hex())
+	if errors.Is(err, ErrNotFound) {
+		return permissions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(refreshToken.Client) > 0 {
+		for _, scope := range refreshToken.Scopes {
+			if !isIdentityScope(scope) && group.HasWebPermission(scope) {
+				permissions[scope] = true
+			}
+		}
+
+		return permissions, nil
+	}
+
+	for permission, granted := range group.WebPermissions {
+		if granted {
+			permissions[permission] = true
+		}
+	}
+
+	return permissions, nil
+}
+
+// rejectReuse revokes the family of a refresh token that was presented after being used, it returns the
+// error passed to the caller.
+func (service *TokenServiceImpl) rejectReuse(ctx context.Context, op string, refreshToken *RefreshToken) error {
+	err := service.revokeFamily(ctx, op, refreshToken, TokenRevokeReuse)
+	if err != nil {
+		return err
+	}
+
+	return newError(op, ErrInvalidToken, "refresh token has already been used")
+}
+
+// revokeFamily revokes every refresh token of a family and removes the access tokens issued with them.
+func (service *TokenServiceImpl) revokeFamily(ctx context.Context, op string, refreshToken *RefreshToken, reason string) error {
+	var family []RefreshToken
+	err := service.refreshTokens().
+		Find(backend.Where("family", backend.Eq, refreshToken.Family).Where("revokedAt", backend.Eq, time.Time{})).
+		All(&family)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	now := time.Now()
+	for _, member := range family {
+		err = service.refreshTokens().Update(backend.Where("_id", backend.Eq, member.ID), backend.Update{
+			Set: map[string]interface{}{"revokedAt": now},
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			return wrapError(op, err)
+		}
+
+		service.deleteAccessToken(ctx, member.AccessToken)
+	}
+
+	err = service.library.EventManager.Call(&TokenFamilyRevokeEvent{
+		Family: refreshToken.Family.Hex(),
+		User:   refreshToken.User.Hex(),
+		Reason: reason,
+	})
+	return wrapError(op, err)
+}
+
+// revokeFamilies revokes the families of the refresh tokens matching a filter that haven't been revoked yet.
+func (service *TokenServiceImpl) revokeFamilies(ctx context.Context, op string, filter backend.Filter, reason string) (int, error) {
+	var refreshTokens []RefreshToken
+	err := service.refreshTokens().Find(filter.Where("revokedAt", backend.Eq, time.Time{})).All(&refreshTokens)
+	if err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	revoked := make(map[bson.ObjectId]bool)
+	for i := range refreshTokens {
+		if revoked[refreshTokens[i].Family] {
+			continue
+		}
+
+		revoked[refreshTokens[i].Family] = true
+		if err = service.revokeFamily(ctx, op, &refreshTokens[i], reason); err != nil {
+			return len(revoked) - 1, err
+		}
+	}
+
+	return len(revoked), nil
+}
+
+// deleteAccessToken removes and revokes an access token issued with a refresh token, it may have been deleted
+// already.
+func (service *TokenServiceImpl) deleteAccessToken(ctx context.Context, id bson.ObjectId) {
+	err := service.Delete(ctx, id.Hex())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.Errorw("[Tokens] Failed to delete a superseded access token.", "token", id.Hex(), logger.Err(err))
+	}
+}
+
+// refreshTokens returns the collection holding the refresh tokens.
+func (service *TokenServiceImpl) refreshTokens() backend.Collection {
+	return service.library.Storage.C(backend.RefreshTokenCollection)
+}
+
+// newRefreshTokenSecret generates a random opaque refresh token.
+func newRefreshTokenSecret() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// hashRefreshToken returns the hash a refresh token is stored as.
+func hashRefreshToken(rawRefreshToken string) string {
+	hash := sha256.Sum256([]byte(rawRefreshToken))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,259 @@
+package api_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRefreshReuseDetection(t *testing.T) {
+	tests := []struct {
+		name string
+		// uses are the indexes of the refresh tokens used in turn, every successful refresh appends a token.
+		uses    []int
+		fails   []bool
+		revoked bool
+	}{
+		{"rotation", []int{0, 1, 2}, []bool{false, false, false}, false},
+		{"reuse of the first token", []int{0, 0}, []bool{false, true}, true},
+		{"reuse after rotations", []int{0, 1, 0}, []bool{false, false, true}, true},
+		{"latest token after reuse", []int{0, 0, 1}, []bool{false, true, true}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, events := apitest.New(t)
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+
+			pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+			if err != nil {
+				t.Fatalf("Issue returned an error: %v", err)
+			}
+
+			pairs := []*api.TokenPair{pair}
+			for i, use := range test.uses {
+				refreshed, err := lib.Token.Refresh(ctx, pairs[use].RefreshToken, "127.0.0.1", "test")
+				if test.fails[i] {
+					if !errors.Is(err, api.ErrInvalidToken) {
+						t.Fatalf("refresh %d = %v, want an invalid token error", i, err)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("refresh %d returned an error: %v", i, err)
+				}
+
+				pairs = append(pairs, refreshed)
+			}
+
+			latest := pairs[len(pairs)-1]
+			_, err = lib.Token.FromJWT(ctx, latest.AccessToken)
+			if test.revoked != (err != nil) {
+				t.Errorf("FromJWT(latest access token) = %v, want revoked %t", err, test.revoked)
+			}
+
+			// Superseded access tokens are revoked on refresh.
+			if len(pairs) > 1 {
+				if _, err = lib.Token.FromJWT(ctx, pairs[0].AccessToken); err == nil {
+					t.Errorf("FromJWT(superseded access token) succeeded")
+				}
+			}
+
+			reuse := apitest.Match(func(event *api.TokenFamilyRevokeEvent) bool {
+				return event.User == user.ID.Hex() && event.Reason == api.TokenRevokeReuse
+			})
+			if test.revoked {
+				events.ExpectCount(api.TokenFamilyRevokeEventType, 1, reuse)
+			} else {
+				events.ExpectNoEvent(api.TokenFamilyRevokeEventType, reuse)
+			}
+		})
+	}
+}
+
+func TestRefreshInvalidToken(t *testing.T) {
+	lib, _ := apitest.New(t)
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+
+	pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+	if err != nil {
+		t.Fatalf("Issue returned an error: %v", err)
+	}
+
+	if err = lib.Token.Logout(ctx, pair.RefreshToken); err != nil {
+		t.Fatalf("Logout returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"unknown", "unknown"},
+		{"logged out", pair.RefreshToken},
+		{"access token", pair.AccessToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := lib.Token.Refresh(ctx, test.token, "127.0.0.1", "test"); !errors.Is(err, api.ErrInvalidToken) {
+				t.Errorf("Refresh() = %v, want an invalid token error", err)
+			}
+		})
+	}
+}
+
+func TestRefreshPermissions(t *testing.T) {
+	tests := []struct {
+		name string
+		// scope is the scope granted to an OAuth client, the tokens are issued on login if it is empty.
+		scope  string
+		before []string
+		after  []string
+		want   map[string]bool
+	}{
+		{"login", "", []string{"user.list"}, []string{"group.list", "punishment.list"}, map[string]bool{"group.list": true, "punishment.list": true}},
+		{"login without permissions", "", []string{"user.list"}, nil, map[string]bool{}},
+		{"oauth", "openid user.list group.list", []string{"user.list", "group.list"}, []string{"group.list", "punishment.list"}, map[string]bool{"group.list": true}},
+		{"oauth granted root", "openid user.list", []string{"user.list"}, []string{"root"}, map[string]bool{"user.list": true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, _ := apitest.New(t, func(config *api.Config) {
+				config.OAuth.Issuer = "https://api.test/"
+			})
+			ctx := context.Background()
+			group := apitest.Group().WebPermissions(test.before...).Create(t, lib)
+			user := apitest.User().Group(group).Create(t, lib)
+
+			var refreshToken string
+			if len(test.scope) < 1 {
+				pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", group.WebPermissions)
+				if err != nil {
+					t.Fatalf("Issue returned an error: %v", err)
+				}
+				refreshToken = pair.RefreshToken
+			} else {
+				refreshToken = oauthRefreshToken(t, lib, user, test.scope)
+			}
+
+			group.WebPermissions = map[string]bool{}
+			for _, permission := range test.after {
+				group.WebPermissions[permission] = true
+			}
+			if err := lib.Group.Update(ctx, group); err != nil {
+				t.Fatalf("Update returned an error: %v", err)
+			}
+
+			refreshed, err := lib.Token.Refresh(ctx, refreshToken, "127.0.0.1", "test")
+			if err != nil {
+				t.Fatalf("Refresh returned an error: %v", err)
+			}
+
+			if !reflect.DeepEqual(refreshed.Token.Permissions, test.want) {
+				t.Errorf("refreshed permissions = %v, want %v", refreshed.Token.Permissions, test.want)
+			}
+		})
+	}
+}
+
+// oauthRefreshToken returns the refresh token of a trusted public client the user granted a scope to.
+func oauthRefreshToken(t *testing.T, lib *api.Library, user *api.User, scope string) string {
+	t.Helper()
+
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	ctx := context.Background()
+
+	client := lib.OAuthClient.New(ctx, "Forums", true)
+	client.RedirectURIs = []string{"https://forums.test/callback"}
+	client.Scopes = []string{api.OAuthScopeOpenID, "user.list", "group.list"}
+	client.Trusted = true
+	if err := lib.OAuthClient.Create(ctx, client); err != nil {
+		t.Fatalf("failed to create the client: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	redirect, err := lib.OAuth.Approve(ctx, &api.OAuthRequest{
+		ClientID:            client.ID.Hex(),
+		RedirectURI:         client.RedirectURIs[0],
+		ResponseType:        "code",
+		Scope:               scope,
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}, user.ID)
+	if err != nil {
+		t.Fatalf("Approve returned an error: %v", err)
+	}
+
+	parsed, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("Approve returned an invalid redirect: %v", err)
+	}
+
+	response, err := lib.OAuth.Exchange(ctx, &api.OAuthTokenRequest{
+		GrantType:    api.OAuthGrantAuthorizationCode,
+		ClientID:     client.ID.Hex(),
+		Code:         parsed.Query().Get("code"),
+		RedirectURI:  client.RedirectURIs[0],
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		t.Fatalf("Exchange returned an error: %v", err)
+	}
+
+	return response.RefreshToken
+}
+
+func TestRefreshDeletedUser(t *testing.T) {
+	lib, _ := apitest.New(t)
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+
+	pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+	if err != nil {
+		t.Fatalf("Issue returned an error: %v", err)
+	}
+
+	if err = lib.User.Delete(ctx, user.ID.Hex()); err != nil {
+		t.Fatalf("Delete returned an error: %v", err)
+	}
+
+	if _, err = lib.Token.Refresh(ctx, pair.RefreshToken, "127.0.0.1", "test"); !errors.Is(err, api.ErrInvalidToken) {
+		t.Errorf("Refresh() = %v, want an invalid token error", err)
+	}
+}
+
+func TestRefreshFamilyExpiry(t *testing.T) {
+	lib, _ := apitest.New(t)
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+
+	pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+	if err != nil {
+		t.Fatalf("Issue returned an error: %v", err)
+	}
+
+	first := pair.RefreshExpiresAt
+	for i := 0; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if pair, err = lib.Token.Refresh(ctx, pair.RefreshToken, "127.0.0.1", "test"); err != nil {
+			t.Fatalf("refresh %d returned an error: %v", i, err)
+		}
+
+		// Rotating the refresh token doesn't extend the lifetime of the family.
+		if pair.RefreshExpiresAt.After(first) || first.Sub(pair.RefreshExpiresAt) > time.Millisecond {
+			t.Errorf("refresh %d expires at %s, want %s", i, pair.RefreshExpiresAt, first)
+		}
+	}
+}
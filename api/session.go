@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"api/logger"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session settings.
+const (
+	// sessionActivityInterval is how often the last-used timestamp of a session is written.
+	sessionActivityInterval = time.Minute
+	// sessionActivityMaxEntries is how many sessions are tracked before the stale ones are forgotten.
+	sessionActivityMaxEntries = 10000
+	sessionMaxNameLength      = 64
+)
+
+// Reasons passed to TokenFamilyRevokeEvent by the session service.
+const (
+	TokenRevokeSession  = "session"
+	TokenRevokePassword = "password"
+)
+
+// sessionContextKey is the context key holding the id of the current session.
+type sessionContextKey struct{}
+
+// WithCurrentSession returns a context marking a token as the session the request was made with.
+func WithCurrentSession(ctx context.Context, id bson.ObjectId) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, id)
+}
+
+// CurrentSession returns the id of the session the request was made with, it is empty if there is none.
+func CurrentSession(ctx context.Context) bson.ObjectId {
+	id, _ := ctx.Value(sessionContextKey{}).(bson.ObjectId)
+	return id
+}
+
+// SessionService is an interface for managing the sessions of a user, a session is an access token.
+type SessionService interface {
+	List(context.Context, bson.ObjectId) ([]Session, error)
+	Rename(context.Context, bson.ObjectId, string, string) error
+	Revoke(context.Context, bson.ObjectId, string) error
+	RevokeOthers(context.Context, bson.ObjectId) (int, error)
+}
+
+// SessionServiceImpl is an implementation for the SessionService interface.
+type SessionServiceImpl struct {
+	library *Library
+}
+
+// Session represents a login of a user, as shown to them.
+type Session struct {
+	ID         bson.ObjectId `json:"id"`
+	Name       string        `json:"name"`
+	Address    string        `json:"address"`
+	UserAgent  UserAgent     `json:"userAgent"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	LastUsedAt time.Time     `json:"lastUsedAt"`
+	ExpiresAt  time.Time     `json:"expiresAt"`
+	// Current is true for the session the request was made with.
+	Current bool `json:"current"`
+}
+
+// List returns the sessions of a user that haven't expired, most recently used first.
+func (service *SessionServiceImpl) List(ctx context.Context, user bson.ObjectId) ([]Session, error) {
+	expired := false
+	tokens, err := service.library.Token.List(ctx, TokenFilter{User: user, Expired: &expired})
+	if err != nil {
+		return nil, err
+	}
+
+	current := CurrentSession(ctx)
+	sessions := make([]Session, len(tokens))
+	for i, token := range tokens {
+		sessions[i] = Session{
+			ID:         token.ID,
+			Name:       token.Name,
+			Address:    token.Address,
+			UserAgent:  ParseUserAgent(token.UserAgent),
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			ExpiresAt:  token.ExpiresAt,
+			Current:    token.ID == current,
+		}
+
+		if sessions[i].LastUsedAt.IsZero() {
+			sessions[i].LastUsedAt = token.CreatedAt
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// Rename names a session of a user, an empty name removes it.
+func (service *SessionServiceImpl) Rename(ctx context.Context, user bson.ObjectId, id string, name string) error {
+	token, err := service.find(ctx, "session.Rename", user, id)
+	if err != nil {
+		return err
+	}
+
+	name = strings.TrimSpace(name)
+	if len(name) > sessionMaxNameLength {
+		return newError("session.Rename", ErrValidation, "name must be at most %d characters long", sessionMaxNameLength)
+	}
+
+	err = service.library.Storage.C(backend.TokenCollection).Update(backend.Where("_id", backend.Eq, token.ID), backend.Update{
+		Set: map[string]interface{}{"name": name},
+	})
+	if err != nil {
+		return wrapError("session.Rename", err)
+	}
+
+	err = service.library.Cache.Del(fmt.Sprintf("ikuta:access:token:%s", token.ID.Hex()))
+	if err != nil {
+		logger.Errorw("[Redis] (session.go) Failed to delete object.", logger.Err(err))
+	}
+
+	return nil
+}
+
+// Revoke revokes a session of a user, and the refresh token it was issued with.
+func (service *SessionServiceImpl) Revoke(ctx context.Context, user bson.ObjectId, id string) error {
+	token, err := service.find(ctx, "session.Revoke", user, id)
+	if err != nil {
+		return err
+	}
+
+	return service.tokens().revokeSession(ctx, "session.Revoke", token.ID, TokenRevokeSession)
+}
+
+// RevokeOthers revokes every session of a user except the current one, it returns the amount of revoked sessions.
+func (service *SessionServiceImpl) RevokeOthers(ctx context.Context, user bson.ObjectId) (int, error) {
+	return service.revokeOthers(ctx, "session.RevokeOthers", user, TokenRevokeSession)
+}
+
+// revokeOthers revokes every session of a user except the current one.
+func (service *SessionServiceImpl) revokeOthers(ctx context.Context, op string, user bson.ObjectId, reason string) (int, error) {
+	tokens, err := service.library.Token.List(ctx, TokenFilter{User: user})
+	if err != nil {
+		return 0, err
+	}
+
+	current := CurrentSession(ctx)
+	revoked := 0
+	for _, token := range tokens {
+		if token.ID == current {
+			continue
+		}
+
+		err = service.tokens().revokeSession(ctx, op, token.ID, reason)
+		if err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// find returns a session of a user, sessions of other users are reported as not found.
+func (service *SessionServiceImpl) find(ctx context.Context, op string, user bson.ObjectId, id string) (*Token, error) {
+	objectID, err := parseObjectID(op, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var token *Token
+	err = service.library.Storage.C(backend.TokenCollection).
+		Find(backend.Where("_id", backend.Eq, objectID).Where("user", backend.Eq, user)).
+		One(&token)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return token, nil
+}
+
+// tokens returns the token service the sessions are stored with.
+func (service *SessionServiceImpl) tokens() *TokenServiceImpl {
+	return &TokenServiceImpl{library: service.library}
+}
+
+// revokeSession revokes an access token, along with the refresh token family it was issued from so it can't
+// be replaced with a new one.
+func (service *TokenServiceImpl) revokeSession(ctx context.Context, op string, id bson.ObjectId, reason string) error {
+	var refreshToken *RefreshToken
+	err := service.refreshTokens().
+		Find(backend.Where("accessToken", backend.Eq, id).Where("revokedAt", backend.Eq, time.Time{})).
+		One(&refreshToken)
+	if err == nil {
+		return service.revokeFamily(ctx, op, refreshToken, reason)
+	}
+	if err != mgo.ErrNotFound {
+		return wrapError(op, err)
+	}
+
+	err = service.Delete(ctx, id.Hex())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// sessionActivity records when the sessions were last used, the writes are throttled to one per session
+// and interval on every instance.
+type sessionActivity struct {
+	library *Library
+
+	lock    sync.Mutex
+	written map[bson.ObjectId]time.Time
+}
+
+// newSessionActivity creates a sessionActivity.
+func newSessionActivity(library *Library) *sessionActivity {
+	return &sessionActivity{
+		library: library,
+		written: map[bson.ObjectId]time.Time{},
+	}
+}
+
+// touch records that a session has been used, the storage is updated in the background.
+func (activity *sessionActivity) touch(id bson.ObjectId) {
+	now := time.Now()
+
+	activity.lock.Lock()
+	if now.Sub(activity.written[id]) < sessionActivityInterval {
+		activity.lock.Unlock()
+		return
+	}
+
+	activity.written[id] = now
+	if len(activity.written) > sessionActivityMaxEntries {
+		for written, at := range activity.written {
+			if now.Sub(at) >= sessionActivityInterval {
+				delete(activity.written, written)
+			}
+		}
+	}
+	activity.lock.Unlock()
+
+	go func() {
+		err := activity.library.Storage.C(backend.TokenCollection).Update(backend.Where("_id", backend.Eq, id), backend.Update{
+			Set: map[string]interface{}{"lastUsedAt": now},
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			logger.Errorw("[Tokens] Failed to update the last use of a session.", "token", id.Hex(), logger.Err(err))
+		}
+	}()
+}
@@ -0,0 +1,599 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"api/logger"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Algorithms of the signing keys.
+const (
+	SigningKeyHS256 = "HS256"
+	SigningKeyRS256 = "RS256"
+	SigningKeyEdDSA = "EdDSA"
+)
+
+// Keyring settings.
+const (
+	signingKeyDefaultAlgorithm = SigningKeyRS256
+	signingKeyDefaultRotation  = 7 * 24 * time.Hour
+	signingKeyDefaultRetention = 30 * 24 * time.Hour
+	// keyringRefreshInterval is how often the keys are reloaded and the active key is checked for rotation.
+	keyringRefreshInterval = time.Minute
+	// keyringReloadInterval is how often a token signed with an unknown key can trigger a reload.
+	keyringReloadInterval = 10 * time.Second
+	// keyringRotationTimeout is how long an instance holds the rotation lock if it doesn't release it.
+	keyringRotationTimeout = 30 * time.Second
+	// keyringStartupAttempts is how many times a starting instance waits for the first key created by another one.
+	keyringStartupAttempts = 10
+	signingKeyRSABits     = 2048
+	signingKeySecretSize  = 32
+)
+
+// keyringRotationKey is the cache key of the lock held by the instance rotating the keys.
+const keyringRotationKey = "ikuta:access:keys:rotation"
+
+// errRotationInProgress is returned by keyring.rotate when another instance is rotating the keys.
+var errRotationInProgress = errors.New("the signing keys are being rotated by another instance")
+
+// SigningKeyService represents a service managing the keys signing the tokens.
+type SigningKeyService interface {
+	List(context.Context) ([]*SigningKey, error)
+	Rotate(context.Context) (*SigningKey, error)
+	Delete(context.Context, string) error
+	JWKS(context.Context) (*JSONWebKeySet, error)
+}
+
+// SigningKeyServiceImpl is an implementation of SigningKeyService.
+type SigningKeyServiceImpl struct {
+	library *Library
+}
+
+// SigningKey represents a key signing tokens, its id is the "kid" header of the tokens it signs.
+//
+// The newest key that hasn't been retired signs the new tokens. Rotating the keys retires the previous key,
+// it keeps verifying the tokens it signed until it expires, after the retention period.
+type SigningKey struct {
+	ID        bson.ObjectId `json:"id" bson:"_id"`
+	Algorithm string        `json:"algorithm" bson:"algorithm"`
+	// Private is the PKCS #8 encoded private key, or the secret of HS256 keys.
+	Private   []byte    `json:"-" bson:"private"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	// RetiredAt and ExpiresAt are zero while the key signs tokens.
+	RetiredAt time.Time `json:"retiredAt" bson:"retiredAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+	// ClaimedUntil is set when a rotation claims the key, before its replacement is stored.
+	ClaimedUntil time.Time `json:"-" bson:"claimedUntil"`
+
+	signingKey      interface{}
+	verificationKey interface{}
+}
+
+// JSONWebKey represents the public part of a signing key, as published in a JSON Web Key Set (RFC 7517).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are the modulus and exponent of RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are the curve and public key of Ed25519 keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet represents a JSON Web Key Set.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// List returns the keys verifying tokens, newest first.
+func (service *SigningKeyServiceImpl) List(ctx context.Context) ([]*SigningKey, error) {
+	return service.library.keyring.list(), nil
+}
+
+// Rotate creates a new key signing the tokens and retires the previous one.
+func (service *SigningKeyServiceImpl) Rotate(ctx context.Context) (*SigningKey, error) {
+	key, err := service.library.keyring.rotate(false)
+	if err == errRotationInProgress {
+		return nil, newError("signingKey.Rotate", ErrConflict, "the keys are being rotated by another instance")
+	}
+	if err != nil {
+		return nil, wrapError("signingKey.Rotate", err)
+	}
+
+	return key, nil
+}
+
+// Delete removes a retired key, the tokens it signed are rejected right away. It is meant for compromised
+// keys, the active key must be rotated first.
+func (service *SigningKeyServiceImpl) Delete(ctx context.Context, id string) error {
+	objectID, err := parseObjectID("signingKey.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	if active := service.library.keyring.activeKey(); active != nil && active.ID == objectID {
+		return newError("signingKey.Delete", ErrValidation, "the active key can't be deleted, rotate it first")
+	}
+
+	err = service.library.EventManager.remove(backend.SigningKeyCollection, objectID, &SigningKeyDeleteEvent{ID: id})
+	if err != nil {
+		return wrapError("signingKey.Delete", err)
+	}
+
+	service.library.keyring.remove(id)
+	return nil
+}
+
+// JWKS returns the public keys verifying tokens, HS256 keys are secret and left out.
+func (service *SigningKeyServiceImpl) JWKS(ctx context.Context) (*JSONWebKeySet, error) {
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for _, key := range service.library.keyring.list() {
+		switch verificationKey := key.verificationKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "RSA",
+				ID:        key.ID.Hex(),
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(verificationKey.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(verificationKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				KeyType:   "OKP",
+				ID:        key.ID.Hex(),
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(verificationKey),
+			})
+		}
+	}
+
+	return set, nil
+}
+
+// keyring holds the signing keys of the storage, it signs the tokens and finds the keys verifying them.
+//
+// Every instance loads the keys every minute and rotates the active key once it is older than the rotation
+// interval. Rotations and deletions are also announced with events so the other instances reload right away.
+//
+// Only one instance rotates the keys at a time: it holds a lock in the cache, then claims the active key with
+// an update filtered on its id before storing the new key. The other instances reload the keys instead.
+type keyring struct {
+	library *Library
+
+	lock     sync.RWMutex
+	keys     map[string]*SigningKey
+	active   *SigningKey
+	loadedAt time.Time
+}
+
+// newKeyring loads the signing keys, creating the first one if there are none, and rotates them in the background.
+func newKeyring(library *Library) (*keyring, error) {
+	if algorithm := library.config.Keys.Algorithm; len(algorithm) > 0 && signingMethods[algorithm] == nil {
+		return nil, fmt.Errorf("unknown signing key algorithm: %s", algorithm)
+	}
+
+	ring := &keyring{
+		library: library,
+		keys:    map[string]*SigningKey{},
+	}
+
+	err := ring.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the first key, or wait for the instance creating it.
+	for attempt := 1; ring.activeKey() == nil; attempt++ {
+		_, err = ring.rotate(true)
+		if err == errRotationInProgress && attempt < keyringStartupAttempts {
+			time.Sleep(time.Second)
+			err = ring.load()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	Register(library.EventManager, func(lib *Library, event *SigningKeyRotateEvent) {
+		if err := ring.load(); err != nil {
+			logger.Errorw("[Keys] Failed to reload the signing keys.", logger.Err(err))
+		}
+	})
+	Register(library.EventManager, func(lib *Library, event *SigningKeyDeleteEvent) {
+		ring.remove(event.ID)
+	})
+
+	library.background.run(ring.run)
+	return ring, nil
+}
+
+// collection returns the collection holding the signing keys.
+func (ring *keyring) collection() backend.Collection {
+	return ring.library.Storage.C(backend.SigningKeyCollection)
+}
+
+// rotationInterval returns how long a key signs tokens before being rotated.
+func (ring *keyring) rotationInterval() time.Duration {
+	if interval := ring.library.config.Keys.RotationInterval; interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+
+	return signingKeyDefaultRotation
+}
+
+// retention returns how long a retired key keeps verifying tokens.
+func (ring *keyring) retention() time.Duration {
+	if retention := ring.library.config.Keys.Retention; retention > 0 {
+		return time.Duration(retention) * time.Second
+	}
+
+	return signingKeyDefaultRetention
+}
+
+// run reloads the keys and rotates the active key when it is due, until stop is closed.
+func (ring *keyring) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(keyringRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := ring.load()
+		if err != nil {
+			logger.Errorw("[Keys] Failed to reload the signing keys.", logger.Err(err))
+			continue
+		}
+
+		if !ring.rotationDue() {
+			continue
+		}
+
+		// Another instance rotating the keys announces the new key, the keys are reloaded then.
+		if _, err = ring.rotate(true); err != nil && err != errRotationInProgress {
+			logger.Errorw("[Keys] Failed to rotate the signing key.", logger.Err(err))
+		}
+	}
+}
+
+// load replaces the keys with the ones of the storage, expired keys are removed from the storage.
+func (ring *keyring) load() error {
+	var stored []*SigningKey
+	err := ring.collection().Find(backend.Filter{}).All(&stored)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	keys := make(map[string]*SigningKey, len(stored))
+	var active *SigningKey
+
+	for _, key := range stored {
+		if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+			if err := ring.collection().RemoveId(key.ID); err != nil {
+				logger.Errorw("[Keys] Failed to remove an expired signing key.", "key", key.ID.Hex(), logger.Err(err))
+			}
+			continue
+		}
+
+		if err := key.parse(); err != nil {
+			logger.Errorw("[Keys] Failed to parse a signing key.", "key", key.ID.Hex(), logger.Err(err))
+			continue
+		}
+
+		keys[key.ID.Hex()] = key
+		if key.RetiredAt.IsZero() && (active == nil || key.CreatedAt.After(active.CreatedAt)) {
+			active = key
+		}
+	}
+
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	ring.keys = keys
+	ring.active = active
+	ring.loadedAt = now
+	return nil
+}
+
+// rotationDue returns true if there is no active key or if it is older than the rotation interval.
+func (ring *keyring) rotationDue() bool {
+	active := ring.activeKey()
+	return active == nil || time.Since(active.CreatedAt) >= ring.rotationInterval()
+}
+
+// rotate creates a new active key and retires the previous ones, errRotationInProgress is returned if another
+// instance is rotating them. If onlyIfDue is set, nothing is done and a nil key is returned unless the
+// rotation is still due once the lock is held.
+func (ring *keyring) rotate(onlyIfDue bool) (*SigningKey, error) {
+	locked, err := ring.library.Cache.Incr(keyringRotationKey, keyringRotationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if locked != 1 {
+		return nil, errRotationInProgress
+	}
+	defer func() {
+		if err := ring.library.Cache.Del(keyringRotationKey); err != nil {
+			logger.Errorw("[Keys] Failed to release the rotation lock.", logger.Err(err))
+		}
+	}()
+
+	// Another instance may have rotated the keys before the lock was taken.
+	err = ring.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if onlyIfDue && !ring.rotationDue() {
+		return nil, nil
+	}
+
+	algorithm := ring.library.config.Keys.Algorithm
+	if len(algorithm) < 1 {
+		algorithm = signingKeyDefaultAlgorithm
+	}
+
+	key, err := newSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the active key, the rotation is abandoned if another instance claimed or retired it. The lock
+	// expiring during a slow rotation is the only way two instances get here. Keys stored before the claims
+	// were introduced have no claimedUntil field.
+	now := time.Now()
+	active := ring.activeKey()
+	if active != nil {
+		err = ring.collection().Update(
+			backend.Filter{Any: []backend.Filter{
+				backend.Where("claimedUntil", backend.Exists, false),
+				backend.Where("claimedUntil", backend.Lte, now),
+			}}.Where("_id", backend.Eq, active.ID).Where("retiredAt", backend.Eq, time.Time{}),
+			backend.Update{Set: map[string]interface{}{"claimedUntil": now.Add(keyringRotationTimeout)}},
+		)
+		if err == mgo.ErrNotFound {
+			return nil, errRotationInProgress
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = ring.collection().Insert(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []SigningKey
+	err = ring.collection().Find(backend.Where("retiredAt", backend.Eq, time.Time{})).All(&previous)
+	if err != nil {
+		return nil, err
+	}
+
+	now = time.Now()
+	for _, retired := range previous {
+		if retired.ID == key.ID {
+			continue
+		}
+
+		err = ring.collection().Update(backend.Where("_id", backend.Eq, retired.ID), backend.Update{
+			Set: map[string]interface{}{"retiredAt": now, "expiresAt": now.Add(ring.retention())},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = ring.load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ring.library.EventManager.Call(&SigningKeyRotateEvent{
+		ID:        key.ID.Hex(),
+		Algorithm: key.Algorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// remove forgets a deleted key.
+func (ring *keyring) remove(id string) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	delete(ring.keys, id)
+}
+
+// activeKey returns the key signing the new tokens.
+func (ring *keyring) activeKey() *SigningKey {
+	ring.lock.RLock()
+	defer ring.lock.RUnlock()
+
+	return ring.active
+}
+
+// list returns the keys verifying tokens, newest first.
+func (ring *keyring) list() []*SigningKey {
+	ring.lock.RLock()
+	keys := make([]*SigningKey, 0, len(ring.keys))
+	for _, key := range ring.keys {
+		keys = append(keys, key)
+	}
+	ring.lock.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+// find returns the key verifying the tokens with a kid header, the keys are reloaded if it is unknown.
+func (ring *keyring) find(id string) *SigningKey {
+	ring.lock.RLock()
+	key := ring.keys[id]
+	loadedAt := ring.loadedAt
+	ring.lock.RUnlock()
+
+	// The key may have been created by another instance.
+	if key == nil && time.Since(loadedAt) >= keyringReloadInterval {
+		if err := ring.load(); err != nil {
+			logger.Errorw("[Keys] Failed to reload the signing keys.", logger.Err(err))
+			return nil
+		}
+
+		ring.lock.RLock()
+		key = ring.keys[id]
+		ring.lock.RUnlock()
+	}
+
+	if key == nil || (!key.ExpiresAt.IsZero() && !time.Now().Before(key.ExpiresAt)) {
+		return nil
+	}
+
+	return key
+}
+
+// sign signs claims with the active key, headers are added to the token's header.
+func (ring *keyring) sign(claims jwt.Claims, headers map[string]interface{}) (string, error) {
+	key := ring.activeKey()
+	if key == nil {
+		return "", errors.New("there is no active signing key")
+	}
+
+	jsonToken := jwt.NewWithClaims(signingMethods[key.Algorithm], claims)
+	for name, value := range headers {
+		jsonToken.Header[name] = value
+	}
+	jsonToken.Header["kid"] = key.ID.Hex()
+
+	return jsonToken.SignedString(key.signingKey)
+}
+
+// keyfunc returns the key verifying a parsed token, it is passed to jwt.Parse.
+//
+// Tokens without a kid header were signed before the keyring was introduced, they are verified with the
+// secret of the config until it is removed.
+func (ring *keyring) keyfunc(token *jwt.Token) (interface{}, error) {
+	id, ok := token.Header["kid"].(string)
+	if !ok {
+		secret := ring.library.config.Secret
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || len(secret) < 1 {
+			return nil, errors.New("jwt is missing the \"kid\" field")
+		}
+
+		return []byte(secret), nil
+	}
+
+	key := ring.find(id)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key: %s", id)
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("invalid signing method: %v", token.Header["alg"])
+	}
+
+	return key.verificationKey, nil
+}
+
+// signingMethods maps the algorithms of the signing keys to their JWT signing method.
+var signingMethods = map[string]jwt.SigningMethod{
+	SigningKeyHS256: jwt.SigningMethodHS256,
+	SigningKeyRS256: jwt.SigningMethodRS256,
+	SigningKeyEdDSA: signingMethodEd25519,
+}
+
+// newSigningKey generates a signing key.
+func newSigningKey(algorithm string) (*SigningKey, error) {
+	key := &SigningKey{
+		ID:        bson.NewObjectId(),
+		Algorithm: algorithm,
+		CreatedAt: time.Now(),
+	}
+
+	var err error
+	switch algorithm {
+	case SigningKeyHS256:
+		key.Private = make([]byte, signingKeySecretSize)
+		_, err = rand.Read(key.Private)
+	case SigningKeyRS256:
+		var privateKey *rsa.PrivateKey
+		privateKey, err = rsa.GenerateKey(rand.Reader, signingKeyRSABits)
+		if err == nil {
+			key.Private, err = x509.MarshalPKCS8PrivateKey(privateKey)
+		}
+	case SigningKeyEdDSA:
+		var privateKey ed25519.PrivateKey
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+		if err == nil {
+			key.Private, err = x509.MarshalPKCS8PrivateKey(privateKey)
+		}
+	default:
+		return nil, fmt.Errorf("unknown signing key algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key, key.parse()
+}
+
+// parse decodes the private key into the keys used to sign and verify tokens.
+func (key *SigningKey) parse() error {
+	if key.Algorithm == SigningKeyHS256 {
+		key.signingKey, key.verificationKey = key.Private, key.Private
+		return nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if key.Algorithm != SigningKeyRS256 {
+			break
+		}
+		key.signingKey, key.verificationKey = privateKey, &privateKey.PublicKey
+		return nil
+	case ed25519.PrivateKey:
+		if key.Algorithm != SigningKeyEdDSA {
+			break
+		}
+		key.signingKey, key.verificationKey = privateKey, privateKey.Public().(ed25519.PublicKey)
+		return nil
+	}
+
+	return fmt.Errorf("the private key doesn't match the %s algorithm", key.Algorithm)
+}
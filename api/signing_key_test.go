@@ -0,0 +1,171 @@
+package api_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+	"time"
+)
+
+// activeSigningKey returns the key signing the new tokens.
+func activeSigningKey(t *testing.T, lib *api.Library) *api.SigningKey {
+	t.Helper()
+
+	keys, err := lib.SigningKey.List(context.Background())
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+
+	var active *api.SigningKey
+	for _, key := range keys {
+		if key.RetiredAt.IsZero() {
+			if active != nil {
+				t.Fatalf("both %s and %s are active", active.ID.Hex(), key.ID.Hex())
+			}
+			active = key
+		}
+	}
+
+	if active == nil {
+		t.Fatalf("no key is active")
+	}
+
+	return active
+}
+
+// signingKeyID returns the kid header of a JWT.
+func signingKeyID(t *testing.T, raw string) string {
+	t.Helper()
+
+	token, _, err := new(jwt.Parser).ParseUnverified(raw, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("failed to parse the token: %v", err)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+func TestSigningKeyRotate(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		jwks      int
+	}{
+		{api.SigningKeyEdDSA, 2},
+		{api.SigningKeyRS256, 2},
+		// HS256 keys are secret, they aren't published.
+		{api.SigningKeyHS256, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			lib, events := apitest.New(t, func(config *api.Config) {
+				config.Keys.Algorithm = test.algorithm
+				config.Keys.Retention = 3600
+			})
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+
+			previous := activeSigningKey(t, lib)
+			before, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+			if err != nil {
+				t.Fatalf("Issue returned an error: %v", err)
+			}
+
+			if kid := signingKeyID(t, before.AccessToken); kid != previous.ID.Hex() {
+				t.Fatalf("token signed with %q, want %q", kid, previous.ID.Hex())
+			}
+
+			rotated, err := lib.SigningKey.Rotate(ctx)
+			if err != nil {
+				t.Fatalf("Rotate returned an error: %v", err)
+			}
+
+			if rotated.Algorithm != test.algorithm || rotated.ID == previous.ID {
+				t.Fatalf("Rotate() = %s %s, want a new %s key", rotated.ID.Hex(), rotated.Algorithm, test.algorithm)
+			}
+
+			if active := activeSigningKey(t, lib); active.ID != rotated.ID {
+				t.Fatalf("active key is %s, want %s", active.ID.Hex(), rotated.ID.Hex())
+			}
+
+			events.ExpectEvent(api.SigningKeyRotateEventType, apitest.Match(func(event *api.SigningKeyRotateEvent) bool {
+				return event.ID == rotated.ID.Hex()
+			}))
+
+			keys, _ := lib.SigningKey.List(ctx)
+			for _, key := range keys {
+				if key.ID == previous.ID && time.Until(key.ExpiresAt) > time.Hour {
+					t.Errorf("retired key expires at %s, want within the retention", key.ExpiresAt)
+				}
+			}
+
+			// The retired key keeps verifying the tokens it signed.
+			if _, err = lib.Token.FromJWT(ctx, before.AccessToken); err != nil {
+				t.Errorf("FromJWT(token of the retired key) returned an error: %v", err)
+			}
+
+			after, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+			if err != nil {
+				t.Fatalf("Issue returned an error: %v", err)
+			}
+
+			if kid := signingKeyID(t, after.AccessToken); kid != rotated.ID.Hex() {
+				t.Errorf("token signed with %q, want %q", kid, rotated.ID.Hex())
+			}
+
+			set, err := lib.SigningKey.JWKS(ctx)
+			if err != nil || len(set.Keys) != test.jwks {
+				t.Errorf("JWKS() = %d keys, %v, want %d keys", len(set.Keys), err, test.jwks)
+			}
+
+			// Deleting the retired key rejects its tokens, the active key can't be deleted.
+			err = lib.SigningKey.Delete(ctx, rotated.ID.Hex())
+			if !errors.Is(err, api.ErrValidation) {
+				t.Errorf("Delete(active key) = %v, want a validation error", err)
+			}
+
+			if err = lib.SigningKey.Delete(ctx, previous.ID.Hex()); err != nil {
+				t.Fatalf("Delete returned an error: %v", err)
+			}
+
+			if _, err = lib.Token.FromJWT(ctx, before.AccessToken); err == nil {
+				t.Errorf("FromJWT(token of the deleted key) succeeded")
+			}
+
+			if _, err = lib.Token.FromJWT(ctx, after.AccessToken); err != nil {
+				t.Errorf("FromJWT(token of the active key) returned an error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSigningKeyRotateInProgress(t *testing.T) {
+	lib, _ := apitest.New(t)
+	ctx := context.Background()
+	previous := activeSigningKey(t, lib)
+
+	// Another instance holds the rotation lock.
+	if _, err := lib.Cache.Incr(api.KeyringRotationKey, time.Minute); err != nil {
+		t.Fatalf("Incr returned an error: %v", err)
+	}
+
+	if _, err := lib.SigningKey.Rotate(ctx); !errors.Is(err, api.ErrConflict) {
+		t.Fatalf("Rotate() = %v, want a conflict error", err)
+	}
+
+	if active := activeSigningKey(t, lib); active.ID != previous.ID {
+		t.Errorf("active key is %s, want %s", active.ID.Hex(), previous.ID.Hex())
+	}
+
+	if err := lib.Cache.Del(api.KeyringRotationKey); err != nil {
+		t.Fatalf("Del returned an error: %v", err)
+	}
+
+	if _, err := lib.SigningKey.Rotate(ctx); err != nil {
+		t.Errorf("Rotate returned an error once the lock was released: %v", err)
+	}
+}
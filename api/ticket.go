@@ -3,7 +3,7 @@ package api
 import (
 	"context"
 	"github.com/globalsign/mgo/bson"
-	"strings"
+	"api/backend"
 	"time"
 )
 
@@ -11,12 +11,12 @@ import (
 type TicketService interface {
 	New(context.Context, bson.ObjectId, string, bson.ObjectId) *Ticket
 	GetByID(context.Context, string) (*Ticket, error)
-	List(context.Context, map[string]interface{}) ([]Ticket, error)
+	List(context.Context, TicketFilter) ([]Ticket, error)
 	Create(context.Context, *Ticket) error
 	Update(context.Context, *Ticket) error
 	Delete(context.Context, string) error
-	Paginate(context.Context, int, int, map[string]interface{}) ([]Ticket, error)
-	Count(context.Context, map[string]interface{}) (int, error)
+	Paginate(context.Context, PageOptions, TicketFilter) (*TicketPage, error)
+	Count(context.Context, TicketFilter) (int, error)
 }
 
 // TicketServiceImpl is an implementation for the TicketService interface.
@@ -40,22 +40,27 @@ func (service *TicketServiceImpl) New(ctx context.Context, user bson.ObjectId, b
 
 // GetByID attempts to get a ticket by using an id.
 func (service *TicketServiceImpl) GetByID(ctx context.Context, id string) (*Ticket, error) {
-	var ticket *Ticket
-	err := service.library.Mongo.Ticket.Find(bson.M{"_id": bson.ObjectIdHex(id)}).One(&ticket)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
+	objectID, err := parseObjectID("ticket.GetByID", id)
+	if err != nil {
 		return nil, err
 	}
 
+	var ticket *Ticket
+	err = service.library.Storage.C(backend.TicketCollection).FindId(objectID).One(&ticket)
+	if err != nil {
+		return nil, wrapError("ticket.GetByID", err)
+	}
+
 	return ticket, nil
 }
 
 // List tickets
-func (service *TicketServiceImpl) List(ctx context.Context, filter map[string]interface{}) ([]Ticket, error) {
+func (service *TicketServiceImpl) List(ctx context.Context, filter TicketFilter) ([]Ticket, error) {
 	var tickets []Ticket
 
-	err := service.library.Mongo.Ticket.Find(filter).All(&tickets)
+	err := service.library.Storage.C(backend.TicketCollection).Find(filter.compile()).All(&tickets)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("ticket.List", err)
 	}
 
 	return tickets, nil
@@ -63,34 +68,79 @@ func (service *TicketServiceImpl) List(ctx context.Context, filter map[string]in
 
 // Create a ticket
 func (service *TicketServiceImpl) Create(ctx context.Context, ticket *Ticket) error {
-	return service.library.Mongo.Ticket.Insert(&ticket)
+	if err := ticket.validate("ticket.Create"); err != nil {
+		return err
+	}
+
+	ticket.Version = 1
+
+	err := service.library.EventManager.insert(backend.TicketCollection, ticket.ID, ticket, &TicketCreateEvent{
+		Ticket: ticket,
+	})
+	if err != nil {
+		return wrapError("ticket.Create", err)
+	}
+
+	return nil
 }
 
 // Update a ticket
 func (service *TicketServiceImpl) Update(ctx context.Context, ticket *Ticket) error {
-	return service.library.Mongo.Ticket.UpdateId(ticket.ID, &ticket)
+	if err := ticket.validate("ticket.Update"); err != nil {
+		return err
+	}
+
+	ticket.UpdatedAt = time.Now()
+
+	updated, err := updateVersioned("ticket.Update", service.library.EventManager, backend.TicketCollection, ticket.ID, ticket.Version, ticket, func() interface{} {
+		updated := *ticket
+		updated.Version++
+		return &TicketUpdateEvent{Ticket: &updated}
+	})
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		ticket.Version++
+	}
+
+	return nil
 }
 
 // Delete a ticket
 func (service *TicketServiceImpl) Delete(ctx context.Context, id string) error {
-	return service.library.Mongo.Ticket.RemoveId(bson.ObjectIdHex(id))
+	objectID, err := parseObjectID("ticket.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	err = service.library.EventManager.remove(backend.TicketCollection, objectID, &TicketDeleteEvent{
+		ID: id,
+	})
+	if err != nil {
+		return wrapError("ticket.Delete", err)
+	}
+
+	return nil
 }
 
 // Paginate a list of tickets
-func (service *TicketServiceImpl) Paginate(ctx context.Context, page int, perPage int, filter map[string]interface{}) ([]Ticket, error) {
-	var tickets []Ticket
+func (service *TicketServiceImpl) Paginate(ctx context.Context, options PageOptions, filter TicketFilter) (*TicketPage, error) {
+	page := &TicketPage{Items: []Ticket{}}
 
-	err := service.library.Mongo.Ticket.Find(filter).Skip(perPage * (page - 1)).Limit(perPage).All(&tickets)
+	err := paginate("ticket.Paginate", service.library.Storage.C(backend.TicketCollection), options, filter.compile(), &page.Items, &page.Page)
 	if err != nil {
 		return nil, err
 	}
 
-	return tickets, nil
+	return page, nil
 }
 
 // Count all tickets
-func (service *TicketServiceImpl) Count(ctx context.Context, filter map[string]interface{}) (int, error) {
-	return service.library.Mongo.Ticket.Find(filter).Count()
+func (service *TicketServiceImpl) Count(ctx context.Context, filter TicketFilter) (int, error) {
+	count, err := service.library.Storage.C(backend.TicketCollection).Find(filter.compile()).Count()
+	return count, wrapError("ticket.Count", err)
 }
 
 // Ticket represents a "egirls.me" ticket
@@ -101,4 +151,51 @@ type Ticket struct {
 	Category  bson.ObjectId `json:"category" bson:"category"`
 	CreatedAt time.Time     `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time     `json:"updatedAt" bson:"updatedAt"`
+	Version   int           `json:"version" bson:"version"`
+}
+
+// TicketPage represents a page of tickets.
+type TicketPage struct {
+	Items []Ticket `json:"items"`
+	Page
+}
+
+// pageKey returns the fields used to create a cursor pointing to this ticket.
+func (ticket Ticket) pageKey() (bson.ObjectId, time.Time) {
+	return ticket.ID, ticket.CreatedAt
+}
+
+// TicketFilter represents the criteria used to filter tickets, empty fields are ignored.
+type TicketFilter struct {
+	User           bson.ObjectId
+	Category       bson.ObjectId
+	CreatedBetween TimeRange
+}
+
+// compile converts the filter into a backend filter.
+func (filter TicketFilter) compile() backend.Filter {
+	compiled := backend.Filter{}
+
+	if len(filter.User) > 0 {
+		compiled = compiled.Where("user", backend.Eq, filter.User)
+	}
+
+	if len(filter.Category) > 0 {
+		compiled = compiled.Where("category", backend.Eq, filter.Category)
+	}
+
+	return filter.CreatedBetween.compile(compiled, "createdAt")
+}
+
+// validate checks that the ticket can be written to storage.
+func (ticket *Ticket) validate(op string) error {
+	if !ticket.ID.Valid() {
+		return newError(op, ErrInvalidID, "ticket is missing an id")
+	}
+
+	if !ticket.User.Valid() {
+		return newError(op, ErrValidation, "ticket must have a user")
+	}
+
+	return nil
 }
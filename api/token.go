@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/globalsign/mgo/bson"
+	"api/backend"
 	"api/logger"
-	"strings"
 	"time"
 )
 
@@ -17,11 +17,15 @@ type TokenService interface {
 	New(context.Context, bson.ObjectId, string, string, map[string]bool) *Token
 	FromJWT(context.Context, string) (*Token, error)
 	GetByID(context.Context, string) (*Token, error)
-	List(context.Context, map[string]interface{}) ([]Token, error)
+	List(context.Context, TokenFilter) ([]Token, error)
 	Create(context.Context, *Token) error
 	Delete(context.Context, string) error
-	Paginate(context.Context, int, int, map[string]interface{}) ([]Token, error)
-	Count(context.Context, map[string]interface{}) (int, error)
+	Paginate(context.Context, PageOptions, TokenFilter) (*TokenPage, error)
+	Count(context.Context, TokenFilter) (int, error)
+	Issue(context.Context, bson.ObjectId, string, string, map[string]bool) (*TokenPair, error)
+	Refresh(context.Context, string, string, string) (*TokenPair, error)
+	Logout(context.Context, string) error
+	RevokeAll(context.Context, bson.ObjectId) (int, error)
 }
 
 // TokenServiceImpl is an implementation for the TokenService interface.
@@ -46,13 +50,7 @@ func (service *TokenServiceImpl) New(ctx context.Context, user bson.ObjectId, ad
 
 // FromJWT converts a "Json Web Token" string to a Token object.
 func (service *TokenServiceImpl) FromJWT(ctx context.Context, rawJwt string) (*Token, error) {
-	parsedJwt, err := jwt.Parse(rawJwt, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("invalid signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(service.library.config.Secret), nil
-	})
+	parsedJwt, err := jwt.Parse(rawJwt, service.library.keyring.keyfunc)
 
 	if parsedJwt == nil {
 		return nil, errors.New("parsed jwt is nil")
@@ -91,6 +89,31 @@ func (service *TokenServiceImpl) FromJWT(ctx context.Context, rawJwt string) (*T
 		return nil, errors.New("jwt is missing the \"exp\" field")
 	}
 
+	if claims["sub"] != "access_token" {
+		return nil, errors.New("jwt isn't an access token")
+	}
+
+	id, err := parseObjectID("token.FromJWT", fmt.Sprint(parsedJwt.Header["id"]))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := parseObjectID("token.FromJWT", fmt.Sprint(claims["aud"]))
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := service.library.revocations.isRevoked("token.FromJWT", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, newError("token.FromJWT", ErrInvalidToken, "token has been revoked")
+	}
+
+	service.library.sessions.touch(id)
+
 	permissions := make(map[string]bool)
 
 	for key, value := range parsedJwt.Header["permissions"].(map[string]interface{}) {
@@ -101,34 +124,41 @@ func (service *TokenServiceImpl) FromJWT(ctx context.Context, rawJwt string) (*T
 	}
 
 	token := &Token{
-		ID:          bson.ObjectIdHex(parsedJwt.Header["id"].(string)),
-		User:        bson.ObjectIdHex(claims["aud"].(string)),
+		ID:          id,
+		User:        user,
 		Address:     parsedJwt.Header["address"].(string),
 		UserAgent:   parsedJwt.Header["userAgent"].(string),
 		Permissions: permissions,
+		Scopes:      headerStrings(parsedJwt.Header["scopes"]),
 		CreatedAt:   time.Unix(int64(claims["iat"].(float64)), 0),
 		ExpiresAt:   time.Unix(int64(claims["exp"].(float64)), 0),
 	}
 
+	if client, ok := parsedJwt.Header["client"].(string); ok && bson.IsObjectIdHex(client) {
+		token.Client = bson.ObjectIdHex(client)
+	}
+
 	return token, nil
 }
 
 // GetByID attempts to get a token by using an id.
 func (service *TokenServiceImpl) GetByID(ctx context.Context, id string) (*Token, error) {
+	objectID, err := parseObjectID("token.GetByID", id)
+	if err != nil {
+		return nil, err
+	}
+
 	var token *Token
 
-	// Attempt to get the token from redis.
-	result, err := service.library.Redis.Client.Get(fmt.Sprintf("ikuta:access:token:%s", id)).Result()
+	// Attempt to get the token from the cache.
+	result, err := service.library.Cache.Get(fmt.Sprintf("ikuta:access:token:%s", id))
 	if err != nil {
 		// Check if the error is telling us the value doesn't exist.
-		if err.Error() == "redis: nil" {
-			// Pull data from mongo.
-			err := service.library.Mongo.Token.Find(bson.M{"_id": bson.ObjectIdHex(id)}).One(&token)
+		if err == backend.ErrCacheMiss {
+			// Pull data from storage.
+			err := service.library.Storage.C(backend.TokenCollection).FindId(objectID).One(&token)
 			if err != nil {
-				if strings.Contains(err.Error(), "not found") {
-					return nil, nil
-				}
-				return nil, err
+				return nil, wrapError("token.GetByID", err)
 			}
 
 			go func() {
@@ -139,35 +169,39 @@ func (service *TokenServiceImpl) GetByID(ctx context.Context, id string) (*Token
 					return
 				}
 
-				// Insert the token into Redis.
-				err = service.library.Redis.Client.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute).Err()
+				// Insert the token into the cache.
+				err = service.library.Cache.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute)
 				if err != nil {
 					logger.Errorw("[Redis] (token.go) Failed to insert object.", logger.Err(err))
 					return
 				}
 			}()
 
-			// Return the token from MongoDB.
+			// Return the token from storage.
 			return token, nil
 		}
-		return nil, err
+		return nil, wrapError("token.GetByID", err)
 	}
 
 	if len(result) < 1 {
-		return nil, errors.New("empty result returned from cache")
+		return nil, newError("token.GetByID", nil, "empty result returned from cache")
 	}
 
-	err = json.Unmarshal([]byte(result), token)
-	return token, err
+	err = json.Unmarshal([]byte(result), &token)
+	if err != nil {
+		return nil, wrapError("token.GetByID", err)
+	}
+
+	return token, nil
 }
 
 // List all tokens matching a filter
-func (service *TokenServiceImpl) List(ctx context.Context, filter map[string]interface{}) ([]Token, error) {
+func (service *TokenServiceImpl) List(ctx context.Context, filter TokenFilter) ([]Token, error) {
 	var tokens []Token
 
-	err := service.library.Mongo.Token.Find(filter).All(&tokens)
+	err := service.library.Storage.C(backend.TokenCollection).Find(filter.compile()).All(&tokens)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("token.List", err)
 	}
 
 	return tokens, nil
@@ -175,9 +209,13 @@ func (service *TokenServiceImpl) List(ctx context.Context, filter map[string]int
 
 // Create a token
 func (service *TokenServiceImpl) Create(ctx context.Context, token *Token) error {
-	/*service.library.EventManager.Call(&TokenCreateEvent{
+	// Insert the token into storage.
+	err := service.library.EventManager.insert(backend.TokenCollection, token.ID, token, &TokenCreateEvent{
 		Token: token,
-	})*/
+	})
+	if err != nil {
+		return wrapError("token.Create", err)
+	}
 
 	go func() {
 		// Convert the token to a JSON string.
@@ -187,51 +225,71 @@ func (service *TokenServiceImpl) Create(ctx context.Context, token *Token) error
 			return
 		}
 
-		// Insert the token into Redis.
-		err = service.library.Redis.Client.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute).Err()
+		// Insert the token into the cache.
+		err = service.library.Cache.Set(fmt.Sprintf("ikuta:access:token:%s", token.ID), data, 10*time.Minute)
 		if err != nil {
 			logger.Errorw("[Redis] (token.go) Failed to insert object.", logger.Err(err))
 			return
 		}
 	}()
 
-	// Insert the token into MongoDB.
-	return service.library.Mongo.Token.Insert(&token)
+	return nil
 }
 
-// Delete a token
+// Delete revokes a token and removes it, its JWT is rejected by FromJWT from then on.
 func (service *TokenServiceImpl) Delete(ctx context.Context, id string) error {
-	/*service.library.EventManager.Call(&TokenDeleteEvent{
+	objectID, err := parseObjectID("token.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	var token *Token
+	err = service.library.Storage.C(backend.TokenCollection).FindId(objectID).One(&token)
+	if err != nil {
+		return wrapError("token.Delete", err)
+	}
+
+	// Revoke the token first so a failed removal can be retried.
+	err = service.library.revocations.revoke(objectID, token.ExpiresAt)
+	if err != nil {
+		return wrapError("token.Delete", err)
+	}
+
+	err = service.library.EventManager.remove(backend.TokenCollection, objectID, &TokenDeleteEvent{
 		ID: id,
-	})*/
+	})
+	if err != nil {
+		return wrapError("token.Delete", err)
+	}
 
 	go func() {
-		// Delete the token from Redis.
-		err := service.library.Redis.Client.Del(fmt.Sprintf("ikuta:access:token:%s", id)).Err()
+		// Delete the token from the cache.
+		err := service.library.Cache.Del(fmt.Sprintf("ikuta:access:token:%s", id))
 		if err != nil {
 			logger.Errorw("[Redis] (token.go) Failed to delete object.", logger.Err(err))
 			return
 		}
 	}()
 
-	return service.library.Mongo.Token.RemoveId(bson.ObjectIdHex(id))
+	return nil
 }
 
 // Paginate a list of tokens
-func (service *TokenServiceImpl) Paginate(ctx context.Context, page int, perPage int, filter map[string]interface{}) ([]Token, error) {
-	var tokens []Token
+func (service *TokenServiceImpl) Paginate(ctx context.Context, options PageOptions, filter TokenFilter) (*TokenPage, error) {
+	page := &TokenPage{Items: []Token{}}
 
-	err := service.library.Mongo.Token.Find(filter).Skip(perPage * (page - 1)).Limit(perPage).All(&tokens)
+	err := paginate("token.Paginate", service.library.Storage.C(backend.TokenCollection), options, filter.compile(), &page.Items, &page.Page)
 	if err != nil {
 		return nil, err
 	}
 
-	return tokens, nil
+	return page, nil
 }
 
 // Count all tokens
-func (service *TokenServiceImpl) Count(ctx context.Context, filter map[string]interface{}) (int, error) {
-	return service.library.Mongo.Token.Find(filter).Count()
+func (service *TokenServiceImpl) Count(ctx context.Context, filter TokenFilter) (int, error) {
+	count, err := service.library.Storage.C(backend.TokenCollection).Find(filter.compile()).Count()
+	return count, wrapError("token.Count", err)
 }
 
 // Token represents a "egirls.me" token
@@ -241,25 +299,89 @@ type Token struct {
 	Address     string          `json:"address" bson:"address"`
 	UserAgent   string          `json:"userAgent" bson:"userAgent"`
 	Permissions map[string]bool `json:"permissions" bson:"permissions"`
-	CreatedAt   time.Time       `json:"createdAt" bson:"createdAt"`
-	ExpiresAt   time.Time       `json:"expiresAt" bson:"expiresAt"`
+	// Name is given by the user to recognise the session, it is kept when the token is refreshed.
+	Name string `json:"name" bson:"name"`
+	// LastUsedAt is updated by FromJWT, at most once a minute.
+	LastUsedAt time.Time `json:"lastUsedAt" bson:"lastUsedAt"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt" bson:"expiresAt"`
+	// Client is the OAuth client the token was issued to, with the scopes the user granted it.
+	Client bson.ObjectId `json:"client,omitempty" bson:"client,omitempty"`
+	Scopes []string      `json:"scopes,omitempty" bson:"scopes,omitempty"`
+}
+
+// TokenPage represents a page of tokens.
+type TokenPage struct {
+	Items []Token `json:"items"`
+	Page
+}
+
+// pageKey returns the fields used to create a cursor pointing to this token.
+func (token Token) pageKey() (bson.ObjectId, time.Time) {
+	return token.ID, token.CreatedAt
+}
+
+// TokenFilter represents the criteria used to filter tokens, empty fields are ignored.
+type TokenFilter struct {
+	User    bson.ObjectId
+	Address string
+	// Expired only matches tokens that have (or haven't) expired.
+	Expired        *bool
+	CreatedBetween TimeRange
+}
+
+// compile converts the filter into a backend filter.
+func (filter TokenFilter) compile() backend.Filter {
+	compiled := backend.Filter{}
+
+	if len(filter.User) > 0 {
+		compiled = compiled.Where("user", backend.Eq, filter.User)
+	}
+
+	if len(filter.Address) > 0 {
+		compiled = compiled.Where("address", backend.Eq, filter.Address)
+	}
+
+	if filter.Expired != nil {
+		if *filter.Expired {
+			compiled = compiled.Where("expiresAt", backend.Lte, time.Now())
+		} else {
+			compiled = compiled.Where("expiresAt", backend.Gt, time.Now())
+		}
+	}
+
+	return filter.CreatedBetween.compile(compiled, "createdAt")
+}
+
+// redacted returns a copy of the token that can be published, it leaves out the permissions granted to the token.
+func (token *Token) redacted() *Token {
+	redacted := *token
+	redacted.Permissions = nil
+	return &redacted
 }
 
 // JWT generates a Json Web Token using the data from the Token object.
 func (token *Token) JWT(lib *Library) (string, error) {
-	jsonToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+	claims := &jwt.StandardClaims{
 		Audience:  token.User.Hex(),
 		ExpiresAt: token.ExpiresAt.Unix(),
 		Issuer:    "egirls.me",
 		IssuedAt:  token.CreatedAt.Unix(),
 		Subject:   "access_token",
-	})
-	jsonToken.Header["id"] = token.ID.Hex()
-	jsonToken.Header["address"] = token.Address
-	jsonToken.Header["userAgent"] = token.UserAgent
-	jsonToken.Header["permissions"] = token.Permissions
+	}
+
+	headers := map[string]interface{}{
+		"id":          token.ID.Hex(),
+		"address":     token.Address,
+		"userAgent":   token.UserAgent,
+		"permissions": token.Permissions,
+	}
+	if len(token.Client) > 0 {
+		headers["client"] = token.Client.Hex()
+		headers["scopes"] = token.Scopes
+	}
 
-	signedJwt, err := jsonToken.SignedString([]byte(lib.config.Secret))
+	signedJwt, err := lib.keyring.sign(claims, headers)
 	if err != nil {
 		logger.Errorw("[Backend] Failed to sign JWT.", logger.Err(err))
 	}
@@ -0,0 +1,353 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"hash/fnv"
+	"api/backend"
+	"api/logger"
+	"sync"
+	"time"
+)
+
+// Revocation settings.
+const (
+	revocationRefreshInterval = 30 * time.Second
+	revocationFilterBits      = 1 << 20
+	revocationFilterHashes    = 7
+	// revocationCacheTTL bounds how long the revocation of a token that never expires is cached.
+	revocationCacheTTL = 24 * time.Hour
+)
+
+// Values cached for the revocation state of a token.
+const (
+	revocationCachedRevoked = "1"
+	revocationCachedValid   = "0"
+)
+
+// revokedToken represents a revoked token, access or internal, it is pruned once the token expires.
+type revokedToken struct {
+	ID bson.ObjectId `bson:"_id"`
+	// ExpiresAt is zero for the tokens that never expire.
+	ExpiresAt time.Time `bson:"expiresAt"`
+	// RevokedAt is in the future for the tokens that are still valid for a grace period.
+	RevokedAt time.Time `bson:"revokedAt"`
+}
+
+// tokenRevocations records the revoked tokens and checks the tokens presented to FromJWT.
+//
+// Revocations are stored in the storage and cached. Every instance also keeps a bloom filter of the revoked
+// tokens, so a token that hasn't been revoked, which is almost every token, is accepted without any I/O.
+// Tokens the filter may contain are checked against the cache, then against the storage if the cache
+// misses or fails. The filter is updated by the delete events of every instance and rebuilt from the
+// storage every 30 seconds, which bounds how long a revocation can go unnoticed if its event is lost. The
+// revocations of the expired tokens are removed before every rebuild.
+type tokenRevocations struct {
+	library *Library
+
+	lock   sync.RWMutex
+	filter *bloomFilter
+	// next is the filter being rebuilt, revocations are added to both filters until it replaces filter.
+	next *bloomFilter
+}
+
+// newTokenRevocations loads the revoked tokens and keeps the filter up to date in the background.
+func newTokenRevocations(library *Library) *tokenRevocations {
+	revocations := &tokenRevocations{
+		library: library,
+		filter:  newBloomFilter(),
+	}
+
+	Register(library.EventManager, func(lib *Library, event *TokenDeleteEvent) {
+		revocations.add(event.ID)
+	})
+	Register(library.EventManager, func(lib *Library, event *InternalTokenDeleteEvent) {
+		revocations.add(event.ID)
+	})
+
+	err := revocations.rebuild()
+	if err != nil {
+		logger.Errorw("[Tokens] Failed to load the revoked tokens.", logger.Err(err))
+	}
+
+	library.background.run(revocations.run)
+	return revocations
+}
+
+// collection returns the collection holding the revoked tokens.
+func (revocations *tokenRevocations) collection() backend.Collection {
+	return revocations.library.Storage.C(backend.RevokedTokenCollection)
+}
+
+// run prunes the storage and rebuilds the filter until stop is closed.
+func (revocations *tokenRevocations) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(revocationRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := revocations.prune()
+		if err != nil {
+			logger.Errorw("[Tokens] Failed to prune the revoked tokens.", logger.Err(err))
+		}
+
+		err = revocations.rebuild()
+		if err != nil {
+			logger.Errorw("[Tokens] Failed to refresh the revoked tokens.", logger.Err(err))
+		}
+	}
+}
+
+// prune removes the revocations of the tokens that have expired, every instance prunes and removing a
+// revocation twice does nothing.
+func (revocations *tokenRevocations) prune() error {
+	_, err := revocations.collection().RemoveAll(
+		backend.Where("expiresAt", backend.Gt, time.Time{}).Where("expiresAt", backend.Lte, time.Now()),
+	)
+	return err
+}
+
+// rebuild replaces the filter with one holding the tokens of the storage that haven't expired yet.
+func (revocations *tokenRevocations) rebuild() error {
+	revocations.lock.Lock()
+	revocations.next = newBloomFilter()
+	revocations.lock.Unlock()
+
+	var revoked []revokedToken
+	err := revocations.collection().Find(backend.Filter{Any: []backend.Filter{
+		backend.Where("expiresAt", backend.Eq, time.Time{}),
+		backend.Where("expiresAt", backend.Gt, time.Now()),
+	}}).All(&revoked)
+
+	revocations.lock.Lock()
+	defer revocations.lock.Unlock()
+
+	if err != nil {
+		revocations.next = nil
+		return err
+	}
+
+	now := time.Now()
+	for _, token := range revoked {
+		if token.ExpiresAt.IsZero() || now.Before(token.ExpiresAt) {
+			revocations.next.add(token.ID.Hex())
+		}
+	}
+
+	revocations.filter, revocations.next = revocations.next, nil
+	return nil
+}
+
+// add adds a revoked token to the filter.
+func (revocations *tokenRevocations) add(id string) {
+	revocations.lock.Lock()
+	defer revocations.lock.Unlock()
+
+	revocations.filter.add(id)
+	if revocations.next != nil {
+		revocations.next.add(id)
+	}
+}
+
+// revoke records a revoked token, expiresAt is zero for the tokens that never expire.
+func (revocations *tokenRevocations) revoke(id bson.ObjectId, expiresAt time.Time) error {
+	return revocations.schedule(id, time.Now(), expiresAt)
+}
+
+// schedule records a token that is revoked once revokeAt is reached, expiresAt is zero for the tokens that
+// never expire.
+func (revocations *tokenRevocations) schedule(id bson.ObjectId, revokeAt time.Time, expiresAt time.Time) error {
+	now := time.Now()
+
+	// Expired tokens are already rejected.
+	if !expiresAt.IsZero() && !now.Before(expiresAt) {
+		return nil
+	}
+
+	err := revocations.collection().Insert(&revokedToken{ID: id, ExpiresAt: expiresAt, RevokedAt: revokeAt})
+	if mgo.IsDup(err) {
+		// Bring forward the revocation of a token in its grace period.
+		err = revocations.collection().Update(
+			backend.Where("_id", backend.Eq, id).Where("revokedAt", backend.Gt, revokeAt),
+			backend.Update{Set: map[string]interface{}{"revokedAt": revokeAt}},
+		)
+		if err == mgo.ErrNotFound {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	revocations.add(id.Hex())
+
+	// The answer cached for a token in its grace period expires before the grace period ends.
+	if now.Before(revokeAt) {
+		return nil
+	}
+
+	err = revocations.library.Cache.Set(revocationCacheKey(id), revocationCachedRevoked, revocationCacheExpiration(expiresAt, now))
+	if err != nil {
+		logger.Errorw("[Redis] Failed to cache a token revocation.", logger.Err(err))
+	}
+
+	return nil
+}
+
+// isRevoked returns true if a token has been revoked.
+func (revocations *tokenRevocations) isRevoked(op string, id bson.ObjectId) (bool, error) {
+	revocations.lock.RLock()
+	mayBeRevoked := revocations.filter.mayContain(id.Hex())
+	revocations.lock.RUnlock()
+
+	if !mayBeRevoked {
+		return false, nil
+	}
+
+	cached, err := revocations.library.Cache.Get(revocationCacheKey(id))
+	if err == nil {
+		return cached == revocationCachedRevoked, nil
+	}
+	if err != backend.ErrCacheMiss {
+		logger.Errorw("[Redis] Failed to get a token revocation, falling back to the storage.", logger.Err(err))
+	}
+
+	var revoked *revokedToken
+	err = revocations.collection().FindId(id).One(&revoked)
+	if err != nil && err != mgo.ErrNotFound {
+		return false, wrapError(op, err)
+	}
+
+	// Cache the answer, false positives of the filter would otherwise hit the storage on every request.
+	now := time.Now()
+	isRevoked := revoked != nil && !now.Before(revoked.RevokedAt)
+	if isRevoked {
+		err = revocations.library.Cache.Set(revocationCacheKey(id), revocationCachedRevoked, revocationCacheExpiration(revoked.ExpiresAt, now))
+	} else {
+		expiration := revocationRefreshInterval
+		if revoked != nil && revoked.RevokedAt.Sub(now) < expiration {
+			expiration = revoked.RevokedAt.Sub(now)
+		}
+		err = revocations.library.Cache.Set(revocationCacheKey(id), revocationCachedValid, expiration)
+	}
+	if err != nil {
+		logger.Errorw("[Redis] Failed to cache a token revocation.", logger.Err(err))
+	}
+
+	return isRevoked, nil
+}
+
+// revocationCacheExpiration returns how long the revocation of a token is cached, until the token expires or
+// for revocationCacheTTL if it never does.
+func revocationCacheExpiration(expiresAt time.Time, now time.Time) time.Duration {
+	switch {
+	case expiresAt.IsZero() || expiresAt.Sub(now) > revocationCacheTTL:
+		return revocationCacheTTL
+	case expiresAt.Sub(now) < time.Second:
+		// A zero expiration would cache the revocation forever.
+		return time.Second
+	}
+
+	return expiresAt.Sub(now)
+}
+
+// revocationCacheKey returns the cache key holding the revocation state of a token.
+func revocationCacheKey(id bson.ObjectId) string {
+	return fmt.Sprintf("ikuta:access:token:revoked:%s", id.Hex())
+}
+
+// RevokeAll revokes every token of a user and the refresh token families they were issued from, it returns
+// the amount of revoked access tokens.
+func (service *TokenServiceImpl) RevokeAll(ctx context.Context, user bson.ObjectId) (int, error) {
+	tokens, err := service.List(ctx, TokenFilter{User: user})
+	if err != nil {
+		return 0, wrapError("token.RevokeAll", err)
+	}
+
+	revoked := 0
+	for _, token := range tokens {
+		err = service.Delete(ctx, token.ID.Hex())
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return revoked, err
+		}
+		if err == nil {
+			revoked++
+		}
+	}
+
+	var refreshTokens []RefreshToken
+	err = service.refreshTokens().
+		Find(backend.Where("user", backend.Eq, user).Where("revokedAt", backend.Eq, time.Time{})).
+		All(&refreshTokens)
+	if err != nil {
+		return revoked, wrapError("token.RevokeAll", err)
+	}
+
+	families := map[bson.ObjectId]bool{}
+	for i := range refreshTokens {
+		if families[refreshTokens[i].Family] {
+			continue
+		}
+		families[refreshTokens[i].Family] = true
+
+		err = service.revokeFamily(ctx, "token.RevokeAll", &refreshTokens[i], TokenRevokeAll)
+		if err != nil {
+			return revoked, err
+		}
+	}
+
+	err = service.library.EventManager.Call(&TokenRevokeAllEvent{
+		User:  user.Hex(),
+		Count: revoked,
+	})
+	return revoked, wrapError("token.RevokeAll", err)
+}
+
+// bloomFilter is a fixed size bloom filter of strings.
+type bloomFilter struct {
+	bits []uint64
+}
+
+// newBloomFilter creates an empty bloom filter.
+func newBloomFilter() *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, revocationFilterBits/64)}
+}
+
+// locations returns the two hashes combined into the bit locations of a key.
+func (filter *bloomFilter) locations(key string) (uint32, uint32) {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	sum := hash.Sum64()
+
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// add adds a key to the filter.
+func (filter *bloomFilter) add(key string) {
+	h1, h2 := filter.locations(key)
+	for i := uint32(0); i < revocationFilterHashes; i++ {
+		bit := (h1 + i*h2) % revocationFilterBits
+		filter.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain returns false if the key has never been added to the filter.
+func (filter *bloomFilter) mayContain(key string) bool {
+	h1, h2 := filter.locations(key)
+	for i := uint32(0); i < revocationFilterHashes; i++ {
+		bit := (h1 + i*h2) % revocationFilterBits
+		if filter.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
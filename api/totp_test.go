@@ -0,0 +1,83 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// totpTestSecret is the SHA1 secret of the RFC 6238 test vectors, "12345678901234567890" encoded in base32.
+const totpTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC 6238 vectors have 8 digits, the codes are their last 6 digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := totpCode(totpTestSecret, totpStep(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode(%d) returned an error: %v", test.unix, err)
+		}
+
+		if code != test.code {
+			t.Errorf("totpCode(%d) = %q, want %q", test.unix, code, test.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	code, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", totpStep(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Errorf("totpCode() = %q, %v, want %q", code, err, "287082")
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+
+	tests := []struct {
+		name  string
+		code  string
+		after int64
+		step  int64
+		ok    bool
+	}{
+		{"current period", "050471", 0, current, true},
+		{"surrounding spaces", " 050471 ", 0, current, true},
+		{"previous period", "081804", 0, current - 1, true},
+		{"replayed code", "050471", current, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"wrong length", "50471", 0, 0, false},
+		{"full rfc code", "14050471", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := matchTOTP(totpTestSecret, test.code, test.after, now)
+			if ok != test.ok || step != test.step {
+				t.Errorf("matchTOTP(%q) = %d, %t, want %d, %t", test.code, step, ok, test.step, test.ok)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPOutsideSkew(t *testing.T) {
+	code, err := totpCode(totpTestSecret, totpStep(time.Unix(1111111111, 0))-totpSkew-1)
+	if err != nil {
+		t.Fatalf("totpCode returned an error: %v", err)
+	}
+
+	if _, ok := matchTOTP(totpTestSecret, code, 0, time.Unix(1111111111, 0)); ok {
+		t.Errorf("matchTOTP accepted a code older than the skew")
+	}
+}
@@ -0,0 +1,521 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"api/logger"
+	"strings"
+	"time"
+)
+
+// Two-factor authentication settings.
+const (
+	twoFactorDefaultIssuer   = "egirls.me"
+	twoFactorRecoveryCodes   = 10
+	twoFactorRecoveryCodeLen = 10
+	// twoFactorRecoveryUseAttempts is how many times using a recovery code is tried again when the user is
+	// updated concurrently.
+	twoFactorRecoveryUseAttempts = 3
+)
+
+// Actions recorded in the two-factor audit log.
+const (
+	TwoFactorAuditEnable             = "enable"
+	TwoFactorAuditDisable            = "disable"
+	TwoFactorAuditReset              = "reset"
+	TwoFactorAuditRecoveryUse        = "recovery_use"
+	TwoFactorAuditRecoveryRegenerate = "recovery_regenerate"
+)
+
+// recoveryCodeEncoding encodes recovery codes with letters and digits that are hard to confuse.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghjkmnpqrstuvwxyz023456789").WithPadding(base32.NoPadding)
+
+// TwoFactorService is an interface for managing the TOTP two-factor authentication of the users.
+type TwoFactorService interface {
+	Status(context.Context, string) (*TwoFactorStatus, error)
+	Required(context.Context, *User) (bool, error)
+	Enroll(context.Context, bson.ObjectId) (*TwoFactorEnrollment, error)
+	Enable(context.Context, bson.ObjectId, string, string) ([]string, error)
+	Disable(context.Context, bson.ObjectId, string, string) error
+	Reset(context.Context, string, string, string) error
+	RegenerateRecoveryCodes(context.Context, bson.ObjectId, string, string) ([]string, error)
+	Challenge(context.Context, string) (*TwoFactorChallenge, error)
+	Complete(context.Context, string, string, string, string) (*TokenPair, error)
+	Audit(context.Context, string, PageOptions) (*TwoFactorAuditPage, error)
+}
+
+// TwoFactorServiceImpl is an implementation for the TwoFactorService interface.
+type TwoFactorServiceImpl struct {
+	library *Library
+}
+
+// TwoFactorStatus represents the two-factor authentication of a user, as shown to them and to staff.
+type TwoFactorStatus struct {
+	Enabled   bool      `json:"enabled"`
+	EnabledAt time.Time `json:"enabledAt"`
+	// Required is true if the group of the user requires two-factor authentication.
+	Required      bool `json:"required"`
+	RecoveryCodes int  `json:"recoveryCodes"`
+}
+
+// TwoFactorEnrollment represents a secret being enrolled, the URI is shown as a QR code to authenticator apps.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorAudit represents a change to the two-factor authentication of a user.
+type TwoFactorAudit struct {
+	ID     bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	User   bson.ObjectId `json:"user" bson:"user"`
+	Action string        `json:"action" bson:"action"`
+	// Staff is the id of the staff member who made the change, it is empty if it was the user.
+	Staff     string    `json:"staff,omitempty" bson:"staff,omitempty"`
+	Address   string    `json:"address" bson:"address"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// TwoFactorAuditPage represents a page of the two-factor audit log of a user.
+type TwoFactorAuditPage struct {
+	Items []TwoFactorAudit `json:"items"`
+	Page
+}
+
+// pageKey returns the fields used to create a cursor pointing to this entry.
+func (audit TwoFactorAudit) pageKey() (bson.ObjectId, time.Time) {
+	return audit.ID, audit.CreatedAt
+}
+
+// Status returns the two-factor authentication status of a user.
+func (service *TwoFactorServiceImpl) Status(ctx context.Context, id string) (*TwoFactorStatus, error) {
+	user, err := service.library.User.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	required, err := service.Required(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorStatus{
+		Enabled:       user.TwoFactor.Enabled,
+		EnabledAt:     user.TwoFactor.EnabledAt,
+		Required:      required,
+		RecoveryCodes: len(user.TwoFactor.RecoveryCodes),
+	}, nil
+}
+
+// Required returns true if the group of a user requires two-factor authentication, either explicitly or
+// because it is granted one of the web permissions of Config.TwoFactor.
+func (service *TwoFactorServiceImpl) Required(ctx context.Context, user *User) (bool, error) {
+	if len(user.Group) < 1 {
+		return false, nil
+	}
+
+	group, err := service.library.Group.GetByID(ctx, user.Group.Hex())
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if group.RequireTwoFactor {
+		return true, nil
+	}
+
+	for _, permission := range service.library.config.TwoFactor.RequiredWebPermissions {
+		if group.HasWebPermission(permission) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Enroll generates a new secret for a user, it is enabled by Enable once a code generated from it is verified.
+// Enrolling again replaces the pending secret, users who enabled two-factor authentication must disable it
+// first.
+func (service *TwoFactorServiceImpl) Enroll(ctx context.Context, id bson.ObjectId) (*TwoFactorEnrollment, error) {
+	user, err := service.library.User.GetByID(ctx, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TwoFactor.Enabled {
+		return nil, newError("twoFactor.Enroll", ErrConflict, "two-factor authentication is already enabled")
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, wrapError("twoFactor.Enroll", err)
+	}
+
+	err = service.update("twoFactor.Enroll", user, map[string]interface{}{"twoFactor.pendingSecret": secret}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := service.library.config.TwoFactor.Issuer
+	if len(issuer) < 1 {
+		issuer = twoFactorDefaultIssuer
+	}
+
+	account := user.Email
+	if len(account) < 1 {
+		account = user.UniqueID
+	}
+
+	return &TwoFactorEnrollment{Secret: secret, URI: totpURI(issuer, account, secret)}, nil
+}
+
+// Enable verifies a code generated from the pending secret of a user and enables two-factor authentication
+// with it, it returns the recovery codes which aren't stored in clear and can't be shown again. The code is
+// throttled like a login, see throttle.
+func (service *TwoFactorServiceImpl) Enable(ctx context.Context, id bson.ObjectId, code string, address string) ([]string, error) {
+	user, err := service.library.User.GetByID(ctx, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	var recoveryCodes []string
+	err = service.throttle(ctx, user, address, func() error {
+		recoveryCodes, err = service.enable(ctx, "twoFactor.Enable", user, code, address)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// enable enables the pending secret of a user if the code matches it.
+func (service *TwoFactorServiceImpl) enable(ctx context.Context, op string, user *User, code string, address string) ([]string, error) {
+	if len(user.TwoFactor.PendingSecret) < 1 {
+		return nil, newError(op, ErrValidation, "two-factor authentication hasn't been enrolled")
+	}
+
+	step, ok := matchTOTP(user.TwoFactor.PendingSecret, code, 0, time.Now())
+	if !ok {
+		return nil, newError(op, ErrInvalidToken, "invalid two-factor code")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	err = service.update(op, user, map[string]interface{}{
+		"twoFactor": UserTwoFactor{
+			Enabled:       true,
+			Secret:        user.TwoFactor.PendingSecret,
+			RecoveryCodes: hashes,
+			LastStep:      step,
+			EnabledAt:     time.Now(),
+		},
+	}, &TwoFactorEnableEvent{
+		User: user.ID.Hex(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	service.audit(user.ID, TwoFactorAuditEnable, "", address)
+	return codes, nil
+}
+
+// Disable disables the two-factor authentication of a user, it requires a code or a recovery code which is
+// throttled like a login, see throttle.
+func (service *TwoFactorServiceImpl) Disable(ctx context.Context, id bson.ObjectId, code string, address string) error {
+	user, err := service.library.User.GetByID(ctx, id.Hex())
+	if err != nil {
+		return err
+	}
+
+	if !user.TwoFactor.Enabled {
+		return newError("twoFactor.Disable", ErrValidation, "two-factor authentication isn't enabled")
+	}
+
+	err = service.throttle(ctx, user, address, func() error {
+		return service.verify(ctx, "twoFactor.Disable", user, code, address)
+	})
+	if err != nil {
+		return err
+	}
+
+	return service.disable(ctx, "twoFactor.Disable", user, "", address)
+}
+
+// Reset disables the two-factor authentication of a user on behalf of a staff member, for users who lost
+// their device and their recovery codes. Staff is the id of the staff member.
+func (service *TwoFactorServiceImpl) Reset(ctx context.Context, id string, staff string, address string) error {
+	user, err := service.library.User.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !user.TwoFactor.Enabled && len(user.TwoFactor.PendingSecret) < 1 {
+		return newError("twoFactor.Reset", ErrValidation, "two-factor authentication isn't enabled")
+	}
+
+	return service.disable(ctx, "twoFactor.Reset", user, staff, address)
+}
+
+// disable removes the two-factor authentication settings of a user.
+func (service *TwoFactorServiceImpl) disable(ctx context.Context, op string, user *User, staff string, address string) error {
+	err := service.update(op, user, map[string]interface{}{"twoFactor": UserTwoFactor{}}, &TwoFactorDisableEvent{
+		User:  user.ID.Hex(),
+		Staff: staff,
+	})
+	if err != nil {
+		return err
+	}
+
+	action := TwoFactorAuditDisable
+	if len(staff) > 0 {
+		action = TwoFactorAuditReset
+	}
+
+	service.audit(user.ID, action, staff, address)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, it requires a code or a recovery code which
+// is throttled like a login, see throttle.
+func (service *TwoFactorServiceImpl) RegenerateRecoveryCodes(ctx context.Context, id bson.ObjectId, code string, address string) ([]string, error) {
+	user, err := service.library.User.GetByID(ctx, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TwoFactor.Enabled {
+		return nil, newError("twoFactor.RegenerateRecoveryCodes", ErrValidation, "two-factor authentication isn't enabled")
+	}
+
+	err = service.throttle(ctx, user, address, func() error {
+		return service.verify(ctx, "twoFactor.RegenerateRecoveryCodes", user, code, address)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, wrapError("twoFactor.RegenerateRecoveryCodes", err)
+	}
+
+	err = service.update("twoFactor.RegenerateRecoveryCodes", user, map[string]interface{}{"twoFactor.recoveryCodes": hashes}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	service.audit(user.ID, TwoFactorAuditRecoveryRegenerate, "", address)
+	return codes, nil
+}
+
+// Audit paginates the two-factor audit log of a user.
+func (service *TwoFactorServiceImpl) Audit(ctx context.Context, id string, options PageOptions) (*TwoFactorAuditPage, error) {
+	objectID, err := parseObjectID("twoFactor.Audit", id)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &TwoFactorAuditPage{Items: []TwoFactorAudit{}}
+
+	filter := backend.Where("user", backend.Eq, objectID)
+	err = paginate("twoFactor.Audit", service.library.Storage.C(backend.TwoFactorAuditCollection), options, filter, &page.Items, &page.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// verify checks a code against the secret of a user, or a recovery code which is then used up. TOTP codes
+// can't be used twice either.
+func (service *TwoFactorServiceImpl) verify(ctx context.Context, op string, user *User, code string, address string) error {
+	if !user.TwoFactor.Enabled {
+		return newError(op, ErrValidation, "two-factor authentication isn't enabled")
+	}
+
+	step, ok := matchTOTP(user.TwoFactor.Secret, code, user.TwoFactor.LastStep, time.Now())
+	if ok {
+		// Only one request can move the last step forward, a concurrent one with the same code fails.
+		err := service.library.Storage.C(backend.UserCollection).Update(
+			backend.Where("_id", backend.Eq, user.ID).Where("twoFactor.lastStep", backend.Lt, step),
+			backend.Update{
+				Set: map[string]interface{}{"twoFactor.lastStep": step},
+				Inc: map[string]int{"version": 1},
+			},
+		)
+		if err == mgo.ErrNotFound {
+			return newError(op, ErrInvalidToken, "invalid two-factor code")
+		}
+		if err != nil {
+			return wrapError(op, err)
+		}
+
+		user.TwoFactor.LastStep = step
+		user.Version++
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for attempt := 1; ; attempt++ {
+		used, err := service.useRecoveryCode(op, user, hash)
+		if err == mgo.ErrNotFound && attempt < twoFactorRecoveryUseAttempts {
+			continue
+		}
+		if err == mgo.ErrNotFound {
+			return newError(op, ErrConflict, "user was updated concurrently, try again")
+		}
+		if err != nil {
+			return err
+		}
+		if !used {
+			return newError(op, ErrInvalidToken, "invalid two-factor code")
+		}
+
+		service.audit(user.ID, TwoFactorAuditRecoveryUse, "", address)
+		return nil
+	}
+}
+
+// throttle checks a code of a user with check behind the login throttle. The code isn't checked while the
+// account or the address is locked, and wrong codes are recorded with LoginService.FailTwoFactor so every
+// route accepting codes shares the same attempts.
+func (service *TwoFactorServiceImpl) throttle(ctx context.Context, user *User, address string, check func() error) error {
+	err := service.library.Login.Check(ctx, user.Email, address)
+	if err != nil {
+		return err
+	}
+
+	err = check()
+	if errors.Is(err, ErrInvalidToken) {
+		if failErr := service.library.Login.FailTwoFactor(ctx, user.Email, address); failErr != nil {
+			return failErr
+		}
+	}
+
+	return err
+}
+
+// useRecoveryCode removes the hash of a recovery code from the stored user, it returns false if the user
+// doesn't have the code. The code is removed at the version it was read at so the remaining codes reported
+// by TwoFactorRecoveryUseEvent are exact, mgo.ErrNotFound is returned if the user changed in the meantime.
+func (service *TwoFactorServiceImpl) useRecoveryCode(op string, user *User, hash string) (bool, error) {
+	var stored *User
+	err := service.library.Storage.C(backend.UserCollection).FindId(user.ID).One(&stored)
+	if err != nil {
+		return false, wrapError(op, err)
+	}
+
+	remaining := make([]string, 0, len(stored.TwoFactor.RecoveryCodes))
+	for _, recoveryCode := range stored.TwoFactor.RecoveryCodes {
+		if recoveryCode != hash {
+			remaining = append(remaining, recoveryCode)
+		}
+	}
+
+	if !stored.TwoFactor.Enabled || len(remaining) == len(stored.TwoFactor.RecoveryCodes) {
+		return false, nil
+	}
+
+	err = service.library.EventManager.update(backend.UserCollection, user.ID,
+		versionFilter(user.ID, stored.Version).Where("twoFactor.recoveryCodes", backend.Eq, hash),
+		backend.Update{
+			Pull: map[string]interface{}{"twoFactor.recoveryCodes": hash},
+			Inc:  map[string]int{"version": 1},
+		},
+		&TwoFactorRecoveryUseEvent{
+			User:      user.ID.Hex(),
+			Remaining: len(remaining),
+		},
+	)
+	if err == mgo.ErrNotFound {
+		return false, err
+	}
+	if err != nil {
+		return false, wrapError(op, err)
+	}
+
+	user.TwoFactor.RecoveryCodes = remaining
+	user.Version = stored.Version + 1
+	return true, nil
+}
+
+// update writes two-factor fields of a user at the version it was read at, the version is incremented so
+// stale copies of the user can't overwrite them with UserService.Update.
+func (service *TwoFactorServiceImpl) update(op string, user *User, set map[string]interface{}, event interface{}) error {
+	filter := versionFilter(user.ID, user.Version)
+	update := backend.Update{
+		Set: set,
+		Inc: map[string]int{"version": 1},
+	}
+
+	var err error
+	if event != nil {
+		err = service.library.EventManager.update(backend.UserCollection, user.ID, filter, update, event)
+	} else {
+		err = service.library.Storage.C(backend.UserCollection).Update(filter, update)
+	}
+	if err == mgo.ErrNotFound {
+		return newError(op, ErrConflict, "user was modified since version %d", user.Version)
+	}
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	user.Version++
+	return nil
+}
+
+// audit records a change to the two-factor authentication of a user, failures are logged.
+func (service *TwoFactorServiceImpl) audit(user bson.ObjectId, action string, staff string, address string) {
+	err := service.library.Storage.C(backend.TwoFactorAuditCollection).Insert(&TwoFactorAudit{
+		ID:        bson.NewObjectId(),
+		User:      user,
+		Action:    action,
+		Staff:     staff,
+		Address:   address,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		logger.Errorw("[TwoFactor] Failed to write an audit entry.", "user", user.Hex(), "action", action, logger.Err(err))
+	}
+}
+
+// newRecoveryCodes generates recovery codes, it returns them along with the hashes they are stored as.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, twoFactorRecoveryCodes)
+	hashes := make([]string, twoFactorRecoveryCodes)
+
+	for i := range codes {
+		data := make([]byte, twoFactorRecoveryCodeLen*5/8)
+		if _, err := rand.Read(data); err != nil {
+			return nil, nil, err
+		}
+
+		code := recoveryCodeEncoding.EncodeToString(data)
+		codes[i] = code[:len(code)/2] + "-" + code[len(code)/2:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hash a recovery code is stored as, dashes, spaces and case are ignored.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
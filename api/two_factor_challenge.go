@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"time"
+)
+
+// Two-factor login settings.
+const (
+	twoFactorDefaultChallengeTTL  = 5 * time.Minute
+	twoFactorChallengeMaxAttempts = 5
+	// twoFactorChallengeSubject is the subject of the challenge JWTs, access tokens use "access_token".
+	twoFactorChallengeSubject = "two_factor"
+)
+
+// Steps of a two-factor login, set on TokenPair.TwoFactor.
+const (
+	// TwoFactorVerify asks the user for a code, or a recovery code.
+	TwoFactorVerify = "verify"
+	// TwoFactorEnroll asks the user to enroll with TwoFactorService.Enroll, their group requires two-factor
+	// authentication. The login is completed with a code generated from the new secret.
+	TwoFactorEnroll = "enroll"
+)
+
+// TwoFactorChallenge represents a login waiting for a two-factor code, its JWT is only accepted by
+// TwoFactorService.Complete and, for enrollments, by TwoFactorService.Enroll.
+type TwoFactorChallenge struct {
+	ID   bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	User bson.ObjectId `json:"user" bson:"user"`
+	Step string        `json:"step" bson:"step"`
+	// Permissions are granted to the access token issued once the challenge is completed.
+	Permissions map[string]bool `json:"-" bson:"permissions"`
+	Attempts    int             `json:"attempts" bson:"attempts"`
+	CreatedAt   time.Time       `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time       `json:"expiresAt" bson:"expiresAt"`
+}
+
+// challenge starts a two-factor login if the user has enabled two-factor authentication or if their group
+// requires it, it returns nil if the user can log in with their password alone.
+func (service *TwoFactorServiceImpl) challenge(ctx context.Context, op string, id bson.ObjectId, permissions map[string]bool) (*TokenPair, error) {
+	user, err := service.library.User.GetByID(ctx, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	step := TwoFactorVerify
+	if !user.TwoFactor.Enabled {
+		required, err := service.Required(ctx, user)
+		if err != nil || !required {
+			return nil, err
+		}
+
+		step = TwoFactorEnroll
+	}
+
+	ttl := time.Duration(service.library.config.TwoFactor.ChallengeTTL) * time.Second
+	if ttl <= 0 {
+		ttl = twoFactorDefaultChallengeTTL
+	}
+
+	now := time.Now()
+	challenge := &TwoFactorChallenge{
+		ID:          bson.NewObjectId(),
+		User:        user.ID,
+		Step:        step,
+		Permissions: permissions,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	err = service.library.Storage.C(backend.TwoFactorChallengeCollection).Insert(challenge)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	jwt, err := challenge.JWT(service.library)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return &TokenPair{
+		AccessToken: jwt,
+		ExpiresAt:   challenge.ExpiresAt,
+		TwoFactor:   step,
+	}, nil
+}
+
+// Challenge returns the pending two-factor login of a challenge JWT, an ErrInvalidToken error is returned if
+// it is unknown, expired or has been completed.
+func (service *TwoFactorServiceImpl) Challenge(ctx context.Context, rawJwt string) (*TwoFactorChallenge, error) {
+	parsedJwt, err := jwt.Parse(rawJwt, service.library.keyring.keyfunc)
+	if parsedJwt == nil || !parsedJwt.Valid {
+		return nil, newError("twoFactor.Challenge", ErrInvalidToken, "%v", err)
+	}
+
+	claims, ok := parsedJwt.Claims.(jwt.MapClaims)
+	if !ok || claims["sub"] != twoFactorChallengeSubject {
+		return nil, newError("twoFactor.Challenge", ErrInvalidToken, "jwt isn't a two-factor challenge")
+	}
+
+	id, err := parseObjectID("twoFactor.Challenge", fmt.Sprint(parsedJwt.Header["id"]))
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge *TwoFactorChallenge
+	err = service.library.Storage.C(backend.TwoFactorChallengeCollection).FindId(id).One(&challenge)
+	if err == mgo.ErrNotFound {
+		return nil, newError("twoFactor.Challenge", ErrInvalidToken, "unknown two-factor challenge")
+	}
+	if err != nil {
+		return nil, wrapError("twoFactor.Challenge", err)
+	}
+
+	if !time.Now().Before(challenge.ExpiresAt) {
+		return nil, newError("twoFactor.Challenge", ErrInvalidToken, "two-factor challenge has expired")
+	}
+
+	return challenge, nil
+}
+
+// Complete completes a two-factor login with a code, or a recovery code, and issues the tokens of the login.
+// Enrollment challenges enable two-factor authentication first, the recovery codes are returned with the
+// tokens. A challenge is given up on after a few wrong codes, wrong codes are also recorded on the account with
+// LoginService.FailTwoFactor so starting new challenges doesn't allow more guesses.
+func (service *TwoFactorServiceImpl) Complete(ctx context.Context, rawJwt string, code string, address string, userAgent string) (*TokenPair, error) {
+	challenge, err := service.Challenge(ctx, rawJwt)
+	if err != nil {
+		return nil, err
+	}
+
+	challenges := service.library.Storage.C(backend.TwoFactorChallengeCollection)
+	err = challenges.Update(
+		backend.Where("_id", backend.Eq, challenge.ID).Where("attempts", backend.Lt, twoFactorChallengeMaxAttempts),
+		backend.Update{Inc: map[string]int{"attempts": 1}},
+	)
+	if err == mgo.ErrNotFound {
+		return nil, newError("twoFactor.Complete", ErrInvalidToken, "too many wrong two-factor codes, log in again")
+	}
+	if err != nil {
+		return nil, wrapError("twoFactor.Complete", err)
+	}
+
+	user, err := service.library.User.GetByID(ctx, challenge.User.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	var recoveryCodes []string
+	err = service.throttle(ctx, user, address, func() error {
+		if challenge.Step == TwoFactorEnroll && !user.TwoFactor.Enabled {
+			recoveryCodes, err = service.enable(ctx, "twoFactor.Complete", user, code, address)
+			return err
+		}
+
+		return service.verify(ctx, "twoFactor.Complete", user, code, address)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Removing the challenge fails if it has been completed concurrently.
+	err = challenges.RemoveId(challenge.ID)
+	if err == mgo.ErrNotFound {
+		return nil, newError("twoFactor.Complete", ErrInvalidToken, "two-factor challenge has been completed")
+	}
+	if err != nil {
+		return nil, wrapError("twoFactor.Complete", err)
+	}
+
+	err = service.library.Login.SucceedTwoFactor(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &TokenServiceImpl{library: service.library}
+	pair, err := tokens.issue(ctx, "twoFactor.Complete", user.ID, address, userAgent, challenge.Permissions, "", tokenGrant{})
+	if err != nil {
+		return nil, err
+	}
+
+	pair.RecoveryCodes = recoveryCodes
+	return pair, nil
+}
+
+// JWT generates the Json Web Token of a challenge, it can't be used as an access token.
+func (challenge *TwoFactorChallenge) JWT(lib *Library) (string, error) {
+	claims := &jwt.StandardClaims{
+		Audience:  challenge.User.Hex(),
+		ExpiresAt: challenge.ExpiresAt.Unix(),
+		Issuer:    "egirls.me",
+		IssuedAt:  challenge.CreatedAt.Unix(),
+		Subject:   twoFactorChallengeSubject,
+	}
+
+	return lib.keyring.sign(claims, map[string]interface{}{
+		"id": challenge.ID.Hex(),
+	})
+}
@@ -0,0 +1,264 @@
+package api_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// enableTwoFactor enables two-factor authentication for a user and returns its secret and recovery codes.
+func enableTwoFactor(t *testing.T, lib *api.Library, user *api.User) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := lib.TwoFactor.Enroll(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Enroll returned an error: %v", err)
+	}
+
+	code, err := api.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode returned an error: %v", err)
+	}
+
+	recoveryCodes, err := lib.TwoFactor.Enable(ctx, user.ID, code, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Enable returned an error: %v", err)
+	}
+
+	return enrollment.Secret, recoveryCodes
+}
+
+func TestTwoFactorComplete(t *testing.T) {
+	const (
+		correct  = "correct"
+		recovery = "recovery"
+		wrong    = "000000"
+	)
+
+	tests := []struct {
+		name string
+		// codes are sent in turn to the same challenge.
+		codes []string
+		errs  []error
+	}{
+		{"correct code", []string{correct}, []error{nil}},
+		{"recovery code", []string{recovery}, []error{nil}},
+		{"wrong code", []string{wrong}, []error{api.ErrInvalidToken}},
+		{"completed", []string{correct, recovery}, []error{nil, api.ErrInvalidToken}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, _ := apitest.New(t)
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+			secret, recoveryCodes := enableTwoFactor(t, lib, user)
+
+			pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+			if err != nil || pair.TwoFactor != api.TwoFactorVerify {
+				t.Fatalf("Issue() = %+v, %v, want a two-factor challenge", pair, err)
+			}
+
+			for i, code := range test.codes {
+				switch code {
+				case recovery:
+					code = recoveryCodes[0]
+				case correct:
+					// The code of the next period, the current one was used to enable two-factor authentication.
+					if code, err = api.TOTPCode(secret, time.Now().Add(30*time.Second)); err != nil {
+						t.Fatalf("TOTPCode returned an error: %v", err)
+					}
+				}
+
+				completed, err := lib.TwoFactor.Complete(ctx, pair.AccessToken, code, "127.0.0.1", "test")
+				if test.errs[i] != nil {
+					if !errors.Is(err, test.errs[i]) {
+						t.Fatalf("Complete(%d) = %v, want %v", i, err, test.errs[i])
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("Complete(%d) returned an error: %v", i, err)
+				}
+
+				if _, err = lib.Token.FromJWT(ctx, completed.AccessToken); err != nil {
+					t.Errorf("FromJWT(completed access token) returned an error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTwoFactorCompleteThrottle(t *testing.T) {
+	const (
+		correct = "correct"
+		wrong   = "000000"
+		unlock  = "unlock"
+	)
+
+	tests := []struct {
+		name string
+		// codes are sent in turn, unlock unlocks the account instead.
+		codes    []string
+		errs     []error
+		attempts int
+	}{
+		{"correct code", []string{correct}, []error{nil}, 0},
+		{"wrong code", []string{wrong}, []error{api.ErrInvalidToken}, 1},
+		{"delayed after a wrong code", []string{wrong, correct}, []error{api.ErrInvalidToken, api.ErrTooManyAttempts}, 1},
+		{"unlocked", []string{wrong, unlock, correct}, []error{api.ErrInvalidToken, nil, nil}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, _ := apitest.New(t)
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+			secret, _ := enableTwoFactor(t, lib, user)
+
+			pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+			if err != nil || pair.TwoFactor != api.TwoFactorVerify {
+				t.Fatalf("Issue() = %+v, %v, want a two-factor challenge", pair, err)
+			}
+
+			for i, code := range test.codes {
+				if code == unlock {
+					if err = lib.Login.Unlock(ctx, user.ID.Hex(), "staff"); err != nil {
+						t.Fatalf("Unlock returned an error: %v", err)
+					}
+					continue
+				}
+
+				// The code of the next period, the current one was used to enable two-factor authentication.
+				if code == correct {
+					if code, err = api.TOTPCode(secret, time.Now().Add(30*time.Second)); err != nil {
+						t.Fatalf("TOTPCode returned an error: %v", err)
+					}
+				}
+
+				_, err = lib.TwoFactor.Complete(ctx, pair.AccessToken, code, "127.0.0.1", "test")
+				if (test.errs[i] == nil && err != nil) || (test.errs[i] != nil && !errors.Is(err, test.errs[i])) {
+					t.Fatalf("Complete(%d) = %v, want %v", i, err, test.errs[i])
+				}
+			}
+
+			status, err := lib.Login.Status(ctx, user.ID.Hex())
+			if err != nil {
+				t.Fatalf("Status returned an error: %v", err)
+			}
+
+			if status.TwoFactorAttempts != test.attempts {
+				t.Errorf("TwoFactorAttempts = %d, want %d", status.TwoFactorAttempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestTwoFactorAttemptsSurvivePasswordLogin(t *testing.T) {
+	lib, _ := apitest.New(t)
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+
+	if err := lib.Login.FailTwoFactor(ctx, user.Email, "127.0.0.1"); err != nil {
+		t.Fatalf("FailTwoFactor returned an error: %v", err)
+	}
+
+	// A correct password doesn't reset the wrong two-factor codes.
+	if err := lib.Login.Succeed(ctx, user.Email); err != nil {
+		t.Fatalf("Succeed returned an error: %v", err)
+	}
+
+	status, err := lib.Login.Status(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Status returned an error: %v", err)
+	}
+
+	if status.TwoFactorAttempts != 1 {
+		t.Errorf("TwoFactorAttempts = %d, want 1", status.TwoFactorAttempts)
+	}
+}
+
+func TestTwoFactorRecoveryCodeUsedOnce(t *testing.T) {
+	lib, _ := apitest.New(t)
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+	_, recoveryCodes := enableTwoFactor(t, lib, user)
+
+	for i, want := range []error{nil, api.ErrInvalidToken} {
+		pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+		if err != nil {
+			t.Fatalf("Issue returned an error: %v", err)
+		}
+
+		_, err = lib.TwoFactor.Complete(ctx, pair.AccessToken, recoveryCodes[0], "127.0.0.1", "test")
+		if (want == nil && err != nil) || (want != nil && !errors.Is(err, want)) {
+			t.Fatalf("Complete(%d) = %v, want %v", i, err, want)
+		}
+	}
+
+	status, err := lib.TwoFactor.Status(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Status returned an error: %v", err)
+	}
+
+	if status.RecoveryCodes != len(recoveryCodes)-1 {
+		t.Errorf("RecoveryCodes = %d, want %d", status.RecoveryCodes, len(recoveryCodes)-1)
+	}
+}
+
+func TestTwoFactorRecoveryCodesUsedConcurrently(t *testing.T) {
+	const used = 3
+
+	lib, events := apitest.New(t)
+	ctx := context.Background()
+	user := apitest.User().Create(t, lib)
+	_, recoveryCodes := enableTwoFactor(t, lib, user)
+
+	challenges := make([]string, used)
+	for i := range challenges {
+		pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+		if err != nil {
+			t.Fatalf("Issue returned an error: %v", err)
+		}
+		challenges[i] = pair.AccessToken
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, used)
+	for i := range challenges {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = lib.TwoFactor.Complete(ctx, challenges[i], recoveryCodes[i], "127.0.0.1", "test")
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Complete(%d) returned an error: %v", i, err)
+		}
+	}
+
+	status, err := lib.TwoFactor.Status(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Status returned an error: %v", err)
+	}
+
+	if status.RecoveryCodes != len(recoveryCodes)-used {
+		t.Errorf("RecoveryCodes = %d, want %d", status.RecoveryCodes, len(recoveryCodes)-used)
+	}
+
+	// Every use reports the codes left once it was stored.
+	for remaining := len(recoveryCodes) - used; remaining < len(recoveryCodes); remaining++ {
+		events.ExpectCount(api.TwoFactorRecoveryUseEventType, 1, apitest.Match(func(event *api.TwoFactorRecoveryUseEvent) bool {
+			return event.User == user.ID.Hex() && event.Remaining == remaining
+		}))
+	}
+}
@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTwoFactorThrottle(t *testing.T) {
+	tests := []struct {
+		name string
+		// enabled is true if two-factor authentication is enabled first, it is only enrolled otherwise.
+		enabled bool
+		call    func(lib *api.Library, user *api.User, code string) error
+	}{
+		{"enable", false, func(lib *api.Library, user *api.User, code string) error {
+			_, err := lib.TwoFactor.Enable(context.Background(), user.ID, code, "127.0.0.1")
+			return err
+		}},
+		{"disable", true, func(lib *api.Library, user *api.User, code string) error {
+			return lib.TwoFactor.Disable(context.Background(), user.ID, code, "127.0.0.1")
+		}},
+		{"regenerate recovery codes", true, func(lib *api.Library, user *api.User, code string) error {
+			_, err := lib.TwoFactor.RegenerateRecoveryCodes(context.Background(), user.ID, code, "127.0.0.1")
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, _ := apitest.New(t)
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+
+			// The code of the next period is correct once two-factor authentication is enabled with the current one.
+			var secret string
+			at := time.Now().Add(30 * time.Second)
+			if test.enabled {
+				secret, _ = enableTwoFactor(t, lib, user)
+			} else {
+				enrollment, err := lib.TwoFactor.Enroll(ctx, user.ID)
+				if err != nil {
+					t.Fatalf("Enroll returned an error: %v", err)
+				}
+				secret, at = enrollment.Secret, time.Now()
+			}
+
+			if err := test.call(lib, user, "000000"); !errors.Is(err, api.ErrInvalidToken) {
+				t.Fatalf("wrong code = %v, want an invalid token error", err)
+			}
+
+			// The next attempt is delayed, even with the correct code.
+			code, err := api.TOTPCode(secret, at)
+			if err != nil {
+				t.Fatalf("TOTPCode returned an error: %v", err)
+			}
+			expectRetry(t, test.call(lib, user, code), true)
+
+			status, err := lib.Login.Status(ctx, user.ID.Hex())
+			if err != nil {
+				t.Fatalf("Status returned an error: %v", err)
+			}
+
+			if status.TwoFactorAttempts != 1 {
+				t.Errorf("TwoFactorAttempts = %d, want 1", status.TwoFactorAttempts)
+			}
+
+			// The code is accepted once the account is unlocked.
+			if err = lib.Login.Unlock(ctx, user.ID.Hex(), "staff"); err != nil {
+				t.Fatalf("Unlock returned an error: %v", err)
+			}
+
+			if err = test.call(lib, user, code); err != nil {
+				t.Errorf("correct code = %v, want nil", err)
+			}
+		})
+	}
+}
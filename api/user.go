@@ -3,8 +3,8 @@ package api
 import (
 	"context"
 	"github.com/globalsign/mgo/bson"
+	"api/backend"
 	"api/utils"
-	"strings"
 	"time"
 )
 
@@ -15,12 +15,12 @@ type UserService interface {
 	GetByUniqueID(context.Context, string) (*User, error)
 	GetByEmail(context.Context, string) (*User, error)
 	GetByToken(context.Context, string) (*User, error)
-	List(context.Context, map[string]interface{}) ([]User, error)
+	List(context.Context, UserFilter) ([]User, error)
 	Create(context.Context, *User) error
 	Update(context.Context, *User) error
 	Delete(context.Context, string) error
-	Paginate(context.Context, int, int, map[string]interface{}) ([]User, error)
-	Count(context.Context, map[string]interface{}) (int, error)
+	Paginate(context.Context, PageOptions, UserFilter) (*UserPage, error)
+	Count(context.Context, UserFilter) (int, error)
 }
 
 // UserServiceImpl is an implementation for the UserService interface.
@@ -55,55 +55,47 @@ func (service *UserServiceImpl) New(ctx context.Context, uniqueID string, email
 
 // GetByID attempts to get a user by using an id.
 func (service *UserServiceImpl) GetByID(ctx context.Context, id string) (*User, error) {
-	var user *User
-	err := service.library.Mongo.User.Find(bson.M{"_id": bson.ObjectIdHex(id)}).One(&user)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
+	objectID, err := parseObjectID("user.GetByID", id)
+	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	return service.findOne("user.GetByID", backend.Where("_id", backend.Eq, objectID))
 }
 
 // GetByUniqueID attempts to get a user by using an email address.
 func (service *UserServiceImpl) GetByUniqueID(ctx context.Context, id string) (*User, error) {
-	var user *User
-	err := service.library.Mongo.User.Find(bson.M{"uniqueId": id}).One(&user)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
-		return nil, err
-	}
-
-	return user, nil
+	return service.findOne("user.GetByUniqueID", backend.Where("uniqueId", backend.Eq, id))
 }
 
 // GetByEmail attempts to get a user by using an email address.
 func (service *UserServiceImpl) GetByEmail(ctx context.Context, email string) (*User, error) {
-	var user *User
-	err := service.library.Mongo.User.Find(bson.M{"email": email}).One(&user)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
-		return nil, err
-	}
-
-	return user, nil
+	return service.findOne("user.GetByEmail", backend.Where("email", backend.Eq, email))
 }
 
 // GetByToken attempts to get a user by using a token.
 func (service *UserServiceImpl) GetByToken(ctx context.Context, token string) (*User, error) {
+	return service.findOne("user.GetByToken", backend.Where("token", backend.Eq, token))
+}
+
+// findOne attempts to get a single user matching a filter.
+func (service *UserServiceImpl) findOne(op string, filter backend.Filter) (*User, error) {
 	var user *User
-	err := service.library.Mongo.User.Find(bson.M{"token": token}).One(&user)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
-		return nil, err
+	err := service.library.Storage.C(backend.UserCollection).Find(filter).One(&user)
+	if err != nil {
+		return nil, wrapError(op, err)
 	}
 
 	return user, nil
 }
 
 // List users
-func (service *UserServiceImpl) List(ctx context.Context, filter map[string]interface{}) ([]User, error) {
+func (service *UserServiceImpl) List(ctx context.Context, filter UserFilter) ([]User, error) {
 	var users []User
 
-	err := service.library.Mongo.User.Find(filter).All(&users)
+	err := service.library.Storage.C(backend.UserCollection).Find(filter.compile()).All(&users)
 	if err != nil {
-		return nil, err
+		return nil, wrapError("user.List", err)
 	}
 
 	return users, nil
@@ -111,43 +103,96 @@ func (service *UserServiceImpl) List(ctx context.Context, filter map[string]inte
 
 // Create a user
 func (service *UserServiceImpl) Create(ctx context.Context, user *User) error {
-	service.library.EventManager.Call(&UserCreateEvent{
+	if err := user.validate("user.Create"); err != nil {
+		return err
+	}
+
+	user.Version = 1
+	user.passwordChanged = false
+
+	err := service.library.EventManager.insert(backend.UserCollection, user.ID, user, &UserCreateEvent{
 		User: user,
 	})
-	return service.library.Mongo.User.Insert(&user)
+	if err != nil {
+		return wrapError("user.Create", err)
+	}
+
+	return nil
 }
 
 // Update a user
 func (service *UserServiceImpl) Update(ctx context.Context, user *User) error {
-	service.library.EventManager.Call(&UserUpdateEvent{
+	err := service.library.EventManager.CallPre("user.Update", &UserPreUpdateEvent{
 		User: user,
 	})
-	return service.library.Mongo.User.UpdateId(user.ID, &user)
+	if err != nil {
+		return err
+	}
+
+	if err := user.validate("user.Update"); err != nil {
+		return err
+	}
+
+	user.UpdatedAt = time.Now()
+
+	updated, err := updateVersioned("user.Update", service.library.EventManager, backend.UserCollection, user.ID, user.Version, user, func() interface{} {
+		updated := *user
+		updated.Version++
+		return &UserUpdateEvent{User: &updated}
+	})
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		user.Version++
+	}
+
+	if user.passwordChanged {
+		user.passwordChanged = false
+
+		sessions := &SessionServiceImpl{library: service.library}
+		if _, err := sessions.revokeOthers(ctx, "user.Update", user.ID, TokenRevokePassword); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Delete a user
 func (service *UserServiceImpl) Delete(ctx context.Context, id string) error {
-	service.library.EventManager.Call(&UserDeleteEvent{
+	objectID, err := parseObjectID("user.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	err = service.library.EventManager.remove(backend.UserCollection, objectID, &UserDeleteEvent{
 		ID: id,
 	})
-	return service.library.Mongo.User.RemoveId(bson.ObjectIdHex(id))
+	if err != nil {
+		return wrapError("user.Delete", err)
+	}
+
+	return nil
 }
 
 // Paginate a list of users
-func (service *UserServiceImpl) Paginate(ctx context.Context, page int, perPage int, filter map[string]interface{}) ([]User, error) {
-	var users []User
+func (service *UserServiceImpl) Paginate(ctx context.Context, options PageOptions, filter UserFilter) (*UserPage, error) {
+	page := &UserPage{Items: []User{}}
 
-	err := service.library.Mongo.User.Find(filter).Skip(perPage * (page - 1)).Limit(perPage).All(&users)
+	err := paginate("user.Paginate", service.library.Storage.C(backend.UserCollection), options, filter.compile(), &page.Items, &page.Page)
 	if err != nil {
 		return nil, err
 	}
 
-	return users, nil
+	return page, nil
 }
 
 // Count all users
-func (service *UserServiceImpl) Count(ctx context.Context, filter map[string]interface{}) (int, error) {
-	return service.library.Mongo.User.Find(filter).Count()
+func (service *UserServiceImpl) Count(ctx context.Context, filter UserFilter) (int, error) {
+	count, err := service.library.Storage.C(backend.UserCollection).Find(filter.compile()).Count()
+	return count, wrapError("user.Count", err)
 }
 
 // User represents a "egirls.me" user
@@ -172,9 +217,94 @@ type User struct {
 	Token            string        `json:"-" bson:"token"`
 	CreatedAt        time.Time     `json:"createdAt" bson:"createdAt"`
 	UpdatedAt        time.Time     `json:"updatedAt" bson:"updatedAt"`
+	Version          int           `json:"version" bson:"version"`
+	// TwoFactor holds the two-factor authentication settings of the user, it is managed by TwoFactorService.
+	TwoFactor UserTwoFactor `json:"-" bson:"twoFactor"`
+
+	// passwordChanged is set by SetPassword, Update revokes the user's other sessions once it is stored.
+	passwordChanged bool
+}
+
+// UserTwoFactor represents the two-factor authentication settings of a user.
+type UserTwoFactor struct {
+	Enabled bool   `bson:"enabled"`
+	Secret  string `bson:"secret"`
+	// PendingSecret is the secret being enrolled, it replaces Secret once a code generated from it is verified.
+	PendingSecret string `bson:"pendingSecret"`
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes.
+	RecoveryCodes []string `bson:"recoveryCodes"`
+	// LastStep is the TOTP period of the last accepted code, a code can't be used twice.
+	LastStep  int64     `bson:"lastStep"`
+	EnabledAt time.Time `bson:"enabledAt"`
+}
+
+// UserPage represents a page of users.
+type UserPage struct {
+	Items []User `json:"items"`
+	Page
+}
+
+// pageKey returns the fields used to create a cursor pointing to this user.
+func (user User) pageKey() (bson.ObjectId, time.Time) {
+	return user.ID, user.CreatedAt
+}
+
+// UserFilter represents the criteria used to filter users, empty fields are ignored.
+type UserFilter struct {
+	UniqueID string
+	Email    string
+	Name     string
+	// Address matches both the user's current address and their previous addresses.
+	Address        string
+	Group          bson.ObjectId
+	CreatedBetween TimeRange
+}
+
+// compile converts the filter into a backend filter.
+func (filter UserFilter) compile() backend.Filter {
+	compiled := backend.Filter{}
+
+	if len(filter.UniqueID) > 0 {
+		compiled = compiled.Where("uniqueId", backend.Eq, filter.UniqueID)
+	}
+
+	if len(filter.Email) > 0 {
+		compiled = compiled.Where("email", backend.Eq, filter.Email)
+	}
+
+	if len(filter.Name) > 0 {
+		compiled = compiled.Where("name", backend.Eq, filter.Name)
+	}
+
+	if len(filter.Address) > 0 {
+		compiled.Any = []backend.Filter{
+			backend.Where("address", backend.Eq, filter.Address),
+			backend.Where("addresses", backend.Eq, filter.Address),
+		}
+	}
+
+	if len(filter.Group) > 0 {
+		compiled = compiled.Where("group", backend.Eq, filter.Group)
+	}
+
+	return filter.CreatedBetween.compile(compiled, "createdAt")
+}
+
+// validate checks that the user can be written to storage.
+func (user *User) validate(op string) error {
+	if !user.ID.Valid() {
+		return newError(op, ErrInvalidID, "user is missing an id")
+	}
+
+	if len(user.UniqueID) < 1 && len(user.Email) < 1 {
+		return newError(op, ErrValidation, "user must have a uniqueId or an email")
+	}
+
+	return nil
 }
 
-// SetPassword hashes a raw password and updates the user's password.
+// SetPassword hashes a raw password and updates the user's password. Once the user is updated, every session
+// of the user but the current one is revoked.
 func (user *User) SetPassword(password string) error {
 	hash, err := utils.HashPassword(password)
 	if err != nil {
@@ -182,6 +312,7 @@ func (user *User) SetPassword(password string) error {
 	}
 
 	user.Password = hash
+	user.passwordChanged = true
 	return nil
 }
 
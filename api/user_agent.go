@@ -0,0 +1,94 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Device types of a parsed user agent.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// UserAgent represents the device and browser parsed from a User-Agent header.
+type UserAgent struct {
+	Device         string `json:"device"`
+	OS             string `json:"os"`
+	Browser        string `json:"browser"`
+	BrowserVersion string `json:"browserVersion"`
+}
+
+// userAgentBrowsers are matched in order, browsers based on Chrome or Safari mention them too so they come first.
+var userAgentBrowsers = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/([\d.]+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+	{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+	{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+}
+
+// userAgentSystems are matched in order, Android and iOS user agents mention Linux and Mac OS X too.
+var userAgentSystems = []struct {
+	name    string
+	pattern string
+}{
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iOS", "iPhone"},
+	{"iPadOS", "iPad"},
+	{"ChromeOS", "CrOS"},
+	{"macOS", "Mac OS X"},
+	{"Linux", "Linux"},
+}
+
+// userAgentBot matches the user agents of crawlers and HTTP clients.
+var userAgentBot = regexp.MustCompile(`(?i)bot|crawler|spider|curl|wget|python-requests|go-http-client`)
+
+// ParseUserAgent extracts the device, operating system and browser from a User-Agent header, the fields
+// that can't be recognised are left empty.
+func ParseUserAgent(header string) UserAgent {
+	agent := UserAgent{Device: DeviceUnknown}
+	if len(header) < 1 {
+		return agent
+	}
+
+	if userAgentBot.MatchString(header) {
+		agent.Device = DeviceBot
+		return agent
+	}
+
+	for _, system := range userAgentSystems {
+		if strings.Contains(header, system.pattern) {
+			agent.OS = system.name
+			break
+		}
+	}
+
+	for _, browser := range userAgentBrowsers {
+		if match := browser.pattern.FindStringSubmatch(header); match != nil {
+			agent.Browser = browser.name
+			agent.BrowserVersion = strings.Split(match[1], ".")[0]
+			break
+		}
+	}
+
+	switch {
+	case agent.OS == "iPadOS" || strings.Contains(header, "Tablet") || (agent.OS == "Android" && !strings.Contains(header, "Mobile")):
+		agent.Device = DeviceTablet
+	case agent.OS == "iOS" || strings.Contains(header, "Mobile"):
+		agent.Device = DeviceMobile
+	case len(agent.OS) > 0:
+		agent.Device = DeviceDesktop
+	}
+
+	return agent
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"reflect"
+)
+
+// versionFilter returns a filter matching a document at a specific version.
+//
+// Documents written before versioning was introduced don't have a version field, they are treated as version 0.
+func versionFilter(id bson.ObjectId, version int) backend.Filter {
+	filter := backend.Where("_id", backend.Eq, id)
+
+	if version == 0 {
+		filter.Any = []backend.Filter{
+			backend.Where("version", backend.Eq, 0),
+			backend.Where("version", backend.Exists, false),
+		}
+		return filter
+	}
+
+	return filter.Where("version", backend.Eq, version)
+}
+
+// updateVersioned writes the fields of a document that changed since it was read at the specified version.
+//
+// It returns ErrConflict if the document has been modified by someone else in the meantime, and whether
+// anything was written, in which case the version of the stored document has been incremented. If newEvent
+// isn't nil, the event it returns is recorded with the write, see EventManager.update. It is only called if
+// something changed.
+func updateVersioned(op string, manager *EventManager, name string, id bson.ObjectId, version int, document interface{}, newEvent func() interface{}) (bool, error) {
+	collection := manager.Library.Storage.C(name)
+
+	var stored bson.M
+	err := collection.Find(versionFilter(id, version)).One(&stored)
+	if err == mgo.ErrNotFound {
+		// Figure out if the document was modified or removed.
+		count, err := collection.Find(backend.Where("_id", backend.Eq, id)).Count()
+		if err != nil {
+			return false, wrapError(op, err)
+		}
+
+		if count > 0 {
+			return false, newError(op, ErrConflict, "document was modified since version %d", version)
+		}
+
+		return false, newError(op, ErrNotFound, "")
+	}
+	if err != nil {
+		return false, wrapError(op, err)
+	}
+
+	// Round trip the document through bson so its values are comparable with the stored ones.
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return false, wrapError(op, err)
+	}
+
+	var current bson.M
+	err = bson.Unmarshal(data, &current)
+	if err != nil {
+		return false, wrapError(op, err)
+	}
+
+	changed := map[string]interface{}{}
+	for field, value := range current {
+		if field == "_id" || field == "version" {
+			continue
+		}
+
+		if !reflect.DeepEqual(stored[field], value) {
+			changed[field] = value
+		}
+	}
+
+	// Bumping the update timestamp alone isn't a change.
+	if _, ok := changed["updatedAt"]; len(changed) < 1 || (ok && len(changed) == 1) {
+		return false, nil
+	}
+
+	update := backend.Update{
+		Set: changed,
+		Inc: map[string]int{"version": 1},
+	}
+	if newEvent != nil {
+		err = manager.update(name, id, versionFilter(id, version), update, newEvent())
+	} else {
+		err = collection.Update(versionFilter(id, version), update)
+	}
+	if err == mgo.ErrNotFound {
+		return false, newError(op, ErrConflict, "document was modified since version %d", version)
+	}
+	if err != nil {
+		return false, wrapError(op, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"net/url"
+	"time"
+)
+
+// Webhook delivery statuses.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+// WebhookService is an interface for interfacing with Webhooks.
+type WebhookService interface {
+	New(context.Context, string, []string) *Webhook
+	GetByID(context.Context, string) (*Webhook, error)
+	List(context.Context) ([]Webhook, error)
+	Create(context.Context, *Webhook) error
+	Update(context.Context, *Webhook) error
+	Delete(context.Context, string) error
+	GetDelivery(context.Context, string) (*WebhookDelivery, error)
+	Deliveries(context.Context, PageOptions, WebhookDeliveryFilter) (*WebhookDeliveryPage, error)
+	Redeliver(context.Context, string) (*WebhookDelivery, error)
+}
+
+// WebhookServiceImpl is an implementation for the WebhookService interface.
+type WebhookServiceImpl struct {
+	library *Library
+}
+
+// New attempts to create a new Webhook object with a random secret.
+func (service *WebhookServiceImpl) New(ctx context.Context, endpoint string, events []string) *Webhook {
+	webhook := &Webhook{
+		ID:        bson.NewObjectId(),
+		URL:       endpoint,
+		Secret:    newWebhookSecret(),
+		Events:    events,
+		Active:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	return webhook
+}
+
+// GetByID attempts to get a webhook by using an id.
+func (service *WebhookServiceImpl) GetByID(ctx context.Context, id string) (*Webhook, error) {
+	objectID, err := parseObjectID("webhook.GetByID", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var webhook *Webhook
+	err = service.library.Storage.C(backend.WebhookCollection).FindId(objectID).One(&webhook)
+	if err != nil {
+		return nil, wrapError("webhook.GetByID", err)
+	}
+
+	return webhook, nil
+}
+
+// List webhooks
+func (service *WebhookServiceImpl) List(ctx context.Context) ([]Webhook, error) {
+	var webhooks []Webhook
+
+	err := service.library.Storage.C(backend.WebhookCollection).Find(backend.Filter{}).All(&webhooks)
+	if err != nil {
+		return nil, wrapError("webhook.List", err)
+	}
+
+	return webhooks, nil
+}
+
+// Create a webhook
+func (service *WebhookServiceImpl) Create(ctx context.Context, webhook *Webhook) error {
+	if err := webhook.validate("webhook.Create"); err != nil {
+		return err
+	}
+
+	webhook.Version = 1
+
+	return wrapError("webhook.Create", service.library.Storage.C(backend.WebhookCollection).Insert(webhook))
+}
+
+// Update a webhook
+func (service *WebhookServiceImpl) Update(ctx context.Context, webhook *Webhook) error {
+	if err := webhook.validate("webhook.Update"); err != nil {
+		return err
+	}
+
+	webhook.UpdatedAt = time.Now()
+
+	updated, err := updateVersioned("webhook.Update", service.library.EventManager, backend.WebhookCollection, webhook.ID, webhook.Version, webhook, nil)
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		webhook.Version++
+	}
+
+	return nil
+}
+
+// Delete a webhook, its pending deliveries are moved to the dead-letter list by the relay.
+func (service *WebhookServiceImpl) Delete(ctx context.Context, id string) error {
+	objectID, err := parseObjectID("webhook.Delete", id)
+	if err != nil {
+		return err
+	}
+
+	return wrapError("webhook.Delete", service.library.Storage.C(backend.WebhookCollection).RemoveId(objectID))
+}
+
+// GetDelivery attempts to get a webhook delivery by using an id.
+func (service *WebhookServiceImpl) GetDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	objectID, err := parseObjectID("webhook.GetDelivery", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var delivery *WebhookDelivery
+	err = service.library.Storage.C(backend.WebhookDeliveryCollection).FindId(objectID).One(&delivery)
+	if err != nil {
+		return nil, wrapError("webhook.GetDelivery", err)
+	}
+
+	return delivery, nil
+}
+
+// Deliveries returns a page of the delivery log, use WebhookDeliveryFilter.Status to list the dead-letters.
+func (service *WebhookServiceImpl) Deliveries(ctx context.Context, options PageOptions, filter WebhookDeliveryFilter) (*WebhookDeliveryPage, error) {
+	page := &WebhookDeliveryPage{}
+
+	err := paginate("webhook.Deliveries", service.library.Storage.C(backend.WebhookDeliveryCollection), options, filter.compile(), &page.Items, &page.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// Redeliver queues a copy of a delivery, the original delivery is kept in the log.
+func (service *WebhookServiceImpl) Redeliver(ctx context.Context, id string) (*WebhookDelivery, error) {
+	original, err := service.GetDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	delivery := &WebhookDelivery{
+		ID:            bson.NewObjectId(),
+		WebhookID:     original.WebhookID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        WebhookDeliveryPending,
+		RedeliveryOf:  original.ID,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	err = service.library.Storage.C(backend.WebhookDeliveryCollection).Insert(delivery)
+	if err != nil {
+		return nil, wrapError("webhook.Redeliver", err)
+	}
+
+	service.library.EventManager.webhooks.wakeUp()
+	return delivery, nil
+}
+
+// Webhook represents an external endpoint that receives the api events.
+type Webhook struct {
+	ID  bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	URL string        `json:"url" bson:"url"`
+	// Secret is the key used to sign the payloads delivered to the webhook.
+	Secret      string `json:"secret" bson:"secret"`
+	Description string `json:"description" bson:"description"`
+	// Events are the event types delivered to the webhook, every event is delivered if it is empty.
+	Events    []string  `json:"events" bson:"events"`
+	Active    bool      `json:"active" bson:"active"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	Version   int       `json:"version" bson:"version"`
+}
+
+// validate checks that the webhook can be written to storage.
+func (webhook *Webhook) validate(op string) error {
+	if !webhook.ID.Valid() {
+		return newError(op, ErrInvalidID, "webhook is missing an id")
+	}
+
+	endpoint, err := url.Parse(webhook.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) < 1 {
+		return newError(op, ErrValidation, "webhook must have an http or https url")
+	}
+
+	if len(webhook.Secret) < 1 {
+		return newError(op, ErrValidation, "webhook must have a secret")
+	}
+
+	return nil
+}
+
+// Subscribes returns true if events of the specified type are delivered to the webhook.
+func (webhook *Webhook) Subscribes(eventType string) bool {
+	if len(webhook.Events) < 1 {
+		return true
+	}
+
+	for _, event := range webhook.Events {
+		if event == eventType || event == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery represents an event delivered, or waiting to be delivered, to a webhook.
+type WebhookDelivery struct {
+	ID        bson.ObjectId `json:"id" bson:"_id,omitempty"`
+	WebhookID bson.ObjectId `json:"webhookId" bson:"webhookId"`
+	EventID   string        `json:"eventId" bson:"eventId"`
+	EventType string        `json:"eventType" bson:"eventType"`
+	// Payload is the signed request body.
+	Payload string `json:"payload" bson:"payload"`
+	// Status is either WebhookDeliveryPending, WebhookDeliveryDelivered or WebhookDeliveryDead.
+	Status         string `json:"status" bson:"status"`
+	Attempts       int    `json:"attempts" bson:"attempts"`
+	ResponseStatus int    `json:"responseStatus" bson:"responseStatus"`
+	LastError      string `json:"lastError" bson:"lastError"`
+	// RedeliveryOf is the id of the delivery this one was copied from.
+	RedeliveryOf  bson.ObjectId `json:"redeliveryOf,omitempty" bson:"redeliveryOf,omitempty"`
+	NextAttemptAt time.Time     `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	ClaimedUntil  time.Time     `json:"-" bson:"claimedUntil"`
+	DeliveredAt   time.Time     `json:"deliveredAt" bson:"deliveredAt"`
+	CreatedAt     time.Time     `json:"createdAt" bson:"createdAt"`
+}
+
+func (delivery WebhookDelivery) pageKey() (bson.ObjectId, time.Time) {
+	return delivery.ID, delivery.CreatedAt
+}
+
+// WebhookDeliveryPage represents a page of webhook deliveries.
+type WebhookDeliveryPage struct {
+	Page
+	Items []WebhookDelivery `json:"items"`
+}
+
+// WebhookDeliveryFilter represents the criteria used to filter webhook deliveries, empty fields are ignored.
+type WebhookDeliveryFilter struct {
+	WebhookID bson.ObjectId
+	EventType string
+	Status    string
+}
+
+// compile converts the filter into a backend filter.
+func (filter WebhookDeliveryFilter) compile() backend.Filter {
+	compiled := backend.Filter{}
+
+	if len(filter.WebhookID) > 0 {
+		compiled = compiled.Where("webhookId", backend.Eq, filter.WebhookID)
+	}
+
+	if len(filter.EventType) > 0 {
+		compiled = compiled.Where("eventType", backend.Eq, filter.EventType)
+	}
+
+	if len(filter.Status) > 0 {
+		compiled = compiled.Where("status", backend.Eq, filter.Status)
+	}
+
+	return compiled
+}
+
+// newWebhookSecret generates a random webhook secret.
+func newWebhookSecret() string {
+	data := make([]byte, 32)
+	_, _ = rand.Read(data)
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,343 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"api/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook relay settings.
+const (
+	webhookBatchSize      = 100
+	webhookWorkers        = 8
+	webhookPollInterval   = time.Second
+	webhookClaimTimeout   = time.Minute
+	webhookRequestTimeout = 10 * time.Second
+	webhookMinBackoff     = 10 * time.Second
+	webhookMaxBackoff     = time.Hour
+	webhookMaxAttempts    = 12
+)
+
+// Headers sent with every webhook delivery.
+//
+// The signature is the hex encoded HMAC-SHA256 of "<timestamp>.<body>" using the webhook's secret, prefixed
+// by "sha256=". Receivers should reject deliveries with an old timestamp to prevent replays.
+const (
+	WebhookEventHeader     = "X-Ikuta-Event"
+	WebhookDeliveryHeader  = "X-Ikuta-Delivery"
+	WebhookTimestampHeader = "X-Ikuta-Timestamp"
+	WebhookSignatureHeader = "X-Ikuta-Signature"
+)
+
+// webhookRelay delivers the events to the registered webhooks in the background, retrying failed deliveries
+// with an exponential backoff until they succeed or are moved to the dead-letter list.
+type webhookRelay struct {
+	manager *EventManager
+	client  *http.Client
+	wake    chan struct{}
+}
+
+// newWebhookRelay creates a new webhook relay.
+func newWebhookRelay(manager *EventManager) *webhookRelay {
+	return &webhookRelay{
+		manager: manager,
+		client:  &http.Client{Timeout: webhookRequestTimeout},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// deliveries returns the collection holding the webhook deliveries.
+func (relay *webhookRelay) deliveries() backend.Collection {
+	return relay.manager.Library.Storage.C(backend.WebhookDeliveryCollection)
+}
+
+// enqueue creates a delivery of an encoded event for every active webhook subscribed to its type.
+//
+// It can be called again for the same event: the deliveries that already exist are skipped, so the ones that
+// failed are created by the next call. The first error is returned once every webhook has been tried.
+func (relay *webhookRelay) enqueue(eventType string, eventID string, timestamp time.Time, data []byte) error {
+	var webhooks []Webhook
+	err := relay.manager.Library.Storage.C(backend.WebhookCollection).Find(backend.Where("active", backend.Eq, true)).All(&webhooks)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	queued := false
+	var firstErr error
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(eventType) {
+			continue
+		}
+
+		err = relay.deliveries().Insert(&WebhookDelivery{
+			ID:            webhookDeliveryID(eventID, webhook.ID, timestamp),
+			WebhookID:     webhook.ID,
+			EventID:       eventID,
+			EventType:     eventType,
+			Payload:       string(data),
+			Status:        WebhookDeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if mgo.IsDup(err) {
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		queued = true
+	}
+
+	if queued {
+		relay.wakeUp()
+	}
+
+	return firstErr
+}
+
+// webhookDeliveryID returns the id of the delivery of an event to a webhook. It starts with the time of the
+// event like other object ids so the deliveries keep their order, the rest is derived from both ids.
+func webhookDeliveryID(eventID string, webhookID bson.ObjectId, timestamp time.Time) bson.ObjectId {
+	sum := sha256.Sum256([]byte(eventID + "." + string(webhookID)))
+
+	id := make([]byte, 12)
+	binary.BigEndian.PutUint32(id, uint32(timestamp.Unix()))
+	copy(id[4:], sum[:8])
+
+	return bson.ObjectId(id)
+}
+
+// wakeUp makes the relay look for pending deliveries right away.
+func (relay *webhookRelay) wakeUp() {
+	select {
+	case relay.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers pending deliveries until stop is closed.
+func (relay *webhookRelay) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-relay.wake:
+		case <-ticker.C:
+		}
+
+		relay.deliverPending()
+	}
+}
+
+// deliverPending sends every delivery that is due.
+func (relay *webhookRelay) deliverPending() {
+	for {
+		now := time.Now()
+
+		var deliveries []WebhookDelivery
+		err := relay.deliveries().
+			Find(backend.Where("status", backend.Eq, WebhookDeliveryPending).Where("nextAttemptAt", backend.Lte, now).Where("claimedUntil", backend.Lte, now)).
+			Sort("_id").
+			Limit(webhookBatchSize).
+			All(&deliveries)
+		if err != nil {
+			logger.Errorw("[Webhooks] Failed to read pending deliveries.", logger.Err(err))
+			return
+		}
+
+		relay.deliverBatch(deliveries)
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliverBatch sends a batch of deliveries with up to webhookWorkers webhooks at a time, so a slow webhook
+// doesn't hold back the others. The deliveries of a webhook are sent one after another in their order.
+func (relay *webhookRelay) deliverBatch(deliveries []WebhookDelivery) {
+	var webhooks []bson.ObjectId
+	queues := make(map[bson.ObjectId][]WebhookDelivery)
+	for _, delivery := range deliveries {
+		if _, ok := queues[delivery.WebhookID]; !ok {
+			webhooks = append(webhooks, delivery.WebhookID)
+		}
+		queues[delivery.WebhookID] = append(queues[delivery.WebhookID], delivery)
+	}
+
+	workers := make(chan struct{}, webhookWorkers)
+	var wg sync.WaitGroup
+	for _, webhookID := range webhooks {
+		workers <- struct{}{}
+		wg.Add(1)
+
+		go func(queue []WebhookDelivery) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			for _, delivery := range queue {
+				relay.deliver(delivery)
+			}
+		}(queues[webhookID])
+	}
+
+	wg.Wait()
+}
+
+// deliver sends a single delivery, making sure no other instance is sending it at the same time.
+func (relay *webhookRelay) deliver(delivery WebhookDelivery) {
+	now := time.Now()
+
+	// Claim the delivery, this fails if another instance claimed it first.
+	err := relay.deliveries().Update(
+		backend.Where("_id", backend.Eq, delivery.ID).Where("claimedUntil", backend.Eq, delivery.ClaimedUntil),
+		backend.Update{Set: map[string]interface{}{"claimedUntil": now.Add(webhookClaimTimeout)}},
+	)
+	if err == mgo.ErrNotFound {
+		return
+	}
+	if err != nil {
+		logger.Errorw("[Webhooks] Failed to claim delivery.", logger.Err(err))
+		return
+	}
+
+	var webhook *Webhook
+	err = relay.manager.Library.Storage.C(backend.WebhookCollection).FindId(delivery.WebhookID).One(&webhook)
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Errorw("[Webhooks] Failed to get the webhook of a delivery.", logger.Err(err))
+		relay.reschedule(delivery, 0, err)
+		return
+	}
+
+	if webhook == nil || !webhook.Active {
+		relay.finish(delivery, WebhookDeliveryDead, 0, "webhook was removed or deactivated")
+		return
+	}
+
+	status, sendErr := relay.send(webhook, delivery)
+	if sendErr != nil {
+		relay.reschedule(delivery, status, sendErr)
+		return
+	}
+
+	relay.finish(delivery, WebhookDeliveryDelivered, status, "")
+}
+
+// send posts a delivery's payload to its webhook and returns the response status.
+func (relay *webhookRelay) send(webhook *Webhook, delivery WebhookDelivery) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.EventType)
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID.Hex())
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhookPayload(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	response, err := relay.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+// reschedule records a failed attempt, moving the delivery to the dead-letter list once it ran out of attempts.
+func (relay *webhookRelay) reschedule(delivery WebhookDelivery, status int, cause error) {
+	if delivery.Attempts+1 >= webhookMaxAttempts {
+		relay.finish(delivery, WebhookDeliveryDead, status, cause.Error())
+		return
+	}
+
+	err := relay.deliveries().Update(backend.Where("_id", backend.Eq, delivery.ID), backend.Update{
+		Set: map[string]interface{}{
+			"responseStatus": status,
+			"lastError":      cause.Error(),
+			"nextAttemptAt":  time.Now().Add(webhookBackoff(delivery.Attempts)),
+			"claimedUntil":   time.Time{},
+		},
+		Inc: map[string]int{"attempts": 1},
+	})
+	if err != nil {
+		logger.Errorw("[Webhooks] Failed to reschedule delivery.", logger.Err(err))
+	}
+}
+
+// finish marks a delivery as delivered or dead.
+func (relay *webhookRelay) finish(delivery WebhookDelivery, deliveryStatus string, status int, lastError string) {
+	set := map[string]interface{}{
+		"status":         deliveryStatus,
+		"responseStatus": status,
+		"lastError":      lastError,
+		"claimedUntil":   time.Time{},
+	}
+	if deliveryStatus == WebhookDeliveryDelivered {
+		set["deliveredAt"] = time.Now()
+	}
+
+	err := relay.deliveries().Update(backend.Where("_id", backend.Eq, delivery.ID), backend.Update{
+		Set: set,
+		Inc: map[string]int{"attempts": 1},
+	})
+	if err != nil {
+		logger.Errorw("[Webhooks] Failed to update delivery.", logger.Err(err))
+	}
+}
+
+// SignWebhookPayload returns the hex encoded signature of a webhook payload sent at the specified unix timestamp.
+func SignWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns how long to wait before retrying a delivery that failed the specified amount of times.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookMinBackoff
+	for i := 0; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+
+	return backoff
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"api/backend"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newWebhookTestRelay creates a relay on an in-memory storage without starting it, with a webhook of the
+// specified url subscribed to every event.
+func newWebhookTestRelay(t *testing.T, url string) (*webhookRelay, *Webhook) {
+	relay := newWebhookRelay(&EventManager{Library: &Library{Storage: backend.NewMemoryStorage(), Cache: backend.NewMemoryCache()}})
+
+	webhook := &Webhook{ID: bson.NewObjectId(), URL: url, Secret: "secret", Active: true, CreatedAt: time.Now()}
+	if err := relay.manager.Library.Storage.C(backend.WebhookCollection).Insert(webhook); err != nil {
+		t.Fatalf("Insert returned an error: %v", err)
+	}
+
+	return relay, webhook
+}
+
+// webhookDeliveries returns every delivery of the relay.
+func webhookDeliveries(t *testing.T, relay *webhookRelay) []WebhookDelivery {
+	t.Helper()
+
+	var deliveries []WebhookDelivery
+	if err := relay.deliveries().Find(backend.Filter{}).Sort("_id").All(&deliveries); err != nil {
+		t.Fatalf("Find returned an error: %v", err)
+	}
+
+	return deliveries
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	const want = "5164242d2d7c1061af198b4bfea622c8f5aeec1b9276e38d50a14d7f9dd39bee"
+	if signature := SignWebhookPayload("secret", "1700000000", []byte(`{"type":"test"}`)); signature != want {
+		t.Fatalf("SignWebhookPayload() = %s, want %s", signature, want)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		payload   string
+	}{
+		{"other secret", "other", "1700000000", `{"type":"test"}`},
+		{"other timestamp", "secret", "1700000001", `{"type":"test"}`},
+		{"other payload", "secret", "1700000000", `{"type":"other"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if SignWebhookPayload(test.secret, test.timestamp, []byte(test.payload)) == want {
+				t.Errorf("SignWebhookPayload() didn't change")
+			}
+		})
+	}
+}
+
+func TestWebhookRelayDelivers(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	relay, webhook := newWebhookTestRelay(t, server.URL)
+	if err := relay.enqueue("TestEvent", "event", time.Now(), []byte(`{"type":"TestEvent"}`)); err != nil {
+		t.Fatalf("enqueue returned an error: %v", err)
+	}
+
+	relay.deliverPending()
+
+	var request *http.Request
+	select {
+	case request = <-received:
+	default:
+		t.Fatalf("the webhook didn't receive the delivery")
+	}
+
+	timestamp := request.Header.Get(WebhookTimestampHeader)
+	if signature := request.Header.Get(WebhookSignatureHeader); signature != "sha256="+SignWebhookPayload(webhook.Secret, timestamp, body) {
+		t.Errorf("signature = %q, want the signature of the body", signature)
+	}
+
+	if string(body) != `{"type":"TestEvent"}` || request.Header.Get(WebhookEventHeader) != "TestEvent" {
+		t.Errorf("received %s %q, want the TestEvent payload", request.Header.Get(WebhookEventHeader), body)
+	}
+
+	deliveries := webhookDeliveries(t, relay)
+	if len(deliveries) != 1 {
+		t.Fatalf("relay holds %d deliveries, want 1", len(deliveries))
+	}
+
+	delivery := deliveries[0]
+	if request.Header.Get(WebhookDeliveryHeader) != delivery.ID.Hex() {
+		t.Errorf("delivery header = %q, want %q", request.Header.Get(WebhookDeliveryHeader), delivery.ID.Hex())
+	}
+
+	if delivery.Status != WebhookDeliveryDelivered || delivery.Attempts != 1 || delivery.ResponseStatus != http.StatusOK || delivery.DeliveredAt.IsZero() {
+		t.Errorf("delivery = %+v, want a delivery delivered on the first attempt", delivery)
+	}
+}
+
+func TestWebhookRelayRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// attempts is the amount of attempts that failed before.
+		attempts int
+		status   int
+		active   bool
+		want     string
+		response int
+	}{
+		{"delivered", 0, http.StatusNoContent, true, WebhookDeliveryDelivered, http.StatusNoContent},
+		{"server error", 0, http.StatusInternalServerError, true, WebhookDeliveryPending, http.StatusInternalServerError},
+		{"not modified", 3, http.StatusNotModified, true, WebhookDeliveryPending, http.StatusNotModified},
+		{"last attempt", webhookMaxAttempts - 1, http.StatusBadGateway, true, WebhookDeliveryDead, http.StatusBadGateway},
+		{"inactive webhook", 0, http.StatusOK, false, WebhookDeliveryDead, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			relay, webhook := newWebhookTestRelay(t, server.URL)
+			if err := relay.enqueue("TestEvent", "event", time.Now(), []byte("{}")); err != nil {
+				t.Fatalf("enqueue returned an error: %v", err)
+			}
+
+			err := relay.deliveries().Update(backend.Filter{}, backend.Update{Set: map[string]interface{}{"attempts": test.attempts}})
+			if err != nil {
+				t.Fatalf("Update returned an error: %v", err)
+			}
+
+			// The webhook is deactivated once the event was queued.
+			if !test.active {
+				err = relay.manager.Library.Storage.C(backend.WebhookCollection).Update(
+					backend.Where("_id", backend.Eq, webhook.ID),
+					backend.Update{Set: map[string]interface{}{"active": false}},
+				)
+				if err != nil {
+					t.Fatalf("Update returned an error: %v", err)
+				}
+			}
+
+			relay.deliverPending()
+
+			delivery := webhookDeliveries(t, relay)[0]
+			if delivery.Status != test.want || delivery.Attempts != test.attempts+1 || delivery.ResponseStatus != test.response {
+				t.Fatalf("delivery = %s after %d attempts with status %d, want %s after %d attempts with status %d",
+					delivery.Status, delivery.Attempts, delivery.ResponseStatus, test.want, test.attempts+1, test.response)
+			}
+
+			if test.want != WebhookDeliveryPending {
+				return
+			}
+
+			if len(delivery.LastError) < 1 || !delivery.ClaimedUntil.IsZero() {
+				t.Errorf("delivery = %+v, want an unclaimed delivery with its error", delivery)
+			}
+
+			// The delivery waits for its backoff before it is retried.
+			if wait := time.Until(delivery.NextAttemptAt); wait < webhookBackoff(test.attempts)-time.Second || wait > webhookBackoff(test.attempts) {
+				t.Errorf("delivery is retried in %s, want %s", wait, webhookBackoff(test.attempts))
+			}
+
+			relay.deliverPending()
+			if delivery = webhookDeliveries(t, relay)[0]; delivery.Attempts != test.attempts+1 {
+				t.Errorf("delivery was retried before its backoff elapsed")
+			}
+		})
+	}
+}
+
+func TestWebhookRelayEnqueueTwice(t *testing.T) {
+	relay, webhook := newWebhookTestRelay(t, "https://hooks.test/")
+	other := &Webhook{ID: bson.NewObjectId(), URL: "https://other.test/", Secret: "secret", Active: true, Events: []string{"OtherEvent"}}
+	if err := relay.manager.Library.Storage.C(backend.WebhookCollection).Insert(other); err != nil {
+		t.Fatalf("Insert returned an error: %v", err)
+	}
+
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// An event relayed again doesn't create a second delivery.
+	for i := 0; i < 2; i++ {
+		if err := relay.enqueue("TestEvent", "event", timestamp, []byte("{}")); err != nil {
+			t.Fatalf("enqueue returned an error: %v", err)
+		}
+	}
+
+	if err := relay.enqueue("TestEvent", "next", timestamp.Add(time.Second), []byte("{}")); err != nil {
+		t.Fatalf("enqueue returned an error: %v", err)
+	}
+
+	deliveries := webhookDeliveries(t, relay)
+	if len(deliveries) != 2 {
+		t.Fatalf("relay holds %d deliveries, want one per event", len(deliveries))
+	}
+
+	for i, eventID := range []string{"event", "next"} {
+		delivery := deliveries[i]
+		if delivery.EventID != eventID || delivery.WebhookID != webhook.ID {
+			t.Errorf("delivery %d is of %s to %s, want %s to %s", i, delivery.EventID, delivery.WebhookID.Hex(), eventID, webhook.ID.Hex())
+		}
+
+		// The ids keep the order of the events.
+		if want := timestamp.Add(time.Duration(i) * time.Second); !delivery.ID.Time().Equal(want) {
+			t.Errorf("delivery %d has the time %s, want %s", i, delivery.ID.Time(), want)
+		}
+	}
+
+	if webhookDeliveryID("event", webhook.ID, timestamp) == webhookDeliveryID("event", other.ID, timestamp) {
+		t.Errorf("deliveries of an event to two webhooks share their id")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, webhookMinBackoff},
+		{1, 2 * webhookMinBackoff},
+		{4, 16 * webhookMinBackoff},
+		{webhookMaxAttempts, webhookMaxBackoff},
+	}
+
+	for _, test := range tests {
+		if backoff := webhookBackoff(test.attempts); backoff != test.want {
+			t.Errorf("webhookBackoff(%d) = %s, want %s", test.attempts, backoff, test.want)
+		}
+	}
+}
+
+func TestWebhookRelayDeliversConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+
+	received := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer fast.Close()
+
+	relay, webhook := newWebhookTestRelay(t, slow.URL)
+	other := &Webhook{ID: bson.NewObjectId(), URL: fast.URL, Secret: "secret", Active: true, Events: []string{"FastEvent"}}
+	if err := relay.manager.Library.Storage.C(backend.WebhookCollection).Insert(other); err != nil {
+		t.Fatalf("Insert returned an error: %v", err)
+	}
+
+	// The delivery to the slow webhook comes first.
+	timestamp := time.Now()
+	if err := relay.enqueue("SlowEvent", "slow", timestamp, []byte("{}")); err != nil {
+		t.Fatalf("enqueue returned an error: %v", err)
+	}
+	if err := relay.enqueue("FastEvent", "fast", timestamp.Add(time.Second), []byte("{}")); err != nil {
+		t.Fatalf("enqueue returned an error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		relay.deliverPending()
+		close(done)
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatalf("the fast webhook waited for the slow one")
+	}
+
+	close(release)
+	<-done
+
+	for _, delivery := range webhookDeliveries(t, relay) {
+		if delivery.Status != WebhookDeliveryDelivered {
+			t.Errorf("delivery of %s to %s is %s, want %s", delivery.EventID, delivery.WebhookID.Hex(), delivery.Status, WebhookDeliveryDelivered)
+		}
+	}
+
+	if deliveries := webhookDeliveries(t, relay); len(deliveries) != 3 || deliveries[0].WebhookID != webhook.ID {
+		t.Errorf("relay holds %d deliveries, want the slow delivery first and one per webhook of the fast event", len(deliveries))
+	}
+}
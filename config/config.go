@@ -38,6 +38,11 @@ type Config struct {
 			From    string `json:"from"`
 			Subject string `json:"subject"`
 		} `json:"register"`
+
+		Locked struct {
+			From    string `json:"from"`
+			Subject string `json:"subject"`
+		} `json:"locked"`
 	} `json:"smtp"`
 }
 
@@ -15,6 +15,10 @@ import (
 // Config represents a configuration for a Server instance
 type Config struct {
 	Address string `json:"address"`
+	// TrustedProxies are the CIDR blocks or addresses of the proxies whose X-Forwarded-For header is trusted
+	// to find the address of the clients, it is used to check the networks of internal tokens, throttle the
+	// login attempts and record where tokens were issued.
+	TrustedProxies []string `json:"trustedProxies"`
 }
 
 // Server represents a HTTP server
@@ -60,6 +64,13 @@ func New(config Config, lib *api.Library) *Server {
 		})
 	})
 
+	// Enforce the networks and scopes of internal tokens.
+	router.Use(routes.InternalTokenGuard(lib, config.TrustedProxies))
+
+	// Throttle the login attempts and email the users whose account gets locked.
+	router.Use(routes.LoginGuard(lib, config.TrustedProxies))
+	routes.LoginNotifications(lib)
+
 	// Add the "GET /" route.
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte("{}"))
@@ -88,6 +99,31 @@ func New(config Config, lib *api.Library) *Server {
 	routes.UserCreate(router, lib)
 	// Add the "PUT /user/{id}" route.
 	routes.UserUpdate(router, lib)
+	// Add the "GET /user/{id}/lock" route.
+	routes.UserLock(router, lib)
+	// Add the "DELETE /user/{id}/lock" route.
+	routes.UserUnlock(router, lib)
+
+	// Add the "POST /user/login/2fa" route.
+	routes.TwoFactorLogin(router, lib, config.TrustedProxies)
+	// Add the "POST /user/login/2fa/enroll" route.
+	routes.TwoFactorLoginEnroll(router, lib)
+	// Add the "GET /user/2fa" route.
+	routes.TwoFactor(router, lib)
+	// Add the "POST /user/2fa/enroll" route.
+	routes.TwoFactorEnroll(router, lib)
+	// Add the "POST /user/2fa/enable" route.
+	routes.TwoFactorEnable(router, lib, config.TrustedProxies)
+	// Add the "DELETE /user/2fa" route.
+	routes.TwoFactorDisable(router, lib, config.TrustedProxies)
+	// Add the "POST /user/2fa/recovery" route.
+	routes.TwoFactorRecovery(router, lib, config.TrustedProxies)
+	// Add the "GET /user/{id}/2fa" route.
+	routes.UserTwoFactor(router, lib)
+	// Add the "DELETE /user/{id}/2fa" route.
+	routes.UserTwoFactorReset(router, lib, config.TrustedProxies)
+	// Add the "GET /user/{id}/2fa/audit" route.
+	routes.UserTwoFactorAudit(router, lib)
 
 	// Add the "GET /token" route.
 	routes.Token(router, lib)
@@ -95,6 +131,57 @@ func New(config Config, lib *api.Library) *Server {
 	routes.TokenGet(router, lib)
 	// Add the "DELETE /token/{id}" route.
 	routes.TokenDelete(router, lib)
+	// Add the "POST /token/refresh" route.
+	routes.TokenRefresh(router, lib, config.TrustedProxies)
+	// Add the "POST /token/logout" route.
+	routes.TokenLogout(router, lib)
+	// Add the "DELETE /token/user/{id}" route.
+	routes.TokenRevokeAll(router, lib)
+	// Add the "GET /.well-known/jwks.json" route.
+	routes.JWKS(router, lib)
+
+	// Add the "POST /internal-token/{id}/rotate" route.
+	routes.InternalTokenRotate(router, lib)
+	// Add the "GET /internal-token/{id}/usage" route.
+	routes.InternalTokenUsage(router, lib)
+
+	// Add the "GET /session" route.
+	routes.Session(router, lib)
+	// Add the "PUT /session/{id}" route.
+	routes.SessionRename(router, lib)
+	// Add the "DELETE /session/{id}" route.
+	routes.SessionRevoke(router, lib)
+	// Add the "DELETE /session" route.
+	routes.SessionRevokeOthers(router, lib)
+
+	// Add the "GET /.well-known/openid-configuration" route.
+	routes.OAuthDiscovery(router, lib)
+	// Add the "GET /oauth/authorize" route.
+	routes.OAuthAuthorize(router, lib)
+	// Add the "POST /oauth/authorize" route.
+	routes.OAuthAuthorizeDecision(router, lib)
+	// Add the "POST /oauth/token" route.
+	routes.OAuthToken(router, lib, config.TrustedProxies)
+	// Add the "GET /oauth/userinfo" route.
+	routes.OAuthUserInfo(router, lib)
+	// Add the "POST /oauth/revoke" route.
+	routes.OAuthRevoke(router, lib)
+	// Add the "GET /oauth/consent" route.
+	routes.OAuthConsent(router, lib)
+	// Add the "DELETE /oauth/consent/{client}" route.
+	routes.OAuthConsentRevoke(router, lib)
+	// Add the "GET /oauth/client" route.
+	routes.OAuthClient(router, lib)
+	// Add the "GET /oauth/client/{id}" route.
+	routes.OAuthClientID(router, lib)
+	// Add the "POST /oauth/client" route.
+	routes.OAuthClientCreate(router, lib)
+	// Add the "PUT /oauth/client/{id}" route.
+	routes.OAuthClientUpdate(router, lib)
+	// Add the "DELETE /oauth/client/{id}" route.
+	routes.OAuthClientDelete(router, lib)
+	// Add the "POST /oauth/client/{id}/secret" route.
+	routes.OAuthClientSecret(router, lib)
 
 	// Add the "GET /group" route.
 	routes.Group(router, lib)
@@ -107,11 +194,35 @@ func New(config Config, lib *api.Library) *Server {
 	// Add the "DELETE /group/{id}" route.
 	routes.GroupDelete(router, lib)
 
+	// Add the "GET /punishment" route.
+	routes.Punishment(router, lib)
 	// Add the "GET /punishment/{id}" route.
 	routes.PunishmentID(router, lib)
 	// Add the "POST /punishment" route.
 	routes.PunishmentCreate(router, lib)
 
+	// Add the "GET /event/stream" route.
+	routes.EventStream(router, lib)
+	// Add the "GET /event/socket" route.
+	routes.EventSocket(router, lib)
+
+	// Add the "GET /webhook" route.
+	routes.Webhook(router, lib)
+	// Add the "GET /webhook/delivery" route.
+	routes.WebhookDeliveries(router, lib)
+	// Add the "GET /webhook/delivery/{id}" route.
+	routes.WebhookDeliveryID(router, lib)
+	// Add the "POST /webhook/delivery/{id}/redeliver" route.
+	routes.WebhookRedeliver(router, lib)
+	// Add the "GET /webhook/{id}" route.
+	routes.WebhookID(router, lib)
+	// Add the "POST /webhook" route.
+	routes.WebhookCreate(router, lib)
+	// Add the "PUT /webhook/{id}" route.
+	routes.WebhookUpdate(router, lib)
+	// Add the "DELETE /webhook/{id}" route.
+	routes.WebhookDelete(router, lib)
+
 	// Return a new Server instance.
 	return &Server{
 		Config:  config,
@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"errors"
+	"api"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// errMissingToken is returned by authenticate when the request has no Authorization header.
+var errMissingToken = errors.New("missing authorization token")
+
+// principal represents the authenticated caller of a request, either a user's Token or an InternalToken.
+type principal struct {
+	Token         *api.Token
+	InternalToken *api.InternalToken
+}
+
+// permissions returns the permissions granted to the principal.
+func (p *principal) permissions() map[string]bool {
+	if p.Token != nil {
+		return p.Token.Permissions
+	}
+
+	return p.InternalToken.Permissions
+}
+
+// hasPermission returns true if the principal has the specified permission.
+func (p *principal) hasPermission(permission string) bool {
+	permissions := p.permissions()
+	return permissions["root"] || permissions[permission]
+}
+
+// missingPermission returns the first of a set of permissions the principal doesn't have, it is empty if the
+// principal has all of them. Principals can't grant permissions they don't have.
+func (p *principal) missingPermission(permissions map[string]bool) string {
+	names := make([]string, 0, len(permissions))
+	for permission, granted := range permissions {
+		if granted && !p.hasPermission(permission) {
+			names = append(names, permission)
+		}
+	}
+
+	if len(names) < 1 {
+		return ""
+	}
+
+	sort.Strings(names)
+	return names[0]
+}
+
+// id returns the id of the user behind the principal, or of the internal token.
+func (p *principal) id() string {
+	if p.Token != nil {
+		return p.Token.User.Hex()
+	}
+
+	return p.InternalToken.ID.Hex()
+}
+
+// bearerToken returns the raw token of the request's Authorization header.
+func bearerToken(r *http.Request) string {
+	return strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
+
+// authenticate converts the request's Authorization header into a principal, unless InternalTokenGuard already did.
+func authenticate(r *http.Request, lib *api.Library) (*principal, error) {
+	if p, ok := r.Context().Value(principalContextKey{}).(*principal); ok {
+		return p, nil
+	}
+
+	return authenticateJWT(r, lib, bearerToken(r))
+}
+
+// authenticateJWT converts an access token or an internal token into a principal.
+func authenticateJWT(r *http.Request, lib *api.Library, rawJwt string) (*principal, error) {
+	if len(rawJwt) < 1 {
+		return nil, errMissingToken
+	}
+
+	token, err := lib.Token.FromJWT(r.Context(), rawJwt)
+	if err == nil {
+		return &principal{Token: token}, nil
+	}
+
+	internalToken, internalErr := lib.InternalToken.FromJWT(r.Context(), rawJwt)
+	if internalErr == nil {
+		return &principal{InternalToken: internalToken}, nil
+	}
+
+	return nil, err
+}
+
+// requirePermission authenticates the request and writes an error response if the principal is missing the permission.
+func requirePermission(w http.ResponseWriter, r *http.Request, lib *api.Library, permission string) (*principal, bool) {
+	p, err := authenticate(r, lib)
+	if err != nil {
+		respondMessage(w, http.StatusUnauthorized, "invalid authorization token")
+		return nil, false
+	}
+
+	if !p.hasPermission(permission) {
+		respondMessage(w, http.StatusForbidden, "missing permission")
+		return nil, false
+	}
+
+	return p, true
+}
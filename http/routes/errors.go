@@ -0,0 +1,82 @@
+package routes
+
+import (
+	"errors"
+	"api"
+	"api/logger"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// statusForError returns the HTTP status code that represents an error returned by the api library.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, api.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, api.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, api.ErrInvalidID), errors.Is(err, api.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, api.ErrRejected), errors.Is(err, api.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, api.ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, api.ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	}
+
+	return http.StatusInternalServerError
+}
+
+// publicMessages are the messages sent for each kind of error, the errors themselves can hold the operation
+// that failed and the text of backend errors.
+var publicMessages = map[error]string{
+	api.ErrNotFound:        "not found",
+	api.ErrConflict:        "conflict",
+	api.ErrInvalidID:       "invalid id",
+	api.ErrValidation:      "validation failed",
+	api.ErrRejected:        "rejected",
+	api.ErrForbidden:       "forbidden",
+	api.ErrInvalidToken:    "invalid token",
+	api.ErrTooManyAttempts: "too many attempts",
+}
+
+// publicMessage returns the message of an error that can be sent to the client. Only the messages written
+// by the services for invalid input, and the reasons of rejected writes, are sent as is.
+func publicMessage(err error) string {
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) && apiErr.Err != nil {
+		switch apiErr.Kind {
+		case api.ErrValidation, api.ErrInvalidID, api.ErrRejected:
+			return apiErr.Err.Error()
+		}
+	}
+
+	for kind, message := range publicMessages {
+		if errors.Is(err, kind) {
+			return message
+		}
+	}
+
+	return http.StatusText(statusForError(err))
+}
+
+// respondError writes an error returned by the api library as a JSON response.
+func respondError(w http.ResponseWriter, err error) {
+	status := statusForError(err)
+
+	// Don't leak backend errors to the client.
+	message := publicMessage(err)
+	if status == http.StatusInternalServerError {
+		logger.Errorw("[HTTP] Unexpected error returned by the api library.", logger.Err(err))
+		message = http.StatusText(status)
+	}
+
+	var retryErr *api.RetryError
+	if errors.As(err, &retryErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryErr.RetryAfter.Seconds()))))
+	}
+
+	respondMessage(w, status, message)
+}
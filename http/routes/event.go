@@ -0,0 +1,314 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
+	"api"
+	"api/logger"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Event stream settings.
+const (
+	eventStreamHeartbeat    = 15 * time.Second
+	eventStreamWriteTimeout = 10 * time.Second
+)
+
+// eventResetType is sent instead of the missed events when a client can't resume from its last event id.
+const eventResetType = "reset"
+
+// eventPermissions maps event type prefixes to the permission required to receive the events.
+var eventPermissions = map[string]string{
+	"group_":          "group.list",
+	"internal_token_": "internal_token.list",
+	"punishment_":     "punishment.list",
+	"ticket_":         "ticket.list",
+	"token_":          "token.list",
+	"user_":           "user.list",
+}
+
+// eventSocketUpgrader upgrades "GET /event/socket" requests, the CORS policy already allows every origin.
+var eventSocketUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// eventStreamFilter decides which events are sent to a client.
+type eventStreamFilter struct {
+	principal *principal
+	types     []string
+	entities  map[string]bool
+}
+
+// newEventStreamFilter reads the "type" and "entity" query parameters, both accept comma separated values
+// and types accept patterns such as "user_*".
+func newEventStreamFilter(r *http.Request, p *principal) *eventStreamFilter {
+	query := r.URL.Query()
+	filter := &eventStreamFilter{principal: p}
+
+	for _, eventType := range strings.Split(query.Get("type"), ",") {
+		if eventType = strings.TrimSpace(eventType); len(eventType) > 0 {
+			filter.types = append(filter.types, eventType)
+		}
+	}
+
+	for _, entity := range strings.Split(query.Get("entity"), ",") {
+		if entity = strings.TrimSpace(entity); len(entity) > 0 {
+			if filter.entities == nil {
+				filter.entities = map[string]bool{}
+			}
+			filter.entities[entity] = true
+		}
+	}
+
+	return filter
+}
+
+// allows returns true if the event matches the filter and the principal may see it.
+func (filter *eventStreamFilter) allows(event api.FeedEvent) bool {
+	if !filter.principal.hasPermission(eventPermission(event.Type)) {
+		return false
+	}
+
+	if filter.entities != nil && !filter.entities[event.EntityID()] {
+		return false
+	}
+
+	if len(filter.types) < 1 {
+		return true
+	}
+
+	for _, pattern := range filter.types {
+		if ok, _ := path.Match(pattern, event.Type); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// eventPermission returns the permission required to receive events of a type, events without a known
+// prefix require the "root" permission.
+func eventPermission(eventType string) string {
+	permission, length := "root", 0
+	for prefix, prefixPermission := range eventPermissions {
+		if strings.HasPrefix(eventType, prefix) && len(prefix) > length {
+			permission, length = prefixPermission, len(prefix)
+		}
+	}
+
+	return permission
+}
+
+// streamToken returns the token of a stream request, browsers can't set headers on EventSource and WebSocket
+// requests so the token can be passed using the "token" query parameter.
+func streamToken(r *http.Request) string {
+	if token := bearerToken(r); len(token) > 0 {
+		return token
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// requireStreamPermission authenticates a stream request with its token, see streamToken.
+func requireStreamPermission(w http.ResponseWriter, r *http.Request, lib *api.Library) (*principal, bool) {
+	if token := r.URL.Query().Get("token"); len(bearerToken(r)) < 1 && len(token) > 0 {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return requirePermission(w, r, lib, "event.stream")
+}
+
+// revalidateStream authenticates the token of an open stream again, it returns false once the token expired,
+// was revoked or lost the "event.stream" permission. The filter gets the current permissions of the token.
+func revalidateStream(r *http.Request, lib *api.Library, filter *eventStreamFilter) bool {
+	p, err := authenticateJWT(r, lib, streamToken(r))
+	if err != nil || !p.hasPermission("event.stream") {
+		return false
+	}
+
+	filter.principal = p
+	return true
+}
+
+// lastEventID returns the id of the last event a client received.
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); len(id) > 0 {
+		return id
+	}
+
+	return r.URL.Query().Get("lastEventId")
+}
+
+// EventStream adds the "GET /event/stream" route, it streams events using Server-Sent Events. The token is
+// checked again with every heartbeat, the stream ends once it is no longer valid.
+func EventStream(router *chi.Mux, lib *api.Library) {
+	router.Get("/event/stream", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireStreamPermission(w, r, lib)
+		if !ok {
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			respondMessage(w, http.StatusInternalServerError, "streaming is not supported")
+			return
+		}
+
+		filter := newEventStreamFilter(r, p)
+		listener, missed, resumed := lib.EventManager.Listen(lastEventID(r))
+		defer listener.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if !resumed {
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventResetType)
+		}
+
+		for _, event := range missed {
+			writeServerSentEvent(w, filter, event)
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(eventStreamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-heartbeat.C:
+				if !revalidateStream(r, lib, filter) {
+					return
+				}
+
+				fmt.Fprint(w, ": heartbeat\n\n")
+
+			case event, ok := <-listener.Events():
+				// The client fell behind, it can resume using the Last-Event-ID header.
+				if !ok {
+					return
+				}
+
+				writeServerSentEvent(w, filter, event)
+			}
+
+			flusher.Flush()
+		}
+	})
+}
+
+// writeServerSentEvent writes an event if it passes the filter.
+func writeServerSentEvent(w http.ResponseWriter, filter *eventStreamFilter, event api.FeedEvent) {
+	if !filter.allows(event) {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorw("[HTTP] Failed to json#Marshal streamed event.", logger.Err(err))
+		return
+	}
+
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
+
+// EventSocket adds the "GET /event/socket" route, it streams events as JSON WebSocket messages.
+//
+// Clients resume using the "lastEventId" query parameter, a message with the "reset" type is sent if the
+// missed events are no longer available. The token is checked again with every heartbeat, the socket is
+// closed with the policy violation code once it is no longer valid.
+func EventSocket(router *chi.Mux, lib *api.Library) {
+	router.Get("/event/socket", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireStreamPermission(w, r, lib)
+		if !ok {
+			return
+		}
+
+		conn, err := eventSocketUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader already wrote an error response.
+			return
+		}
+		defer conn.Close()
+
+		filter := newEventStreamFilter(r, p)
+		listener, missed, resumed := lib.EventManager.Listen(lastEventID(r))
+		defer listener.Close()
+
+		// Read the connection so control frames are handled, the client doesn't send anything else.
+		closed := make(chan struct{})
+		conn.SetReadDeadline(time.Now().Add(2 * eventStreamHeartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * eventStreamHeartbeat))
+		})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		if !resumed && writeSocketMessage(conn, api.FeedEvent{Type: eventResetType, Timestamp: time.Now()}) != nil {
+			return
+		}
+
+		for _, event := range missed {
+			if filter.allows(event) && writeSocketMessage(conn, event) != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(eventStreamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+
+			case <-heartbeat.C:
+				if !revalidateStream(r, lib, filter) {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token is no longer valid"), time.Now().Add(eventStreamWriteTimeout))
+					return
+				}
+
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventStreamWriteTimeout))
+
+			case event, ok := <-listener.Events():
+				if !ok {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind"), time.Now().Add(eventStreamWriteTimeout))
+					return
+				}
+
+				if filter.allows(event) {
+					err = writeSocketMessage(conn, event)
+				}
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// writeSocketMessage writes an event as a JSON message.
+func writeSocketMessage(conn *websocket.Conn, event api.FeedEvent) error {
+	conn.SetWriteDeadline(time.Now().Add(eventStreamWriteTimeout))
+	return conn.WriteJSON(event)
+}
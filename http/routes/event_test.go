@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"context"
+	"github.com/globalsign/mgo/bson"
+	"api"
+	"api/apitest"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestEventStreamFilter(t *testing.T) {
+	user := &api.User{ID: bson.NewObjectId()}
+	userEvent := api.FeedEvent{ID: "1", Type: api.UserUpdateEventType, Event: &api.UserUpdateEvent{User: user}}
+	groupEvent := api.FeedEvent{ID: "2", Type: api.GroupCreateEventType, Event: &api.GroupCreateEvent{Group: &api.Group{ID: bson.NewObjectId()}}}
+	internalTokenEvent := api.FeedEvent{ID: "3", Type: api.InternalTokenCreateEventType}
+	unknownEvent := api.FeedEvent{ID: "4", Type: "custom_event"}
+
+	tests := []struct {
+		name        string
+		permissions map[string]bool
+		query       string
+		event       api.FeedEvent
+		want        bool
+	}{
+		{"permitted", map[string]bool{"user.list": true}, "", userEvent, true},
+		{"missing permission", map[string]bool{"group.list": true}, "", userEvent, false},
+		{"root", map[string]bool{"root": true}, "", userEvent, true},
+		{"longest prefix", map[string]bool{"token.list": true}, "", internalTokenEvent, false},
+		{"longest prefix permitted", map[string]bool{"internal_token.list": true}, "", internalTokenEvent, true},
+		{"unknown type", map[string]bool{"user.list": true, "group.list": true}, "", unknownEvent, false},
+		{"unknown type with root", map[string]bool{"root": true}, "", unknownEvent, true},
+		{"type", map[string]bool{"root": true}, "type=group_create", groupEvent, true},
+		{"other type", map[string]bool{"root": true}, "type=group_create", userEvent, false},
+		{"type pattern", map[string]bool{"root": true}, "type=user_*", userEvent, true},
+		{"type list", map[string]bool{"root": true}, "type=group_*,%20user_update", userEvent, true},
+		{"type without permission", map[string]bool{"group.list": true}, "type=user_*", userEvent, false},
+		{"entity", map[string]bool{"root": true}, "entity=" + user.ID.Hex(), userEvent, true},
+		{"other entity", map[string]bool{"root": true}, "entity=" + user.ID.Hex(), groupEvent, false},
+		{"entity without permission", map[string]bool{"group.list": true}, "entity=" + user.ID.Hex(), userEvent, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/event/stream?"+test.query, nil)
+			filter := newEventStreamFilter(request, &principal{Token: &api.Token{Permissions: test.permissions}})
+
+			if allowed := filter.allows(test.event); allowed != test.want {
+				t.Errorf("allows(%s) = %t, want %t", test.event.Type, allowed, test.want)
+			}
+		})
+	}
+}
+
+func TestEventStreamFilterInternalToken(t *testing.T) {
+	filter := newEventStreamFilter(
+		httptest.NewRequest(http.MethodGet, "/event/stream", nil),
+		&principal{InternalToken: &api.InternalToken{Permissions: map[string]bool{"punishment.list": true}}},
+	)
+
+	if !filter.allows(api.FeedEvent{Type: api.PunishmentCreateEventType}) {
+		t.Errorf("allows(%s) = false, want true", api.PunishmentCreateEventType)
+	}
+
+	if filter.allows(api.FeedEvent{Type: api.TicketCreateEventType}) {
+		t.Errorf("allows(%s) = true, want false", api.TicketCreateEventType)
+	}
+}
+
+func TestRevalidateStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions map[string]bool
+		revoke      bool
+		internal    bool
+		want        bool
+	}{
+		{"valid", map[string]bool{"event.stream": true, "user.list": true}, false, false, true},
+		{"revoked", map[string]bool{"event.stream": true}, true, false, false},
+		{"missing permission", map[string]bool{"user.list": true}, false, false, false},
+		{"internal token", map[string]bool{"event.stream": true}, false, true, true},
+		{"revoked internal token", map[string]bool{"event.stream": true}, true, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, _ := apitest.New(t)
+			ctx := context.Background()
+
+			var raw, id string
+			if test.internal {
+				token := lib.InternalToken.New(ctx, "test", test.permissions)
+				if err := lib.InternalToken.Create(ctx, token); err != nil {
+					t.Fatalf("Create returned an error: %v", err)
+				}
+
+				var err error
+				if raw, err = token.JWT(lib); err != nil {
+					t.Fatalf("JWT returned an error: %v", err)
+				}
+				id = token.ID.Hex()
+			} else {
+				pair, err := lib.Token.Issue(ctx, apitest.User().Create(t, lib).ID, "127.0.0.1", "test", test.permissions)
+				if err != nil {
+					t.Fatalf("Issue returned an error: %v", err)
+				}
+				raw, id = pair.AccessToken, pair.Token.ID.Hex()
+			}
+
+			if test.revoke {
+				var err error
+				if test.internal {
+					err = lib.InternalToken.Delete(ctx, id)
+				} else {
+					err = lib.Token.Delete(ctx, id)
+				}
+				if err != nil {
+					t.Fatalf("Delete returned an error: %v", err)
+				}
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/event/stream?token="+raw, nil)
+			filter := newEventStreamFilter(request, &principal{Token: &api.Token{}})
+
+			if ok := revalidateStream(request, lib, filter); ok != test.want {
+				t.Fatalf("revalidateStream() = %t, want %t", ok, test.want)
+			}
+
+			if test.want && !reflect.DeepEqual(filter.principal.permissions(), test.permissions) {
+				t.Errorf("filter permissions = %v, want %v", filter.principal.permissions(), test.permissions)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"api"
+	"api/logger"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// internalTokenDefaultGrace is how long a rotated internal token keeps working if the request doesn't say.
+const internalTokenDefaultGrace = 24 * time.Hour
+
+// principalContextKey is the context key holding the principal authenticated by InternalTokenGuard.
+type principalContextKey struct{}
+
+// internalTokenRotateBody represents the body of the "POST /internal-token/{id}/rotate" route.
+type internalTokenRotateBody struct {
+	// Grace is how many seconds the previous token keeps working.
+	Grace *int `json:"grace"`
+}
+
+// InternalTokenGuard returns a middleware enforcing the networks and scopes of internal tokens and recording
+// their uses, other requests are passed through. The principal is stored in the request's context so the
+// routes don't authenticate it again.
+//
+// Networks are checked against the address of the connection, X-Forwarded-For is only trusted when the
+// connection comes from one of the trusted proxies, as CIDR blocks or addresses.
+func InternalTokenGuard(lib *api.Library, trustedProxies []string) func(http.Handler) http.Handler {
+	proxies := parseProxies(trustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := authenticate(r, lib)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p))
+			if p.InternalToken == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			usage := api.InternalTokenUsage{
+				Token:   p.InternalToken.ID,
+				Address: clientAddress(r, proxies),
+				Method:  r.Method,
+				Route:   r.URL.Path,
+			}
+
+			err = lib.InternalToken.Authorize(r.Context(), p.InternalToken, usage.Address, r.Method, r.URL.Path)
+			if err != nil {
+				usage.Status = statusForError(err)
+				lib.InternalToken.RecordUsage(r.Context(), usage)
+
+				respondError(w, err)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			if routeContext := chi.RouteContext(r.Context()); routeContext != nil && len(routeContext.RoutePattern()) > 0 {
+				usage.Route = routeContext.RoutePattern()
+			}
+			usage.Status = ww.Status()
+			lib.InternalToken.RecordUsage(r.Context(), usage)
+		})
+	}
+}
+
+// parseProxies parses the trusted proxies, the invalid ones are logged and ignored.
+func parseProxies(trustedProxies []string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, proxy := range trustedProxies {
+		network, err := parseProxy(proxy)
+		if err != nil {
+			logger.Errorw("[HTTP] Ignoring an invalid trusted proxy.", "proxy", proxy, logger.Err(err))
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies
+}
+
+// parseProxy parses a trusted proxy, a CIDR block or an address.
+func parseProxy(proxy string) (*net.IPNet, error) {
+	if !strings.Contains(proxy, "/") {
+		if ip := net.ParseIP(proxy); ip != nil {
+			proxy += "/128"
+			if ip.To4() != nil {
+				proxy = ip.To4().String() + "/32"
+			}
+		}
+	}
+
+	_, network, err := net.ParseCIDR(proxy)
+	return network, err
+}
+
+// clientAddress returns the address of the client, the X-Forwarded-For header is followed from the right as
+// long as the addresses belong to trusted proxies.
+func clientAddress(r *http.Request, proxies []*net.IPNet) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0 && isTrustedProxy(address, proxies); i-- {
+		next := strings.TrimSpace(forwarded[i])
+		if len(next) < 1 {
+			break
+		}
+		address = next
+	}
+
+	return address
+}
+
+// isTrustedProxy returns true if an address belongs to a trusted proxy.
+func isTrustedProxy(address string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InternalTokenRotate adds the "POST /internal-token/{id}/rotate" route, it replaces an internal token and
+// returns the new token with its JWT.
+func InternalTokenRotate(router *chi.Mux, lib *api.Library) {
+	router.Post("/internal-token/{id}/rotate", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "internalToken.manage"); !ok {
+			return
+		}
+
+		var body internalTokenRotateBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		grace := internalTokenDefaultGrace
+		if body.Grace != nil {
+			grace = time.Duration(*body.Grace) * time.Second
+		}
+
+		token, err := lib.InternalToken.Rotate(r.Context(), chi.URLParam(r, "id"), grace)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		jwt, err := token.JWT(lib)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, map[string]interface{}{"internalToken": token, "jwt": jwt})
+	})
+}
+
+// InternalTokenUsage adds the "GET /internal-token/{id}/usage" route, it paginates the usage log of an
+// internal token.
+func InternalTokenUsage(router *chi.Mux, lib *api.Library) {
+	router.Get("/internal-token/{id}/usage", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "internalToken.manage"); !ok {
+			return
+		}
+
+		page, err := lib.InternalToken.Usage(r.Context(), chi.URLParam(r, "id"), pageOptions(r))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondPage(w, r, &page.Page, page)
+	})
+}
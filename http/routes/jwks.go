@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+)
+
+// JWKS adds the "GET /.well-known/jwks.json" route, it publishes the public keys verifying the tokens so other
+// services can verify them without being able to sign them. Clients should fetch it again when a token is
+// signed with an unknown key.
+func JWKS(router *chi.Mux, lib *api.Library) {
+	router.Get("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		set, err := lib.SigningKey.JWKS(r.Context())
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondJSON(w, http.StatusOK, set)
+	})
+}
@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"api"
+	"api/logger"
+	"io/ioutil"
+	"mail"
+	"net/http"
+	"time"
+)
+
+// loginMaxBodySize is how many bytes of a login request are read to find the account.
+const loginMaxBodySize = 1 << 20
+
+// loginBody represents the part of the body of the "POST /user/login" route identifying the account.
+type loginBody struct {
+	Email string `json:"email"`
+}
+
+// LoginGuard returns a middleware throttling the "POST /user/login" route with lib.Login, other requests are
+// passed through. Attempts are rejected with a 429 status while the account is locked or delayed, or while the
+// address is blocked. Responses with a 401, 403 or 404 status are recorded as failed attempts and successful
+// ones reset the attempts of the account. The other routes checking credentials, "POST /user/login/2fa" and
+// "POST /oauth/token", are throttled by their service.
+func LoginGuard(lib *api.Library, trustedProxies []string) func(http.Handler) http.Handler {
+	proxies := parseProxies(trustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != "/user/login" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, loginMaxBodySize))
+			if err != nil {
+				respondMessage(w, http.StatusBadRequest, "invalid body")
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+
+			// The route rejects malformed bodies itself, the address is still throttled without an account.
+			var body loginBody
+			_ = json.Unmarshal(raw, &body)
+			address := clientAddress(r, proxies)
+
+			err = lib.Login.Check(r.Context(), body.Email, address)
+			if err != nil {
+				respondError(w, err)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			switch status := ww.Status(); {
+			case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound:
+				err = lib.Login.Fail(r.Context(), body.Email, address)
+			case status >= 200 && status < 300 && len(body.Email) > 0:
+				err = lib.Login.Succeed(r.Context(), body.Email)
+			}
+			if err != nil {
+				logger.Errorw("[HTTP] Failed to record a login attempt.", "address", address, logger.Err(err))
+			}
+		})
+	}
+}
+
+// LoginNotifications emails the users whose account gets locked. Every instance receives the lock events, the
+// first one to claim a lock in the cache sends the email.
+func LoginNotifications(lib *api.Library) {
+	api.Register(lib.EventManager, func(lib *api.Library, lock *api.LoginLockEvent) {
+		if len(lock.User) < 1 {
+			return
+		}
+
+		duration := time.Duration(lock.Duration) * time.Second
+		claims, err := lib.Cache.Incr(fmt.Sprintf("ikuta:access:login:notified:%s", lock.User), duration)
+		if err != nil {
+			logger.Errorw("[HTTP] Failed to claim a lock notification.", "user", lock.User, logger.Err(err))
+			return
+		}
+		if claims != 1 {
+			return
+		}
+
+		go func() {
+			user, err := lib.User.GetByID(context.Background(), lock.User)
+			if err != nil {
+				logger.Errorw("[HTTP] Failed to find a locked user.", "user", lock.User, logger.Err(err))
+				return
+			}
+
+			mail.Locked(user.Email, duration)
+		}()
+	})
+}
+
+// UserLock adds the "GET /user/{id}/lock" route, it returns the failed login attempts of a user and whether
+// they are locked. It requires the "user.unlock" permission.
+func UserLock(router *chi.Mux, lib *api.Library) {
+	router.Get("/user/{id}/lock", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "user.unlock"); !ok {
+			return
+		}
+
+		status, err := lib.Login.Status(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, status)
+	})
+}
+
+// UserUnlock adds the "DELETE /user/{id}/lock" route, it unlocks a user and resets their failed login
+// attempts. It requires the "user.unlock" permission.
+func UserUnlock(router *chi.Mux, lib *api.Library) {
+	router.Delete("/user/{id}/lock", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requirePermission(w, r, lib, "user.unlock")
+		if !ok {
+			return
+		}
+
+		err := lib.Login.Unlock(r.Context(), chi.URLParam(r, "id"), p.id())
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
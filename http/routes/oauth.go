@@ -0,0 +1,467 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+	"net/url"
+)
+
+// oauthAuthorizeBody represents the body of the "POST /oauth/authorize" route, the parameters of the
+// authorization request and the decision of the user.
+type oauthAuthorizeBody struct {
+	api.OAuthRequest
+	Approve bool `json:"approve"`
+}
+
+// oauthClientBody represents the body of the "POST /oauth/client" and "PUT /oauth/client/{id}" routes.
+type oauthClientBody struct {
+	Name         string          `json:"name"`
+	Public       bool            `json:"public"`
+	RedirectURIs []string        `json:"redirectUris"`
+	GrantTypes   []string        `json:"grantTypes"`
+	Scopes       []string        `json:"scopes"`
+	Permissions  map[string]bool `json:"permissions"`
+	Trusted      bool            `json:"trusted"`
+	// Version is required by "PUT /oauth/client/{id}" to detect concurrent updates.
+	Version int `json:"version"`
+}
+
+// oauthRequest reads the parameters of an authorization request from the query string.
+func oauthRequest(r *http.Request) *api.OAuthRequest {
+	query := r.URL.Query()
+
+	return &api.OAuthRequest{
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		ResponseType:        query.Get("response_type"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+}
+
+// oauthClientCredentials returns the client_id and client_secret of a request to the token or revocation
+// endpoints, from the Authorization header or the form.
+func oauthClientCredentials(r *http.Request) (string, string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		// The credentials are form encoded before being put in the header (RFC 6749, section 2.3.1).
+		if unescaped, err := url.QueryUnescape(id); err == nil {
+			id = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(secret); err == nil {
+			secret = unescaped
+		}
+
+		return id, secret
+	}
+
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+}
+
+// respondOAuthError writes an error returned by lib.OAuth the way OAuth clients expect it, other errors are
+// written by respondError.
+func respondOAuthError(w http.ResponseWriter, err error) {
+	var oauthErr *api.OAuthError
+	if !errors.As(err, &oauthErr) {
+		respondError(w, err)
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == api.OAuthInvalidClient {
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="egirls.me"`)
+	}
+
+	// The OAuth service writes a description for every error it returns, other errors get their public message.
+	description := publicMessage(oauthErr.Err)
+	var apiErr *api.Error
+	if errors.As(oauthErr.Err, &apiErr) && apiErr.Err != nil {
+		description = apiErr.Err.Error()
+	}
+
+	respondJSON(w, status, map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": description,
+	})
+}
+
+// requireUserSession authenticates the request with a user's own token, tokens issued to OAuth clients are
+// rejected.
+func requireUserSession(w http.ResponseWriter, r *http.Request, lib *api.Library) (*api.Token, bool) {
+	token, _, ok := requireSession(w, r, lib)
+	if !ok {
+		return nil, false
+	}
+
+	if len(token.Client) > 0 {
+		respondMessage(w, http.StatusForbidden, "tokens issued to OAuth clients can't authorize clients")
+		return nil, false
+	}
+
+	return token, true
+}
+
+// OAuthAuthorize adds the "GET /oauth/authorize" route, it validates the authorization request of a client
+// for the authenticated user. The website shows the returned scopes to the user when their consent is
+// required.
+func OAuthAuthorize(router *chi.Mux, lib *api.Library) {
+	router.Get("/oauth/authorize", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := requireUserSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		authorization, err := lib.OAuth.Authorize(r.Context(), oauthRequest(r), token.User)
+		if err != nil {
+			respondOAuthError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, authorization)
+	})
+}
+
+// OAuthAuthorizeDecision adds the "POST /oauth/authorize" route, it approves or denies the authorization
+// request of a client for the authenticated user. It returns the uri the user must be redirected to.
+func OAuthAuthorizeDecision(router *chi.Mux, lib *api.Library) {
+	router.Post("/oauth/authorize", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := requireUserSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		var body oauthAuthorizeBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		var redirectURI string
+		var err error
+		if body.Approve {
+			redirectURI, err = lib.OAuth.Approve(r.Context(), &body.OAuthRequest, token.User)
+		} else {
+			redirectURI, err = lib.OAuth.Deny(r.Context(), &body.OAuthRequest, token.User)
+		}
+		if err != nil {
+			respondOAuthError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]string{"redirectUri": redirectURI})
+	})
+}
+
+// OAuthToken adds the "POST /oauth/token" route, the token endpoint of the OAuth clients. It takes a form
+// encoded body and the client credentials as HTTP basic authentication, or in the body. The address of the
+// client is found with trustedProxies.
+func OAuthToken(router *chi.Mux, lib *api.Library, trustedProxies []string) {
+	proxies := parseProxies(trustedProxies)
+
+	router.Post("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": api.OAuthInvalidRequest})
+			return
+		}
+
+		clientID, clientSecret := oauthClientCredentials(r)
+		response, err := lib.OAuth.Exchange(r.Context(), &api.OAuthTokenRequest{
+			GrantType:    r.PostForm.Get("grant_type"),
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Code:         r.PostForm.Get("code"),
+			RedirectURI:  r.PostForm.Get("redirect_uri"),
+			CodeVerifier: r.PostForm.Get("code_verifier"),
+			RefreshToken: r.PostForm.Get("refresh_token"),
+			Scope:        r.PostForm.Get("scope"),
+			Address:      clientAddress(r, proxies),
+			UserAgent:    r.UserAgent(),
+		})
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		if err != nil {
+			respondOAuthError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, response)
+	})
+}
+
+// OAuthUserInfo adds the "GET /oauth/userinfo" route, it returns the claims about the user an access token
+// was issued to.
+func OAuthUserInfo(router *chi.Mux, lib *api.Library) {
+	router.Get("/oauth/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		p, err := authenticate(r, lib)
+		if err != nil || p.Token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondMessage(w, http.StatusUnauthorized, "invalid authorization token")
+			return
+		}
+
+		claims, err := lib.OAuth.UserInfo(r.Context(), p.Token)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, claims)
+	})
+}
+
+// OAuthRevoke adds the "POST /oauth/revoke" route, it lets a client revoke one of its refresh or access tokens
+// (RFC 7009). It succeeds even if the token is unknown.
+func OAuthRevoke(router *chi.Mux, lib *api.Library) {
+	router.Post("/oauth/revoke", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": api.OAuthInvalidRequest})
+			return
+		}
+
+		clientID, clientSecret := oauthClientCredentials(r)
+		err := lib.OAuth.Revoke(r.Context(), clientID, clientSecret, r.PostForm.Get("token"))
+		if err != nil {
+			respondOAuthError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// OAuthDiscovery adds the "GET /.well-known/openid-configuration" route, the OpenID Connect discovery document.
+func OAuthDiscovery(router *chi.Mux, lib *api.Library) {
+	router.Get("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondJSON(w, http.StatusOK, lib.OAuth.Discovery(r.Context()))
+	})
+}
+
+// OAuthConsent adds the "GET /oauth/consent" route, it lists the clients the authenticated user granted
+// access to.
+func OAuthConsent(router *chi.Mux, lib *api.Library) {
+	router.Get("/oauth/consent", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := requireUserSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		consents, err := lib.OAuth.Consents(r.Context(), token.User)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, consents)
+	})
+}
+
+// OAuthConsentRevoke adds the "DELETE /oauth/consent/{client}" route, it revokes the access of a client to the
+// account of the authenticated user.
+func OAuthConsentRevoke(router *chi.Mux, lib *api.Library) {
+	router.Delete("/oauth/consent/{client}", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := requireUserSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		err := lib.OAuth.RevokeConsent(r.Context(), token.User, chi.URLParam(r, "client"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// OAuthClient adds the "GET /oauth/client" route.
+func OAuthClient(router *chi.Mux, lib *api.Library) {
+	router.Get("/oauth/client", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "oauth.manage"); !ok {
+			return
+		}
+
+		clients, err := lib.OAuthClient.List(r.Context())
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, clients)
+	})
+}
+
+// OAuthClientID adds the "GET /oauth/client/{id}" route.
+func OAuthClientID(router *chi.Mux, lib *api.Library) {
+	router.Get("/oauth/client/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "oauth.manage"); !ok {
+			return
+		}
+
+		client, err := lib.OAuthClient.GetByID(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, client)
+	})
+}
+
+// OAuthClientCreate adds the "POST /oauth/client" route, the secret of confidential clients is only returned
+// by this route and "POST /oauth/client/{id}/secret". The caller must have every permission granted to the
+// client.
+func OAuthClientCreate(router *chi.Mux, lib *api.Library) {
+	router.Post("/oauth/client", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requirePermission(w, r, lib, "oauth.manage")
+		if !ok {
+			return
+		}
+
+		var body oauthClientBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		client := lib.OAuthClient.New(r.Context(), body.Name, body.Public)
+		client.RedirectURIs = body.RedirectURIs
+		client.Trusted = body.Trusted
+		if body.GrantTypes != nil {
+			client.GrantTypes = body.GrantTypes
+		}
+		if body.Scopes != nil {
+			client.Scopes = body.Scopes
+		}
+		if body.Permissions != nil {
+			client.Permissions = body.Permissions
+		}
+
+		if !requireClientPermissions(w, p, client) {
+			return
+		}
+
+		err := lib.OAuthClient.Create(r.Context(), client)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, client)
+	})
+}
+
+// OAuthClientUpdate adds the "PUT /oauth/client/{id}" route, a client can't be made public or confidential
+// once it has been created. The caller must have every permission of the client, before and after the update.
+func OAuthClientUpdate(router *chi.Mux, lib *api.Library) {
+	router.Put("/oauth/client/{id}", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requirePermission(w, r, lib, "oauth.manage")
+		if !ok {
+			return
+		}
+
+		var body oauthClientBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		client, err := lib.OAuthClient.GetByID(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		if !requireClientPermissions(w, p, client) {
+			return
+		}
+
+		client.Name = body.Name
+		client.RedirectURIs = body.RedirectURIs
+		client.GrantTypes = body.GrantTypes
+		client.Scopes = body.Scopes
+		client.Permissions = body.Permissions
+		client.Trusted = body.Trusted
+		client.Version = body.Version
+
+		if !requireClientPermissions(w, p, client) {
+			return
+		}
+
+		err = lib.OAuthClient.Update(r.Context(), client)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, client)
+	})
+}
+
+// OAuthClientDelete adds the "DELETE /oauth/client/{id}" route.
+func OAuthClientDelete(router *chi.Mux, lib *api.Library) {
+	router.Delete("/oauth/client/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "oauth.manage"); !ok {
+			return
+		}
+
+		err := lib.OAuthClient.Delete(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// OAuthClientSecret adds the "POST /oauth/client/{id}/secret" route, it replaces the secret of a confidential
+// client and returns the client with its new secret. The previous secret stops working immediately. The caller
+// must have every permission of the client.
+func OAuthClientSecret(router *chi.Mux, lib *api.Library) {
+	router.Post("/oauth/client/{id}/secret", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requirePermission(w, r, lib, "oauth.manage")
+		if !ok {
+			return
+		}
+
+		client, err := lib.OAuthClient.GetByID(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		if !requireClientPermissions(w, p, client) {
+			return
+		}
+
+		client, err = lib.OAuthClient.RotateSecret(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, client)
+	})
+}
+
+// requireClientPermissions writes an error response if the principal is missing one of the permissions of a
+// client, the client credentials grant would give them the permission.
+func requireClientPermissions(w http.ResponseWriter, p *principal, client *api.OAuthClient) bool {
+	if permission := p.missingPermission(client.Permissions); len(permission) > 0 {
+		respondMessage(w, http.StatusForbidden, fmt.Sprintf("missing the %q permission granted to the client", permission))
+		return false
+	}
+
+	return true
+}
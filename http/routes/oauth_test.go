@@ -0,0 +1,393 @@
+package routes_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"http/routes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	oauthTestRedirectURI = "https://forums.test/callback"
+	oauthTestVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// oauthTest holds a library with a confidential client and a user who authorizes it.
+type oauthTest struct {
+	lib    *api.Library
+	router *chi.Mux
+	client *api.OAuthClient
+	user   *api.User
+}
+
+// newOAuthTest creates a library serving the OAuth routes.
+func newOAuthTest(t *testing.T) *oauthTest {
+	lib, _ := apitest.New(t, func(config *api.Config) {
+		config.OAuth.Issuer = "https://api.test/"
+	})
+	ctx := context.Background()
+
+	client := lib.OAuthClient.New(ctx, "Forums", false)
+	client.RedirectURIs = []string{oauthTestRedirectURI}
+	client.Scopes = []string{api.OAuthScopeOpenID, api.OAuthScopeProfile}
+	client.Trusted = true
+	if err := lib.OAuthClient.Create(ctx, client); err != nil {
+		t.Fatalf("failed to create the client: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(routes.InternalTokenGuard(lib, nil))
+	routes.OAuthToken(router, lib, nil)
+
+	group := apitest.Group().Create(t, lib)
+	return &oauthTest{lib: lib, router: router, client: client, user: apitest.User().Group(group).Create(t, lib)}
+}
+
+// code approves an authorization request of the client with the PKCE challenge of oauthTestVerifier.
+func (test *oauthTest) code(t *testing.T) string {
+	return test.codeFor(t, oauthTestRedirectURI)
+}
+
+// codeFor approves an authorization request like code with the specified redirect uri, which may be empty.
+func (test *oauthTest) codeFor(t *testing.T, redirectURI string) string {
+	sum := sha256.Sum256([]byte(oauthTestVerifier))
+
+	redirect, err := test.lib.OAuth.Approve(context.Background(), &api.OAuthRequest{
+		ClientID:            test.client.ID.Hex(),
+		RedirectURI:         redirectURI,
+		ResponseType:        "code",
+		Scope:               "openid profile",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}, test.user.ID)
+	if err != nil {
+		t.Fatalf("Approve returned an error: %v", err)
+	}
+
+	parsed, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("Approve returned an invalid redirect: %v", err)
+	}
+
+	return parsed.Query().Get("code")
+}
+
+// token posts a form to the token endpoint with the client's credentials.
+func (test *oauthTest) token(secret string, form url.Values) (int, map[string]interface{}, http.Header) {
+	request := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(test.client.ID.Hex(), secret)
+
+	recorder := httptest.NewRecorder()
+	test.router.ServeHTTP(recorder, request)
+
+	var body map[string]interface{}
+	_ = json.Unmarshal(recorder.Body.Bytes(), &body)
+
+	return recorder.Code, body, recorder.Header()
+}
+
+func TestOAuthTokenAuthorizationCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret func(test *oauthTest) string
+		// implicitRedirect leaves the redirect uri out of the authorization request.
+		implicitRedirect bool
+		form             func(code string) url.Values
+		status           int
+		error            string
+	}{
+		{
+			name: "valid",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {oauthTestVerifier}}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "wrong verifier",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {strings.Repeat("v", 43)}}
+			},
+			status: http.StatusBadRequest,
+			error:  api.OAuthInvalidGrant,
+		},
+		{
+			name: "missing verifier",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {oauthTestRedirectURI}}
+			},
+			status: http.StatusBadRequest,
+			error:  api.OAuthInvalidGrant,
+		},
+		{
+			name: "other redirect uri",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://evil.test/callback"}, "code_verifier": {oauthTestVerifier}}
+			},
+			status: http.StatusBadRequest,
+			error:  api.OAuthInvalidGrant,
+		},
+		{
+			name: "missing redirect uri",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {oauthTestVerifier}}
+			},
+			status: http.StatusBadRequest,
+			error:  api.OAuthInvalidGrant,
+		},
+		{
+			name:             "redirect uri left out of both requests",
+			implicitRedirect: true,
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "code_verifier": {oauthTestVerifier}}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:             "redirect uri left out of the authorization request",
+			implicitRedirect: true,
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {oauthTestVerifier}}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "unknown code",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {"unknown"}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {oauthTestVerifier}}
+			},
+			status: http.StatusBadRequest,
+			error:  api.OAuthInvalidGrant,
+		},
+		{
+			name:   "wrong secret",
+			secret: func(test *oauthTest) string { return "wrong" },
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {oauthTestVerifier}}
+			},
+			status: http.StatusUnauthorized,
+			error:  api.OAuthInvalidClient,
+		},
+		{
+			name: "unsupported grant",
+			form: func(code string) url.Values {
+				return url.Values{"grant_type": {"password"}}
+			},
+			status: http.StatusBadRequest,
+			error:  api.OAuthUnsupportedGrantType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newOAuthTest(t)
+			secret := test.client.Secret
+			if tt.secret != nil {
+				secret = tt.secret(test)
+			}
+
+			redirectURI := oauthTestRedirectURI
+			if tt.implicitRedirect {
+				redirectURI = ""
+			}
+
+			status, body, header := test.token(secret, tt.form(test.codeFor(t, redirectURI)))
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d: %v", status, tt.status, body)
+			}
+
+			if header.Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", header.Get("Cache-Control"))
+			}
+
+			if len(tt.error) > 0 {
+				if body["error"] != tt.error || body["error_description"] == "" {
+					t.Errorf("body = %v, want the %s error with a description", body, tt.error)
+				}
+				return
+			}
+
+			for _, field := range []string{"access_token", "refresh_token", "id_token"} {
+				if value, _ := body[field].(string); len(value) < 1 {
+					t.Errorf("response is missing %s: %v", field, body)
+				}
+			}
+
+			token, err := test.lib.Token.FromJWT(context.Background(), body["access_token"].(string))
+			if err != nil || token.Client != test.client.ID || token.User != test.user.ID {
+				t.Errorf("FromJWT(access_token) = %+v, %v, want a token of the client and the user", token, err)
+			}
+		})
+	}
+}
+
+func TestOAuthTokenCodeReuse(t *testing.T) {
+	test := newOAuthTest(t)
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {test.code(t)}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {oauthTestVerifier}}
+
+	status, body, _ := test.token(test.client.Secret, form)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", status, http.StatusOK, body)
+	}
+	access := body["access_token"].(string)
+
+	status, body, _ = test.token(test.client.Secret, form)
+	if status != http.StatusBadRequest || body["error"] != api.OAuthInvalidGrant {
+		t.Fatalf("reused code = %d %v, want the invalid_grant error", status, body)
+	}
+
+	// The tokens issued with a reused code are revoked.
+	if _, err := test.lib.Token.FromJWT(context.Background(), access); err == nil {
+		t.Errorf("FromJWT(access token of the reused code) succeeded")
+	}
+}
+
+func TestOAuthTokenRefresh(t *testing.T) {
+	test := newOAuthTest(t)
+
+	status, body, _ := test.token(test.client.Secret, url.Values{"grant_type": {"authorization_code"}, "code": {test.code(t)}, "redirect_uri": {oauthTestRedirectURI}, "code_verifier": {oauthTestVerifier}})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", status, http.StatusOK, body)
+	}
+	first := body["refresh_token"].(string)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		error  string
+	}{
+		{"rotation", first, http.StatusOK, ""},
+		{"reuse", first, http.StatusBadRequest, api.OAuthInvalidGrant},
+		{"unknown", "unknown", http.StatusBadRequest, api.OAuthInvalidGrant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body, _ := test.token(test.client.Secret, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tt.token}})
+			if status != tt.status || (len(tt.error) > 0 && body["error"] != tt.error) {
+				t.Errorf("refresh = %d %v, want %d %s", status, body, tt.status, tt.error)
+			}
+		})
+	}
+}
+
+func TestOAuthClientPermissions(t *testing.T) {
+	tests := []struct {
+		name string
+		// caller are the permissions of the caller besides "oauth.manage".
+		caller []string
+		// client are the permissions of the stored client, the body grants body.
+		client []string
+		method string
+		body   []string
+		status int
+	}{
+		{"create with a held permission", []string{"user.list"}, nil, http.MethodPost, []string{"user.list"}, http.StatusCreated},
+		{"create with another permission", []string{"user.list"}, nil, http.MethodPost, []string{"punishment.list"}, http.StatusForbidden},
+		{"create granting root", []string{"user.list"}, nil, http.MethodPost, []string{"root"}, http.StatusForbidden},
+		{"create as root", []string{"root"}, nil, http.MethodPost, []string{"root"}, http.StatusCreated},
+		{"update with a held permission", []string{"user.list"}, []string{"user.list"}, http.MethodPut, []string{"user.list"}, http.StatusOK},
+		{"update granting another permission", []string{"user.list"}, []string{"user.list"}, http.MethodPut, []string{"punishment.list"}, http.StatusForbidden},
+		{"update of a client with another permission", []string{"user.list"}, []string{"punishment.list"}, http.MethodPut, nil, http.StatusForbidden},
+		{"secret of a client with a held permission", []string{"user.list"}, []string{"user.list"}, "secret", nil, http.StatusOK},
+		{"secret of a client with another permission", []string{"user.list"}, []string{"root"}, "secret", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lib, _ := apitest.New(t)
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+
+			permissions := map[string]bool{"oauth.manage": true}
+			for _, permission := range tt.caller {
+				permissions[permission] = true
+			}
+
+			pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", permissions)
+			if err != nil {
+				t.Fatalf("Issue returned an error: %v", err)
+			}
+
+			client := lib.OAuthClient.New(ctx, "Bot", false)
+			client.GrantTypes = []string{api.OAuthGrantClientCredentials}
+			for _, permission := range tt.client {
+				client.Permissions[permission] = true
+			}
+			if err = lib.OAuthClient.Create(ctx, client); err != nil {
+				t.Fatalf("failed to create the client: %v", err)
+			}
+
+			body := map[string]interface{}{"name": "Bot", "grantTypes": client.GrantTypes, "version": client.Version, "permissions": map[string]bool{}}
+			for _, permission := range tt.body {
+				body["permissions"].(map[string]bool)[permission] = true
+			}
+			raw, _ := json.Marshal(body)
+
+			router := chi.NewRouter()
+			routes.OAuthClientCreate(router, lib)
+			routes.OAuthClientUpdate(router, lib)
+			routes.OAuthClientSecret(router, lib)
+
+			var request *http.Request
+			switch tt.method {
+			case http.MethodPost:
+				request = httptest.NewRequest(http.MethodPost, "/oauth/client", strings.NewReader(string(raw)))
+			case http.MethodPut:
+				request = httptest.NewRequest(http.MethodPut, "/oauth/client/"+client.ID.Hex(), strings.NewReader(string(raw)))
+			default:
+				request = httptest.NewRequest(http.MethodPost, "/oauth/client/"+client.ID.Hex()+"/secret", nil)
+			}
+			request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.status, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestOAuthTokenClientCredentials(t *testing.T) {
+	test := newOAuthTest(t)
+	ctx := context.Background()
+
+	client := test.lib.OAuthClient.New(ctx, "Bot", false)
+	client.GrantTypes = []string{api.OAuthGrantClientCredentials}
+	client.Permissions = map[string]bool{"user.list": true, "group.list": true}
+	if err := test.lib.OAuthClient.Create(ctx, client); err != nil {
+		t.Fatalf("failed to create the client: %v", err)
+	}
+	test.client = client
+
+	status, body, _ := test.token(client.Secret, url.Values{"grant_type": {"client_credentials"}, "scope": {"user.list"}})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", status, http.StatusOK, body)
+	}
+
+	token, err := test.lib.InternalToken.FromJWT(ctx, body["access_token"].(string))
+	if err != nil {
+		t.Fatalf("FromJWT returned an error: %v", err)
+	}
+
+	if !token.Permissions["user.list"] || token.Permissions["group.list"] || token.ExpiresAt.IsZero() {
+		t.Errorf("token = %+v, want an expiring token granted the requested scope", token)
+	}
+
+	// The tokens of the grant aren't stored.
+	if count, err := test.lib.InternalToken.Count(ctx, api.InternalTokenFilter{}); err != nil || count != 0 {
+		t.Errorf("Count() = %d, %v, want no stored internal token", count, err)
+	}
+}
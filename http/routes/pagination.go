@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"fmt"
+	"api"
+	"net/http"
+	"strconv"
+)
+
+// pageOptions reads the "cursor", "limit", "sort", "order" and "total" query parameters of a list route.
+func pageOptions(r *http.Request) api.PageOptions {
+	query := r.URL.Query()
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	return api.PageOptions{
+		Cursor:     query.Get("cursor"),
+		Limit:      limit,
+		SortBy:     query.Get("sort"),
+		Descending: query.Get("order") == "desc",
+		Total:      query.Get("total") == "true",
+	}
+}
+
+// respondPage writes a page envelope, pointing to the next page using the Link header.
+func respondPage(w http.ResponseWriter, r *http.Request, page *api.Page, envelope interface{}) {
+	if len(page.NextCursor) > 0 {
+		next := *r.URL
+		query := next.Query()
+		query.Set("cursor", page.NextCursor)
+		next.RawQuery = query.Encode()
+
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
+
+	respondJSON(w, http.StatusOK, envelope)
+}
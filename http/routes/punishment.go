@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+	"strconv"
+)
+
+// Punishment adds the "GET /punishment" route.
+func Punishment(router *chi.Mux, lib *api.Library) {
+	router.Get("/punishment", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "punishment.list"); !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		filter := api.PunishmentFilter{
+			Address: query.Get("address"),
+			Type:    query.Get("type"),
+			Server:  query.Get("server"),
+		}
+
+		if userID := query.Get("user"); len(userID) > 0 {
+			if !bson.IsObjectIdHex(userID) {
+				respondError(w, api.ErrInvalidID)
+				return
+			}
+
+			filter.UserID = bson.ObjectIdHex(userID)
+		}
+
+		if active, err := strconv.ParseBool(query.Get("active")); err == nil {
+			filter.Active = &active
+		}
+
+		page, err := lib.Punishment.Paginate(r.Context(), pageOptions(r), filter)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondPage(w, r, &page.Page, page)
+	})
+}
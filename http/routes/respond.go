@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"encoding/json"
+	"api/logger"
+	"net/http"
+)
+
+// respondJSON writes a value as a JSON response.
+func respondJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		logger.Errorw("[HTTP] Failed to write response.", logger.Err(err))
+	}
+}
+
+// respondMessage writes an error message as a JSON response.
+func respondMessage(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, status, map[string]string{"error": message})
+}
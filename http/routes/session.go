@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+)
+
+// sessionBody represents the body of the "PUT /session/{id}" route.
+type sessionBody struct {
+	Name string `json:"name"`
+}
+
+// requireSession authenticates the request with a user's token, it returns the token and a context marking
+// it as the current session. Internal tokens have no sessions and are rejected.
+func requireSession(w http.ResponseWriter, r *http.Request, lib *api.Library) (*api.Token, context.Context, bool) {
+	p, err := authenticate(r, lib)
+	if err != nil {
+		respondMessage(w, http.StatusUnauthorized, "invalid authorization token")
+		return nil, nil, false
+	}
+
+	if p.Token == nil {
+		respondMessage(w, http.StatusForbidden, "sessions belong to users")
+		return nil, nil, false
+	}
+
+	return p.Token, api.WithCurrentSession(r.Context(), p.Token.ID), true
+}
+
+// Session adds the "GET /session" route, it lists the sessions of the authenticated user.
+func Session(router *chi.Mux, lib *api.Library) {
+	router.Get("/session", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		sessions, err := lib.Session.List(ctx, token.User)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, sessions)
+	})
+}
+
+// SessionRename adds the "PUT /session/{id}" route, it names a session of the authenticated user.
+func SessionRename(router *chi.Mux, lib *api.Library) {
+	router.Put("/session/{id}", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		var body sessionBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err := lib.Session.Rename(ctx, token.User, chi.URLParam(r, "id"), body.Name)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// SessionRevoke adds the "DELETE /session/{id}" route, it revokes a session of the authenticated user.
+func SessionRevoke(router *chi.Mux, lib *api.Library) {
+	router.Delete("/session/{id}", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		err := lib.Session.Revoke(ctx, token.User, chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// SessionRevokeOthers adds the "DELETE /session" route, it revokes every session of the authenticated user
+// except the one the request was made with.
+func SessionRevokeOthers(router *chi.Mux, lib *api.Library) {
+	router.Delete("/session", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		revoked, err := lib.Session.RevokeOthers(ctx, token.User)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]int{"revoked": revoked})
+	})
+}
@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+)
+
+// refreshTokenBody represents the body of the "POST /token/refresh" and "POST /token/logout" routes.
+type refreshTokenBody struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// TokenRefresh adds the "POST /token/refresh" route, it exchanges a refresh token for a new access token and
+// the next refresh token. The address of the client is found with trustedProxies.
+func TokenRefresh(router *chi.Mux, lib *api.Library, trustedProxies []string) {
+	proxies := parseProxies(trustedProxies)
+
+	router.Post("/token/refresh", func(w http.ResponseWriter, r *http.Request) {
+		var body refreshTokenBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		pair, err := lib.Token.Refresh(r.Context(), body.RefreshToken, clientAddress(r, proxies), r.UserAgent())
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, pair)
+	})
+}
+
+// TokenLogout adds the "POST /token/logout" route, it revokes a refresh token and every token issued with it.
+func TokenLogout(router *chi.Mux, lib *api.Library) {
+	router.Post("/token/logout", func(w http.ResponseWriter, r *http.Request) {
+		var body refreshTokenBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err := lib.Token.Logout(r.Context(), body.RefreshToken)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
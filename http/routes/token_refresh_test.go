@@ -0,0 +1,70 @@
+package routes_test
+
+import (
+	"api"
+	"api/apitest"
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"http/routes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenRefreshAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   []string
+		forwarded string
+		want      string
+	}{
+		{"direct", nil, "", "203.0.113.7"},
+		{"untrusted forwarded header", nil, "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", []string{"203.0.113.0/24"}, "198.51.100.1", "198.51.100.1"},
+		{"spoofed through a trusted proxy", []string{"203.0.113.7"}, "192.0.2.1, 198.51.100.1", "198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lib, _ := apitest.New(t)
+			ctx := context.Background()
+			user := apitest.User().Create(t, lib)
+
+			pair, err := lib.Token.Issue(ctx, user.ID, "127.0.0.1", "test", map[string]bool{})
+			if err != nil {
+				t.Fatalf("Issue returned an error: %v", err)
+			}
+
+			router := chi.NewRouter()
+			routes.TokenRefresh(router, lib, test.proxies)
+
+			request := httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(`{"refreshToken":"`+pair.RefreshToken+`"}`))
+			request.RemoteAddr = "203.0.113.7:52000"
+			if len(test.forwarded) > 0 {
+				request.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+			}
+
+			var refreshed api.TokenPair
+			if err = json.Unmarshal(recorder.Body.Bytes(), &refreshed); err != nil {
+				t.Fatalf("failed to decode the response: %v", err)
+			}
+
+			token, err := lib.Token.FromJWT(ctx, refreshed.AccessToken)
+			if err != nil {
+				t.Fatalf("FromJWT returned an error: %v", err)
+			}
+
+			if token.Address != test.want {
+				t.Errorf("token issued to %q, want %q", token.Address, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+)
+
+// TokenRevokeAll adds the "DELETE /token/user/{id}" route, it revokes every token of a user. Users can revoke
+// their own tokens, revoking the tokens of another user requires the "token.delete" permission.
+func TokenRevokeAll(router *chi.Mux, lib *api.Library) {
+	router.Delete("/token/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if !bson.IsObjectIdHex(id) {
+			respondMessage(w, http.StatusBadRequest, "invalid user id")
+			return
+		}
+		user := bson.ObjectIdHex(id)
+
+		p, err := authenticate(r, lib)
+		if err != nil {
+			respondMessage(w, http.StatusUnauthorized, "invalid authorization token")
+			return
+		}
+
+		ownTokens := p.Token != nil && p.Token.User == user
+		if !ownTokens && !p.hasPermission("token.delete") {
+			respondMessage(w, http.StatusForbidden, "missing permission")
+			return
+		}
+
+		revoked, err := lib.Token.RevokeAll(r.Context(), user)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]int{"revoked": revoked})
+	})
+}
@@ -0,0 +1,247 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+)
+
+// twoFactorBody represents the body of the routes asking for a two-factor code, or a recovery code.
+type twoFactorBody struct {
+	Code string `json:"code"`
+}
+
+// decodeTwoFactorBody decodes the body of a request asking for a two-factor code and writes an error response
+// if it is invalid.
+func decodeTwoFactorBody(w http.ResponseWriter, r *http.Request) (*twoFactorBody, bool) {
+	var body twoFactorBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Code) < 1 {
+		respondMessage(w, http.StatusBadRequest, "invalid request body")
+		return nil, false
+	}
+
+	return &body, true
+}
+
+// TwoFactorLogin adds the "POST /user/login/2fa" route, it completes a two-factor login with the challenge
+// JWT returned by the login as the bearer token. It returns the tokens of the login, along with the recovery
+// codes if the login enrolled the user. The address of the client is found with trustedProxies, like the
+// networks of internal tokens.
+func TwoFactorLogin(router *chi.Mux, lib *api.Library, trustedProxies []string) {
+	proxies := parseProxies(trustedProxies)
+
+	router.Post("/user/login/2fa", func(w http.ResponseWriter, r *http.Request) {
+		body, ok := decodeTwoFactorBody(w, r)
+		if !ok {
+			return
+		}
+
+		pair, err := lib.TwoFactor.Complete(r.Context(), bearerToken(r), body.Code, clientAddress(r, proxies), r.UserAgent())
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, pair)
+	})
+}
+
+// TwoFactorLoginEnroll adds the "POST /user/login/2fa/enroll" route, it enrolls a user whose group requires
+// two-factor authentication during their login, with the challenge JWT as the bearer token.
+func TwoFactorLoginEnroll(router *chi.Mux, lib *api.Library) {
+	router.Post("/user/login/2fa/enroll", func(w http.ResponseWriter, r *http.Request) {
+		challenge, err := lib.TwoFactor.Challenge(r.Context(), bearerToken(r))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		if challenge.Step != api.TwoFactorEnroll {
+			respondMessage(w, http.StatusForbidden, "two-factor authentication is already enabled")
+			return
+		}
+
+		enrollment, err := lib.TwoFactor.Enroll(r.Context(), challenge.User)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, enrollment)
+	})
+}
+
+// TwoFactor adds the "GET /user/2fa" route, it returns the two-factor authentication status of the
+// authenticated user.
+func TwoFactor(router *chi.Mux, lib *api.Library) {
+	router.Get("/user/2fa", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		status, err := lib.TwoFactor.Status(ctx, token.User.Hex())
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, status)
+	})
+}
+
+// TwoFactorEnroll adds the "POST /user/2fa/enroll" route, it generates a secret for the authenticated user
+// and returns it with its otpauth URI.
+func TwoFactorEnroll(router *chi.Mux, lib *api.Library) {
+	router.Post("/user/2fa/enroll", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		enrollment, err := lib.TwoFactor.Enroll(ctx, token.User)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, enrollment)
+	})
+}
+
+// TwoFactorEnable adds the "POST /user/2fa/enable" route, it enables two-factor authentication for the
+// authenticated user with a code generated from the enrolled secret and returns the recovery codes.
+func TwoFactorEnable(router *chi.Mux, lib *api.Library, trustedProxies []string) {
+	proxies := parseProxies(trustedProxies)
+
+	router.Post("/user/2fa/enable", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		body, ok := decodeTwoFactorBody(w, r)
+		if !ok {
+			return
+		}
+
+		codes, err := lib.TwoFactor.Enable(ctx, token.User, body.Code, clientAddress(r, proxies))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string][]string{"recoveryCodes": codes})
+	})
+}
+
+// TwoFactorDisable adds the "DELETE /user/2fa" route, it disables the two-factor authentication of the
+// authenticated user with a code or a recovery code.
+func TwoFactorDisable(router *chi.Mux, lib *api.Library, trustedProxies []string) {
+	proxies := parseProxies(trustedProxies)
+
+	router.Delete("/user/2fa", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		body, ok := decodeTwoFactorBody(w, r)
+		if !ok {
+			return
+		}
+
+		err := lib.TwoFactor.Disable(ctx, token.User, body.Code, clientAddress(r, proxies))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// TwoFactorRecovery adds the "POST /user/2fa/recovery" route, it replaces the recovery codes of the
+// authenticated user with a code or a recovery code.
+func TwoFactorRecovery(router *chi.Mux, lib *api.Library, trustedProxies []string) {
+	proxies := parseProxies(trustedProxies)
+
+	router.Post("/user/2fa/recovery", func(w http.ResponseWriter, r *http.Request) {
+		token, ctx, ok := requireSession(w, r, lib)
+		if !ok {
+			return
+		}
+
+		body, ok := decodeTwoFactorBody(w, r)
+		if !ok {
+			return
+		}
+
+		codes, err := lib.TwoFactor.RegenerateRecoveryCodes(ctx, token.User, body.Code, clientAddress(r, proxies))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string][]string{"recoveryCodes": codes})
+	})
+}
+
+// UserTwoFactor adds the "GET /user/{id}/2fa" route, it returns the two-factor authentication status of a
+// user. It requires the "user.twoFactor" permission.
+func UserTwoFactor(router *chi.Mux, lib *api.Library) {
+	router.Get("/user/{id}/2fa", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "user.twoFactor"); !ok {
+			return
+		}
+
+		status, err := lib.TwoFactor.Status(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, status)
+	})
+}
+
+// UserTwoFactorReset adds the "DELETE /user/{id}/2fa" route, it disables the two-factor authentication of a
+// user who lost their device and recovery codes. It requires the "user.twoFactor" permission and is recorded
+// in the user's two-factor audit log.
+func UserTwoFactorReset(router *chi.Mux, lib *api.Library, trustedProxies []string) {
+	proxies := parseProxies(trustedProxies)
+
+	router.Delete("/user/{id}/2fa", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requirePermission(w, r, lib, "user.twoFactor")
+		if !ok {
+			return
+		}
+
+		err := lib.TwoFactor.Reset(r.Context(), chi.URLParam(r, "id"), p.id(), clientAddress(r, proxies))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// UserTwoFactorAudit adds the "GET /user/{id}/2fa/audit" route, it paginates the two-factor audit log of a
+// user. It requires the "user.twoFactor" permission.
+func UserTwoFactorAudit(router *chi.Mux, lib *api.Library) {
+	router.Get("/user/{id}/2fa/audit", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "user.twoFactor"); !ok {
+			return
+		}
+
+		page, err := lib.TwoFactor.Audit(r.Context(), chi.URLParam(r, "id"), pageOptions(r))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondPage(w, r, &page.Page, page)
+	})
+}
@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"encoding/json"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-chi/chi"
+	"api"
+	"net/http"
+)
+
+// webhookBody represents the body of the "POST /webhook" and "PUT /webhook/{id}" routes.
+type webhookBody struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	Active      *bool    `json:"active"`
+	// Version is required by "PUT /webhook/{id}" to detect concurrent updates.
+	Version int `json:"version"`
+}
+
+// Webhook adds the "GET /webhook" route.
+func Webhook(router *chi.Mux, lib *api.Library) {
+	router.Get("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		webhooks, err := lib.Webhook.List(r.Context())
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, webhooks)
+	})
+}
+
+// WebhookID adds the "GET /webhook/{id}" route.
+func WebhookID(router *chi.Mux, lib *api.Library) {
+	router.Get("/webhook/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		webhook, err := lib.Webhook.GetByID(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, webhook)
+	})
+}
+
+// WebhookCreate adds the "POST /webhook" route.
+func WebhookCreate(router *chi.Mux, lib *api.Library) {
+	router.Post("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		var body webhookBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		webhook := lib.Webhook.New(r.Context(), body.URL, body.Events)
+		webhook.Description = body.Description
+		if body.Active != nil {
+			webhook.Active = *body.Active
+		}
+
+		err := lib.Webhook.Create(r.Context(), webhook)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, webhook)
+	})
+}
+
+// WebhookUpdate adds the "PUT /webhook/{id}" route.
+func WebhookUpdate(router *chi.Mux, lib *api.Library) {
+	router.Put("/webhook/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		var body webhookBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		webhook, err := lib.Webhook.GetByID(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		webhook.URL = body.URL
+		webhook.Description = body.Description
+		webhook.Events = body.Events
+		webhook.Version = body.Version
+		if body.Active != nil {
+			webhook.Active = *body.Active
+		}
+
+		err = lib.Webhook.Update(r.Context(), webhook)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, webhook)
+	})
+}
+
+// WebhookDelete adds the "DELETE /webhook/{id}" route.
+func WebhookDelete(router *chi.Mux, lib *api.Library) {
+	router.Delete("/webhook/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		err := lib.Webhook.Delete(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// WebhookDeliveries adds the "GET /webhook/delivery" route, use "?status=dead" to list the dead-letters.
+func WebhookDeliveries(router *chi.Mux, lib *api.Library) {
+	router.Get("/webhook/delivery", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		filter := api.WebhookDeliveryFilter{
+			EventType: query.Get("event"),
+			Status:    query.Get("status"),
+		}
+
+		if webhookID := query.Get("webhook"); len(webhookID) > 0 {
+			if !bson.IsObjectIdHex(webhookID) {
+				respondError(w, api.ErrInvalidID)
+				return
+			}
+
+			filter.WebhookID = bson.ObjectIdHex(webhookID)
+		}
+
+		page, err := lib.Webhook.Deliveries(r.Context(), pageOptions(r), filter)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondPage(w, r, &page.Page, page)
+	})
+}
+
+// WebhookDeliveryID adds the "GET /webhook/delivery/{id}" route.
+func WebhookDeliveryID(router *chi.Mux, lib *api.Library) {
+	router.Get("/webhook/delivery/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		delivery, err := lib.Webhook.GetDelivery(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, delivery)
+	})
+}
+
+// WebhookRedeliver adds the "POST /webhook/delivery/{id}/redeliver" route.
+func WebhookRedeliver(router *chi.Mux, lib *api.Library) {
+	router.Post("/webhook/delivery/{id}/redeliver", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requirePermission(w, r, lib, "webhook.manage"); !ok {
+			return
+		}
+
+		delivery, err := lib.Webhook.Redeliver(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+
+		respondJSON(w, http.StatusAccepted, delivery)
+	})
+}
@@ -0,0 +1,15 @@
+package mail
+
+import (
+	"config"
+	"fmt"
+	"time"
+)
+
+// Locked sends an email to the specified address telling its owner that their account has been locked after
+// too many failed login attempts.
+func Locked(address string, duration time.Duration) {
+	send(address, config.Get().SMTP.Locked.From, config.Get().SMTP.Locked.Subject, fmt.Sprintf(`Your Ikuta account has been locked for %v after too many failed login attempts.
+
+If these attempts weren't yours, someone may be trying to guess your password. You can still log in once the lock is over, consider choosing a stronger password.`, duration))
+}
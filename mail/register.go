@@ -14,6 +14,13 @@ import (
 
 // Register sends a registration confirmation email to the specified address.
 func Register(address string, token string) {
+	send(address, config.Get().SMTP.Register.From, config.Get().SMTP.Register.Subject, fmt.Sprintf(`Welcome to Ikuta!
+
+Here is your registration confirmation link: https://egirls.me/user/register?token=%s`, token))
+}
+
+// send sends a plain text email to the specified address, errors are logged.
+func send(address string, from string, subject string, body string) {
 	c, err := smtp.Dial(config.Get().SMTP.Host + ":587")
 	if err != nil {
 		logger.Errorw("[SMTP] Failed to dial smtp host.", logger.Err(err))
@@ -61,9 +68,7 @@ To: <%s>
 Subject: %s
 Content-Type: text/plain; charset="utf-8"
 
-Welcome to Ikuta!
-
-Here is your registration confirmation link: https://egirls.me/user/register?token=%s`, config.Get().SMTP.Register.From, config.Get().SMTP.From, address, config.Get().SMTP.Register.Subject, token)))); err != nil {
+%s`, from, config.Get().SMTP.From, address, subject, body)))); err != nil {
 		logger.Errorw("[SMTP] Failed to input data into data stream.", logger.Err(err))
 		return
 	}